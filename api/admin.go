@@ -0,0 +1,239 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mijara/statspout/audit"
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/secret"
+)
+
+// Controls which containers are collected at runtime, implemented by statspout.Collector.
+type Pauser interface {
+	Pause(selector string) error
+	Resume(selector string) bool
+	Paused() []string
+}
+
+// Tracks Docker hosts at runtime, implemented by statspout.Fleet.
+type Hoster interface {
+	Add(name string, endpoint backend.Endpoint) error
+	Remove(name string) bool
+	Hosts() map[string]backend.Endpoint
+}
+
+// Everything controlled by the admin endpoints.
+type Admin interface {
+	Pauser
+	Hoster
+}
+
+// Docker host, as listed by the admin API.
+type Host struct {
+	Name string `json:"name"`
+	backend.Endpoint
+}
+
+// Paused selectors, as listed by the admin API.
+type Pauses struct {
+	Paused []string `json:"paused"`
+}
+
+// Enables the admin endpoints, which require the given token as a bearer token. The token also grants access to
+// the read endpoints, see RequireRead. Must be called before Start.
+func (s *Server) EnableAdmin(admin Admin, token secret.Secret) {
+	s.adminTokens = []secret.Secret{token}
+
+	s.mux.Handle(PREFIX+"admin/pauses", s.audited(authenticate(s.adminTokens, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.pauses(w, r, admin)
+	}))))
+
+	s.mux.Handle(PREFIX+"admin/hosts", s.audited(authenticate(s.adminTokens, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hosts(w, r, admin)
+	}))))
+}
+
+// Requires a bearer token on every endpoint but the admin ones, which check their own: one of the given read-only
+// tokens, or the admin token. Must be called before Start.
+func (s *Server) RequireRead(tokens []secret.Secret) {
+	s.readTokens = tokens
+}
+
+// Records every request to the admin endpoints on the audit log, rejected ones included. Must be called before Start.
+func (s *Server) UseAudit(log *audit.Log) {
+	s.audit = log
+}
+
+// Lists paused selectors (GET), pauses (POST) or resumes (DELETE) the one given by the selector query
+// parameter.
+func (s *Server) pauses(w http.ResponseWriter, r *http.Request, pauser Pauser) {
+	selector := r.URL.Query().Get("selector")
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		if err := pauser.Pause(selector); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid selector: "+err.Error())
+			return
+		}
+
+	case http.MethodDelete:
+		if !pauser.Resume(selector) {
+			writeError(w, http.StatusNotFound, "selector "+selector+" is not paused")
+			return
+		}
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Pauses{Paused: pauser.Paused()})
+}
+
+// Lists hosts (GET), adds (POST) the one given by the name, network and address query parameters, or
+// removes (DELETE) the one given by name.
+func (s *Server) hosts(w http.ResponseWriter, r *http.Request, hoster Hoster) {
+	query := r.URL.Query()
+	name := query.Get("name")
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		endpoint := backend.Endpoint{Network: query.Get("network"), Address: query.Get("address")}
+		if err := hoster.Add(name, endpoint); err != nil {
+			writeError(w, http.StatusBadRequest, "cannot add host: "+err.Error())
+			return
+		}
+
+	case http.MethodDelete:
+		if !hoster.Remove(name) {
+			writeError(w, http.StatusNotFound, "no host named "+name)
+			return
+		}
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	hosts := []Host{}
+	for name, endpoint := range hoster.Hosts() {
+		hosts = append(hosts, Host{Name: name, Endpoint: endpoint})
+	}
+
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Name < hosts[j].Name
+	})
+
+	writeJSON(w, http.StatusOK, hosts)
+}
+
+// Rejects requests without one of the bearer tokens.
+func authenticate(tokens []secret.Secret, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, tokens) {
+			unauthorized(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Writes an admin record of the request to the audit log, if any, once answered.
+func (s *Server) audited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.audit == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// the token is never written, only its fingerprint in the identity.
+		query := r.URL.Query()
+		query.Del("token")
+
+		s.audit.Write(audit.Record{
+			Type:     audit.TYPE_ADMIN,
+			Identity: identity(r),
+			Remote:   r.RemoteAddr,
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Query:    query.Encode(),
+			Status:   recorder.status,
+		})
+	})
+}
+
+// Identifies the caller by the common name of its client certificate and the fingerprint of its token, either may
+// be missing.
+func identity(r *http.Request) string {
+	var parts []string
+
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		parts = append(parts, "cert:"+r.TLS.PeerCertificates[0].Subject.CommonName)
+	}
+
+	if token := bearer(r); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		parts = append(parts, "token:"+hex.EncodeToString(sum[:4]))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Keeps the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Token given by the request as a bearer token or, on GET requests, as the token query parameter.
+func bearer(r *http.Request) string {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if given == "" && r.Method == http.MethodGet {
+		given = r.URL.Query().Get("token")
+	}
+
+	return given
+}
+
+// Checks if the request carries one of the tokens, as a bearer token or, since browsers cannot set headers on
+// WebSockets and Server-Sent Events, as the token query parameter of GET requests. Tokens read from files are
+// compared to their current value, so rotations apply right away.
+func authorized(r *http.Request, tokens ...[]secret.Secret) bool {
+	given := bearer(r)
+	if given == "" {
+		return false
+	}
+
+	for _, list := range tokens {
+		for _, t := range list {
+			if token := t.Value(); token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="statspout"`)
+	writeError(w, http.StatusUnauthorized, "unauthorized")
+}
@@ -0,0 +1,276 @@
+/*
+HTTP API:
+Serves the most recent sample of each container as JSON, independently of the repository in use, and
+pushes samples as they are collected through WebSockets.
+
+Endpoints
+
+	GET /api/v1/containers                 containers with stats, with their labels.
+	GET /api/v1/containers/{name}/stats    latest stats of the container.
+	GET /api/v1/containers/{name}/history  samples retained between from and to (-api.history).
+	GET /api/v1/snapshot                   latest stats of every container, as JSON or CSV (format=csv).
+	GET /api/v1/telemetry                  internal metrics of the collection pipeline.
+	GET /api/v1/repositories               state of the repositories: last success, failures, queue and last error.
+	GET /api/v1/grafana/                   Grafana JSON datasource: POST to search, query and annotations.
+	GET /healthz                           health of the collection, 503 if no recent sample, open to everyone.
+	GET /ws                                WebSocket pushing samples as JSON frames.
+	GET /events/stats                      Server-Sent Events stream of samples and lifecycle events.
+	GET /                                  web dashboard.
+
+Container names are only unique within a Docker host, so {name} may be given as {host}/{name}. A bare name stands
+for the container of that name on the first host, by name, holding one.
+
+Every endpoint requires a read-only or admin bearer token if enabled with RequireRead, the token query parameter is
+accepted on GET requests for browsers.
+
+Admin endpoints, enabled with EnableAdmin, require the admin token as a bearer token. Requests to them are recorded
+on the audit log given to UseAudit, if any
+
+	GET    /api/v1/admin/pauses                           selectors excluded from collection.
+	POST   /api/v1/admin/pauses?selector=<sel>            excludes containers matching the selector.
+	DELETE /api/v1/admin/pauses?selector=<sel>            includes them again.
+	GET    /api/v1/admin/hosts                            Docker hosts being collected.
+	POST   /api/v1/admin/hosts?name=&network=&address=    starts collecting a Docker host.
+	DELETE /api/v1/admin/hosts?name=<name>                stops collecting it.
+*/
+package api
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mijara/statspout/audit"
+	"github.com/mijara/statspout/common"
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/secret"
+	"github.com/mijara/statspout/stats"
+)
+
+const (
+	PREFIX = "/api/v1/"
+
+	// allows every origin to open the WebSocket, see AllowOrigins.
+	ANY_ORIGIN = "*"
+)
+
+// Server publishing the stats held by a memory repository and pushed to a hub.
+type Server struct {
+	memory *common.Memory
+	hub    *Hub
+	mux    *http.ServeMux
+	server *http.Server
+
+	readTokens  []secret.Secret // tokens granting access to the read endpoints, open if empty.
+	adminTokens []secret.Secret // tokens granting access to every endpoint.
+	audit       *audit.Log      // records the admin requests, if not nil.
+	origins     map[string]bool // origins allowed to open the WebSocket besides the same one, see AllowOrigins.
+
+	started    time.Time     // when the server started, the first samples are awaited for staleAfter.
+	staleAfter time.Duration // age of the newest sample making the instance unhealthy, see SetStaleAfter.
+}
+
+// Container as listed by the API.
+type Container struct {
+	Name   string            `json:"name"`
+	Host   string            `json:"host,omitempty"` // Docker host of the container, see stats.LABEL_HOST.
+	Labels map[string]string `json:"labels"`
+}
+
+// Creates the server, which will serve the stats pushed to the given memory repository and hub.
+func New(address string, memory *common.Memory, hub *Hub) *Server {
+	s := &Server{
+		memory:     memory,
+		hub:        hub,
+		mux:        http.NewServeMux(),
+		staleAfter: DEFAULT_STALE_AFTER,
+	}
+
+	s.mux.HandleFunc(PREFIX+"containers", s.containers)
+	s.mux.HandleFunc(PREFIX+"containers/", s.container)
+	s.mux.HandleFunc(PREFIX+"snapshot", s.snapshot)
+	s.mux.HandleFunc(PREFIX+"telemetry", s.telemetry)
+	s.mux.HandleFunc(PREFIX+"repositories", s.repositories)
+	s.mux.HandleFunc(GRAFANA_PATH, s.grafana)
+	s.mux.HandleFunc(HEALTH_PATH, s.healthz)
+	s.mux.HandleFunc("/ws", s.ws)
+	s.mux.HandleFunc("/events/stats", s.sse)
+	s.mux.HandleFunc("/", s.dashboard)
+
+	s.server = &http.Server{
+		Addr:    address,
+		Handler: http.HandlerFunc(s.serve),
+	}
+
+	return s
+}
+
+// Checks the read scope, if required, before routing. Admin endpoints check their own scope, the health one is open.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if len(s.readTokens) > 0 && !strings.HasPrefix(r.URL.Path, PREFIX+"admin/") && r.URL.Path != HEALTH_PATH &&
+		!authorized(r, s.readTokens, s.adminTokens) {
+		unauthorized(w)
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// Allows pages of the given origins, as scheme://host[:port], to open the WebSocket, besides the ones served by the
+// API itself. ANY_ORIGIN allows every page. Must be called before Start.
+func (s *Server) AllowOrigins(origins []string) {
+	s.origins = make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		s.origins[strings.TrimSuffix(origin, "/")] = true
+	}
+}
+
+// Serves over TLS with the given configuration, which may require client certificates. Must be called before Start.
+func (s *Server) UseTLS(config *tls.Config) {
+	s.server.TLSConfig = config
+}
+
+// Starts serving in the background.
+func (s *Server) Start() {
+	s.started = time.Now()
+
+	go func() {
+		var err error
+		if s.server.TLSConfig != nil {
+			err = s.server.ListenAndServeTLS("", "")
+		} else {
+			err = s.server.ListenAndServe()
+		}
+
+		if err != http.ErrServerClosed {
+			log.Error.Fatal(err)
+		}
+	}()
+
+	log.Info.Printf("API listening on %s", s.server.Addr)
+}
+
+// Stops the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Lists containers with stats.
+func (s *Server) containers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	snapshot := s.memory.Snapshot()
+
+	list := make([]Container, 0, len(snapshot))
+	for _, samples := range snapshot {
+		if len(samples) == 0 {
+			continue
+		}
+
+		latest := samples[len(samples)-1]
+		list = append(list, Container{
+			Name:   latest.Name,
+			Host:   latest.Labels[stats.LABEL_HOST],
+			Labels: latest.Labels,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Host < list[j].Host
+	})
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// Routes requests for a single container, given by name or by host and name.
+func (s *Server) container(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, PREFIX+"containers/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[len(parts)-2] == "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	name := strings.Join(parts[:len(parts)-1], "/")
+
+	switch parts[len(parts)-1] {
+	case "stats":
+		s.stats(w, name)
+	case "history":
+		s.history(w, r, name)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// Serves the latest stats of the container.
+func (s *Server) stats(w http.ResponseWriter, name string) {
+	latest, ok := s.memory.Latest(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no stats for container "+name)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, latest)
+}
+
+// Serves the retained samples of the container within the requested range, from oldest to newest.
+func (s *Server) history(w http.ResponseWriter, r *http.Request, name string) {
+	now := time.Now()
+
+	from, err := parseTime(r.URL.Query().Get("from"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid from: "+err.Error())
+		return
+	}
+
+	to, err := parseTime(r.URL.Query().Get("to"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid to: "+err.Error())
+		return
+	}
+
+	if _, ok := s.memory.Latest(name); !ok {
+		writeError(w, http.StatusNotFound, "no stats for container "+name)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.memory.Range(name, from, to))
+}
+
+// Parses a time given as RFC 3339 or as a duration before now, the zero time if empty.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
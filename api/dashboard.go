@@ -0,0 +1,185 @@
+package api
+
+import (
+	"net/http"
+)
+
+// Serves the dashboard, a single page fed by the WebSocket endpoint.
+func (s *Server) dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(dashboardHTML))
+}
+
+// Self-contained dashboard: a sortable table of containers with sparklines of the last samples.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>statspout</title>
+<style>
+  body { font-family: sans-serif; margin: 2em; color: #222; }
+  h1 { font-size: 1.4em; }
+  #status { color: #888; font-size: 0.9em; }
+  table { border-collapse: collapse; width: 100%; }
+  th, td { padding: 0.4em 0.8em; border-bottom: 1px solid #ddd; text-align: right; white-space: nowrap; }
+  th:first-child, td:first-child { text-align: left; }
+  th { cursor: pointer; user-select: none; background: #f5f5f5; }
+  th.sorted:after { content: " \25BC"; }
+  th.sorted.asc:after { content: " \25B2"; }
+  svg { vertical-align: middle; margin-left: 0.5em; }
+  polyline { fill: none; stroke: #3a7bd5; stroke-width: 1.5; }
+</style>
+</head>
+<body>
+<h1>statspout</h1>
+<p id="status">connecting...</p>
+<table>
+  <thead>
+    <tr>
+      <th data-key="name">Container</th>
+      <th data-key="cpu">CPU %</th>
+      <th data-key="mem">Memory %</th>
+      <th data-key="usage">Memory</th>
+      <th data-key="tx">Tx B/s</th>
+      <th data-key="rx">Rx B/s</th>
+    </tr>
+  </thead>
+  <tbody id="rows"></tbody>
+</table>
+<script>
+(function () {
+  var SAMPLES = 60;
+  var containers = {};
+  var sortKey = "name", ascending = true;
+
+  // containers are named by host and name, names are only unique within a host.
+  function entry(s) {
+    var host = (s.Labels && s.Labels.host) || "";
+    var name = host ? host + "/" + s.name : s.name;
+    if (!containers[name]) {
+      containers[name] = {name: name, cpu: [], mem: [], usage: 0, tx: [], rx: [], last: null};
+    }
+    return containers[name];
+  }
+
+  function add(series, value) {
+    series.push(value);
+    if (series.length > SAMPLES) series.shift();
+  }
+
+  function latest(series) {
+    return series.length ? series[series.length - 1] : 0;
+  }
+
+  function push(s) {
+    var c = entry(s);
+    add(c.cpu, s.cpu_percent);
+    add(c.mem, s.mem_percent);
+    c.usage = s.mem_usage;
+
+    // network totals are turned into rates between consecutive samples.
+    var time = Date.parse(s["@timestamp"]) / 1000;
+    if (c.last && time > c.last.time) {
+      add(c.tx, Math.max(0, (s.tx_bytes - c.last.tx) / (time - c.last.time)));
+      add(c.rx, Math.max(0, (s.rx_bytes - c.last.rx) / (time - c.last.time)));
+    }
+    c.last = {time: time, tx: s.tx_bytes, rx: s.rx_bytes};
+  }
+
+  function sparkline(series) {
+    if (series.length < 2) return "";
+    var max = Math.max.apply(null, series) || 1;
+    var points = series.map(function (v, i) {
+      return (i * 80 / (SAMPLES - 1)).toFixed(1) + "," + (18 - v * 16 / max).toFixed(1);
+    });
+    return '<svg width="80" height="20"><polyline points="' + points.join(" ") + '"/></svg>';
+  }
+
+  function bytes(value) {
+    var units = ["B", "KiB", "MiB", "GiB", "TiB"], i = 0;
+    while (value >= 1024 && i < units.length - 1) { value /= 1024; i++; }
+    return value.toFixed(i ? 1 : 0) + " " + units[i];
+  }
+
+  function value(c, key) {
+    if (key === "name") return c.name;
+    if (key === "usage") return c.usage;
+    return latest(c[key]);
+  }
+
+  function escape(text) {
+    var div = document.createElement("div");
+    div.textContent = text;
+    return div.innerHTML;
+  }
+
+  function render() {
+    var list = Object.keys(containers).map(function (name) { return containers[name]; });
+    list.sort(function (a, b) {
+      var x = value(a, sortKey), y = value(b, sortKey);
+      var order = x < y ? -1 : x > y ? 1 : 0;
+      return ascending ? order : -order;
+    });
+
+    document.getElementById("rows").innerHTML = list.map(function (c) {
+      return "<tr><td>" + escape(c.name) + "</td>" +
+        "<td>" + latest(c.cpu).toFixed(2) + sparkline(c.cpu) + "</td>" +
+        "<td>" + latest(c.mem).toFixed(2) + sparkline(c.mem) + "</td>" +
+        "<td>" + bytes(c.usage) + "</td>" +
+        "<td>" + bytes(latest(c.tx)) + sparkline(c.tx) + "</td>" +
+        "<td>" + bytes(latest(c.rx)) + sparkline(c.rx) + "</td></tr>";
+    }).join("");
+
+    Array.prototype.forEach.call(document.querySelectorAll("th"), function (th) {
+      th.className = th.dataset.key === sortKey ? (ascending ? "sorted asc" : "sorted") : "";
+    });
+  }
+
+  Array.prototype.forEach.call(document.querySelectorAll("th"), function (th) {
+    th.addEventListener("click", function () {
+      if (sortKey === th.dataset.key) {
+        ascending = !ascending;
+      } else {
+        sortKey = th.dataset.key;
+        ascending = sortKey === "name";
+      }
+      render();
+    });
+  });
+
+  function connect() {
+    var status = document.getElementById("status");
+    var ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws" + location.search);
+
+    ws.onopen = function () { status.textContent = "live"; };
+    ws.onmessage = function (event) { push(JSON.parse(event.data)); };
+    ws.onclose = function () {
+      status.textContent = "disconnected, retrying...";
+      setTimeout(connect, 3000);
+    };
+  }
+
+  // seed the table with the latest samples, then follow the stream.
+  // the token query parameter, if any, is passed along.
+  fetch("/api/v1/containers" + location.search).then(function (res) { return res.json(); }).then(function (list) {
+    return Promise.all(list.map(function (c) {
+      var path = (c.host ? encodeURIComponent(c.host) + "/" : "") + encodeURIComponent(c.name);
+      return fetch("/api/v1/containers/" + path + "/stats" + location.search)
+        .then(function (res) { return res.ok ? res.json() : null; })
+        .then(function (s) { if (s) push(s); });
+    }));
+  }).then(render, render);
+
+  connect();
+  setInterval(render, 1000);
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mijara/statspout/stats"
+)
+
+// Path of the Grafana JSON datasource, given as its URL.
+const GRAFANA_PATH = PREFIX + "grafana/"
+
+// Metrics graphed by Grafana, targeted as <container>.<metric>, or *.<metric> for every container.
+var grafanaMetrics = []struct {
+	name  string
+	value func(s *stats.Stats) float64
+}{
+	{"cpu_percent", func(s *stats.Stats) float64 { return s.CpuPercent }},
+	{"cpu_limit", func(s *stats.Stats) float64 { return s.CpuLimit }},
+	{"mem_usage", func(s *stats.Stats) float64 { return float64(s.MemoryUsage) }},
+	{"mem_percent", func(s *stats.Stats) float64 { return s.MemoryPercent }},
+	{"mem_limit", func(s *stats.Stats) float64 { return float64(s.MemoryLimit) }},
+	{"mem_failcnt", func(s *stats.Stats) float64 { return float64(s.MemoryFailcnt) }},
+	{"swap_usage", func(s *stats.Stats) float64 { return float64(s.SwapUsage) }},
+	{"open_fds", func(s *stats.Stats) float64 { return float64(s.OpenFds) }},
+	{"tx_bytes", func(s *stats.Stats) float64 { return float64(s.TxBytesTotal) }},
+	{"rx_bytes", func(s *stats.Stats) float64 { return float64(s.RxBytesTotal) }},
+	{"tx_packets", func(s *stats.Stats) float64 { return float64(s.TxPacketsTotal) }},
+	{"rx_packets", func(s *stats.Stats) float64 { return float64(s.RxPacketsTotal) }},
+	{"tx_errors", func(s *stats.Stats) float64 { return float64(s.TxErrorsTotal) }},
+	{"rx_errors", func(s *stats.Stats) float64 { return float64(s.RxErrorsTotal) }},
+	{"tx_dropped", func(s *stats.Stats) float64 { return float64(s.TxDroppedTotal) }},
+	{"rx_dropped", func(s *stats.Stats) float64 { return float64(s.RxDroppedTotal) }},
+	{"blkio_service_time", func(s *stats.Stats) float64 { return float64(s.BlkioServiceTime) }},
+	{"blkio_serviced", func(s *stats.Stats) float64 { return float64(s.BlkioServiced) }},
+	{"blkio_queue", func(s *stats.Stats) float64 { return float64(s.BlkioQueue) }},
+	{"blkio_read_bps", func(s *stats.Stats) float64 { return s.BlkioReadBps }},
+	{"blkio_write_bps", func(s *stats.Stats) float64 { return s.BlkioWriteBps }},
+	{"blkio_read_iops", func(s *stats.Stats) float64 { return s.BlkioReadIops }},
+	{"blkio_write_iops", func(s *stats.Stats) float64 { return s.BlkioWriteIops }},
+}
+
+// Time range of Grafana requests.
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaQuery struct {
+	Range   grafanaRange `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"` // timeserie, or table.
+	} `json:"targets"`
+	MaxDataPoints int `json:"maxDataPoints"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"` // value and time in milliseconds.
+}
+
+type grafanaTable struct {
+	Type    string              `json:"type"`
+	Columns []map[string]string `json:"columns"`
+	Rows    [][]interface{}     `json:"rows"`
+}
+
+type grafanaAnnotations struct {
+	Range      grafanaRange           `json:"range"`
+	Annotation map[string]interface{} `json:"annotation"`
+}
+
+type grafanaAnnotation struct {
+	Annotation map[string]interface{} `json:"annotation"`
+	Time       int64                  `json:"time"`
+	Title      string                 `json:"title"`
+	Text       string                 `json:"text"`
+	Tags       []string               `json:"tags"`
+}
+
+// Routes the requests of the Grafana JSON datasource: the connection test, search, query and annotations.
+func (s *Server) grafana(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, GRAFANA_PATH)
+
+	if endpoint == "" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	switch endpoint {
+	case "search":
+		s.grafanaSearch(w, r)
+	case "query":
+		s.grafanaQuery(w, r)
+	case "annotations":
+		s.grafanaAnnotations(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// Lists the targets containing the given one, every target if empty.
+func (s *Server) grafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	names := []string{"*"}
+	for name, samples := range s.memory.Snapshot() {
+		if len(samples) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	targets := []string{}
+	for _, name := range names {
+		for _, metric := range grafanaMetrics {
+			target := name + "." + metric.name
+			if strings.Contains(target, request.Target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, targets)
+}
+
+// Serves the retained samples of the targets within the range, as series or tables, down to maxDataPoints samples
+// per series.
+func (s *Server) grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var request grafanaQuery
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	response := []interface{}{}
+
+	for _, target := range request.Targets {
+		// container names may have dots, metric names have none.
+		dot := strings.LastIndex(target.Target, ".")
+		if dot < 1 {
+			writeError(w, http.StatusBadRequest, "invalid target "+target.Target+", use <container>.<metric>")
+			return
+		}
+
+		name, metric := target.Target[:dot], target.Target[dot+1:]
+
+		value := grafanaMetric(metric)
+		if value == nil {
+			writeError(w, http.StatusBadRequest, "unknown metric "+metric)
+			return
+		}
+
+		names := []string{name}
+		if name == "*" {
+			names = names[:0]
+			for n := range s.memory.Snapshot() {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+		}
+
+		table := grafanaTable{
+			Type: "table",
+			Columns: []map[string]string{
+				{"text": "Time", "type": "time"},
+				{"text": "container", "type": "string"},
+				{"text": metric, "type": "number"},
+			},
+			Rows: [][]interface{}{},
+		}
+
+		for _, n := range names {
+			samples := downsample(s.memory.Range(n, request.Range.From, request.Range.To), request.MaxDataPoints)
+
+			if target.Type == "table" {
+				for i := range samples {
+					table.Rows = append(table.Rows, []interface{}{
+						milliseconds(samples[i].Timestamp), n, value(&samples[i]),
+					})
+				}
+				continue
+			}
+
+			series := grafanaSeries{Target: n + "." + metric, Datapoints: [][2]float64{}}
+			for i := range samples {
+				series.Datapoints = append(series.Datapoints, [2]float64{
+					value(&samples[i]), float64(milliseconds(samples[i].Timestamp)),
+				})
+			}
+			response = append(response, series)
+		}
+
+		if target.Type == "table" {
+			response = append(response, table)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Serves the retained events within the range as annotations. The query of the annotation, if any, is a list of
+// container names separated by comma.
+func (s *Server) grafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	var request grafanaAnnotations
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	var names []string
+	if query, ok := request.Annotation["query"].(string); ok && strings.TrimSpace(query) != "" {
+		for _, name := range strings.Split(query, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+
+	annotations := []grafanaAnnotation{}
+	for _, event := range s.memory.Events(request.Range.From, request.Range.To) {
+		if len(names) > 0 && !containsName(names, event.Name) {
+			continue
+		}
+
+		text := event.Name + " " + event.Action
+		if event.State != "" {
+			text = event.Name + " " + event.State
+		}
+
+		annotations = append(annotations, grafanaAnnotation{
+			Annotation: request.Annotation,
+			Time:       milliseconds(event.Timestamp),
+			Title:      event.Action,
+			Text:       text,
+			Tags:       []string{event.Name, event.Action},
+		})
+	}
+
+	writeJSON(w, http.StatusOK, annotations)
+}
+
+// Value of the named metric, nil if unknown.
+func grafanaMetric(name string) func(s *stats.Stats) float64 {
+	for _, metric := range grafanaMetrics {
+		if metric.name == name {
+			return metric.value
+		}
+	}
+
+	return nil
+}
+
+// Evenly spaced samples, at most max of them, all of them if max is not positive.
+func downsample(samples []stats.Stats, max int) []stats.Stats {
+	if max <= 0 || len(samples) <= max {
+		return samples
+	}
+
+	stride := (len(samples) + max - 1) / max
+
+	kept := make([]stats.Stats, 0, max)
+	for i := 0; i < len(samples); i += stride {
+		kept = append(kept, samples[i])
+	}
+
+	return kept
+}
+
+func milliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mijara/statspout/telemetry"
+)
+
+// Path of the health endpoint, left open to orchestrators even if tokens are required.
+const HEALTH_PATH = "/healthz"
+
+// Health of the instance, as served by the health endpoint.
+type Health struct {
+	Status     string     `json:"status"`                // ok, or stale if no recent sample was collected.
+	Containers int64      `json:"containers"`            // containers monitored.
+	LastSample *time.Time `json:"last_sample,omitempty"` // newest sample of any container, if any.
+}
+
+// Samples older than this make the instance unhealthy while containers are monitored, see SetStaleAfter.
+const DEFAULT_STALE_AFTER = time.Minute
+
+// Sets the age of the newest sample above which the instance is reported unhealthy, while containers are monitored.
+// Must be called before Start.
+func (s *Server) SetStaleAfter(d time.Duration) {
+	s.staleAfter = d
+}
+
+// Health of the instance: healthy while no container is monitored, or if a sample was collected recently. Instances
+// started recently are given time for the first samples.
+func (s *Server) health() Health {
+	health := Health{
+		Status:     "ok",
+		Containers: telemetry.Default.Level("containers_monitored").Value(),
+	}
+
+	for _, samples := range s.memory.Snapshot() {
+		if len(samples) == 0 {
+			continue
+		}
+
+		read := samples[len(samples)-1].Timestamp
+		if health.LastSample == nil || read.After(*health.LastSample) {
+			health.LastSample = &read
+		}
+	}
+
+	recent := func(t time.Time) bool {
+		return time.Since(t) <= s.staleAfter
+	}
+
+	if health.Containers > 0 && !recent(s.started) && (health.LastSample == nil || !recent(*health.LastSample)) {
+		health.Status = "stale"
+	}
+
+	return health
+}
+
+// Serves the health of the instance, with 503 if unhealthy, so orchestrators restart it when collection is broken.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	health := s.health()
+
+	status := http.StatusOK
+	if health.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, health)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+const (
+	// samples and events buffered per subscriber, slower subscribers lose them.
+	SUBSCRIBER_BUFFER = 64
+)
+
+// Hub is a repository that forwards every sample and event to its subscribers, used by the streaming APIs.
+type Hub struct {
+	mutex       sync.RWMutex
+	subscribers map[*Subscription]bool
+}
+
+// Samples and events accepted by a filter, available on C and Events until closed.
+type Subscription struct {
+	C      <-chan *stats.Stats
+	Events <-chan *stats.Event
+
+	c      chan *stats.Stats
+	events chan *stats.Event
+	filter *Filter
+	hub    *Hub
+}
+
+// Accepts samples and events of the given container names (any of them, if not empty) having every
+// given label.
+type Filter struct {
+	Names  []string
+	Labels map[string]string
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+func (*Hub) Name() string {
+	return "hub"
+}
+
+func (*Hub) Create(v interface{}) (repo.Interface, error) {
+	return NewHub(), nil
+}
+
+// Sends the sample to every subscriber accepting it, without blocking. Subscribers read it later, so they share a
+// copy.
+func (hub *Hub) Push(s *stats.Stats) error {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	var sample *stats.Stats
+
+	for sub := range hub.subscribers {
+		if !sub.filter.Match(s.Name, s.Labels) {
+			continue
+		}
+
+		if sample == nil {
+			copied := *s
+			sample = &copied
+		}
+
+		select {
+		case sub.c <- sample:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Sends the event to every subscriber accepting it, without blocking. Event attributes include the
+// labels of the container.
+func (hub *Hub) PushEvent(event *stats.Event) error {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	for sub := range hub.subscribers {
+		if !sub.filter.Match(event.Name, event.Attributes) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Closes every subscription.
+func (hub *Hub) Close() {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for sub := range hub.subscribers {
+		delete(hub.subscribers, sub)
+		sub.close()
+	}
+}
+
+func (hub *Hub) Clear(name string) {
+}
+
+// Subscribes to the samples and events accepted by the filter, a nil filter accepts everything.
+func (hub *Hub) Subscribe(filter *Filter) *Subscription {
+	c := make(chan *stats.Stats, SUBSCRIBER_BUFFER)
+	events := make(chan *stats.Event, SUBSCRIBER_BUFFER)
+
+	sub := &Subscription{
+		C:      c,
+		Events: events,
+		c:      c,
+		events: events,
+		filter: filter,
+		hub:    hub,
+	}
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	hub.subscribers[sub] = true
+
+	return sub
+}
+
+// Stops receiving samples and events, closing C and Events.
+func (sub *Subscription) Close() {
+	sub.hub.mutex.Lock()
+	defer sub.hub.mutex.Unlock()
+
+	if sub.hub.subscribers[sub] {
+		delete(sub.hub.subscribers, sub)
+		sub.close()
+	}
+}
+
+func (sub *Subscription) close() {
+	close(sub.c)
+	close(sub.events)
+}
+
+// Checks if a container with the given name and labels is accepted, a nil filter accepts everything.
+func (f *Filter) Match(name string, labels map[string]string) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Names) > 0 {
+		found := false
+		for _, n := range f.Names {
+			if n == name {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	for key, value := range f.Labels {
+		if labels[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,17 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/mijara/statspout/repo"
+)
+
+// Serves the state of the repositories pushed to, to tell which one is failing or falling behind.
+func (s *Server) repositories(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, repo.Statuses())
+}
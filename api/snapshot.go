@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mijara/statspout/common"
+	"github.com/mijara/statspout/stats"
+	"github.com/mijara/statspout/version"
+)
+
+// Formats in which a snapshot can be written.
+var SnapshotFormats = []string{"json", "csv"}
+
+// Latest stats and metadata of every monitored container, at a point in time.
+type Snapshot struct {
+	Timestamp  time.Time       `json:"@timestamp"`
+	Version    string          `json:"version"`
+	Containers []SnapshotEntry `json:"containers"`
+
+	// Format of the timestamps of the CSV, RFC 3339 with nanoseconds as reported if not set.
+	TimeFormat *stats.TimeFormat `json:"-"`
+}
+
+// Single container of a snapshot.
+type SnapshotEntry struct {
+	Name   string            `json:"name"`
+	Host   string            `json:"host,omitempty"` // Docker host of the container, see stats.LABEL_HOST.
+	Labels map[string]string `json:"labels"`
+	Stats  stats.Stats       `json:"stats"`
+}
+
+// Takes a snapshot of the latest samples held by the memory repository, sorted by container name and host.
+func NewSnapshot(memory *common.Memory) *Snapshot {
+	snapshot := &Snapshot{
+		Timestamp:  time.Now(),
+		Version:    version.Version,
+		Containers: []SnapshotEntry{},
+	}
+
+	for _, samples := range memory.Snapshot() {
+		if len(samples) == 0 {
+			continue
+		}
+
+		latest := samples[len(samples)-1]
+		snapshot.Containers = append(snapshot.Containers, SnapshotEntry{
+			Name:   latest.Name,
+			Host:   latest.Labels[stats.LABEL_HOST],
+			Labels: latest.Labels,
+			Stats:  latest,
+		})
+	}
+
+	sort.Slice(snapshot.Containers, func(i, j int) bool {
+		a, b := snapshot.Containers[i], snapshot.Containers[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Host < b.Host
+	})
+
+	return snapshot
+}
+
+// Writes the snapshot in the given format, json or csv.
+func (s *Snapshot) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(s)
+	case "csv":
+		return s.writeCSV(w)
+	}
+
+	return errors.New("Unknown snapshot format: " + format)
+}
+
+// Writes one row per container, labels are joined as key=value pairs separated by semicolons.
+func (s *Snapshot) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	writer.Write([]string{
+		"name", "host", "image", "image_created", "image_age_days", "restart_policy", "privileged", "network_mode", "timestamp", "cpu_percent", "cpu_limit", "cpu_shares", "mem_usage", "mem_percent", "mem_limit", "mem_limited", "mem_failcnt", "open_fds", "fd_limit", "tcp_established", "tcp_time_wait", "swap_usage", "swap_limit", "blkio_service_time", "blkio_serviced", "blkio_queue",
+		"blkio_read_bytes", "blkio_write_bytes", "blkio_reads", "blkio_writes", "blkio_read_bps", "blkio_write_bps",
+		"blkio_read_iops", "blkio_write_iops",
+		"tx_bytes", "rx_bytes", "tx_packets", "rx_packets", "tx_errors", "rx_errors", "tx_dropped", "rx_dropped", "labels",
+	})
+
+	for _, entry := range s.Containers {
+		labels := make([]string, 0, len(entry.Labels))
+		for key, value := range entry.Labels {
+			labels = append(labels, key+"="+value)
+		}
+		sort.Strings(labels)
+
+		established, timeWait := tcpCounts(entry.Stats.Tcp)
+
+		writer.Write([]string{
+			entry.Name,
+			entry.Host,
+			entry.Stats.Image,
+			imageCreated(entry.Stats.ImageCreated),
+			strconv.FormatFloat(entry.Stats.ImageAgeDays, 'f', 1, 64),
+			entry.Stats.RestartPolicy,
+			strconv.FormatBool(entry.Stats.Privileged),
+			entry.Stats.NetworkMode,
+			s.timestamp(entry.Stats.Timestamp),
+			strconv.FormatFloat(entry.Stats.CpuPercent, 'f', 2, 64),
+			strconv.FormatFloat(entry.Stats.CpuLimit, 'f', -1, 64),
+			strconv.FormatUint(entry.Stats.CpuShares, 10),
+			strconv.FormatUint(entry.Stats.MemoryUsage, 10),
+			strconv.FormatFloat(entry.Stats.MemoryPercent, 'f', 2, 64),
+			strconv.FormatUint(entry.Stats.MemoryLimit, 10),
+			strconv.FormatBool(entry.Stats.MemoryLimited),
+			strconv.FormatUint(entry.Stats.MemoryFailcnt, 10),
+			strconv.FormatUint(entry.Stats.OpenFds, 10),
+			strconv.FormatUint(entry.Stats.FdLimit, 10),
+			established,
+			timeWait,
+			strconv.FormatUint(entry.Stats.SwapUsage, 10),
+			strconv.FormatUint(entry.Stats.SwapLimit, 10),
+			strconv.FormatUint(entry.Stats.BlkioServiceTime, 10),
+			strconv.FormatUint(entry.Stats.BlkioServiced, 10),
+			strconv.FormatUint(entry.Stats.BlkioQueue, 10),
+			strconv.FormatUint(entry.Stats.BlkioReadBytes, 10),
+			strconv.FormatUint(entry.Stats.BlkioWriteBytes, 10),
+			strconv.FormatUint(entry.Stats.BlkioReads, 10),
+			strconv.FormatUint(entry.Stats.BlkioWrites, 10),
+			strconv.FormatFloat(entry.Stats.BlkioReadBps, 'f', 2, 64),
+			strconv.FormatFloat(entry.Stats.BlkioWriteBps, 'f', 2, 64),
+			strconv.FormatFloat(entry.Stats.BlkioReadIops, 'f', 2, 64),
+			strconv.FormatFloat(entry.Stats.BlkioWriteIops, 'f', 2, 64),
+			strconv.FormatUint(entry.Stats.TxBytesTotal, 10),
+			strconv.FormatUint(entry.Stats.RxBytesTotal, 10),
+			strconv.FormatUint(entry.Stats.TxPacketsTotal, 10),
+			strconv.FormatUint(entry.Stats.RxPacketsTotal, 10),
+			strconv.FormatUint(entry.Stats.TxErrorsTotal, 10),
+			strconv.FormatUint(entry.Stats.RxErrorsTotal, 10),
+			strconv.FormatUint(entry.Stats.TxDroppedTotal, 10),
+			strconv.FormatUint(entry.Stats.RxDroppedTotal, 10),
+			strings.Join(labels, ";"),
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// Timestamp of a sample in the CSV.
+func (s *Snapshot) timestamp(t time.Time) string {
+	if s.TimeFormat == nil {
+		return t.Format(time.RFC3339Nano)
+	}
+
+	return s.TimeFormat.Format(t)
+}
+
+// Established and time-wait connections in the CSV, empty if not read.
+func tcpCounts(c *stats.Connections) (string, string) {
+	if c == nil {
+		return "", ""
+	}
+
+	return strconv.FormatUint(c.Established, 10), strconv.FormatUint(c.TimeWait, 10)
+}
+
+// Creation time of the image in the CSV, empty if unknown.
+func imageCreated(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// Serves a snapshot of every container as a download, in the format given by the format query
+// parameter, json by default. The timezone and timeformat parameters format the timestamps of the CSV, see
+// stats.ParseTimeFormat.
+func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	contentType := "application/json; charset=UTF-8"
+	switch format {
+	case "json":
+	case "csv":
+		contentType = "text/csv; charset=UTF-8"
+	default:
+		writeError(w, http.StatusBadRequest, "unknown format "+format+", use json or csv")
+		return
+	}
+
+	snapshot := NewSnapshot(s.memory)
+
+	if zone, layout := r.URL.Query().Get("timezone"), r.URL.Query().Get("timeformat"); zone != "" || layout != "" {
+		if layout == "" {
+			layout = stats.TIME_LAYOUT_RFC3339_NANO
+		}
+
+		timeFormat, err := stats.ParseTimeFormat(zone, layout)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		snapshot.TimeFormat = &timeFormat
+	}
+
+	filename := "statspout-snapshot-" + snapshot.Timestamp.UTC().Format("20060102T150405Z") + "." + format
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	snapshot.Write(w, format)
+}
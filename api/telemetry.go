@@ -0,0 +1,17 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/mijara/statspout/telemetry"
+)
+
+// Serves the internal metrics of the collection pipeline.
+func (s *Server) telemetry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, telemetry.Default.Snapshot())
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	WS_WRITE_TIMEOUT = 10 * time.Second // time allowed to write a frame.
+	WS_PING_PERIOD   = 30 * time.Second // time between pings, to keep idle connections alive.
+)
+
+// Pushes samples to the client as JSON text frames, until it disconnects.
+func (s *Server) ws(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an error.
+		return
+	}
+	defer conn.Close()
+
+	sub := s.hub.Subscribe(requestFilter(r))
+	defer sub.Close()
+
+	// client messages are discarded, but reading is needed to notice when the client goes away.
+	gone := make(chan bool)
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(WS_PING_PERIOD)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case sample, ok := <-sub.C:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "statspout is stopping"),
+					time.Now().Add(WS_WRITE_TIMEOUT))
+				return
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+			if err := conn.WriteJSON(sample); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WS_WRITE_TIMEOUT)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Browsers send the WebSocket handshake from any page, with the cookies and tokens of the visitor, so only the
+// same origin and the allowed ones may open it. Requests without an origin come from other clients than browsers.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s.origins[ANY_ORIGIN] || s.origins[origin] {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// Filter given by the query of the request, as name=<container> and label=<key>=<value> parameters, which
+// can be repeated.
+func requestFilter(r *http.Request) *Filter {
+	query := r.URL.Query()
+
+	labels := map[string]string{}
+	for _, label := range query["label"] {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) == 2 {
+			labels[parts[0]] = parts[1]
+		}
+	}
+
+	return &Filter{Names: query["name"], Labels: labels}
+}
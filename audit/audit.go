@@ -0,0 +1,134 @@
+/*
+Package audit records what leaves the process and who changed it: the pushes to the repository, summarized
+periodically with their destination, counts and failures, and every request to the admin endpoints, with the identity
+of the caller. Records are written as JSON lines to a file or to syslog.
+
+Example
+
+	log, err := audit.New("/var/log/statspout/audit.log") // or syslog, syslog://host:514, syslog+tcp://host:514.
+	repository = audit.NewRepo(repository, log, "influxdb", "http://influxdb:8086", time.Minute)
+*/
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+)
+
+// Record types.
+const (
+	TYPE_PUSH  = "push"
+	TYPE_SEND  = "send"
+	TYPE_ADMIN = "admin"
+)
+
+// Entry of the audit log. Push records summarize the pushes since the previous one, send records describe a single
+// batch sent in the background and admin records a single request.
+type Record struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+
+	Repository  string `json:"repository,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Stats       int    `json:"stats,omitempty"`  // samples pushed, or everything a batch carried if sent.
+	Events      int    `json:"events,omitempty"` // events pushed.
+	Failed      int    `json:"failed,omitempty"` // samples and events that could not be pushed or sent.
+	Error       string `json:"error,omitempty"`  // last push or send error.
+
+	Identity string `json:"identity,omitempty"` // client certificate and token fingerprint of the caller.
+	Remote   string `json:"remote,omitempty"`
+	Method   string `json:"method,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Query    string `json:"query,omitempty"`
+	Status   int    `json:"status,omitempty"`
+}
+
+// Audit log, safe for concurrent use.
+type Log struct {
+	mutex  sync.Mutex
+	writer io.WriteCloser
+}
+
+// Opens the audit log: a file path, appended to, syslog for the local daemon, or syslog://host:port and
+// syslog+tcp://host:port for a remote one.
+func New(target string) (*Log, error) {
+	if err := CheckTarget(target); err != nil {
+		return nil, err
+	}
+
+	var writer io.WriteCloser
+	var err error
+
+	if target == "syslog" || strings.HasPrefix(target, "syslog://") || strings.HasPrefix(target, "syslog+tcp://") {
+		writer, err = dialSyslog(target)
+	} else {
+		writer, err = os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Log{writer: writer}, nil
+}
+
+// Checks that the target is a file path or a valid syslog address, without opening it.
+func CheckTarget(target string) error {
+	switch {
+	case target == "":
+		return errors.New("The audit log target is empty.")
+	case target == "syslog":
+		return nil
+	case strings.HasPrefix(target, "syslog://") || strings.HasPrefix(target, "syslog+tcp://"):
+		u, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+
+		if u.Host == "" {
+			return errors.New("The syslog address needs a host: " + target)
+		}
+
+		return nil
+	case strings.Contains(target, "://"):
+		return errors.New("Unknown audit log target: " + target + ", use a file path, syslog or syslog://host:port")
+	}
+
+	return nil
+}
+
+// Writes a record as a JSON line, timestamped now if not set. Errors are logged, auditing never stops collection.
+func (l *Log) Write(record Record) {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Error.Printf("Could not encode the audit record: %s", err.Error())
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, err := l.writer.Write(append(data, '\n')); err != nil {
+		log.Error.Printf("Could not write the audit log: %s", err.Error())
+	}
+}
+
+// Closes the file or syslog connection.
+func (l *Log) Close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.writer.Close()
+}
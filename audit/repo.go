@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+// Repo counts the pushes to a repository and writes a push record every interval in which there were any, and a last
+// one on close. Repositories sending in the background (see repo.Reporter) only buffer what is pushed, a send record
+// is written for each batch they report instead, with its outcome.
+type Repo struct {
+	repo        repo.Interface
+	log         *Log
+	name        string
+	destination string
+	interval    time.Duration
+
+	mutex  sync.Mutex
+	stats  int
+	events int
+	failed int
+	err    string // last push error.
+
+	quit chan bool
+	done chan bool
+}
+
+// Creates a repository that audits the pushes to the given one, named name and pushing to destination, such as its
+// address. Records are written every interval, in the background.
+func NewRepo(repository repo.Interface, log *Log, name string, destination string, interval time.Duration) *Repo {
+	r := &Repo{
+		repo:        repository,
+		log:         log,
+		name:        name,
+		destination: destination,
+		interval:    interval,
+		quit:        make(chan bool),
+		done:        make(chan bool),
+	}
+
+	if reporter, ok := repository.(repo.Reporter); ok {
+		reporter.Report(r.sent)
+	}
+
+	go r.loop()
+
+	return r
+}
+
+func (*Repo) Name() string {
+	return "audit"
+}
+
+func (r *Repo) Create(v interface{}) (repo.Interface, error) {
+	return NewRepo(r.repo, r.log, r.name, r.destination, r.interval), nil
+}
+
+func (r *Repo) Push(s *stats.Stats) error {
+	err := r.repo.Push(s)
+	r.count(&r.stats, err)
+
+	return err
+}
+
+// Pushes the event if the repository can store events, only those are counted.
+func (r *Repo) PushEvent(event *stats.Event) error {
+	pusher, ok := r.repo.(repo.EventPusher)
+	if !ok {
+		return nil
+	}
+
+	err := pusher.PushEvent(event)
+	r.count(&r.events, err)
+
+	return err
+}
+
+// Pushes the volume if the repository can store volumes, only failures are counted.
+func (r *Repo) PushVolume(volume *stats.Volume) error {
+	pusher, ok := r.repo.(repo.VolumePusher)
+	if !ok {
+		return nil
+	}
+
+	err := pusher.PushVolume(volume)
+	if err != nil {
+		r.count(nil, err)
+	}
+
+	return err
+}
+
+// Pushes the processes if the repository can store processes, only failures are counted.
+func (r *Repo) PushProcesses(processes *stats.Processes) error {
+	pusher, ok := r.repo.(repo.ProcessPusher)
+	if !ok {
+		return nil
+	}
+
+	err := pusher.PushProcesses(processes)
+	if err != nil {
+		r.count(nil, err)
+	}
+
+	return err
+}
+
+// Writes the last record and closes the repository.
+func (r *Repo) Close() {
+	close(r.quit)
+	<-r.done
+
+	r.repo.Close()
+}
+
+func (r *Repo) Clear(name string) {
+	r.repo.Clear(name)
+}
+
+func (r *Repo) ClearHost(host string, name string) {
+	repo.ClearHost(r.repo, host, name)
+}
+
+func (r *Repo) ContainerAdded(container repo.Container) {
+	if lifecycle, ok := r.repo.(repo.Lifecycle); ok {
+		lifecycle.ContainerAdded(container)
+	}
+}
+
+func (r *Repo) ContainerRemoved(container repo.Container) {
+	if lifecycle, ok := r.repo.(repo.Lifecycle); ok {
+		lifecycle.ContainerRemoved(container)
+	}
+}
+
+// Counts a push on pushed, or as failed if err is not nil.
+func (r *Repo) count(pushed *int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err != nil {
+		r.failed++
+		r.err = err.Error()
+	} else {
+		*pushed++
+	}
+}
+
+// Writes a record of a batch sent in the background, what it carried is failed if err is not nil.
+func (r *Repo) sent(count int, err error) {
+	record := Record{
+		Type:        TYPE_SEND,
+		Repository:  r.name,
+		Destination: r.destination,
+	}
+
+	if err != nil {
+		record.Failed, record.Error = count, err.Error()
+	} else {
+		record.Stats = count
+	}
+
+	r.log.Write(record)
+}
+
+func (r *Repo) loop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.flush()
+		case <-r.quit:
+			r.flush()
+			return
+		}
+	}
+}
+
+// Writes a record of the pushes since the previous one, if any, and resets the counts.
+func (r *Repo) flush() {
+	r.mutex.Lock()
+	record := Record{
+		Type:        TYPE_PUSH,
+		Repository:  r.name,
+		Destination: r.destination,
+		Stats:       r.stats,
+		Events:      r.events,
+		Failed:      r.failed,
+		Error:       r.err,
+	}
+	r.stats, r.events, r.failed, r.err = 0, 0, 0, ""
+	r.mutex.Unlock()
+
+	if record.Stats+record.Events+record.Failed > 0 {
+		r.log.Write(record)
+	}
+}
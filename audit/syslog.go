@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package audit
+
+import (
+	"io"
+	"log/syslog"
+	"net/url"
+)
+
+// Connects to the local syslog daemon, or to the remote one of a syslog:// (UDP) or syslog+tcp:// address.
+func dialSyslog(target string) (io.WriteCloser, error) {
+	priority := syslog.LOG_INFO | syslog.LOG_AUTH
+
+	if target == "syslog" {
+		return syslog.New(priority, "statspout")
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	network := "udp"
+	if u.Scheme == "syslog+tcp" {
+		network = "tcp"
+	}
+
+	return syslog.Dial(network, u.Host, priority, "statspout")
+}
@@ -0,0 +1,11 @@
+package audit
+
+import (
+	"errors"
+	"io"
+)
+
+// Syslog is not available on Windows, write the audit log to a file instead.
+func dialSyslog(target string) (io.WriteCloser, error) {
+	return nil, errors.New("Syslog is not supported on Windows, use a file for the audit log.")
+}
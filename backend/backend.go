@@ -1,41 +1,104 @@
 package backend
 
 import (
-	"bufio"
-	"encoding/json"
+	"bytes"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"math"
+	"net"
 	"net/http"
-	"net/http/httputil"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mijara/statspout/log"
 	"github.com/mijara/statspout/repo"
 	"github.com/mijara/statspout/stats"
+	"github.com/mijara/statspout/telemetry"
 )
 
 const (
 	STATS_QUERY = "/containers/%s/stats?stream=0"
+
+	// returns immediately instead of waiting for a second sample, precpu_stats is left empty.
+	STATS_ONE_SHOT_QUERY = "/containers/%s/stats?stream=0&one-shot=true"
+
+	// weight of the newest sample in the moving average of the request latency.
+	LATENCY_WEIGHT = 0.2
+
+	// stages of a query reported to the telemetry: waiting for a daemon, until the response headers, decoding a
+	// frame and pushing it to the repository.
+	STAGE_SCHEDULE = "schedule"
+	STAGE_REQUEST  = "request"
+	STAGE_DECODE   = "decode"
+	STAGE_PUSH     = "push"
+
+	// default number of queries queued while every daemon is busy, enough for thousands of containers.
+	QUERY_QUEUE_SIZE = 8192
+)
+
+var (
+	// queries waiting for a daemon, of every client.
+	queued = telemetry.Default.Level("queue_depth")
+
+	// queries dropped because the container still had one pending or the queue was full, of every client.
+	skipped = telemetry.Default.Level("queries_skipped")
 )
 
+// Size of the query queue of new clients, see SetQueueSize.
+var queueSize = QUERY_QUEUE_SIZE
+
+// Sets the number of queries queued while every daemon is busy, further queries are skipped until there is room.
+// Must be called before creating clients.
+func SetQueueSize(size int) {
+	queueSize = size
+}
+
 // Client holding data for the Backend.
 type Client struct {
-	service *Service       // the service to handle multiple daemons as a pipeline.
-	daemons int            // the number of daemons.
-	repo    repo.Interface // the repository to push stats.
-	exit    bool           // did this client exited.
-
-	clients   chan *httputil.ClientConn // queue of clients for daemons.
-	dedicated *httputil.ClientConn      // dedicated client for side requests.
+	service    *Service       // the service to handle multiple daemons as a pipeline.
+	daemons    int            // the number of daemons.
+	minDaemons int            // lower bound of daemons when autoscaling.
+	maxDaemons int            // upper bound of daemons when autoscaling, autoscaling is disabled if not greater than min.
+	pipeline   int            // number of daemons sharing each connection.
+	repo       repo.Interface // the repository to push stats.
+	exit       atomic.Bool    // did this client exited, read by the daemons.
+
+	network string // tcp, unix or npipe, see Dial.
+	address string // address of the endpoint, socket or pipe path.
+	host    string // identity of the Docker host, used in the idempotency key of the samples.
+
+	mutex sync.Mutex // guards the pool size.
+
+	latencyMutex sync.Mutex    // guards the latency.
+	latency      time.Duration // moving average of the stats requests latency.
+
+	oneShot    bool         // use one-shot stats requests.
+	execEvents bool         // push exec and attach events.
+	baselines  *baselineMap // last CPU stats of each container, used on one-shot requests.
+	pending    *pendingSet  // containers with a query queued or running.
+	limits     sync.Map     // limits and settings of each container by name, see containerLimits.
+	images     sync.Map     // creation time of each image by ID, see imageCreated.
+	pids       sync.Map     // process ID of each container by name, inspected along its limits.
+	proc       string       // path to the /proc of the Docker host, file descriptors are not read if empty.
+	tcp        bool         // count the TCP connections of the containers from /proc.
+	identity   *Identity    // identity of the containers in what is pushed, their name if nil.
+	excluded   []string     // patterns of the interfaces left out of the network totals.
+	perIface   bool         // push the network stats of each interface along the totals.
+
+	clients   chan *dockerConn // queue of clients for daemons, a connection is queued once per daemon sharing it.
+	shared    *dockerConn      // last connection dialed for daemons, shared until pipeline daemons use it.
+	dedicated *dockerConn      // dedicated client for side requests.
 
 	events *EventsMonitor // monitor attached to the events API.
 }
 
 // Work to process by daemons.
 type Workload struct {
-	connection *httputil.ClientConn // connection on which the request is going to be made.
-	container  Container           // container object to request.
+	container Container // container object to request.
+	queued    time.Time // when the query was made.
 }
 
 // Cpu Usage reported by the Docker Stats API.
@@ -50,23 +113,51 @@ type CpuStats struct {
 	SystemCpuUsage uint64   `json:"system_cpu_usage"`
 }
 
+// Copy not sharing the per-CPU usage, which is reused by the next decoded frame.
+func (c CpuStats) copy() CpuStats {
+	c.Usage.PerCpu = append([]uint64(nil), c.Usage.PerCpu...)
+	return c
+}
+
 // Memory Stats reported by the Docker Stats API.
 type MemoryStats struct {
-	Usage uint64 `json:"usage"`
-	Limit uint64 `json:"limit"`
+	Usage   uint64 `json:"usage"`
+	Limit   uint64 `json:"limit"`
+	Failcnt uint64 `json:"failcnt"` // times the usage hit the limit.
+
+	Detail MemoryDetail `json:"stats"` // detailed stats of the cgroup, only the swap ones are read.
+}
+
+// Detailed memory stats reported by the Docker Stats API, swap is only reported on cgroup v1 hosts with swap
+// accounting enabled.
+type MemoryDetail struct {
+	Swap        uint64 `json:"swap"`
+	MemswLimit  uint64 `json:"hierarchical_memsw_limit"`  // memory plus swap limit.
+	MemoryLimit uint64 `json:"hierarchical_memory_limit"` // memory limit of the cgroup.
+}
+
+// Block I/O stats reported by the Docker Stats API, totals of the reads and writes of every device. The service time
+// and queue are only reported on cgroup v1 hosts, they stay 0 on cgroup v2.
+type BlkioStats struct {
+	ServiceTime uint64 `json:"service_time"` // time spent serving requests, in nanoseconds.
+	Queued      uint64 `json:"queued"`       // requests waiting to be served.
+	ReadBytes   uint64 `json:"read_bytes"`
+	WriteBytes  uint64 `json:"write_bytes"`
+	Reads       uint64 `json:"reads"`  // read requests served.
+	Writes      uint64 `json:"writes"` // write requests served.
 }
 
 // Network Interface stats.
 type InterfaceStats struct {
-	RxBytes   uint32 `json:"rx_bytes"`
-	RxDropped uint32 `json:"rx_dropped"`
-	RxErrors  uint32 `json:"rx_errors"`
-	RxPackets uint32 `json:"rx_packets"`
-
-	TxBytes   uint32 `json:"tx_bytes"`
-	TxDropped uint32 `json:"tx_dropped"`
-	TxErrors  uint32 `json:"tx_errors"`
-	TxPackets uint32 `json:"tx_packets"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxDropped uint64 `json:"rx_dropped"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxPackets uint64 `json:"rx_packets"`
+
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxDropped uint64 `json:"tx_dropped"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxPackets uint64 `json:"tx_packets"`
 }
 
 // Container Stats reported by the Docker Stats API.
@@ -78,62 +169,133 @@ type ContainerStats struct {
 
 	Networks map[string]InterfaceStats `json:"networks"`
 
+	Blkio BlkioStats `json:"blkio_stats"`
+
 	Read time.Time `json:"read"`
 }
 
 // Container struct to unmarshal JSON response form Docker List Containers API.
 type Container struct {
-	Names  []string          `json:"Names"`
-	Labels map[string]string `json:"Labels"`
+	ID      string            `json:"Id"`
+	Names   []string          `json:"Names"`
+	Image   string            `json:"Image"`
+	ImageID string            `json:"ImageID"`
+	Labels  map[string]string `json:"Labels"`
 
 	CanonicalName string
 }
 
 type ContainerInspect struct {
-	Name string `json:"Name"`
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	Image string `json:"Image"` // ID of the image.
 
 	Config struct {
+		Image  string            `json:"Image"`
 		Labels map[string]string `json:"Labels"`
 	} `json:"Config"`
 }
 
-// Creates a new Backend Client, which uses the given repository, can be created as a HTTP or Socket
-// client, specified by the http parameter. The address parameter must point to the endpoint or socket path,
-// finally, n will be the number of daemons available to take requests.
-func New(repo repo.Interface, http bool, address string, n int) (*Client, error) {
+// Docker endpoint, network is "unix" (address is the socket path), "npipe" (address is the named pipe path, on
+// Windows) or "tcp" (address is host:port). Labels, if any, are added to every sample of the host.
+type Endpoint struct {
+	Network string            `json:"network"`
+	Address string            `json:"address"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// Parses an endpoint given as unix:///path/to/socket, npipe:////./pipe/name (or npipe://./pipe/name),
+// tcp://host:port or host:port.
+func ParseEndpoint(value string) (Endpoint, error) {
+	switch {
+	case strings.HasPrefix(value, "unix://"):
+		return Endpoint{Network: "unix", Address: strings.TrimPrefix(value, "unix://")}, nil
+	case strings.HasPrefix(value, "npipe://"):
+		return Endpoint{Network: "npipe", Address: pipePath(strings.TrimPrefix(value, "npipe://"))}, nil
+	case strings.HasPrefix(value, "tcp://"):
+		value = strings.TrimPrefix(value, "tcp://")
+	case strings.Contains(value, "://"):
+		return Endpoint{}, errors.New("Unknown endpoint: " + value)
+	}
+
+	if _, _, err := net.SplitHostPort(value); err != nil {
+		return Endpoint{}, err
+	}
+
+	return Endpoint{Network: "tcp", Address: value}, nil
+}
+
+// Path of a named pipe given after npipe://, which drops one of the leading slashes when written as npipe://./pipe.
+func pipePath(path string) string {
+	if strings.HasPrefix(path, "./") || strings.HasPrefix(path, `.\`) {
+		return "//" + path
+	}
+
+	return path
+}
+
+// Creates a new Backend Client, which uses the given repository, connecting through the given network (see Dial).
+// The address parameter must point to the endpoint, socket or named pipe path, finally, n will be the number of
+// daemons available to take requests. If max is greater than n, the
+// number of daemons will be scaled between both values as needed (see Autoscale). Up to pipeline daemons share
+// each connection, which saves file descriptors on hosts with many containers, but their requests are serialized on
+// it (see dockerConn).
+func New(repo repo.Interface, network string, address string, n int, max int, pipeline int) (*Client, error) {
+	if pipeline < 1 {
+		pipeline = 1
+	}
+
 	// create a client with simple information.
 	cli := &Client{
-		repo:    repo,
-		daemons: n,
+		repo:       repo,
+		daemons:    n,
+		minDaemons: n,
+		maxDaemons: max,
+		pipeline:   pipeline,
+		network:    network,
+		address:    address,
+		baselines:  newBaselineMap(),
+		pending:    newPendingSet(),
 	}
 
-	// create the service to hold daemons.
-	cli.service = NewService(n, cli.process, cli.onError)
+	// create the service to hold daemons, queries are queued for them instead of waiting for one.
+	cli.service = NewService(n, queueSize, cli.process, cli.onError)
 
-	// create the channel for client connections.
-	cli.clients = make(chan *httputil.ClientConn, n)
+	// create the channel for client connections, big enough to hold the largest pool.
+	size := n
+	if max > size {
+		size = max
+	}
+	cli.clients = make(chan *dockerConn, size)
 
-	// for each daemon, create one client connection for them to work with.
+	// for each daemon, queue a client connection for them to work with.
 	for i := 0; i < n; i++ {
-		conn, err := createConn(http, address)
-		if err != nil {
+		if err := cli.addClient(); err != nil {
+			cli.abort()
 			return nil, err
 		}
-
-		cli.clients <- httputil.NewClientConn(conn, nil)
 	}
 
 	log.Info.Printf("%d daemons clients created.", n)
 
 	// create a dedicated client connection for side requests.
-	conn, err := createConn(http, address)
+	dedicated, err := dialDocker(network, address)
 	if err != nil {
+		cli.abort()
 		return nil, err
 	}
-	cli.dedicated = httputil.NewClientConn(conn, nil)
+	cli.dedicated = dedicated
 
-	cli.events, err = NewEventsMonitor(http, address)
+	// the daemon ID is the same whatever the address used to reach it.
+	cli.host, err = cli.daemonID()
 	if err != nil {
+		log.Warning.Printf("Could not get the Docker daemon ID, using its address instead: %s", err.Error())
+		cli.host = address
+	}
+
+	cli.events, err = NewEventsMonitor(network, address)
+	if err != nil {
+		cli.abort()
 		return nil, err
 	}
 
@@ -142,66 +304,229 @@ func New(repo repo.Interface, http bool, address string, n int) (*Client, error)
 	return cli, nil
 }
 
-// Queries the Docker Stats API for a container given by the canonical name.
-func (cli *Client) Query(container Container) {
-	// take one client connection, will block until there's one available.
+// Enables one-shot stats requests, needed for sub-second intervals since regular requests wait for a second
+// sample from the daemon. CPU usage is then calculated against the previous request of each container.
+func (cli *Client) SetOneShot(oneShot bool) {
+	cli.oneShot = oneShot
+}
+
+// Pushes the exec and attach events of the containers to the repository, such as the commands run, to audit the
+// access to the containers. Must be called before StartMonitor.
+func (cli *Client) SetExecEvents(enabled bool) {
+	cli.execEvents = enabled
+}
+
+// Reads the open file descriptors of the containers from the given /proc, which must be the one of the Docker host:
+// statspout must run on the host, or in a container sharing its PID namespace or mounting it. Disabled if empty.
+func (cli *Client) SetProc(path string) {
+	cli.proc = path
+}
+
+// Counts the established and time-wait TCP connections of the containers from the /proc given to SetProc. Reading
+// them is proportional to the connections, so it is left disabled unless asked.
+func (cli *Client) SetConnections(enabled bool) {
+	cli.tcp = enabled
+}
+
+// Identifies the containers in the pushed samples, events, volumes and processes, and when clearing them from the
+// repository. Containers are identified by name if nil. Must be called before StartMonitor.
+func (cli *Client) SetIdentity(identity *Identity) {
+	cli.identity = identity
+}
+
+// Leaves the interfaces matching any of the patterns, in the path.Match syntax, out of the network totals, such as
+// the bridges and overlays counting the same traffic twice on Swarm nodes. Every interface is counted if empty.
+func (cli *Client) SetExcludedInterfaces(patterns []string) {
+	cli.excluded = patterns
+}
+
+// Pushes the network stats of each interface, but the excluded ones, along the totals, to tell apart the networks of
+// containers attached to several. Only the totals are pushed by default.
+func (cli *Client) SetPerInterface(enabled bool) {
+	cli.perIface = enabled
+}
+
+// Identity of the container in what is pushed, see SetIdentity.
+func (cli *Client) Identify(container Container) string {
+	return cli.identity.Of(container)
+}
+
+// Queries the Docker Stats API for a container given by the canonical name. Never blocks: the query is queued for
+// the daemons, or skipped if the container still has one pending or the queue is full, returning false.
+func (cli *Client) Query(container Container) bool {
+	if cli.exit.Load() {
+		return false
+	}
+
+	// a container is queried once at a time, a slow daemon would otherwise pile up queries.
+	if !cli.pending.add(container.CanonicalName) {
+		skipped.Add(1)
+		return false
+	}
+
+	queued.Add(1)
+
+	// the daemon taking the workload picks a connection, see process.
+	if !cli.service.TrySend(Workload{container: container, queued: time.Now()}) {
+		queued.Add(-1)
+		skipped.Add(1)
+		cli.pending.remove(container.CanonicalName)
+		return false
+	}
+
+	return true
+}
+
+// Resizes the daemon pool so every container can be queried within half of the interval, given the
+// observed latency of the stats requests. The pool is kept between the bounds given to New.
+func (cli *Client) Autoscale(containers int, interval time.Duration) error {
+	if cli.maxDaemons <= cli.minDaemons || cli.exit.Load() {
+		return nil
+	}
+
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+
+	latency := cli.Latency()
+
+	needed := int(math.Ceil(float64(containers) * float64(latency) / float64(interval/2)))
+	if needed < cli.minDaemons {
+		needed = cli.minDaemons
+	} else if needed > cli.maxDaemons {
+		needed = cli.maxDaemons
+	}
+
+	if needed == cli.daemons {
+		return nil
+	}
+
+	log.Info.Printf("Resizing pool from %d to %d daemons (latency: %s).", cli.daemons, needed, latency)
+
+	// add connections and daemons for them to work with.
+	for cli.daemons < needed {
+		if err := cli.addClient(); err != nil {
+			return err
+		}
+
+		cli.service.Grow(1)
+		cli.daemons++
+	}
+
+	// remove daemons and its connections, waiting for them to be released.
+	for cli.daemons > needed {
+		cli.service.Shrink(1)
+		cli.removeClient()
+		cli.daemons--
+	}
+
+	return nil
+}
+
+// Queues a client connection for a new daemon, sharing the last connection dialed unless pipeline daemons use it
+// already.
+func (cli *Client) addClient() error {
+	if cli.shared == nil || cli.shared.daemons >= cli.pipeline {
+		conn, err := dialDocker(cli.network, cli.address)
+		if err != nil {
+			return err
+		}
+
+		cli.shared = conn
+	}
+
+	cli.shared.daemons++
+	cli.clients <- cli.shared
+
+	return nil
+}
+
+// Takes the client connection of a removed daemon, waiting for it to be released, and closes it once no daemon
+// shares it.
+func (cli *Client) removeClient() {
 	conn := <-cli.clients
 
-	// send the workload to the service, which will then select one daemon for the task.
-	cli.service.Send(Workload{
-		connection: conn,
-		container:  container,
-	})
+	conn.daemons--
+	if conn.daemons > 0 {
+		return
+	}
 
-	// send back the client connection (this will never block).
-	cli.clients <- conn
+	conn.Close()
+	if conn == cli.shared {
+		cli.shared = nil
+	}
 }
 
-// Get containers names currently available in the Docker instance (only the ones that are running).
-func (cli *Client) GetContainers() (map[string]Container, error) {
-	req, err := http.NewRequest("GET", "/containers/json", nil)
+// Queries the Docker Info API for the ID of the daemon.
+func (cli *Client) daemonID() (string, error) {
+	req, err := http.NewRequest("GET", "/info", nil)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	res, err := cli.dedicated.Do(req)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New("Unexpected status: " + res.Status)
 	}
 
-	var containers []Container
-	json.Unmarshal(body, &containers)
-
-	result := make(map[string]Container)
+	var info struct {
+		ID string `json:"ID"`
+	}
+	if err := decode(ENDPOINT_INFO, res.Body, &info, infoFields); err != nil {
+		return "", err
+	}
 
-	for _, container := range containers {
-		container.CanonicalName = container.Names[0][1:]
-		result[container.CanonicalName] = container
+	if info.ID == "" {
+		return "", errors.New("Empty daemon ID.")
 	}
 
-	return result, nil
+	return info.ID, nil
 }
 
-func (cli *Client) StartMonitor(containers map[string]Container) {
+// Keeps the containers up to date with the events of the daemon, in the background.
+func (cli *Client) StartMonitor(containers *Containers) {
 	cli.events.monitor(cli, containers)
 }
 
-// Closes all connections and Goroutines.
+// Releases what New created before failing, since hosts can be added at runtime.
+func (cli *Client) abort() {
+	cli.service.Close()
+
+	for len(cli.clients) > 0 {
+		cli.removeClient()
+	}
+
+	if cli.dedicated != nil {
+		cli.dedicated.Close()
+	}
+}
+
+// Closes all connections and Goroutines, once.
 func (cli *Client) Close() {
-	cli.exit = true
+	if cli.exit.Swap(true) {
+		return
+	}
+
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
 
 	cli.events.Close()
 	cli.service.Close()
 
+	// the queries left in the queue are never made.
+	for _, feed := range cli.service.Drain() {
+		if wl, ok := feed.(Workload); ok {
+			queued.Add(-1)
+			cli.pending.remove(wl.container.CanonicalName)
+		}
+	}
+
 	for i := 0; i < cli.daemons; i++ {
-		conn := <-cli.clients
-		conn.Close()
+		cli.removeClient()
 	}
 
 	cli.dedicated.Close()
@@ -210,61 +535,289 @@ func (cli *Client) Close() {
 // Process a single requests, this will be spawned by the some daemon and it meant to be used
 // as a callback routine.
 func (cli *Client) process(v interface{}) error {
-	// client wants to exit, ignore workload.
-	if cli.exit {
-		return nil
-	}
-
 	// assert the type of the workload.
 	wl, ok := v.(Workload)
 	if !ok {
 		return errors.New(fmt.Sprintf("This is not a workload %T", v))
 	}
 
+	queued.Add(-1)
+	defer cli.pending.remove(wl.container.CanonicalName)
+
+	// client wants to exit, ignore workload.
+	if cli.exit.Load() {
+		return nil
+	}
+
+	query := STATS_QUERY
+	if cli.oneShot {
+		query = STATS_ONE_SHOT_QUERY
+	}
+
 	// create the request for stats.
-	req, err := http.NewRequest("GET", fmt.Sprintf(STATS_QUERY, wl.container.CanonicalName), nil)
+	req, err := newRequest("GET", fmt.Sprintf(query, wl.container.CanonicalName))
 	if err != nil {
 		return err
 	}
 
+	// take one client connection, will block only while the pool is borrowed or resized.
+	conn := <-cli.clients
+	defer func() {
+		cli.clients <- conn
+	}()
+
+	telemetry.Default.Observe(STAGE_SCHEDULE, time.Since(wl.queued))
+
+	d := details{}
+
+	// the limits are left empty if the container could not be inspected, and inspected again on the next query.
+	d.limits, d.settings, err = cli.containerLimits(conn, wl.container.CanonicalName)
+	if err != nil {
+		log.Debug.Printf("Could not get the limits of %s: %s", wl.container.CanonicalName, err.Error())
+	}
+
+	// likewise, the image age is left unknown.
+	if wl.container.ImageID != "" {
+		d.created, err = cli.imageCreated(conn, wl.container.ImageID)
+		if err != nil {
+			log.Debug.Printf("Could not get the image of %s: %s", wl.container.CanonicalName, err.Error())
+		}
+	}
+
+	// and the file descriptors are left empty, for example if the process is not visible.
+	if cli.proc != "" {
+		d.fds, err = cli.containerDescriptors(wl.container.CanonicalName)
+		if err != nil {
+			log.Debug.Printf("Could not get the file descriptors of %s: %s", wl.container.CanonicalName, err.Error())
+		}
+	}
+
+	if cli.proc != "" && cli.tcp {
+		d.tcp, err = cli.containerConnections(wl.container.CanonicalName)
+		if err != nil {
+			log.Debug.Printf("Could not get the connections of %s: %s", wl.container.CanonicalName, err.Error())
+		}
+	}
+
 	// request using the client.
-	res, err := wl.connection.Do(req)
+	start := time.Now()
+
+	res, err := conn.Do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
-	// here, since the stats API is a stream, we have to read until the delimiter, and then break with EOF.
-	reader := bufio.NewReader(res.Body)
+	telemetry.Default.Observe(STAGE_REQUEST, time.Since(start))
+
+	body, release, err := responseBody(res)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// the stats API is a stream of JSON objects delimited by newlines, each one is decoded in place as it arrives.
+	reader := acquireReader(body)
+	defer releaseReader(reader)
+
 	for {
-		line, err := reader.ReadBytes('\n')
-		if err != nil {
-			break // EOF is an error.
+		frame, err := readFrame(reader)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
 		}
 
-		container := &ContainerStats{}
-		err = json.Unmarshal(line, container)
-		if err != nil {
+		if len(bytes.TrimSpace(frame)) == 0 {
+			continue
+		}
+
+		if err := cli.push(wl.container, d, frame); err != nil {
 			// this error could mean that the container does not exists.
 			return err
 		}
+	}
 
-		// push the stats to the repository, calculating relevant data.
-		cli.repo.Push(&stats.Stats{
-			MemoryPercent: calcMemoryPercent(container),
-			CpuPercent:    calcCpuPercent(container),
-			MemoryUsage:   container.Memory.Usage,
-			TxBytesTotal:  sumTxBytesTotal(container.Networks),
-			RxBytesTotal:  sumRxBytesTotal(container.Networks),
-			Timestamp:     container.Read,
-			Name:          wl.container.CanonicalName,
-			Labels:        wl.container.Labels,
-		})
+	cli.observe(time.Since(start))
+
+	return nil
+}
+
+// What is known of a container besides its stats, gathered before each query.
+type details struct {
+	limits   Limits             // resource limits, empty if not inspected.
+	settings Settings           // restart policy, privileged and network mode, empty if not inspected.
+	created  time.Time          // creation time of the image, zero if unknown.
+	fds      Descriptors        // file descriptors of the init process, empty if not read.
+	tcp      *stats.Connections // TCP connections of the network namespace, nil if not read.
+}
+
+// Decodes a stats frame of the container and pushes it to the repository, calculating relevant data. The decoded
+// stats and the sample are reused once pushed.
+func (cli *Client) push(c Container, d details, frame []byte) error {
+	container := acquireContainerStats()
+	defer releaseContainerStats(container)
+
+	start := time.Now()
+	if err := unmarshal(ENDPOINT_STATS, frame, container, statsFields); err != nil {
+		return err
+	}
+	telemetry.Default.Observe(STAGE_DECODE, time.Since(start))
+
+	// the previous counters give the CPU usage of one-shot requests and the block I/O rates.
+	current := Baseline{Blkio: container.Blkio, Read: container.Read}
+	if cli.oneShot {
+		current.Cpu = container.Cpu.copy()
+	}
+
+	previous, ok := cli.baselines.swap(c.CanonicalName, current)
+	if cli.oneShot {
+		container.PreCpu = previousCpu(previous, ok, current)
+	}
+
+	rates := calcBlkioRates(previous, ok, current)
+
+	s := stats.Acquire()
+	defer stats.Release(s)
+
+	network := sumNetworks(container.Networks, cli.excluded)
+
+	*s = stats.Stats{
+		MemoryPercent:    calcMemoryPercent(container),
+		CpuPercent:       calcCpuPercent(container),
+		MemoryUsage:      container.Memory.Usage,
+		MemoryFailcnt:    container.Memory.Failcnt,
+		MemoryLimit:      container.Memory.Limit,
+		MemoryLimited:    d.limits.Memory > 0,
+		CpuLimit:         d.limits.Cpus,
+		CpuShares:        d.limits.CpuShares,
+		RestartPolicy:    d.settings.RestartPolicy,
+		Privileged:       d.settings.Privileged,
+		NetworkMode:      d.settings.NetworkMode,
+		OpenFds:          d.fds.Open,
+		FdLimit:          d.fds.Limit,
+		Tcp:              d.tcp,
+		SwapUsage:        container.Memory.Detail.Swap,
+		SwapLimit:        calcSwapLimit(container),
+		BlkioServiceTime: container.Blkio.ServiceTime,
+		BlkioServiced:    container.Blkio.Reads + container.Blkio.Writes,
+		BlkioQueue:       container.Blkio.Queued,
+		BlkioReadBytes:   container.Blkio.ReadBytes,
+		BlkioWriteBytes:  container.Blkio.WriteBytes,
+		BlkioReads:       container.Blkio.Reads,
+		BlkioWrites:      container.Blkio.Writes,
+		BlkioReadBps:     rates.readBytes,
+		BlkioWriteBps:    rates.writeBytes,
+		BlkioReadIops:    rates.reads,
+		BlkioWriteIops:   rates.writes,
+		TxBytesTotal:     network.TxBytes,
+		RxBytesTotal:     network.RxBytes,
+		TxPacketsTotal:   network.TxPackets,
+		RxPacketsTotal:   network.RxPackets,
+		TxErrorsTotal:    network.TxErrors,
+		RxErrorsTotal:    network.RxErrors,
+		TxDroppedTotal:   network.TxDropped,
+		RxDroppedTotal:   network.RxDropped,
+		Timestamp:        container.Read,
+		Name:             cli.Identify(c),
+		Image:            c.Image,
+		ImageCreated:     d.created,
+		ImageAgeDays:     imageAgeDays(d.created, container.Read),
+		Labels:           c.Labels,
+		ID:               stats.Key(cli.host, c.CanonicalName, container.Read),
 	}
 
+	if cli.perIface {
+		s.Interfaces = perInterface(container.Networks, cli.excluded)
+	}
+
+	start = time.Now()
+	cli.repo.Push(s)
+	telemetry.Default.Observe(STAGE_PUSH, time.Since(start))
+
 	return nil
 }
 
+// CPU stats of the previous sample of a one-shot request. Without one (or only queried by regular requests), or if the
+// counters were reset since (a restored baseline of a container that restarted in the meantime), the current ones,
+// reporting no usage.
+func previousCpu(previous Baseline, ok bool, current Baseline) CpuStats {
+	if !ok || previous.Cpu.SystemCpuUsage == 0 || current.Cpu.Usage.Total < previous.Cpu.Usage.Total ||
+		current.Cpu.SystemCpuUsage < previous.Cpu.SystemCpuUsage {
+		return current.Cpu
+	}
+
+	return previous.Cpu
+}
+
+// Last counters seen of each container, to be restored after a restart, see Restore.
+func (cli *Client) Baselines() map[string]Baseline {
+	return cli.baselines.all()
+}
+
+// Restores the counters seen before a restart, so the first one-shot request of each container derives its rates
+// from them. Baselines older than BASELINE_MAX_AGE are discarded.
+func (cli *Client) Restore(baselines map[string]Baseline) {
+	for name, baseline := range baselines {
+		if time.Since(baseline.Read) <= BASELINE_MAX_AGE {
+			cli.baselines.set(name, baseline)
+		}
+	}
+}
+
+// Tells the repository about the container, if it manages containers (see repo.Lifecycle).
+func (cli *Client) Added(container Container) {
+	if lifecycle, ok := cli.repo.(repo.Lifecycle); ok {
+		lifecycle.ContainerAdded(cli.lifecycle(container))
+	}
+}
+
+// Clears the container from the repository, and tells it the container is gone if it manages containers.
+func (cli *Client) Removed(container Container) {
+	cli.repo.Clear(cli.Identify(container))
+
+	if lifecycle, ok := cli.repo.(repo.Lifecycle); ok {
+		lifecycle.ContainerRemoved(cli.lifecycle(container))
+	}
+}
+
+// The container as given to the lifecycle callbacks, named by its identity.
+func (cli *Client) lifecycle(container Container) repo.Container {
+	return repo.Container{
+		Name:   cli.Identify(container),
+		ID:     container.ID,
+		Image:  container.Image,
+		Labels: container.Labels,
+	}
+}
+
+// Forgets the previous CPU stats of the container.
+func (cli *Client) forget(name string) {
+	cli.baselines.delete(name)
+	cli.forgetLimits(name)
+}
+
+// Moving average of the stats requests latency.
+func (cli *Client) Latency() time.Duration {
+	cli.latencyMutex.Lock()
+	defer cli.latencyMutex.Unlock()
+
+	return cli.latency
+}
+
+// Adds a sample to the moving average of the request latency.
+func (cli *Client) observe(latency time.Duration) {
+	cli.latencyMutex.Lock()
+	defer cli.latencyMutex.Unlock()
+
+	if cli.latency == 0 {
+		cli.latency = latency
+		return
+	}
+
+	cli.latency = time.Duration(LATENCY_WEIGHT*float64(latency) + (1-LATENCY_WEIGHT)*float64(cli.latency))
+}
+
 // Reports errors to STDERR.
 func (cli *Client) onError(err error) {
 	log.Error.Printf(err.Error())
@@ -272,7 +825,7 @@ func (cli *Client) onError(err error) {
 
 // RequestContainer ask the docker API for a single container data.
 func (cli *Client) RequestContainer(name string) (*Container, error) {
-	req, err := http.NewRequest("GET", "/containers/"+name+"/json", nil)
+	req, err := newRequest("GET", "/containers/"+name+"/json")
 	if err != nil {
 		return nil, err
 	}
@@ -283,11 +836,22 @@ func (cli *Client) RequestContainer(name string) (*Container, error) {
 	}
 	defer res.Body.Close()
 
+	body, release, err := responseBody(res)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	container := &ContainerInspect{}
-	json.NewDecoder(res.Body).Decode(container)
+	if err := decode(ENDPOINT_INSPECT, body, container, inspectFields); err != nil {
+		return nil, err
+	}
 
 	return &Container{
+		ID:            container.ID,
 		Names:         []string{container.Name},
+		Image:         container.Config.Image,
+		ImageID:       container.Image,
 		CanonicalName: name,
 		Labels:        container.Config.Labels,
 	}, nil
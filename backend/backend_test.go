@@ -0,0 +1,58 @@
+package backend_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/dockertest"
+)
+
+// Hosts are closed while their loop still queries them, as when removed from a fleet: queries are refused, never
+// sent on the closed queue.
+func TestQueryWhileClosing(t *testing.T) {
+	server := dockertest.NewServer()
+	defer server.Close()
+
+	containers := make([]backend.Container, 50)
+	for i := range containers {
+		name := fmt.Sprintf("c%02d", i)
+		server.AddContainer(name, nil)
+		containers[i] = backend.Container{CanonicalName: name, Names: []string{"/" + name}}
+	}
+
+	for round := 0; round < 10; round++ {
+		cli, err := backend.New(dockertest.NewRecorder(), server.Network(), server.Address(), 2, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cli.SetOneShot(true)
+
+		var wg sync.WaitGroup
+		var closed atomic.Bool
+
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for n := 0; !closed.Load(); n++ {
+					cli.Query(containers[n%len(containers)])
+				}
+			}()
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		cli.Close()
+		closed.Store(true)
+		wg.Wait()
+
+		if cli.Query(containers[0]) {
+			t.Fatalf("round %d: expected no query once closed", round)
+		}
+	}
+}
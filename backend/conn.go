@@ -0,0 +1,210 @@
+package backend
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+)
+
+// Connections idle for longer than this are pinged before being used, the daemon or a proxy may have dropped them.
+const CONN_IDLE_CHECK = 30 * time.Second
+
+// Connection to the Docker API, dialed again when it breaks, so a daemon restart heals without restarting statspout.
+// Requests may be sent concurrently, they take turns on the connection: the responses are read in order, and reading
+// one discards what is left of the previous body, so each request waits until the body of the previous one is closed.
+type dockerConn struct {
+	network string
+	address string
+
+	exchange sync.Mutex // held from each request until its response body is closed.
+
+	mutex  sync.Mutex
+	client *httputil.ClientConn
+	used   time.Time // time of the last request that went through.
+	closed bool
+
+	daemons int // daemons sharing the connection, guarded by the mutex of the client.
+}
+
+// Dials the Docker API at the given address, through the given network (see Dial).
+func dialDocker(network string, address string) (*dockerConn, error) {
+	c := &dockerConn{
+		network: network,
+		address: address,
+	}
+
+	client, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = client
+	c.used = time.Now()
+
+	return c, nil
+}
+
+// Sends the request, dialing again and sending it once more if the connection is broken. Only GET requests are sent
+// to the Docker API, so retrying is safe. A connection idle for longer than CONN_IDLE_CHECK is pinged first. The
+// exchange is recorded, if recording (see SetRecordDir). The connection is held until the response body is closed,
+// which callers must always do.
+func (c *dockerConn) Do(req *http.Request) (*http.Response, error) {
+	c.exchange.Lock()
+
+	res, err := c.do(req)
+	if err != nil {
+		c.exchange.Unlock()
+		return nil, err
+	}
+
+	res.Body = &exchangeBody{ReadCloser: res.Body, release: c.exchange.Unlock}
+	return res, nil
+}
+
+func (c *dockerConn) do(req *http.Request) (*http.Response, error) {
+	client, err := c.healthy()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err == nil {
+		c.touch()
+		record(req, res)
+		return res, nil
+	}
+
+	client, err = c.redial(client, err)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err = client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.touch()
+	record(req, res)
+	return res, nil
+}
+
+// Closes the connection, it is not dialed again.
+func (c *dockerConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	return c.client.Close()
+}
+
+// Current client, pinged first if idle for too long and dialed again if the ping fails.
+func (c *dockerConn) healthy() (*httputil.ClientConn, error) {
+	c.mutex.Lock()
+	client, idle := c.client, time.Since(c.used)
+	c.mutex.Unlock()
+
+	if idle < CONN_IDLE_CHECK {
+		return client, nil
+	}
+
+	err := ping(client)
+	if err == nil {
+		c.touch()
+		return client, nil
+	}
+
+	return c.redial(client, err)
+}
+
+// Replaces the broken client with a new connection, unless another request already did.
+func (c *dockerConn) redial(broken *httputil.ClientConn, cause error) (*httputil.ClientConn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil, errors.New("Connection closed.")
+	}
+
+	if c.client != broken {
+		return c.client, nil
+	}
+
+	client, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Warning.Printf("Connection to Docker at %s broke (%s), dialed again.", c.address, cause.Error())
+
+	broken.Close()
+	c.client = client
+	c.used = time.Now()
+
+	return client, nil
+}
+
+func (c *dockerConn) dial() (*httputil.ClientConn, error) {
+	conn, err := createConn(c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+
+	return httputil.NewClientConn(conn, nil), nil
+}
+
+func (c *dockerConn) touch() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.used = time.Now()
+}
+
+// Body of a response, releasing the connection to the next request once closed.
+type exchangeBody struct {
+	io.ReadCloser
+
+	release func()
+	once    sync.Once
+}
+
+// Closes the body, which reads what is left of it, and releases the connection.
+func (b *exchangeBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+
+	return err
+}
+
+// Checks that the Docker API answers on the connection.
+func ping(client *httputil.ClientConn) error {
+	req, err := http.NewRequest("GET", "/_ping", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// drain the body to keep the connection usable.
+	buffer := make([]byte, 64)
+	for {
+		if _, err := res.Body.Read(buffer); err != nil {
+			break
+		}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("Unexpected status: " + res.Status)
+	}
+
+	return nil
+}
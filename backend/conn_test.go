@@ -0,0 +1,96 @@
+package backend_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/dockertest"
+	"github.com/mijara/statspout/log"
+)
+
+// Writer keeping what the loggers write, from any goroutine.
+type logBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buffer.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buffer.String()
+}
+
+// Two daemons share a connection, each response is read whole while the other request waits its turn.
+func TestSharedConnectionConcurrentStats(t *testing.T) {
+	logs := &logBuffer{}
+	defer log.Redirect(logs)()
+
+	server := dockertest.NewServer()
+	defer server.Close()
+
+	// frames large enough to still be read when the other request goes out.
+	large := backend.ContainerStats{Networks: map[string]backend.InterfaceStats{}}
+	large.Memory.Usage, large.Memory.Limit = 64<<20, 1<<30
+	for i := 0; i < 20000; i++ {
+		large.Networks[fmt.Sprintf("veth%04d", i)] = backend.InterfaceStats{RxBytes: 1, TxBytes: 1}
+	}
+
+	names := []string{"web", "db"}
+	for _, name := range names {
+		server.AddContainer(name, nil)
+		server.SetStats(name, large)
+	}
+
+	recorder := dockertest.NewRecorder()
+
+	cli, err := backend.New(recorder, server.Network(), server.Address(), 2, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	const rounds = 20
+	for round := 1; round <= rounds; round++ {
+		for _, name := range names {
+			container := backend.Container{CanonicalName: name, Names: []string{"/" + name}}
+			for !cli.Query(container) {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		sampled := func(r *dockertest.Recorder) bool {
+			return len(r.Samples("web")) >= round && len(r.Samples("db")) >= round
+		}
+
+		if !recorder.Wait(5*time.Second, sampled) {
+			t.Fatalf("round %d: expected a sample of each container, got %d of web and %d of db", round,
+				len(recorder.Samples("web")), len(recorder.Samples("db")))
+		}
+	}
+
+	// a request reading its response cuts the previous body short, failing the request.
+	if strings.Contains(logs.String(), "ERROR") {
+		t.Errorf("expected every request to succeed, got:\n%s", logs.String())
+	}
+
+	for _, name := range names {
+		for _, s := range recorder.Samples(name) {
+			if s.RxBytesTotal != 20000 || s.MemoryUsage != 64<<20 {
+				t.Fatalf("%s: expected whole frames, got rx %d and memory %d", name, s.RxBytesTotal, s.MemoryUsage)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"sync"
+)
+
+// Running containers by canonical name, kept up to date by the events monitor while the collector reads them. Sharded
+// by name like the per-container state, see SHARDS.
+type Containers struct {
+	shards [SHARDS]struct {
+		mutex      sync.RWMutex
+		containers map[string]Container
+	}
+}
+
+// Containers starting with the listed ones, see GetContainers.
+func NewContainers(containers map[string]Container) *Containers {
+	c := &Containers{}
+	for i := range c.shards {
+		c.shards[i].containers = make(map[string]Container)
+	}
+
+	for name, container := range containers {
+		c.shards[shardOf(name)].containers[name] = container
+	}
+
+	return c
+}
+
+func (c *Containers) Get(name string) (Container, bool) {
+	shard := &c.shards[shardOf(name)]
+
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	container, ok := shard.containers[name]
+	return container, ok
+}
+
+// Adds or replaces the container, by its canonical name.
+func (c *Containers) Set(container Container) {
+	shard := &c.shards[shardOf(container.CanonicalName)]
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	shard.containers[container.CanonicalName] = container
+}
+
+func (c *Containers) Delete(name string) {
+	shard := &c.shards[shardOf(name)]
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	delete(shard.containers, name)
+}
+
+func (c *Containers) Len() int {
+	n := 0
+	for i := range c.shards {
+		shard := &c.shards[i]
+
+		shard.mutex.RLock()
+		n += len(shard.containers)
+		shard.mutex.RUnlock()
+	}
+
+	return n
+}
+
+// Copy of every container, which the monitor does not change while read.
+func (c *Containers) All() map[string]Container {
+	containers := make(map[string]Container)
+
+	for i := range c.shards {
+		shard := &c.shards[i]
+
+		shard.mutex.RLock()
+		for name, container := range shard.containers {
+			containers[name] = container
+		}
+		shard.mutex.RUnlock()
+	}
+
+	return containers
+}
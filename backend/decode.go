@@ -0,0 +1,609 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/telemetry"
+)
+
+// Default size of the buffer reading stats frames, which are decoded in place if they fit.
+const FRAME_BUFFER_SIZE = 16 << 10
+
+// Numbers of the stats frames out of the range of their field, taken as zero, see scanner.uint64.
+var outOfRange = telemetry.Default.Level("values_out_of_range")
+
+// Size of the buffer of new frame readers, see SetFrameBufferSize.
+var frameBufferSize = FRAME_BUFFER_SIZE
+
+// Readers of stats frames are reused across requests, keeping their buffer.
+var frameReaders = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReaderSize(nil, frameBufferSize)
+	},
+}
+
+// Sets the size of the buffer reading stats frames, larger frames are copied instead of decoded in place. Must be
+// called before creating clients.
+func SetFrameBufferSize(size int) {
+	frameBufferSize = size
+}
+
+// Decoded stats are reused across frames, keeping the per-CPU usage and networks allocated by previous ones.
+var containerStats = sync.Pool{
+	New: func() interface{} {
+		return new(ContainerStats)
+	},
+}
+
+// Gets a reader of the stats frames of the body from the pool.
+func acquireReader(body io.Reader) *bufio.Reader {
+	reader := frameReaders.Get().(*bufio.Reader)
+	reader.Reset(body)
+
+	return reader
+}
+
+// Puts the reader back in the pool, without keeping the body.
+func releaseReader(reader *bufio.Reader) {
+	reader.Reset(nil)
+	frameReaders.Put(reader)
+}
+
+// Gets empty stats from the pool.
+func acquireContainerStats() *ContainerStats {
+	return containerStats.Get().(*ContainerStats)
+}
+
+// Empties the stats and puts them back in the pool. The per-CPU usage and networks are kept for the next frame, so
+// nothing may keep them: baselines copy the CPU stats, see CpuStats.copy. The previous CPU stats come from a baseline
+// and are dropped instead.
+func releaseContainerStats(c *ContainerStats) {
+	perCpu := c.Cpu.Usage.PerCpu[:0]
+
+	networks := c.Networks
+	for name := range networks {
+		delete(networks, name)
+	}
+
+	*c = ContainerStats{}
+	c.Cpu.Usage.PerCpu = perCpu
+	c.Networks = networks
+
+	containerStats.Put(c)
+}
+
+// Reads the next frame of a stats response, delimited by newlines, io.EOF at the end. The frame is only valid until the
+// next read, it is not copied unless it doesn't fit in the buffer.
+func readFrame(reader *bufio.Reader) ([]byte, error) {
+	frame, err := reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		frame = append([]byte(nil), frame...)
+
+		var rest []byte
+		rest, err = reader.ReadBytes('\n')
+		frame = append(frame, rest...)
+	}
+
+	// the last frame may lack the delimiter.
+	if err == io.EOF && len(bytes.TrimSpace(frame)) > 0 {
+		return frame, nil
+	}
+
+	return frame, err
+}
+
+// The stats payload is decoded for every container on every interval, and carries far more than what is used (pids,
+// storage and most of the blkio and detailed memory stats). This decoder reads the used fields and skips the rest without
+// decoding them, instead of going through reflection. Fields added to ContainerStats must be added here too.
+func (c *ContainerStats) UnmarshalJSON(data []byte) error {
+	s := &scanner{data: data}
+
+	err := s.object(func(key []byte) error {
+		switch string(key) {
+		case "cpu_stats":
+			return s.cpuStats(&c.Cpu)
+		case "precpu_stats":
+			return s.cpuStats(&c.PreCpu)
+		case "memory_stats":
+			return s.memoryStats(&c.Memory)
+		case "networks":
+			return s.networks(&c.Networks)
+		case "blkio_stats":
+			return s.blkioStats(&c.Blkio)
+		case "read":
+			return s.time(&c.Read)
+		}
+
+		return s.skip()
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.end()
+}
+
+func (s *scanner) cpuStats(cpu *CpuStats) error {
+	return s.object(func(key []byte) error {
+		switch string(key) {
+		case "cpu_usage":
+			return s.object(func(key []byte) error {
+				switch string(key) {
+				case "total_usage":
+					return s.uint64(&cpu.Usage.Total)
+				case "percpu_usage":
+					return s.uint64s(&cpu.Usage.PerCpu)
+				}
+
+				return s.skip()
+			})
+		case "system_cpu_usage":
+			return s.uint64(&cpu.SystemCpuUsage)
+		}
+
+		return s.skip()
+	})
+}
+
+func (s *scanner) memoryStats(memory *MemoryStats) error {
+	return s.object(func(key []byte) error {
+		switch string(key) {
+		case "usage":
+			return s.uint64(&memory.Usage)
+		case "limit":
+			return s.uint64(&memory.Limit)
+		case "failcnt":
+			return s.uint64(&memory.Failcnt)
+		case "stats":
+			return s.object(func(key []byte) error {
+				switch string(key) {
+				case "swap":
+					return s.uint64(&memory.Detail.Swap)
+				case "hierarchical_memsw_limit":
+					return s.uint64(&memory.Detail.MemswLimit)
+				case "hierarchical_memory_limit":
+					return s.uint64(&memory.Detail.MemoryLimit)
+				}
+
+				return s.skip()
+			})
+		}
+
+		return s.skip()
+	})
+}
+
+func (s *scanner) blkioStats(blkio *BlkioStats) error {
+	return s.object(func(key []byte) error {
+		switch string(key) {
+		case "io_service_time_recursive":
+			return s.blkioEntries(&blkio.ServiceTime, &blkio.ServiceTime)
+		case "io_service_bytes_recursive":
+			return s.blkioEntries(&blkio.ReadBytes, &blkio.WriteBytes)
+		case "io_serviced_recursive":
+			return s.blkioEntries(&blkio.Reads, &blkio.Writes)
+		case "io_queue_recursive":
+			return s.blkioEntries(&blkio.Queued, &blkio.Queued)
+		}
+
+		return s.skip()
+	})
+}
+
+// Sums the read and write entries of every device, the other ops (sync, async and total) count them again.
+func (s *scanner) blkioEntries(read *uint64, write *uint64) error {
+	if s.null() {
+		return nil
+	}
+
+	return s.array(func() error {
+		var op string
+		var value uint64
+
+		err := s.object(func(key []byte) error {
+			switch string(key) {
+			case "op":
+				return s.string(&op)
+			case "value":
+				return s.uint64(&value)
+			}
+
+			return s.skip()
+		})
+		if err != nil {
+			return err
+		}
+
+		if strings.EqualFold(op, "read") {
+			*read += value
+		} else if strings.EqualFold(op, "write") {
+			*write += value
+		}
+		return nil
+	})
+}
+
+func (s *scanner) networks(networks *map[string]InterfaceStats) error {
+	if s.null() {
+		return nil
+	}
+
+	// emptied, if reused.
+	if *networks == nil {
+		*networks = make(map[string]InterfaceStats)
+	}
+
+	return s.object(func(key []byte) error {
+		name := string(key)
+		i := (*networks)[name]
+
+		err := s.object(func(key []byte) error {
+			switch string(key) {
+			case "rx_bytes":
+				return s.uint64(&i.RxBytes)
+			case "rx_dropped":
+				return s.uint64(&i.RxDropped)
+			case "rx_errors":
+				return s.uint64(&i.RxErrors)
+			case "rx_packets":
+				return s.uint64(&i.RxPackets)
+			case "tx_bytes":
+				return s.uint64(&i.TxBytes)
+			case "tx_dropped":
+				return s.uint64(&i.TxDropped)
+			case "tx_errors":
+				return s.uint64(&i.TxErrors)
+			case "tx_packets":
+				return s.uint64(&i.TxPackets)
+			}
+
+			return s.skip()
+		})
+
+		(*networks)[name] = i
+		return err
+	})
+}
+
+// Reads a single JSON value, given whole to the decoder above.
+type scanner struct {
+	data []byte
+	pos  int
+}
+
+// Reads an object, calling field with each key once the colon is consumed. field must consume the value.
+func (s *scanner) object(field func(key []byte) error) error {
+	if s.null() {
+		return nil
+	}
+
+	if err := s.expect('{'); err != nil {
+		return err
+	}
+
+	if s.peek() == '}' {
+		s.pos++
+		return nil
+	}
+
+	for {
+		key, err := s.key()
+		if err != nil {
+			return err
+		}
+
+		if err := s.expect(':'); err != nil {
+			return err
+		}
+
+		if err := field(key); err != nil {
+			return s.fieldError(key, err)
+		}
+
+		switch s.peek() {
+		case ',':
+			s.pos++
+		case '}':
+			s.pos++
+			return nil
+		default:
+			return s.error("',' or '}'")
+		}
+	}
+}
+
+// Reads an array, calling item for each element, which item must consume.
+func (s *scanner) array(item func() error) error {
+	if err := s.expect('['); err != nil {
+		return err
+	}
+
+	if s.peek() == ']' {
+		s.pos++
+		return nil
+	}
+
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+
+		switch s.peek() {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			return nil
+		default:
+			return s.error("',' or ']'")
+		}
+	}
+}
+
+// Reads an object key, without copying it unless it has escapes.
+func (s *scanner) key() ([]byte, error) {
+	raw, escaped, err := s.quoted()
+	if err != nil || !escaped {
+		return raw, err
+	}
+
+	var key string
+	err = json.Unmarshal(s.data[s.pos-len(raw)-2:s.pos], &key)
+	return []byte(key), err
+}
+
+func (s *scanner) string(value *string) error {
+	if s.null() {
+		return nil
+	}
+
+	raw, escaped, err := s.quoted()
+	if err != nil {
+		return err
+	}
+
+	if escaped {
+		return json.Unmarshal(s.data[s.pos-len(raw)-2:s.pos], value)
+	}
+
+	*value = string(raw)
+	return nil
+}
+
+func (s *scanner) time(value *time.Time) error {
+	if s.null() {
+		return nil
+	}
+
+	var raw string
+	if err := s.string(&raw); err != nil {
+		return err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return err
+	}
+
+	*value = t
+	return nil
+}
+
+// Reads an unsigned integer. Numbers out of its range, negative, fractional or too large, are taken as zero and
+// counted, instead of failing the whole frame over one field.
+func (s *scanner) uint64(value *uint64) error {
+	if s.null() {
+		return nil
+	}
+
+	start := s.pos
+	if s.peek() == '-' {
+		s.pos++
+	}
+
+	if s.digits() == 0 {
+		s.pos = start
+		return s.error("an unsigned integer")
+	}
+
+	n, err := strconv.ParseUint(string(s.data[start:s.pos]), 10, 64)
+
+	// fraction and exponent, as JSON numbers have them.
+	if s.pos < len(s.data) && s.data[s.pos] == '.' {
+		s.pos++
+		if s.digits() == 0 {
+			return s.error("a digit")
+		}
+		err = strconv.ErrRange
+	}
+
+	if s.pos < len(s.data) && (s.data[s.pos] == 'e' || s.data[s.pos] == 'E') {
+		s.pos++
+		if s.pos < len(s.data) && (s.data[s.pos] == '+' || s.data[s.pos] == '-') {
+			s.pos++
+		}
+		if s.digits() == 0 {
+			return s.error("a digit")
+		}
+		err = strconv.ErrRange
+	}
+
+	if err != nil {
+		outOfRange.Add(1)
+		log.Debug.Printf("Value %s at offset %d is out of range, taken as 0.", s.data[start:s.pos], start)
+		n = 0
+	}
+
+	*value = n
+	return nil
+}
+
+// Consumes the digits at the position, returning how many.
+func (s *scanner) digits() int {
+	start := s.pos
+	for s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '9' {
+		s.pos++
+	}
+
+	return s.pos - start
+}
+
+func (s *scanner) uint64s(values *[]uint64) error {
+	if s.null() {
+		*values = nil
+		return nil
+	}
+
+	*values = (*values)[:0]
+	return s.array(func() error {
+		var n uint64
+		if err := s.uint64(&n); err != nil {
+			return err
+		}
+
+		*values = append(*values, n)
+		return nil
+	})
+}
+
+// Skips any value without decoding it.
+func (s *scanner) skip() error {
+	switch s.peek() {
+	case '{', '[':
+		depth := 0
+		for s.pos < len(s.data) {
+			switch s.data[s.pos] {
+			case '"':
+				if _, _, err := s.quoted(); err != nil {
+					return err
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+
+			s.pos++
+			if depth == 0 {
+				return nil
+			}
+		}
+
+		return s.error("end of value")
+	case '"':
+		_, _, err := s.quoted()
+		return err
+	}
+
+	// numbers, true, false and null run until the next delimiter.
+	start := s.pos
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ',', '}', ']', ' ', '\t', '\n', '\r':
+			if start == s.pos {
+				return s.error("a value")
+			}
+			return nil
+		}
+		s.pos++
+	}
+
+	if start == s.pos {
+		return s.error("a value")
+	}
+
+	return nil
+}
+
+// Reads a string, returning its contents without the quotes and whether it has escapes.
+func (s *scanner) quoted() ([]byte, bool, error) {
+	if err := s.expect('"'); err != nil {
+		return nil, false, err
+	}
+
+	start := s.pos
+	escaped := false
+
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case '\\':
+			escaped = true
+			s.pos += 2
+			continue
+		case '"':
+			s.pos++
+			return s.data[start : s.pos-1], escaped, nil
+		}
+		s.pos++
+	}
+
+	return nil, false, errors.New("Unterminated JSON string.")
+}
+
+// Consumes a null literal, if next.
+func (s *scanner) null() bool {
+	if s.peek() == 'n' && len(s.data)-s.pos >= 4 && string(s.data[s.pos:s.pos+4]) == "null" {
+		s.pos += 4
+		return true
+	}
+
+	return false
+}
+
+// Consumes the given delimiter, after any whitespace.
+func (s *scanner) expect(c byte) error {
+	if s.peek() != c {
+		return s.error(strconv.QuoteRune(rune(c)))
+	}
+
+	s.pos++
+	return nil
+}
+
+// Next byte after any whitespace, which is skipped, or 0 at the end.
+func (s *scanner) peek() byte {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return s.data[s.pos]
+		}
+	}
+
+	return 0
+}
+
+// Checks that nothing but whitespace is left.
+func (s *scanner) end() error {
+	if s.peek() != 0 {
+		return s.error("end of value")
+	}
+
+	return nil
+}
+
+// Prefixes the path of the error with the key of the field it happened in.
+func (s *scanner) fieldError(key []byte, err error) error {
+	if e, ok := err.(*fieldError); ok {
+		e.path = append([]string{string(key)}, e.path...)
+		return e
+	}
+
+	return &fieldError{path: []string{string(key)}, offset: s.pos, err: err}
+}
+
+func (s *scanner) error(expected string) error {
+	return fmt.Errorf("Invalid JSON at offset %d: expected %s.", s.pos, expected)
+}
@@ -0,0 +1,251 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/telemetry"
+)
+
+// Frame of a cgroup v1 host, as the daemon sends it.
+const frameV1 = `{"read":"2026-10-16T07:20:01.123456789Z","preread":"2026-10-16T07:20:00.120987654Z",` +
+	`"pids_stats":{"current":12,"limit":4096},` +
+	`"blkio_stats":{` +
+	`"io_service_bytes_recursive":[{"major":8,"minor":0,"op":"Read","value":4096000},` +
+	`{"major":8,"minor":0,"op":"Write","value":1228800},{"major":8,"minor":0,"op":"Sync","value":5324800},` +
+	`{"major":8,"minor":0,"op":"Async","value":0},{"major":8,"minor":0,"op":"Total","value":5324800}],` +
+	`"io_serviced_recursive":[{"major":8,"minor":0,"op":"Read","value":250},` +
+	`{"major":8,"minor":0,"op":"Write","value":75},{"major":8,"minor":0,"op":"Total","value":325}],` +
+	`"io_queue_recursive":[{"major":8,"minor":0,"op":"Read","value":1},{"major":8,"minor":0,"op":"Write","value":2}],` +
+	`"io_service_time_recursive":[{"major":8,"minor":0,"op":"Read","value":1500000},` +
+	`{"major":8,"minor":0,"op":"Write","value":900000},{"major":8,"minor":0,"op":"Total","value":2400000}],` +
+	`"io_wait_time_recursive":[],"io_merged_recursive":[],"io_time_recursive":[],"sectors_recursive":[]},` +
+	`"num_procs":0,"storage_stats":{},` +
+	`"cpu_stats":{"cpu_usage":{"total_usage":100215355,"percpu_usage":[8646879,24472255,36438778,30657443],` +
+	`"usage_in_kernelmode":50000000,"usage_in_usermode":50000000},"system_cpu_usage":739306590000000,` +
+	`"online_cpus":4,"throttling_data":{"periods":0,"throttled_periods":0,"throttled_time":0}},` +
+	`"precpu_stats":{"cpu_usage":{"total_usage":100093996,"percpu_usage":[8646879,24350896,36438778,30657443],` +
+	`"usage_in_kernelmode":50000000,"usage_in_usermode":50000000},"system_cpu_usage":739302590000000,` +
+	`"online_cpus":4,"throttling_data":{"periods":0,"throttled_periods":0,"throttled_time":0}},` +
+	`"memory_stats":{"usage":6537216,"max_usage":6651904,"failcnt":3,"limit":67108864,` +
+	`"stats":{"active_anon":6537216,"active_file":0,"cache":0,"dirty":0,"hierarchical_memory_limit":67108864,` +
+	`"hierarchical_memsw_limit":134217728,"inactive_anon":0,"inactive_file":0,"mapped_file":0,"pgfault":964,` +
+	`"pgmajfault":0,"pgpgin":477,"pgpgout":414,"rss":6537216,"rss_huge":6291456,"swap":1048576,` +
+	`"total_active_anon":6537216,"total_cache":0,"total_rss":6537216,"total_swap":1048576,"unevictable":0,` +
+	`"writeback":0}},` +
+	`"name":"/web","id":"b3e2d4c5a6f7081920a1b2c3d4e5f60718293a4b5c6d7e8f9a0b1c2d3e4f5a6b",` +
+	`"networks":{"eth0":{"rx_bytes":5338,"rx_dropped":0,"rx_errors":0,"rx_packets":36,"tx_bytes":648,` +
+	`"tx_dropped":0,"tx_errors":0,"tx_packets":8},"eth5":{"rx_bytes":4641,"rx_dropped":1,"rx_errors":2,` +
+	`"rx_packets":26,"tx_bytes":690,"tx_dropped":3,"tx_errors":4,"tx_packets":9}}}`
+
+// Frame of a cgroup v2 host: no per-CPU usage, no swap, and only the bytes and requests of the blkio stats.
+const frameV2 = `{"read":"2026-10-16T07:20:01Z","preread":"2026-10-16T07:20:00Z",` +
+	`"pids_stats":{"current":3,"limit":18446744073709551615},` +
+	`"blkio_stats":{"io_service_bytes_recursive":[{"major":259,"minor":0,"op":"read","value":1024},` +
+	`{"major":259,"minor":0,"op":"write","value":2048}],"io_serviced_recursive":null,"io_queue_recursive":null,` +
+	`"io_service_time_recursive":null,"io_wait_time_recursive":null,"io_merged_recursive":null,` +
+	`"io_time_recursive":null,"sectors_recursive":null},"num_procs":0,"storage_stats":{},` +
+	`"cpu_stats":{"cpu_usage":{"total_usage":22430000,"usage_in_kernelmode":9000000,"usage_in_usermode":13430000},` +
+	`"system_cpu_usage":3061750000000,"online_cpus":2,"throttling_data":{"periods":0,"throttled_periods":0,` +
+	`"throttled_time":0}},"precpu_stats":{"cpu_usage":{"total_usage":0,"usage_in_kernelmode":0,` +
+	`"usage_in_usermode":0},"throttling_data":{"periods":0,"throttled_periods":0,"throttled_time":0}},` +
+	`"memory_stats":{"usage":1875968,"stats":{"active_anon":4096,"anon":233472,"file":0,"pgfault":1023},` +
+	`"limit":8217317376},"name":"/db","id":"1f2e3d4c5b6a79880716253443526170f1e2d3c4b5a6978877665544332211ff",` +
+	`"networks":{"eth0":{"rx_bytes":796,"rx_packets":10,"rx_errors":0,"rx_dropped":0,"tx_bytes":0,` +
+	`"tx_packets":0,"tx_errors":0,"tx_dropped":0}}}`
+
+// Stats in the format the daemon sends them, decoded by encoding/json as reference.
+type referenceStats struct {
+	Cpu      backend.CpuStats                  `json:"cpu_stats"`
+	PreCpu   backend.CpuStats                  `json:"precpu_stats"`
+	Memory   backend.MemoryStats               `json:"memory_stats"`
+	Networks map[string]backend.InterfaceStats `json:"networks"`
+	Blkio    map[string][]struct {
+		Op    string `json:"op"`
+		Value uint64 `json:"value"`
+	} `json:"blkio_stats"`
+	Read time.Time `json:"read"`
+}
+
+// Stats as the hand-written decoder gives them, summing the read and write entries of every device.
+func (r *referenceStats) stats() backend.ContainerStats {
+	c := backend.ContainerStats{Cpu: r.Cpu, PreCpu: r.PreCpu, Memory: r.Memory, Networks: r.Networks, Read: r.Read}
+
+	sum := func(key string, read *uint64, write *uint64) {
+		for _, entry := range r.Blkio[key] {
+			if strings.EqualFold(entry.Op, "read") {
+				*read += entry.Value
+			} else if strings.EqualFold(entry.Op, "write") {
+				*write += entry.Value
+			}
+		}
+	}
+
+	sum("io_service_time_recursive", &c.Blkio.ServiceTime, &c.Blkio.ServiceTime)
+	sum("io_service_bytes_recursive", &c.Blkio.ReadBytes, &c.Blkio.WriteBytes)
+	sum("io_serviced_recursive", &c.Blkio.Reads, &c.Blkio.Writes)
+	sum("io_queue_recursive", &c.Blkio.Queued, &c.Blkio.Queued)
+
+	return c
+}
+
+// Empty and missing per-CPU usage are the same to the collector.
+func normalize(c *backend.ContainerStats) {
+	if len(c.Cpu.Usage.PerCpu) == 0 {
+		c.Cpu.Usage.PerCpu = nil
+	}
+	if len(c.PreCpu.Usage.PerCpu) == 0 {
+		c.PreCpu.Usage.PerCpu = nil
+	}
+}
+
+// The hand-written decoder gives what encoding/json does, including the fields encoding/json cannot fit in their
+// type, which are left as zero.
+func TestDecodeMatchesEncodingJSON(t *testing.T) {
+	cases := []struct {
+		name       string
+		frame      string
+		err        bool // the frame is rejected.
+		outOfRange bool // a number does not fit its field.
+	}{
+		{name: "cgroup v1", frame: frameV1},
+		{name: "cgroup v2", frame: frameV2},
+		{name: "empty", frame: `{}`},
+		{name: "whitespace", frame: " \n{ \"memory_stats\" : { \"usage\" : 5 , \"limit\" : 10 } }\r\n"},
+		{name: "missing fields", frame: `{"read":"2026-10-16T07:20:01Z","cpu_stats":{}}`},
+		{name: "null objects", frame: `{"cpu_stats":null,"precpu_stats":{"cpu_usage":null},"memory_stats":null,` +
+			`"networks":null,"blkio_stats":null,"read":null}`},
+		{name: "null values", frame: `{"cpu_stats":{"cpu_usage":{"total_usage":null,"percpu_usage":null},` +
+			`"system_cpu_usage":null},"memory_stats":{"usage":null,"stats":{"swap":null}},` +
+			`"networks":{"eth0":{"rx_bytes":null,"tx_bytes":7}},` +
+			`"blkio_stats":{"io_service_bytes_recursive":[{"op":null,"value":null}]}}`},
+		{name: "null array items", frame: `{"cpu_stats":{"cpu_usage":{"percpu_usage":[1,null,3]}}}`},
+		{name: "empty arrays and objects", frame: `{"cpu_stats":{"cpu_usage":{"percpu_usage":[]}},"networks":{},` +
+			`"blkio_stats":{"io_service_bytes_recursive":[]}}`},
+		{name: "largest numbers", frame: `{"cpu_stats":{"cpu_usage":{"total_usage":18446744073709551615}},` +
+			`"networks":{"eth0":{"rx_packets":5000000000,"tx_dropped":4294967296}}}`},
+		{name: "overflow", frame: `{"cpu_stats":{"cpu_usage":{"total_usage":18446744073709551616}},` +
+			`"memory_stats":{"usage":5}}`, outOfRange: true},
+		{name: "huge", frame: `{"memory_stats":{"usage":123456789012345678901234567890,"limit":10}}`,
+			outOfRange: true},
+		{name: "negative", frame: `{"memory_stats":{"usage":-1,"limit":10}}`, outOfRange: true},
+		{name: "fraction", frame: `{"memory_stats":{"usage":1.5,"limit":10}}`, outOfRange: true},
+		{name: "exponent", frame: `{"cpu_stats":{"cpu_usage":{"percpu_usage":[1,1e3,3]}},"memory_stats":{"limit":7}}`,
+			outOfRange: true},
+		{name: "blkio overflow", frame: `{"blkio_stats":{"io_service_bytes_recursive":[` +
+			`{"op":"Read","value":99999999999999999999},{"op":"Write","value":4}]}}`, outOfRange: true},
+		{name: "escaped keys", frame: `{"memory\u005fstats":{"usage":12},"networks":{"eth\u0030":{"rx_bytes":1}}}`},
+		{name: "escaped strings", frame: `{"name":"/a\"b}]","id":"\\","blkio_stats":{"io_service_bytes_recursive":[` +
+			`{"op":"Read","value":3}]},"memory_stats":{"usage":9}}`},
+		{name: "skipped values", frame: `{"pids_stats":{"current":1,"nested":[[{"a":[]}],true,false,null,-2.5e-3]},` +
+			`"name":"x","num_procs":0,"memory_stats":{"usage":1}}`},
+		{name: "time with offset", frame: `{"read":"2026-10-16T09:20:01.5+02:00"}`},
+		{name: "not an object", frame: `[]`, err: true},
+		{name: "string for a number", frame: `{"memory_stats":{"usage":"12"}}`, err: true},
+		{name: "object for a number", frame: `{"memory_stats":{"usage":{}}}`, err: true},
+		{name: "bare minus", frame: `{"memory_stats":{"usage":-}}`, err: true},
+		{name: "missing exponent", frame: `{"memory_stats":{"usage":1e}}`, err: true},
+		{name: "invalid time", frame: `{"read":"yesterday"}`, err: true},
+		{name: "missing colon", frame: `{"memory_stats" {}}`, err: true},
+		{name: "trailing comma", frame: `{"memory_stats":{"usage":1,}}`, err: true},
+		{name: "trailing data", frame: `{"memory_stats":{}} {}`, err: true},
+		{name: "unterminated string", frame: `{"name":"web`, err: true},
+		{name: "no data", frame: ``, err: true},
+	}
+
+	outOfRange := telemetry.Default.Level("values_out_of_range")
+
+	for _, c := range cases {
+		before := outOfRange.Value()
+
+		var decoded backend.ContainerStats
+		err := decoded.UnmarshalJSON([]byte(c.frame))
+
+		if counted := outOfRange.Value() > before; counted != c.outOfRange {
+			t.Errorf("%s: expected out of range values counted: %t, got %t", c.name, c.outOfRange, counted)
+		}
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err.Error())
+			continue
+		}
+
+		var reference referenceStats
+		if err := json.Unmarshal([]byte(c.frame), &reference); err != nil {
+			// encoding/json reports the numbers it cannot fit, decoding the rest.
+			if _, ok := err.(*json.UnmarshalTypeError); !ok || !c.outOfRange {
+				t.Fatalf("%s: %s", c.name, err.Error())
+			}
+		}
+
+		expected := reference.stats()
+		normalize(&expected)
+		normalize(&decoded)
+
+		if !decoded.Read.Equal(expected.Read) {
+			t.Errorf("%s: expected read %s, got %s", c.name, expected.Read, decoded.Read)
+		}
+		decoded.Read, expected.Read = time.Time{}, time.Time{}
+
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("%s: expected\n%+v\ngot\n%+v", c.name, expected, decoded)
+		}
+	}
+}
+
+// A frame cut anywhere is rejected, without panicking.
+func TestDecodeTruncated(t *testing.T) {
+	for _, frame := range []string{frameV1, frameV2} {
+		for n := 0; n < len(frame); n++ {
+			var decoded backend.ContainerStats
+			if err := decoded.UnmarshalJSON([]byte(frame[:n])); err == nil {
+				t.Fatalf("expected an error on the frame cut at %d: %s", n, frame[:n])
+			}
+		}
+	}
+}
+
+// The full cgroup v1 frame, decoded by the hand-written decoder and by encoding/json.
+func BenchmarkDecode(b *testing.B) {
+	data := []byte(frameV1)
+
+	b.Run("scanner", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+
+		var decoded backend.ContainerStats
+		for i := 0; i < b.N; i++ {
+			for name := range decoded.Networks {
+				delete(decoded.Networks, name)
+			}
+			decoded.Blkio = backend.BlkioStats{}
+
+			if err := decoded.UnmarshalJSON(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+
+		for i := 0; i < b.N; i++ {
+			var reference referenceStats
+			if err := json.Unmarshal(data, &reference); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
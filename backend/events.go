@@ -2,60 +2,114 @@ package backend
 
 import (
 	"bufio"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
 )
 
+// Event reported by the Docker Events API. Container attributes include the name and labels.
 type Event struct {
 	Type   string `json:"Type"`
 	Action string `json:"Action"`
 	Actor  struct {
-		Attributes struct {
-			Name    string `json:"name"`
-			OldName string `json:"oldName,omitempty"`
-		} `json:"Attributes"`
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
 	} `json:"Actor"`
+
+	Time     int64 `json:"time"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+// Container actions forwarded to repositories as lifecycle events.
+var lifecycle = map[string]bool{
+	"start":   true,
+	"stop":    true,
+	"die":     true,
+	"oom":     true,
+	"restart": true,
+	"rename":  true,
+}
+
+// Prefix of the health check transitions, as in "health_status: unhealthy".
+const HEALTH_STATUS = "health_status"
+
+// Action of the state transitions pushed on start and die, with the precise times reported by Docker.
+const STATE = "state"
+
+// State of a container, as reported by the Docker Inspect API. Times are zero if it never started or finished.
+type containerState struct {
+	StartedAt  time.Time `json:"StartedAt"`
+	FinishedAt time.Time `json:"FinishedAt"`
+}
+
+// Container access actions forwarded to repositories when enabled, see SetExecEvents. Exec actions carry the command
+// run, as in "exec_start: sh -c ls".
+var access = map[string]bool{
+	"exec_create": true,
+	"exec_start":  true,
+	"exec_die":    true,
+	"attach":      true,
+	"detach":      true,
+}
+
+// Health of a container since some time.
+type health struct {
+	status string
+	since  time.Time
 }
 
 type EventsMonitor struct {
 	client *httputil.ClientConn
 	quit   chan bool
+	health map[string]health // last health status of each container, only used by the loop.
 }
 
-func NewEventsMonitor(http bool, address string) (*EventsMonitor, error) {
-	conn, err := createConn(http, address)
+func NewEventsMonitor(network string, address string) (*EventsMonitor, error) {
+	conn, err := createConn(network, address)
 	if err != nil {
 		return nil, err
 	}
 
 	return &EventsMonitor{
 		client: httputil.NewClientConn(conn, nil),
+		health: make(map[string]health),
 	}, nil
 }
 
-func (em *EventsMonitor) monitor(cli *Client, containers map[string]Container) {
-	em.quit = make(chan bool, 1)
+func (em *EventsMonitor) monitor(cli *Client, containers *Containers) {
+	em.quit = make(chan bool)
 	go em.loop(cli, containers)
 }
 
+// Stops the monitor, closing the connection unblocks the pending read of the events stream.
 func (em *EventsMonitor) Close() {
-	em.quit <- true
+	if em.quit != nil {
+		close(em.quit)
+	}
+
+	em.client.Close()
 }
 
-func (em *EventsMonitor) loop(cli *Client, containers map[string]Container) {
+func (em *EventsMonitor) loop(cli *Client, containers *Containers) {
 	req, err := http.NewRequest("GET", "/events", nil)
 	if err != nil {
 		log.Error.Printf("Could not monitor events: %s", err.Error())
+		return
 	}
 
 	res, err := em.client.Do(req)
 	if err != nil {
 		log.Error.Printf("Events request failed: %s", err.Error())
+		return
 	}
+	record(req, res)
 	defer res.Body.Close()
 
 	reader := bufio.NewReader(res.Body)
@@ -67,51 +121,309 @@ func (em *EventsMonitor) loop(cli *Client, containers map[string]Container) {
 		default:
 			line, err := reader.ReadBytes('\n')
 			if err != nil {
-				log.Error.Printf("Events response error: %s", err.Error())
+				select {
+				case <-em.quit:
+				default:
+					log.Error.Printf("Events response error: %s", err.Error())
+				}
+				return
 			}
 
 			event := Event{}
-			err = json.Unmarshal(line, &event)
+			err = unmarshal(ENDPOINT_EVENTS, line, &event, eventFields)
 			if err != nil {
 				log.Error.Printf("Events response error: %s", err.Error())
+				continue
 			}
 
-			if event.Type == "container" {
-				switch event.Action {
-				case "stop":
-					log.Info.Printf("Container %s stopped.", event.Actor.Attributes.Name)
-					delete(containers, event.Actor.Attributes.Name)
-					cli.repo.Clear(event.Actor.Attributes.Name)
-
-				case "start":
-					log.Info.Printf("Container %s started.", event.Actor.Attributes.Name)
-
-					// retrieve and store new container data.
-					container, err := cli.RequestContainer(event.Actor.Attributes.Name)
-					if err != nil {
-						log.Error.Printf("Cannot retrieve container data for %s. Error: %s",
-							event.Actor.Attributes.Name, err.Error())
-					}
-					containers[container.CanonicalName] = *container
-
-				case "rename":
-					oldName := event.Actor.Attributes.OldName[1:]
-					log.Info.Printf("Container %s renamed to %s.", oldName, event.Actor.Attributes.Name)
-
-					// delete registered container from map.
-					delete(containers, oldName)
-					cli.repo.Clear(oldName)
-
-					// retrieve and store new container data.
-					container, err := cli.RequestContainer(event.Actor.Attributes.Name)
-					if err != nil {
-						log.Error.Printf("Cannot retrieve container data for %s. Error: %s",
-							event.Actor.Attributes.Name, err.Error())
-					}
-					fmt.Println(container)
-					containers[container.CanonicalName] = *container
+			if event.Type != "container" {
+				continue
+			}
+
+			em.handle(cli, containers, event)
+
+			// records are named by the identity of the container, see SetIdentity.
+			identity := cli.Identify(event.container())
+			push := func(record *stats.Event) {
+				record.Name = identity
+				cli.pushEvent(record)
+			}
+
+			if lifecycle[event.Action] {
+				push(event.record())
+
+				if record, ok := cli.stateTransition(event); ok {
+					push(record)
+				}
+			} else if status, ok := healthStatus(event.Action); ok {
+				push(em.transition(event, status))
+			} else if cli.execEvents {
+				if record, ok := accessRecord(event); ok {
+					push(record)
 				}
 			}
 		}
 	}
 }
+
+// Keeps the containers map up to date with the event.
+func (em *EventsMonitor) handle(cli *Client, containers *Containers, event Event) {
+	name := event.Actor.Attributes["name"]
+
+	switch event.Action {
+	case "update":
+		// limits changed with docker update, inspected again on the next query.
+		cli.forgetLimits(name)
+
+	case "stop":
+		log.Info.Printf("Container %s stopped.", name)
+
+		// the known container has its labels alone, the attributes of the event mix them with the name and image.
+		container, ok := containers.Get(name)
+		if !ok {
+			container = event.container()
+		}
+
+		containers.Delete(name)
+		delete(em.health, name)
+		cli.Removed(container)
+		cli.forget(name)
+
+	case "die":
+		if code := event.Actor.Attributes["exitCode"]; code != "" && code != "0" {
+			log.Warning.Printf("Container %s exited with code %s.", name, code)
+		}
+
+	case "start":
+		log.Info.Printf("Container %s started.", name)
+
+		// health checks start over, in the starting status.
+		em.health[name] = health{status: "starting", since: event.timestamp()}
+
+		// retrieve and store new container data.
+		container, err := cli.RequestContainer(name)
+		if err != nil {
+			log.Error.Printf("Cannot retrieve container data for %s. Error: %s", name, err.Error())
+			return
+		}
+		containers.Set(*container)
+		cli.Added(*container)
+
+	case "rename":
+		oldName := strings.TrimPrefix(event.Actor.Attributes["oldName"], "/")
+		log.Info.Printf("Container %s renamed to %s.", oldName, name)
+
+		if h, ok := em.health[oldName]; ok {
+			em.health[name] = h
+			delete(em.health, oldName)
+		}
+
+		// delete registered container from map.
+		old, ok := containers.Get(oldName)
+		if !ok {
+			old = Container{CanonicalName: oldName}
+		}
+		cli.Removed(old)
+		containers.Delete(oldName)
+		cli.forget(oldName)
+
+		// retrieve and store new container data.
+		container, err := cli.RequestContainer(name)
+		if err != nil {
+			log.Error.Printf("Cannot retrieve container data for %s. Error: %s", name, err.Error())
+			return
+		}
+		containers.Set(*container)
+		cli.Added(*container)
+	}
+}
+
+// Record of a health check transition, with the previous status and the time spent in it, if seen.
+func (em *EventsMonitor) transition(event Event, status string) *stats.Event {
+	record := event.record()
+	record.Action = HEALTH_STATUS
+	record.State = status
+
+	if previous, ok := em.health[record.Name]; ok {
+		record.Previous = previous.status
+		record.Duration = record.Timestamp.Sub(previous.since).Seconds()
+	}
+
+	em.health[record.Name] = health{status: status, since: record.Timestamp}
+
+	return record
+}
+
+// Record of the transition of the container to running on start, or to exited on die, timestamped with the time
+// Docker reports instead of the time of the event. The duration is the downtime since the previous run, or the uptime
+// of the run, if known. False on other actions or if the container could not be inspected, for example if already
+// removed.
+func (cli *Client) stateTransition(event Event) (*stats.Event, bool) {
+	if event.Action != "start" && event.Action != "die" {
+		return nil, false
+	}
+
+	state, err := cli.containerState(event.Actor.Attributes["name"])
+	if err != nil {
+		log.Debug.Printf("Could not get the state of %s: %s", event.Actor.Attributes["name"], err.Error())
+		return nil, false
+	}
+
+	record := event.record()
+	record.Action = STATE
+
+	if !state.StartedAt.IsZero() {
+		record.StartedAt = &state.StartedAt
+	}
+
+	switch event.Action {
+	case "start":
+		record.State = "running"
+		record.Previous = "created"
+		record.Timestamp = state.StartedAt
+
+		// the finish time is kept until the next one, from the previous run.
+		if !state.FinishedAt.IsZero() && state.FinishedAt.Before(state.StartedAt) {
+			record.FinishedAt = &state.FinishedAt
+			record.Previous = "exited"
+			record.Duration = state.StartedAt.Sub(state.FinishedAt).Seconds()
+		}
+
+	case "die":
+		record.State = "exited"
+		record.Previous = "running"
+		record.Timestamp = state.FinishedAt
+		record.FinishedAt = &state.FinishedAt
+
+		// unless already started again.
+		if state.FinishedAt.After(state.StartedAt) {
+			record.Duration = state.FinishedAt.Sub(state.StartedAt).Seconds()
+		}
+	}
+
+	if record.Timestamp.IsZero() {
+		record.Timestamp = event.timestamp()
+	}
+
+	return record, true
+}
+
+// Queries the Docker Inspect API for the state of the container.
+func (cli *Client) containerState(name string) (containerState, error) {
+	req, err := newRequest("GET", "/containers/"+name+"/json")
+	if err != nil {
+		return containerState{}, err
+	}
+
+	res, err := cli.dedicated.Do(req)
+	if err != nil {
+		return containerState{}, err
+	}
+	defer res.Body.Close()
+
+	body, release, err := responseBody(res)
+	if err != nil {
+		return containerState{}, err
+	}
+	defer release()
+
+	if res.StatusCode != http.StatusOK {
+		return containerState{}, errors.New("Could not inspect " + name + ": " + res.Status)
+	}
+
+	inspect := struct {
+		State containerState `json:"State"`
+	}{}
+	if err := decode(ENDPOINT_INSPECT, body, &inspect, stateFields); err != nil {
+		return containerState{}, err
+	}
+
+	return inspect.State, nil
+}
+
+// Status of a health check transition, false if the action is not one.
+func healthStatus(action string) (string, bool) {
+	if !strings.HasPrefix(action, HEALTH_STATUS+":") {
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(action, HEALTH_STATUS+":")), true
+}
+
+// Record of an exec or attach to the container, the command run is kept as the command attribute. False if the
+// action is not an access.
+func accessRecord(event Event) (*stats.Event, bool) {
+	action := event.Action
+	command := ""
+	if i := strings.Index(action, ":"); i >= 0 {
+		action, command = action[:i], strings.TrimSpace(action[i+1:])
+	}
+
+	if !access[action] {
+		return nil, false
+	}
+
+	record := event.record()
+	record.Action = action
+
+	if command != "" {
+		attributes := make(map[string]string, len(record.Attributes)+1)
+		for key, value := range record.Attributes {
+			attributes[key] = value
+		}
+		attributes["command"] = command
+		record.Attributes = attributes
+	}
+
+	return record, true
+}
+
+// Forwards the event to the repository, if it can store events.
+func (cli *Client) pushEvent(record *stats.Event) {
+	pusher, ok := cli.repo.(repo.EventPusher)
+	if !ok {
+		return
+	}
+
+	if err := pusher.PushEvent(record); err != nil {
+		cli.onError(err)
+	}
+}
+
+// Event as pushed to the repositories, with the exit code reported by die and exec_die events.
+func (event Event) record() *stats.Event {
+	record := &stats.Event{
+		Timestamp:  event.timestamp(),
+		Name:       event.Actor.Attributes["name"],
+		Action:     event.Action,
+		Attributes: event.Actor.Attributes,
+	}
+
+	if code, err := strconv.Atoi(event.Actor.Attributes["exitCode"]); err == nil {
+		record.ExitCode = &code
+	}
+
+	return record
+}
+
+// Container the event is about, as far as its attributes tell: they hold its labels along the name and image.
+func (event Event) container() Container {
+	return Container{
+		ID:            event.Actor.ID,
+		Image:         event.Actor.Attributes["image"],
+		Labels:        event.Actor.Attributes,
+		CanonicalName: event.Actor.Attributes["name"],
+	}
+}
+
+// Time of the event, as precise as reported by the daemon.
+func (event Event) timestamp() time.Time {
+	switch {
+	case event.TimeNano != 0:
+		return time.Unix(0, event.TimeNano)
+	case event.Time != 0:
+		return time.Unix(event.Time, 0)
+	}
+
+	return time.Now()
+}
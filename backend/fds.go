@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Open file descriptors of the init process of a container, read from /proc.
+type Descriptors struct {
+	Open  uint64 // file descriptors open.
+	Limit uint64 // soft limit of open file descriptors, 0 if unlimited.
+}
+
+// Reads the file descriptors of the process from the given /proc, which must be the one of the Docker host since the
+// process ID is of its PID namespace.
+func readDescriptors(proc string, pid int) (Descriptors, error) {
+	dir := filepath.Join(proc, strconv.Itoa(pid))
+
+	fd, err := os.Open(filepath.Join(dir, "fd"))
+	if err != nil {
+		return Descriptors{}, err
+	}
+	defer fd.Close()
+
+	names, err := fd.Readdirnames(-1)
+	if err != nil {
+		return Descriptors{}, err
+	}
+
+	limit, err := readFdLimit(filepath.Join(dir, "limits"))
+	if err != nil {
+		return Descriptors{}, err
+	}
+
+	return Descriptors{Open: uint64(len(names)), Limit: limit}, nil
+}
+
+// Reads the soft limit of the "Max open files" row of a /proc limits file.
+func readFdLimit(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 {
+			break
+		}
+
+		if fields[0] == "unlimited" {
+			return 0, nil
+		}
+
+		return strconv.ParseUint(fields[0], 10, 64)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, errors.New("No open files limit in " + path + ".")
+}
+
+// File descriptors of the container, if /proc was given (see SetProc) and the container was inspected.
+func (cli *Client) containerDescriptors(name string) (Descriptors, error) {
+	pid, err := cli.containerPid(name)
+	if err != nil {
+		return Descriptors{}, err
+	}
+
+	return readDescriptors(cli.proc, pid)
+}
+
+// Process ID of the init process of the container, once inspected along its limits.
+func (cli *Client) containerPid(name string) (int, error) {
+	pid, ok := cli.pids.Load(name)
+	if !ok || pid.(int) <= 0 {
+		return 0, errors.New("Unknown process of " + name + ".")
+	}
+
+	return pid.(int), nil
+}
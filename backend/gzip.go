@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Decompressing readers are reused across responses.
+var gzipReaders sync.Pool
+
+// Creates a request to the Docker API asking for a gzip compressed response. The daemon itself answers uncompressed,
+// but proxies in front of remote daemons may compress, saving most of the bandwidth of the stats payloads.
+func newRequest(method string, path string) (*http.Request, error) {
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept-Encoding", "gzip")
+	return req, nil
+}
+
+// Body of the response, decompressed if it was compressed. release must be called once done reading, before closing
+// the body.
+func responseBody(res *http.Response) (body io.Reader, release func(), err error) {
+	if !strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		return res.Body, func() {}, nil
+	}
+
+	reader, _ := gzipReaders.Get().(*gzip.Reader)
+	if reader == nil {
+		reader, err = gzip.NewReader(res.Body)
+	} else {
+		err = reader.Reset(res.Body)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return reader, func() {
+		gzipReaders.Put(reader)
+	}, nil
+}
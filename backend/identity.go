@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Identities named by ParseIdentity, besides templates.
+const (
+	IDENTITY_NAME         = "name"   // container name, the default.
+	IDENTITY_ID           = "id"     // short container ID.
+	IDENTITY_LABEL_PREFIX = "label:" // value of the label, as in label:com.docker.swarm.service.name.
+)
+
+// Length of the short container IDs, as printed by docker ps.
+const SHORT_ID_LENGTH = 12
+
+// What identifies the containers in the pushed samples, events, volumes and processes, rendered from their name, ID,
+// image and labels. Containers are still queried by name.
+type Identity struct {
+	template *template.Template
+}
+
+// Fields of a container given to the identity templates.
+type identityData struct {
+	Name    string
+	ID      string
+	ShortID string
+	Image   string
+	Labels  map[string]string
+}
+
+// Value of the label, empty if the container doesn't have it.
+func (d identityData) Label(key string) string {
+	return d.Labels[key]
+}
+
+// Parses an identity: name, id, label:<key> or a Go template over the container fields .Name, .ID, .ShortID,
+// .Image and .Labels, such as {{.Label "com.docker.swarm.service.name"}}.{{.ShortID}}.
+func ParseIdentity(spec string) (*Identity, error) {
+	text := spec
+
+	switch {
+	case spec == IDENTITY_NAME:
+		text = "{{.Name}}"
+	case spec == IDENTITY_ID:
+		text = "{{.ShortID}}"
+	case strings.HasPrefix(spec, IDENTITY_LABEL_PREFIX):
+		text = "{{.Label " + strconv.Quote(strings.TrimPrefix(spec, IDENTITY_LABEL_PREFIX)) + "}}"
+	case !strings.Contains(spec, "{{"):
+		// a constant would identify every container the same.
+		return nil, errors.New("Unknown identity " + spec + ", use name, id, label:<key> or a template.")
+	}
+
+	t, err := template.New("identity").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{template: t}, nil
+}
+
+// Identity of the container, its name if the identity renders empty or fails, for example if the label is missing.
+func (identity *Identity) Of(container Container) string {
+	if identity == nil {
+		return container.CanonicalName
+	}
+
+	shortID := container.ID
+	if len(shortID) > SHORT_ID_LENGTH {
+		shortID = shortID[:SHORT_ID_LENGTH]
+	}
+
+	var buffer bytes.Buffer
+	err := identity.template.Execute(&buffer, identityData{
+		Name:    container.CanonicalName,
+		ID:      container.ID,
+		ShortID: shortID,
+		Image:   container.Image,
+		Labels:  container.Labels,
+	})
+
+	if err != nil || strings.TrimSpace(buffer.String()) == "" {
+		return container.CanonicalName
+	}
+
+	return strings.TrimSpace(buffer.String())
+}
@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Creation time of the image, inspected on the given connection the first time and cached by ID, since images never
+// change once built. Images are listed with the containers only by ID, so each one is inspected once.
+func (cli *Client) imageCreated(conn *dockerConn, id string) (time.Time, error) {
+	if created, ok := cli.images.Load(id); ok {
+		return created.(time.Time), nil
+	}
+
+	req, err := newRequest("GET", "/images/"+id+"/json")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	res, err := conn.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	body, release, err := responseBody(res)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer release()
+
+	if res.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("Could not inspect image %s: %s", id, res.Status)
+	}
+
+	inspect := struct {
+		Created time.Time `json:"Created"`
+	}{}
+	if err := decode(ENDPOINT_IMAGES, body, &inspect, imageFields); err != nil {
+		return time.Time{}, err
+	}
+
+	cli.images.Store(id, inspect.Created)
+
+	return inspect.Created, nil
+}
+
+// Age of an image created at the given time, in days, 0 if unknown.
+func imageAgeDays(created time.Time, now time.Time) float64 {
+	if created.IsZero() {
+		return 0
+	}
+
+	return now.Sub(created).Hours() / 24
+}
@@ -0,0 +1,139 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Default period of the CPU quota, in microseconds, when not set.
+const CPU_PERIOD = 100000
+
+// Resource limits of a container, from its HostConfig.
+type Limits struct {
+	Cpus      float64 // CPUs the container may use, 0 if unlimited.
+	CpuShares uint64  // relative weight against other containers, 0 if the default.
+	Memory    uint64  // memory the container may use in bytes, 0 if unlimited.
+}
+
+// Settings of a container relevant to operations and security, from its HostConfig.
+type Settings struct {
+	RestartPolicy string // no, always, unless-stopped or on-failure.
+	Privileged    bool   // whether the container runs with every capability and device of the host.
+	NetworkMode   string // bridge, host, none, container:<name> or the name of a network.
+}
+
+// HostConfig of a container, as reported by the Docker Inspect API.
+type hostConfig struct {
+	NanoCpus  int64 `json:"NanoCpus"`
+	CpuQuota  int64 `json:"CpuQuota"`
+	CpuPeriod int64 `json:"CpuPeriod"`
+	CpuShares int64 `json:"CpuShares"`
+	Memory    int64 `json:"Memory"`
+
+	RestartPolicy struct {
+		Name string `json:"Name"`
+	} `json:"RestartPolicy"`
+	Privileged  bool   `json:"Privileged"`
+	NetworkMode string `json:"NetworkMode"`
+}
+
+// What is cached of the inspection of a container.
+type inspection struct {
+	limits   Limits
+	settings Settings
+}
+
+// Effective limits of the host config: --cpus takes precedence over the quota, both cannot be set together.
+func (h hostConfig) limits() Limits {
+	limits := Limits{}
+
+	if h.CpuShares > 0 {
+		limits.CpuShares = uint64(h.CpuShares)
+	}
+
+	if h.Memory > 0 {
+		limits.Memory = uint64(h.Memory)
+	}
+
+	period := h.CpuPeriod
+	if period <= 0 {
+		period = CPU_PERIOD
+	}
+
+	switch {
+	case h.NanoCpus > 0:
+		limits.Cpus = float64(h.NanoCpus) / 1e9
+	case h.CpuQuota > 0:
+		limits.Cpus = float64(h.CpuQuota) / float64(period)
+	}
+
+	return limits
+}
+
+// Settings of the host config, an empty restart policy is reported as no.
+func (h hostConfig) settings() Settings {
+	settings := Settings{
+		RestartPolicy: h.RestartPolicy.Name,
+		Privileged:    h.Privileged,
+		NetworkMode:   h.NetworkMode,
+	}
+
+	if settings.RestartPolicy == "" {
+		settings.RestartPolicy = "no"
+	}
+
+	return settings
+}
+
+// Limits and settings of the container, inspected on the given connection the first time and cached until forgotten,
+// see forgetLimits. Containers are listed without their host config, so each one is inspected once. Its process ID is
+// cached along, see containerDescriptors.
+func (cli *Client) containerLimits(conn *dockerConn, name string) (Limits, Settings, error) {
+	if cached, ok := cli.limits.Load(name); ok {
+		i := cached.(inspection)
+		return i.limits, i.settings, nil
+	}
+
+	req, err := newRequest("GET", "/containers/"+name+"/json")
+	if err != nil {
+		return Limits{}, Settings{}, err
+	}
+
+	res, err := conn.Do(req)
+	if err != nil {
+		return Limits{}, Settings{}, err
+	}
+	defer res.Body.Close()
+
+	body, release, err := responseBody(res)
+	if err != nil {
+		return Limits{}, Settings{}, err
+	}
+	defer release()
+
+	if res.StatusCode != http.StatusOK {
+		return Limits{}, Settings{}, fmt.Errorf("Could not inspect %s: %s", name, res.Status)
+	}
+
+	inspect := struct {
+		HostConfig hostConfig `json:"HostConfig"`
+		State      struct {
+			Pid int `json:"Pid"` // 0 if not running.
+		} `json:"State"`
+	}{}
+	if err := decode(ENDPOINT_INSPECT, body, &inspect, limitsFields); err != nil {
+		return Limits{}, Settings{}, err
+	}
+
+	i := inspection{limits: inspect.HostConfig.limits(), settings: inspect.HostConfig.settings()}
+	cli.limits.Store(name, i)
+	cli.pids.Store(name, inspect.State.Pid)
+
+	return i.limits, i.settings, nil
+}
+
+// Forgets the limits, settings and the process of the container, inspected again on its next query.
+func (cli *Client) forgetLimits(name string) {
+	cli.limits.Delete(name)
+	cli.pids.Delete(name)
+}
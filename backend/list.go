@@ -0,0 +1,153 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+const (
+	// containers are listed in partitions by the first hex digit of their ID, fetched in parallel.
+	LIST_PARTITIONS = 16
+
+	// maximum number of partitions fetched at once, each on a connection of the pool.
+	LIST_CONCURRENCY = 4
+
+	// containers per page, each partition is paged from the newest container to the oldest.
+	LIST_PAGE_SIZE = 100
+)
+
+// Filters of the containers list, as expected by the Docker API.
+type listFilters struct {
+	ID     []string `json:"id,omitempty"`
+	Before []string `json:"before,omitempty"`
+	Status []string `json:"status,omitempty"`
+}
+
+// Get containers names currently available in the Docker instance (only the ones that are running). On daemons with
+// thousands of containers a single list takes seconds, so it is split in partitions by ID fetched in parallel, each
+// one in pages, on idle connections of the pool.
+func (cli *Client) GetContainers() (map[string]Container, error) {
+	// the pool is not resized while its connections are borrowed.
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+
+	workers := LIST_CONCURRENCY
+	if workers > cli.daemons {
+		workers = cli.daemons
+	}
+
+	partitions := make(chan string, LIST_PARTITIONS)
+	for i := 0; i < LIST_PARTITIONS; i++ {
+		partitions <- fmt.Sprintf("^%x", i)
+	}
+	close(partitions)
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var failure error
+
+	result := make(map[string]Container)
+
+	for i := 0; i < workers; i++ {
+		// the connection is given back once the worker is done, queries wait for it meanwhile.
+		conn := <-cli.clients
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				cli.clients <- conn
+			}()
+
+			for prefix := range partitions {
+				containers, err := listPartition(conn, prefix)
+
+				mutex.Lock()
+				if err != nil && failure == nil {
+					failure = err
+				}
+				for _, container := range containers {
+					result[container.CanonicalName] = container
+				}
+				mutex.Unlock()
+
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if failure != nil {
+		return nil, failure
+	}
+
+	return result, nil
+}
+
+// Lists the running containers whose ID matches the prefix, a page at a time. Paged lists include the stopped
+// containers, unless filtered by status.
+func listPartition(conn *dockerConn, prefix string) ([]Container, error) {
+	filters := listFilters{ID: []string{prefix}, Status: []string{"running"}}
+
+	var containers []Container
+	for {
+		page, err := listPage(conn, filters)
+		if err != nil {
+			return nil, err
+		}
+
+		containers = append(containers, page...)
+
+		if len(page) < LIST_PAGE_SIZE {
+			return containers, nil
+		}
+
+		// the list is sorted from the newest container, the next page starts before the last one.
+		filters.Before = []string{page[len(page)-1].ID}
+	}
+}
+
+func listPage(conn *dockerConn, filters listFilters) ([]Container, error) {
+	encoded, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("limit", fmt.Sprint(LIST_PAGE_SIZE))
+	query.Set("filters", string(encoded))
+
+	req, err := newRequest("GET", "/containers/json?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := conn.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, release, err := responseBody(res)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// decoded as it arrives, instead of buffering the whole list first, unless strict.
+	var page []Container
+	if err := decode(ENDPOINT_LIST, body, &page, listFields); err != nil {
+		return nil, err
+	}
+
+	for i := range page {
+		page[i].CanonicalName = page[i].Names[0][1:]
+	}
+
+	return page, nil
+}
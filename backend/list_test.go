@@ -0,0 +1,46 @@
+package backend_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/dockertest"
+)
+
+// Paged lists leave out the stopped containers, which the daemon lists once a limit is given.
+func TestGetContainersRunningOnly(t *testing.T) {
+	server := dockertest.NewServer()
+	defer server.Close()
+
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("c%03d", i)
+		server.AddContainer(name, nil)
+		if i%2 == 1 {
+			server.Exit(name)
+		}
+	}
+
+	cli, err := backend.New(dockertest.NewRecorder(), server.Network(), server.Address(), 2, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	containers, err := cli.GetContainers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(containers) != 20 {
+		t.Errorf("expected 20 running containers, got %d", len(containers))
+	}
+
+	for name := range containers {
+		var i int
+		fmt.Sscanf(name, "c%d", &i)
+		if i%2 == 1 {
+			t.Errorf("%s: expected the stopped container to be left out", name)
+		}
+	}
+}
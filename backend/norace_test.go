@@ -0,0 +1,7 @@
+//go:build !race
+// +build !race
+
+package backend_test
+
+// Whether the race detector is on, slowing down the tests bound to time.
+const race = false
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package backend
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+func dialPipe(path string, timeout time.Duration) (net.Conn, error) {
+	return nil, errors.New("Named pipes are only supported on Windows.")
+}
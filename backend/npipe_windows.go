@@ -0,0 +1,17 @@
+package backend
+
+import (
+	"net"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// Dials the named pipe, waiting for the default time of the pipe if the timeout is not positive.
+func dialPipe(path string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return winio.DialPipe(path, nil)
+	}
+
+	return winio.DialPipe(path, &timeout)
+}
@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mijara/statspout/log"
+)
+
+// Extension of the files holding the recorded exchanges.
+const RECORD_EXTENSION = ".http"
+
+var (
+	// directory where the exchanges with the Docker API are saved, see SetRecordDir.
+	recordDir string
+
+	// exchanges recorded so far, numbering their files.
+	recorded uint64
+)
+
+// Saves every request to the Docker API and its response to the directory, one file each, numbered in the order the
+// responses arrive, so the trace can be served back by dockertest.Server.Replay. The files hold the request and the
+// response as sent over the wire, the body is written as it is read, so streams are saved while they last. Must be
+// called before creating clients, disabled if empty.
+func SetRecordDir(dir string) {
+	recordDir = dir
+}
+
+// Records the exchange, if recording, the body of the response is saved as it is read.
+func record(req *http.Request, res *http.Response) {
+	if recordDir == "" {
+		return
+	}
+
+	n := atomic.AddUint64(&recorded, 1)
+	path := filepath.Join(recordDir, fmt.Sprintf("%06d%s", n, RECORD_EXTENSION))
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Warning.Printf("Could not record %s %s: %s", req.Method, req.URL.RequestURI(), err.Error())
+		return
+	}
+
+	if err := writeExchange(f, req, res); err != nil {
+		log.Warning.Printf("Could not record %s %s: %s", req.Method, req.URL.RequestURI(), err.Error())
+		f.Close()
+		return
+	}
+
+	res.Body = &recordedBody{ReadCloser: res.Body, file: f}
+}
+
+// Writes the request and the head of the response. The body follows with neither length nor chunks, so it is read
+// until the end of the file.
+func writeExchange(w io.Writer, req *http.Request, res *http.Response) error {
+	dump, err := httputil.DumpRequest(req, false)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(dump); err != nil {
+		return err
+	}
+
+	header := res.Header.Clone()
+	header.Del("Content-Length")
+	header.Del("Transfer-Encoding")
+	header.Set("Connection", "close")
+
+	if _, err := fmt.Fprintf(w, "HTTP/1.1 %s\r\n", res.Status); err != nil {
+		return err
+	}
+
+	if err := header.Write(w); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\r\n")
+	return err
+}
+
+// Body of a recorded response, copied to its file as it is read.
+type recordedBody struct {
+	io.ReadCloser
+
+	file *os.File
+	once sync.Once
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if _, err := b.file.Write(p[:n]); err != nil {
+			log.Warning.Printf("Could not record to %s: %s", b.file.Name(), err.Error())
+		}
+	}
+
+	return n, err
+}
+
+func (b *recordedBody) Close() error {
+	b.once.Do(func() {
+		b.file.Close()
+	})
+
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,196 @@
+package backend_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/dockertest"
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+	"github.com/mijara/statspout/telemetry"
+)
+
+// Repository counting the samples of each container.
+type counter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func (c *counter) Create(v interface{}) (repo.Interface, error) {
+	return c, nil
+}
+
+func (c *counter) Push(s *stats.Stats) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.counts[s.Name]++
+	return nil
+}
+
+func (c *counter) Close() {
+}
+
+func (c *counter) Clear(name string) {
+}
+
+func (c *counter) Name() string {
+	return "counter"
+}
+
+// Containers with less than the given samples.
+func (c *counter) behind(names []string, samples int) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	behind := 0
+	for _, name := range names {
+		if c.counts[name] < samples {
+			behind++
+		}
+	}
+
+	return behind
+}
+
+// Load polled on time in a scale test.
+type load struct {
+	containers int           // containers polled on every interval.
+	interval   time.Duration // time every container must be sampled within.
+	latency    time.Duration // time the daemon takes to answer.
+	daemons    int           // daemons of the client.
+	rounds     int           // intervals polled.
+	churn      int           // containers started then stopped meanwhile, updating the monitored ones.
+}
+
+// A single client polls 5,000 containers every 10 seconds: every query is accepted and every container is sampled
+// before the next interval. The daemon answers in 20ms, as cmd/bench runs it.
+func TestScaleTarget(t *testing.T) {
+	if testing.Short() || race {
+		t.Skip("polls 5,000 containers for 20 seconds, not with -short or -race")
+	}
+
+	poll(t, load{containers: 5000, interval: 10 * time.Second, latency: 20 * time.Millisecond, daemons: 20, rounds: 2})
+}
+
+// The same path on a smaller load, short enough for -race, while containers start and stop.
+func TestScaleChurn(t *testing.T) {
+	poll(t, load{containers: 500, interval: time.Second, latency: 10 * time.Millisecond, daemons: 20, rounds: 3,
+		churn: 20})
+}
+
+func poll(t *testing.T, l load) {
+	logs := &logBuffer{}
+	defer log.Redirect(logs)()
+
+	server := dockertest.NewServer()
+	defer server.Close()
+
+	server.SetRealistic(true)
+	server.SetLatency(l.latency)
+
+	for i := 0; i < l.containers; i++ {
+		server.AddContainer(fmt.Sprintf("scale-%04d", i), nil)
+	}
+
+	repository := &counter{counts: make(map[string]int)}
+
+	cli, err := backend.New(repository, server.Network(), server.Address(), l.daemons, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	cli.SetOneShot(true)
+
+	list, err := cli.GetContainers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != l.containers {
+		t.Fatalf("expected %d containers listed, got %d", l.containers, len(list))
+	}
+
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+
+	// the monitor updates the containers while they are polled, as in the collector.
+	containers := backend.NewContainers(list)
+	cli.StartMonitor(containers)
+
+	var scenario dockertest.Scenario
+	for i := 0; i < l.churn; i++ {
+		step := time.Duration(l.rounds) * l.interval / time.Duration(2*l.churn+1)
+		name := fmt.Sprintf("churn-%02d", i)
+		scenario = append(scenario, dockertest.Step{After: step, Do: dockertest.Start(name, nil)},
+			dockertest.Step{After: step, Do: dockertest.Stop(name)})
+	}
+	server.Run(scenario)
+
+	skipped := telemetry.Default.Level("queries_skipped")
+	before := skipped.Value()
+
+	for round := 1; round <= l.rounds; round++ {
+		start := time.Now()
+
+		monitored := containers.All()
+		for _, name := range names {
+			if _, ok := monitored[name]; !ok {
+				t.Fatalf("round %d: %s is no longer monitored", round, name)
+			}
+
+			container, _ := containers.Get(name)
+			if !cli.Query(container) {
+				t.Fatalf("round %d: query of %s skipped", round, name)
+			}
+		}
+
+		for repository.behind(names, round) > 0 && time.Since(start) < l.interval {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if behind := repository.behind(names, round); behind > 0 {
+			t.Fatalf("round %d: %d containers not sampled within the interval", round, behind)
+		}
+
+		if round < l.rounds {
+			time.Sleep(l.interval - time.Since(start))
+		}
+	}
+
+	if n := skipped.Value() - before; n != 0 {
+		t.Errorf("expected no query skipped, got %d", n)
+	}
+
+	// the containers started meanwhile were added, then removed once stopped.
+	deadline := time.Now().Add(5 * time.Second)
+	for containers.Len() != l.containers && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := containers.Len(); n != l.containers {
+		t.Errorf("expected %d containers once the others stopped, got %d", l.containers, n)
+	}
+
+	// queries left queued on close are drained, and no more are taken.
+	for _, name := range names {
+		cli.Query(list[name])
+	}
+	cli.Close()
+
+	if cli.Query(list[names[0]]) {
+		t.Error("expected no query once closed")
+	}
+
+	if strings.Contains(logs.String(), "ERROR") {
+		t.Errorf("expected every request to succeed, got:\n%s", logs.String())
+	}
+}
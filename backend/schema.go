@@ -0,0 +1,216 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/telemetry"
+)
+
+// Endpoints of the Docker API whose responses are parsed, each one counting its parse errors.
+const (
+	ENDPOINT_STATS   = "stats"
+	ENDPOINT_LIST    = "list"
+	ENDPOINT_INSPECT = "inspect"
+	ENDPOINT_EVENTS  = "events"
+	ENDPOINT_IMAGES  = "images"
+	ENDPOINT_TOP     = "top"
+	ENDPOINT_DF      = "system_df"
+	ENDPOINT_INFO    = "info"
+)
+
+// Prefix of the telemetry levels counting the responses of each endpoint that could not be parsed, such as
+// parse_errors_stats.
+const PARSE_ERRORS_PREFIX = "parse_errors_"
+
+// Bytes of the raw response logged around where it could not be parsed, at debug level.
+const SNIPPET_LENGTH = 256
+
+// Fields each response must have in strict mode, as dotted paths. Checked on every item of arrays.
+var (
+	statsFields   = []string{"read", "cpu_stats.cpu_usage.total_usage", "memory_stats"}
+	listFields    = []string{"Id", "Names"}
+	inspectFields = []string{"Id", "Name", "Config"}
+	stateFields   = []string{"State"}
+	limitsFields  = []string{"HostConfig", "State"}
+	eventFields   = []string{"Type", "Action", "Actor"}
+	imageFields   = []string{"Created"}
+	topFields     = []string{"Titles", "Processes"}
+	dfFields      = []string{"Volumes", "Containers"}
+	infoFields    = []string{"ID"}
+)
+
+// Responses are checked for the fields in use, see SetStrict.
+var strict bool
+
+func init() {
+	// counted from 0, so they are reported before the first error.
+	for _, endpoint := range []string{
+		ENDPOINT_STATS, ENDPOINT_LIST, ENDPOINT_INSPECT, ENDPOINT_EVENTS, ENDPOINT_IMAGES, ENDPOINT_TOP, ENDPOINT_DF,
+		ENDPOINT_INFO,
+	} {
+		telemetry.Default.Level(PARSE_ERRORS_PREFIX + endpoint)
+	}
+}
+
+// Checks that the responses of Docker have the fields in use, instead of taking missing ones as zero. Responses are
+// buffered whole to be checked, so the raw response around parse errors of every endpoint is logged at debug level,
+// not only of the stats frames and events. Must be called before creating clients.
+func SetStrict(enabled bool) {
+	strict = enabled
+}
+
+// Response of an endpoint that could not be parsed.
+type ParseError struct {
+	Endpoint string
+	Field    string // dotted path of the field, empty if unknown.
+	Err      error
+}
+
+func (e *ParseError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("Could not parse the %s response: %s", e.Endpoint, e.Err.Error())
+	}
+
+	return fmt.Sprintf("Could not parse the %s response at %s: %s", e.Endpoint, e.Field, e.Err.Error())
+}
+
+// Error of a field of a JSON value, see scanner.object.
+type fieldError struct {
+	path   []string
+	offset int // in the value, -1 if unknown.
+	err    error
+}
+
+func (e *fieldError) Error() string {
+	return strings.Join(e.path, ".") + ": " + e.err.Error()
+}
+
+// Decodes the response of the endpoint, which in strict mode must have the given fields.
+func decode(endpoint string, body io.Reader, v interface{}, fields []string) error {
+	if strict {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+
+		return unmarshal(endpoint, data, v, fields)
+	}
+
+	return parsed(endpoint, nil, json.NewDecoder(body).Decode(v))
+}
+
+// Unmarshals a response of the endpoint, which in strict mode must have the given fields.
+func unmarshal(endpoint string, data []byte, v interface{}, fields []string) error {
+	var err error
+	if unmarshaler, ok := v.(json.Unmarshaler); ok {
+		// decoded in place, without validating it first.
+		err = unmarshaler.UnmarshalJSON(data)
+	} else {
+		err = json.Unmarshal(data, v)
+	}
+
+	if err == nil && strict {
+		err = require(data, fields)
+	}
+
+	return parsed(endpoint, data, err)
+}
+
+// Counts the error, if any, and reports the endpoint and field that could not be parsed. The raw response is logged
+// around the error at debug level, if given.
+func parsed(endpoint string, data []byte, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	telemetry.Default.Level(PARSE_ERRORS_PREFIX + endpoint).Add(1)
+
+	parseErr := &ParseError{Endpoint: endpoint, Err: err}
+	offset := -1
+
+	switch e := err.(type) {
+	case *fieldError:
+		parseErr.Field = strings.Join(e.path, ".")
+		parseErr.Err = e.err
+		offset = e.offset
+	case *json.UnmarshalTypeError:
+		parseErr.Field = e.Field
+		offset = int(e.Offset)
+	case *json.SyntaxError:
+		offset = int(e.Offset)
+	}
+
+	if data != nil {
+		log.Debug.Printf("%s, raw response: %s", parseErr.Error(), snippet(data, offset))
+	}
+
+	return parseErr
+}
+
+// Part of the data around the offset, or its beginning if unknown.
+func snippet(data []byte, offset int) []byte {
+	start := offset - SNIPPET_LENGTH/2
+	if start < 0 {
+		start = 0
+	}
+
+	end := start + SNIPPET_LENGTH
+	if end > len(data) {
+		end = len(data)
+	}
+
+	return data[start:end]
+}
+
+// Checks that the value has the fields, on every item if it is an array.
+func require(data []byte, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		return requireFields(value, nil, fields)
+	}
+
+	for i, item := range items {
+		if err := requireFields(item, []string{fmt.Sprintf("[%d]", i)}, fields); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func requireFields(value interface{}, prefix []string, fields []string) error {
+	for _, field := range fields {
+		current := value
+
+		path := append([]string(nil), prefix...)
+		for _, key := range strings.Split(field, ".") {
+			path = append(path, key)
+
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return &fieldError{path: path, offset: -1, err: errors.New("Missing field.")}
+			}
+
+			if current, ok = object[key]; !ok {
+				return &fieldError{path: path, offset: -1, err: errors.New("Missing field.")}
+			}
+		}
+	}
+
+	return nil
+}
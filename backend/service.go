@@ -5,14 +5,18 @@ Spawns a number of daemons to listen for requests, which process in different go
 
 Example
 
-	service := NewService(10, MyRoutine, errorNotifier)
+	service := NewService(10, 100, MyRoutine, errorNotifier)
 
-	// will block until a daemon receives these messages (no process, just receive).
+	// queued for the daemons, will block while the queue is full (no process, just queue).
 	service.Send(99)
 	service.Send(22)
 	service.Send(97)
 	service.Send("hello")
-	service.Send(42)
+
+	// fails instead of blocking while the queue is full.
+	if !service.TrySend(42) {
+		...
+	}
 
 	// will block until all daemons exit.
 	service.Close()
@@ -20,12 +24,16 @@ Example
 package backend
 
 import (
-	"time"
+	"sync"
 
 	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/telemetry"
 	"errors"
 )
 
+// Daemons running, of every service.
+var running = telemetry.Default.Level("daemons")
+
 type Routine func(interface{}) error
 type ErrNotifier func(error)
 
@@ -33,9 +41,13 @@ type Service struct {
 	daemons int
 	r       Routine
 	errNot  ErrNotifier
+	mutex   sync.Mutex
 
 	closeChan chan bool
 	pipe      chan interface{}
+
+	sending sync.RWMutex // held while feeds are sent, so the pipe is never closed under a sender.
+	closed  bool
 }
 
 func daemon(routine Routine, pipe chan interface{}, close chan bool, errNotifier ErrNotifier) {
@@ -67,34 +79,125 @@ func daemon(routine Routine, pipe chan interface{}, close chan bool, errNotifier
 	}
 }
 
-func NewService(n int, r Routine, errNot ErrNotifier) *Service {
+// Creates a service with n daemons, queueing up to queue feeds while every daemon is busy.
+func NewService(n int, queue int, r Routine, errNot ErrNotifier) *Service {
 	closeChan := make(chan bool)
-	pipe := make(chan interface{})
+	pipe := make(chan interface{}, queue)
 
 	for i := 0; i < n; i++ {
 		go daemon(r, pipe, closeChan, errNot)
 	}
 
+	running.Add(int64(n))
 	log.Info.Printf("%d daemons started.", n)
 
 	return &Service{
 		daemons:   n,
+		r:         r,
+		errNot:    errNot,
 		pipe:      pipe,
 		closeChan: closeChan,
 	}
 }
 
+// Spawns n more daemons listening on the service.
+func (s *Service) Grow(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := 0; i < n; i++ {
+		go daemon(s.r, s.pipe, s.closeChan, s.errNot)
+	}
+
+	s.daemons += n
+	running.Add(int64(n))
+}
+
+// Stops n daemons, will block until each of them finishes its current work.
+func (s *Service) Shrink(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if n > s.daemons {
+		n = s.daemons
+	}
+
+	for i := 0; i < n; i++ {
+		s.closeChan <- true
+	}
+
+	s.daemons -= n
+	running.Add(int64(-n))
+}
+
+// Number of daemons currently running.
+func (s *Service) Daemons() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.daemons
+}
+
+// Queues the feed, blocking while the queue is full. Dropped once closed.
 func (s *Service) Send(feed interface{}) {
-	s.pipe <- feed
+	s.sending.RLock()
+	defer s.sending.RUnlock()
+
+	if !s.closed {
+		s.pipe <- feed
+	}
+}
+
+// Queues the feed unless the queue is full or the service closed, never blocks.
+func (s *Service) TrySend(feed interface{}) bool {
+	s.sending.RLock()
+	defer s.sending.RUnlock()
+
+	if s.closed {
+		return false
+	}
+
+	select {
+	case s.pipe <- feed:
+		return true
+	default:
+		return false
+	}
 }
 
+// Feeds left in the queue once closed, which no daemon will process.
+func (s *Service) Drain() []interface{} {
+	var feeds []interface{}
+	for feed := range s.pipe {
+		if feed != nil {
+			feeds = append(feeds, feed)
+		}
+	}
+
+	return feeds
+}
+
+// Stops the daemons once they finish their current work, feeds sent afterwards are dropped.
 func (s *Service) Close() {
+	// the senders still queueing are waited for, the daemons take their feeds meanwhile.
+	s.sending.Lock()
+	closed := s.closed
+	s.closed = true
+	s.sending.Unlock()
+
+	if closed {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	for i := s.daemons; i > 0; i-- {
 		s.closeChan <- true
 	}
+	running.Add(int64(-s.daemons))
+	s.daemons = 0
 
 	close(s.pipe)
 	close(s.closeChan)
-
-	time.Sleep(time.Millisecond * 500)
 }
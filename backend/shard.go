@@ -0,0 +1,125 @@
+package backend
+
+import (
+	"sync"
+)
+
+// Number of shards of the maps updated by every query, so daemons rarely wait for each other on the same lock.
+const SHARDS = 32
+
+// Shard of the container name, by its FNV-1a hash.
+func shardOf(name string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint32(name[i])
+		hash *= 16777619
+	}
+
+	return int(hash % SHARDS)
+}
+
+// Last counters seen of each container, sharded by name.
+type baselineMap struct {
+	shards [SHARDS]struct {
+		mutex     sync.Mutex
+		baselines map[string]Baseline
+	}
+}
+
+func newBaselineMap() *baselineMap {
+	m := &baselineMap{}
+	for i := range m.shards {
+		m.shards[i].baselines = make(map[string]Baseline)
+	}
+
+	return m
+}
+
+// Stores the baseline of the container, returning the previous one, if any.
+func (m *baselineMap) swap(name string, current Baseline) (Baseline, bool) {
+	shard := &m.shards[shardOf(name)]
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	previous, ok := shard.baselines[name]
+	shard.baselines[name] = current
+
+	return previous, ok
+}
+
+func (m *baselineMap) set(name string, baseline Baseline) {
+	shard := &m.shards[shardOf(name)]
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	shard.baselines[name] = baseline
+}
+
+func (m *baselineMap) delete(name string) {
+	shard := &m.shards[shardOf(name)]
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	delete(shard.baselines, name)
+}
+
+// Copy of every baseline.
+func (m *baselineMap) all() map[string]Baseline {
+	baselines := make(map[string]Baseline)
+
+	for i := range m.shards {
+		shard := &m.shards[i]
+
+		shard.mutex.Lock()
+		for name, baseline := range shard.baselines {
+			baselines[name] = baseline
+		}
+		shard.mutex.Unlock()
+	}
+
+	return baselines
+}
+
+// Containers with a query queued or running, sharded by name.
+type pendingSet struct {
+	shards [SHARDS]struct {
+		mutex sync.Mutex
+		names map[string]bool
+	}
+}
+
+func newPendingSet() *pendingSet {
+	p := &pendingSet{}
+	for i := range p.shards {
+		p.shards[i].names = make(map[string]bool)
+	}
+
+	return p
+}
+
+// Marks the container as pending, false if it already was.
+func (p *pendingSet) add(name string) bool {
+	shard := &p.shards[shardOf(name)]
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if shard.names[name] {
+		return false
+	}
+
+	shard.names[name] = true
+	return true
+}
+
+func (p *pendingSet) remove(name string) {
+	shard := &p.shards[shardOf(name)]
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	delete(shard.names, name)
+}
@@ -0,0 +1,109 @@
+package backend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Baselines older than this are discarded when restored, a rate over such a long gap would flatten any spike.
+const BASELINE_MAX_AGE = 10 * time.Minute
+
+// Last counters seen for a container, on which its rates are derived on the next request.
+type Baseline struct {
+	Cpu   CpuStats   `json:"cpu"` // only kept for one-shot requests.
+	Blkio BlkioStats `json:"blkio"`
+	Read  time.Time  `json:"read"`
+}
+
+// File keeping the baselines of the containers of every host across restarts, so the first sample after a restart
+// is derived from the last one before it instead of starting from zero.
+type StateFile struct {
+	path string
+
+	mutex sync.Mutex
+	hosts map[string]map[string]Baseline // baselines of each host, by container name.
+	dirty bool                           // whether there are changes to save.
+}
+
+// Loads the state file at the given path, it is created on the first save if it doesn't exist.
+func LoadStateFile(path string) (*StateFile, error) {
+	state := &StateFile{
+		path:  path,
+		hosts: make(map[string]map[string]Baseline),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &state.hosts); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// Baselines of the containers of the named host.
+func (s *StateFile) Baselines(host string) map[string]Baseline {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.hosts[host]
+}
+
+// Replaces the baselines of the named host, they are written on the next save.
+func (s *StateFile) Update(host string, baselines map[string]Baseline) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.hosts[host] = baselines
+	s.dirty = true
+}
+
+// Writes the baselines to the file if they changed since the last save. The file is replaced atomically, so a
+// crash while saving leaves the previous state.
+func (s *StateFile) Save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(s.hosts)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.dirty = false
+
+	return nil
+}
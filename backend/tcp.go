@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mijara/statspout/stats"
+)
+
+// States of the TCP connections in /proc/net/tcp, in hexadecimal.
+const (
+	TCP_ESTABLISHED = "01"
+	TCP_TIME_WAIT   = "06"
+)
+
+// Counts the TCP connections of the network namespace of the process, over IPv4 and IPv6, from the given /proc.
+func readConnections(proc string, pid int) (*stats.Connections, error) {
+	dir := filepath.Join(proc, strconv.Itoa(pid), "net")
+	connections := &stats.Connections{}
+
+	for _, table := range []string{"tcp", "tcp6"} {
+		err := countConnections(filepath.Join(dir, table), connections)
+
+		// IPv6 may be disabled.
+		if os.IsNotExist(err) && table == "tcp6" {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return connections, nil
+}
+
+// Adds the connections of a /proc/net/tcp table to the counts, by the state in its fourth column.
+func countConnections(path string, connections *stats.Connections) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	// header.
+	scanner.Scan()
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+
+		switch fields[3] {
+		case TCP_ESTABLISHED:
+			connections.Established++
+		case TCP_TIME_WAIT:
+			connections.TimeWait++
+		}
+	}
+
+	return scanner.Err()
+}
+
+// TCP connections of the container, if /proc was given (see SetProc) and the container was inspected.
+func (cli *Client) containerConnections(name string) (*stats.Connections, error) {
+	pid, err := cli.containerPid(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return readConnections(cli.proc, pid)
+}
@@ -0,0 +1,149 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+// Columns asked to ps by the Docker Top API, Docker needs the PID one to tell the processes of the container.
+const TOP_PS_ARGS = "-o pid,nlwp,pcpu,comm"
+
+// Processes of a container, as reported by the Docker Top API.
+type topResponse struct {
+	Titles    []string   `json:"Titles"`
+	Processes [][]string `json:"Processes"`
+}
+
+// Lists the processes of the containers through the Docker Top API and pushes their counts to the repository, if it
+// can store processes, along the names of up to names processes using the most CPU. Docker runs ps for each
+// container, so this is meant to run far less often than the stats are queried. Containers that could not be listed
+// are skipped.
+func (cli *Client) PushProcesses(containers []Container, names int) error {
+	pusher, ok := cli.repo.(repo.ProcessPusher)
+	if !ok {
+		return nil
+	}
+
+	for _, container := range containers {
+		top, err := cli.top(container.CanonicalName)
+		if err != nil {
+			log.Debug.Printf("Could not list the processes of %s: %s", container.CanonicalName, err.Error())
+			continue
+		}
+
+		processes := top.processes(names)
+		processes.Timestamp = time.Now()
+		processes.Name = cli.Identify(container)
+		processes.Labels = container.Labels
+
+		if err := pusher.PushProcesses(processes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Queries the Docker Top API for the processes of the container.
+func (cli *Client) top(name string) (*topResponse, error) {
+	req, err := newRequest("GET", "/containers/"+name+"/top?ps_args="+url.QueryEscape(TOP_PS_ARGS))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := cli.dedicated.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, release, err := responseBody(res)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Could not list the processes of %s: %s", name, res.Status)
+	}
+
+	top := &topResponse{}
+	if err := decode(ENDPOINT_TOP, body, top, topFields); err != nil {
+		return nil, err
+	}
+
+	return top, nil
+}
+
+// Counts the processes and their threads, and names up to n processes using the most CPU, once each. Daemons that
+// ignore the ps arguments (Windows) report no threads nor CPU, each process counts as a single thread then.
+func (top *topResponse) processes(n int) *stats.Processes {
+	columns := make(map[string]int, len(top.Titles))
+	for i, title := range top.Titles {
+		columns[title] = i
+	}
+
+	column := func(process []string, title string) (string, bool) {
+		i, ok := columns[title]
+		if !ok || i >= len(process) {
+			return "", false
+		}
+		return process[i], true
+	}
+
+	type usage struct {
+		command string
+		cpu     float64
+	}
+
+	processes := &stats.Processes{Processes: uint64(len(top.Processes))}
+	usages := make([]usage, 0, len(top.Processes))
+
+	for _, process := range top.Processes {
+		threads := uint64(1)
+		if value, ok := column(process, "NLWP"); ok {
+			if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+				threads = n
+			}
+		}
+		processes.Threads += threads
+
+		command, ok := column(process, "COMMAND")
+		if !ok {
+			continue
+		}
+
+		cpu := 0.0
+		if value, ok := column(process, "%CPU"); ok {
+			cpu, _ = strconv.ParseFloat(value, 64)
+		}
+
+		usages = append(usages, usage{command, cpu})
+	}
+
+	sort.SliceStable(usages, func(i, j int) bool {
+		return usages[i].cpu > usages[j].cpu
+	})
+
+	seen := make(map[string]bool)
+	for _, u := range usages {
+		if len(processes.Top) >= n {
+			break
+		}
+
+		if !seen[u.command] {
+			seen[u.command] = true
+			processes.Top = append(processes.Top, u.command)
+		}
+	}
+
+	return processes
+}
@@ -1,14 +1,30 @@
 package backend
 
-import "net"
+import (
+	"errors"
+	"net"
+	"path"
+	"time"
 
-// Creates a client for TCP (http) or Unix with the given address.
-func createConn(http bool, address string) (net.Conn, error) {
-	if http {
-		return net.Dial("tcp", address)
+	"github.com/mijara/statspout/stats"
+)
+
+// Creates a client for the network (see Dial) with the given address.
+func createConn(network string, address string) (net.Conn, error) {
+	return Dial(network, address, 0)
+}
+
+// Dials the Docker endpoint through TCP (tcp), a Unix socket (unix) or a Windows named pipe (npipe), giving up after
+// the timeout, if positive.
+func Dial(network string, address string, timeout time.Duration) (net.Conn, error) {
+	switch network {
+	case "tcp", "unix":
+		return net.DialTimeout(network, address, timeout)
+	case "npipe":
+		return dialPipe(address, timeout)
 	}
 
-	return net.Dial("unix", address)
+	return nil, errors.New("Unknown network: " + network)
 }
 
 // taken from: https://github.com/portainer/portainer/blob/develop/app/components/stats/statsController.js#L177-L193
@@ -29,16 +45,103 @@ func calcMemoryPercent(stats *ContainerStats) float64 {
 	return float64(stats.Memory.Usage) * 100.0 / float64(stats.Memory.Limit)
 }
 
-func sumTxBytesTotal(interfaces map[string]InterfaceStats) (sum uint32) {
-	for _, i := range interfaces {
-		sum += i.TxBytes
+// Limits at or above this are the cgroup v1 way of saying there is no limit.
+const UNLIMITED = 1 << 62
+
+// Swap the container may use on top of its memory limit, 0 if unlimited or not reported.
+func calcSwapLimit(stats *ContainerStats) uint64 {
+	detail := stats.Memory.Detail
+	if detail.MemswLimit >= UNLIMITED || detail.MemswLimit < detail.MemoryLimit {
+		return 0
 	}
-	return
+
+	return detail.MemswLimit - detail.MemoryLimit
+}
+
+// Block I/O per second between two samples.
+type blkioRates struct {
+	readBytes  float64
+	writeBytes float64
+	reads      float64
+	writes     float64
 }
 
-func sumRxBytesTotal(interfaces map[string]InterfaceStats) (sum uint32) {
-	for _, i := range interfaces {
-		sum += i.RxBytes
+// Block I/O rates since the previous sample, 0 without one, or if the counters were reset since (a container that
+// restarted).
+func calcBlkioRates(previous Baseline, ok bool, current Baseline) blkioRates {
+	elapsed := current.Read.Sub(previous.Read).Seconds()
+
+	before, after := previous.Blkio, current.Blkio
+	if !ok || elapsed <= 0 || after.ReadBytes < before.ReadBytes || after.WriteBytes < before.WriteBytes ||
+		after.Reads < before.Reads || after.Writes < before.Writes {
+		return blkioRates{}
+	}
+
+	return blkioRates{
+		readBytes:  float64(after.ReadBytes-before.ReadBytes) / elapsed,
+		writeBytes: float64(after.WriteBytes-before.WriteBytes) / elapsed,
+		reads:      float64(after.Reads-before.Reads) / elapsed,
+		writes:     float64(after.Writes-before.Writes) / elapsed,
+	}
+}
+
+// Totals of every interface, but the excluded ones (see SetExcludedInterfaces).
+func sumNetworks(interfaces map[string]InterfaceStats, excluded []string) (sum InterfaceStats) {
+	for name, i := range interfaces {
+		if excludedInterface(name, excluded) {
+			continue
+		}
+
+		sum.RxBytes += i.RxBytes
+		sum.RxDropped += i.RxDropped
+		sum.RxErrors += i.RxErrors
+		sum.RxPackets += i.RxPackets
+		sum.TxBytes += i.TxBytes
+		sum.TxDropped += i.TxDropped
+		sum.TxErrors += i.TxErrors
+		sum.TxPackets += i.TxPackets
 	}
 	return
 }
+
+// Stats of every interface, but the excluded ones (see SetPerInterface).
+func perInterface(interfaces map[string]InterfaceStats, excluded []string) map[string]stats.Interface {
+	result := make(map[string]stats.Interface, len(interfaces))
+	for name, i := range interfaces {
+		if excludedInterface(name, excluded) {
+			continue
+		}
+
+		result[name] = stats.Interface{
+			TxBytes:   i.TxBytes,
+			RxBytes:   i.RxBytes,
+			TxPackets: i.TxPackets,
+			RxPackets: i.RxPackets,
+			TxErrors:  i.TxErrors,
+			RxErrors:  i.RxErrors,
+			TxDropped: i.TxDropped,
+			RxDropped: i.RxDropped,
+		}
+	}
+	return result
+}
+
+// Whether the interface matches any of the patterns.
+func excludedInterface(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks that the interface pattern follows the path.Match syntax.
+func CheckInterfacePattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return errors.New("Invalid interface pattern " + pattern + ": " + err.Error())
+	}
+
+	return nil
+}
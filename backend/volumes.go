@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+// Disk usage of the volumes and the mounts of the containers, as reported by the Docker System DF API.
+type diskUsage struct {
+	Volumes []struct {
+		Name      string `json:"Name"`
+		UsageData *struct {
+			Size int64 `json:"Size"` // -1 if not measured.
+		} `json:"UsageData"`
+	} `json:"Volumes"`
+
+	Containers []struct {
+		Names  []string          `json:"Names"`
+		Labels map[string]string `json:"Labels"`
+		Mounts []struct {
+			Type        string `json:"Type"`
+			Name        string `json:"Name"`
+			Destination string `json:"Destination"`
+		} `json:"Mounts"`
+	} `json:"Containers"`
+}
+
+// Measures the disk usage of the named volumes mounted by the containers and pushes them to the repository, if it can
+// store volumes. Docker walks every volume to measure it, which is slow on large ones, so this is meant to run far
+// less often than the stats are queried. Bind mounts are not measured by Docker and are left out.
+func (cli *Client) PushVolumes(containers []Container) error {
+	pusher, ok := cli.repo.(repo.VolumePusher)
+	if !ok {
+		return nil
+	}
+
+	monitored := make(map[string]Container, len(containers))
+	for _, container := range containers {
+		monitored[container.CanonicalName] = container
+	}
+
+	req, err := newRequest("GET", "/system/df")
+	if err != nil {
+		return err
+	}
+
+	res, err := cli.dedicated.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, release, err := responseBody(res)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("Could not get the disk usage: " + res.Status)
+	}
+
+	usage := diskUsage{}
+	if err := decode(ENDPOINT_DF, body, &usage, dfFields); err != nil {
+		return err
+	}
+
+	sizes := make(map[string]uint64, len(usage.Volumes))
+	for _, volume := range usage.Volumes {
+		if volume.UsageData != nil && volume.UsageData.Size >= 0 {
+			sizes[volume.Name] = uint64(volume.UsageData.Size)
+		}
+	}
+
+	now := time.Now()
+
+	for _, container := range usage.Containers {
+		if len(container.Names) == 0 {
+			continue
+		}
+
+		name := strings.TrimPrefix(container.Names[0], "/")
+		known, ok := monitored[name]
+		if !ok {
+			continue
+		}
+
+		for _, mount := range container.Mounts {
+			size, ok := sizes[mount.Name]
+			if mount.Type != "volume" || !ok {
+				continue
+			}
+
+			err := pusher.PushVolume(&stats.Volume{
+				Timestamp:   now,
+				Name:        cli.Identify(known),
+				Volume:      mount.Name,
+				Destination: mount.Destination,
+				Size:        size,
+				Labels:      container.Labels,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+/*
+Benchmark:
+Collects stats from a fake Docker API simulating many containers and pushes them to the chosen repository, measuring
+the throughput and the end-to-end latency, from the daemon reading the stats to the repository accepting them. Every
+statspout flag applies, such as the interval, the daemons and the repository options.
+
+Example
+
+	go run ./cmd/bench -bench.containers=1000 -bench.duration=1m -interval=2s -repository=prometheus
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout"
+	"github.com/mijara/statspout/backend"
+	_ "github.com/mijara/statspout/common" // registers the repositories.
+	"github.com/mijara/statspout/dockertest"
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/opts"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+	"github.com/mijara/statspout/telemetry"
+)
+
+// Repository used unless another one is given, keeping the pushes cheap to measure statspout itself.
+const DEFAULT_REPOSITORY = "memory"
+
+// Stages of the pipeline reported, in order, see the telemetry package.
+var stages = []string{backend.STAGE_SCHEDULE, backend.STAGE_REQUEST, backend.STAGE_DECODE, backend.STAGE_PUSH}
+
+var (
+	containers = flag.Int("bench.containers", 100, "Number of simulated containers.")
+	duration   = flag.Duration("bench.duration", 30*time.Second, "Time to collect for.")
+	latency    = flag.Duration("bench.latency", 0, "Time the simulated daemon takes to answer each stats request.")
+	realistic  = flag.Bool("bench.realistic", true, "Simulate the full stats payload of a real daemon.")
+)
+
+// Repository measuring the pushes to another one.
+type recorder struct {
+	repo.Interface
+
+	mutex     sync.Mutex
+	latencies []time.Duration // from the daemon reading the stats to the push returning.
+	failed    int
+}
+
+func (r *recorder) Push(s *stats.Stats) error {
+	err := r.Interface.Push(s)
+	latency := time.Since(s.Timestamp)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err != nil {
+		r.failed++
+	} else {
+		r.latencies = append(r.latencies, latency)
+	}
+
+	return err
+}
+
+func main() {
+	cfg := opts.NewConfig()
+
+	cfg.AddRegistered()
+
+	options := opts.GetOpts()
+
+	repository := flag.Lookup("repository")
+	repository.DefValue = DEFAULT_REPOSITORY
+	repository.Value.Set(DEFAULT_REPOSITORY)
+
+	options.Parse()
+
+	// stdout is left for the report.
+	log.Redirect(os.Stderr)
+
+	if err := run(cfg); err != nil {
+		log.Error.Fatal(err)
+	}
+}
+
+func run(cfg *opts.Config) error {
+	if *containers < 1 {
+		return fmt.Errorf("At least one container is needed, got %d.", *containers)
+	}
+
+	if err := opts.Validate(cfg, false); err != nil {
+		return err
+	}
+
+	server := dockertest.NewServer()
+	defer server.Close()
+
+	server.SetLatency(*latency)
+	server.SetRealistic(*realistic)
+
+	for i := 0; i < *containers; i++ {
+		server.AddContainer(fmt.Sprintf("bench-%d", i), map[string]string{"bench": "true"})
+	}
+
+	repository, err := opts.CreateRepositoryFromFlags(cfg)
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	r := &recorder{Interface: repository}
+
+	collector, err := statspout.NewCollector(
+		statspout.WithEndpoint(server.Network(), server.Address()),
+		statspout.WithInterval(opts.GetOpts().Interval),
+		statspout.WithDaemons(opts.GetOpts().Daemons, opts.GetOpts().MaxDaemons),
+		statspout.WithPipeline(opts.GetOpts().Pipeline),
+		statspout.WithSpread(opts.GetOpts().Spread),
+		statspout.WithOneShot(opts.GetOpts().OneShot),
+		statspout.WithSample(opts.GetOpts().Sample),
+		statspout.WithAdaptive(opts.GetOpts().Adaptive.Latency, opts.GetOpts().Adaptive.Max),
+		statspout.WithRepo(r))
+	if err != nil {
+		return err
+	}
+
+	log.Info.Printf("Collecting %d containers for %s.", *containers, *duration)
+
+	start := time.Now()
+	if err := collector.Start(); err != nil {
+		return err
+	}
+
+	time.Sleep(*duration)
+	elapsed := time.Since(start)
+	collector.Stop()
+
+	report(r, elapsed)
+	return nil
+}
+
+// Prints the throughput, the latency percentiles and the latency of each stage.
+func report(r *recorder, elapsed time.Duration) {
+	r.mutex.Lock()
+	latencies := r.latencies
+	failed := r.failed
+	r.mutex.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+
+	expected := float64(*containers) / opts.GetOpts().Interval.Seconds()
+
+	fmt.Printf("containers:  %d, every %s, for %s\n",
+		*containers, opts.GetOpts().Interval, elapsed.Round(time.Millisecond))
+	fmt.Printf("repository:  %s\n", opts.GetOpts().Repository)
+	fmt.Printf("samples:     %d pushed, %d failed\n", len(latencies), failed)
+	fmt.Printf("throughput:  %.1f samples/s (expected %.1f)\n", float64(len(latencies))/elapsed.Seconds(), expected)
+
+	if len(latencies) > 0 {
+		fmt.Printf("latency:     p50 %s, p95 %s, p99 %s, max %s\n",
+			percentile(latencies, 0.50), percentile(latencies, 0.95), percentile(latencies, 0.99),
+			latencies[len(latencies)-1])
+	}
+
+	snapshot := telemetry.Default.Snapshot()
+	for _, name := range stages {
+		stage, ok := snapshot.Stages[name]
+		if !ok || stage.Count == 0 {
+			continue
+		}
+
+		fmt.Printf("%-12s %s average, %s max\n", name+":",
+			seconds(stage.Sum/float64(stage.Count)), seconds(stage.Max))
+	}
+}
+
+// Latency below which the given fraction of the sorted latencies falls.
+func percentile(latencies []time.Duration, fraction float64) time.Duration {
+	return latencies[int(fraction*float64(len(latencies)-1))]
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -2,20 +2,14 @@ package main
 
 import (
 	"github.com/mijara/statspout"
-	"github.com/mijara/statspout/common"
+	_ "github.com/mijara/statspout/common" // registers the repositories.
 	"github.com/mijara/statspout/opts"
 )
 
 func main() {
 	cfg := opts.NewConfig()
 
-	cfg.AddRepository(&common.Stdout{}, nil)
-
-	cfg.AddRepository(&common.Rest{}, common.CreateRestOpts())
-
-	cfg.AddRepository(&common.Prometheus{}, common.CreatePrometheusOpts())
-	cfg.AddRepository(&common.InfluxDB{}, common.CreateInfluxDBOpts())
-	cfg.AddRepository(&common.Mongo{}, common.CreateMongoOpts())
+	cfg.AddRegistered()
 
 	statspout.Start(cfg)
 }
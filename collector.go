@@ -0,0 +1,685 @@
+package statspout
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/schedule"
+	"github.com/mijara/statspout/telemetry"
+)
+
+const (
+	// factor intervals are stretched by each time Docker is found slow, and shrunk by once it recovered.
+	ADAPTIVE_BACKOFF  = 1.5
+	ADAPTIVE_RECOVERY = 1.25
+)
+
+// Containers of every collector, reported to the telemetry: discovered on the hosts, monitored once filtered, and
+// excluded by the filters or paused.
+var (
+	discovered = telemetry.Default.Level("containers_discovered")
+	monitored  = telemetry.Default.Level("containers_monitored")
+	excluded   = telemetry.Default.Level("containers_excluded")
+)
+
+// Collector queries the stats of the Docker containers and pushes them to a repository. It can be
+// embedded in other programs, since it doesn't depend on command line flags or global state.
+//
+// Example
+//
+//	collector, err := statspout.NewCollector(
+//		statspout.WithEndpoint("unix", "/var/run/docker.sock"),
+//		statspout.WithInterval(10*time.Second),
+//		statspout.WithRepo(common.NewStdout()))
+//
+//	err = collector.Start()
+//	...
+//	collector.Stop()
+type Collector struct {
+	network    string                       // network of the Docker endpoint, unix or tcp.
+	address    string                       // address of the Docker endpoint or socket path.
+	interval   time.Duration                // default interval between each stats query.
+	rules      []schedule.Rule              // interval overrides.
+	windows    []schedule.Window            // when the matching containers are collected.
+	repo       repo.Interface               // the repository to push stats.
+	filter     func(backend.Container) bool // which containers to query.
+	daemons    int                          // number of daemons to handle requests.
+	maxDaemons int                          // maximum number of daemons when autoscaling.
+	pipeline   int                          // number of daemons sharing each connection.
+	spread     bool                         // spread queries along the tick.
+	oneShot    bool                         // use one-shot stats requests whatever the interval.
+	slow       time.Duration                // latency above which intervals are stretched, disabled if 0.
+	maxStretch float64                      // maximum factor intervals are stretched by.
+	sample     int                          // containers queried each tick, all of the due ones if 0.
+	execEvents bool                         // push exec and attach events.
+	volumes    time.Duration                // interval between measuring the volumes, disabled if 0.
+	top        time.Duration                // interval between listing the processes, disabled if 0.
+	topNames   int                          // names of the processes using the most CPU pushed along.
+	proc       string                       // path to the /proc of the Docker host, disabled if empty.
+	tcp        bool                         // count the TCP connections from /proc.
+	identity   *backend.Identity            // identity of the containers in what is pushed, their name if nil.
+	excluded   []string                     // patterns of the interfaces left out of the network totals.
+	perIface   bool                         // push the network stats of each interface.
+	adapted    time.Time                    // last time intervals were stretched or shrunk.
+	labels     map[string]string            // labels added to every sample.
+	state      *backend.StateFile           // keeps the counter baselines across restarts.
+	stateKey   string                       // name of the host in the state file.
+
+	client     *backend.Client
+	sched      *schedule.Scheduler
+	containers *backend.Containers // updated by the events monitor while the loop reads them.
+
+	pauseMutex sync.RWMutex
+	paused     map[string]bool // selectors excluded from collection at runtime.
+
+	counted [3]int64 // containers discovered, monitored and excluded last reported, see count.
+
+	measures      []*measure     // measures slower than the stats, run in the background.
+	measuresMutex sync.Mutex     // guards the running measures.
+	measuring     sync.WaitGroup // running measures, waited on Stop.
+
+	quit chan bool // signals the loop to stop.
+	done chan bool // closed when the loop stopped.
+}
+
+// Functional option to configure a Collector.
+type Option func(*Collector)
+
+// Sets the Docker endpoint, network must be "unix" (address is the socket path), "npipe" (address is the named
+// pipe path, on Windows) or "tcp" (address is host:port). Defaults to the unix socket at /var/run/docker.sock.
+func WithEndpoint(network string, address string) Option {
+	return func(c *Collector) {
+		c.network = network
+		c.address = address
+	}
+}
+
+// Sets the default interval between each stats query. Defaults to 5 seconds.
+func WithInterval(interval time.Duration) Option {
+	return func(c *Collector) {
+		c.interval = interval
+	}
+}
+
+// Collects the containers matching the windows only while one of them is open, for example during business hours
+// or the window of a batch job. Containers matching no window are always collected.
+func WithWindows(windows ...schedule.Window) Option {
+	return func(c *Collector) {
+		c.windows = windows
+	}
+}
+
+// Sets interval overrides for containers matching the rules.
+func WithRules(rules ...schedule.Rule) Option {
+	return func(c *Collector) {
+		c.rules = rules
+	}
+}
+
+// Sets the repository to push stats to, this option is mandatory. The repository is not closed by the
+// Collector.
+func WithRepo(repository repo.Interface) Option {
+	return func(c *Collector) {
+		c.repo = repository
+	}
+}
+
+// Sets a filter to decide which containers are queried, by default all of them are.
+func WithFilter(filter func(backend.Container) bool) Option {
+	return func(c *Collector) {
+		c.filter = filter
+	}
+}
+
+// Sets the number of daemons to handle requests, if max is greater than n, the pool will autoscale
+// between both values. Defaults to 10 daemons without autoscaling.
+func WithDaemons(n int, max int) Option {
+	return func(c *Collector) {
+		c.daemons = n
+		c.maxDaemons = max
+	}
+}
+
+// Shares each connection to the Docker endpoint among n daemons. Fewer connections save file descriptors on hosts
+// with many containers, but the requests on a connection are serialized, not pipelined: Docker answers them one at a
+// time, so each one waits until the response to the previous one is read. Regular stats requests hold the connection
+// for a second, so more than 1 needs one-shot requests (see WithOneShot) or a sub-second tick. Defaults to 1, a
+// connection per daemon.
+func WithPipeline(n int) Option {
+	return func(c *Collector) {
+		c.pipeline = n
+	}
+}
+
+// Spreads the queries evenly along the tick instead of querying all containers at once.
+func WithSpread(spread bool) Option {
+	return func(c *Collector) {
+		c.spread = spread
+	}
+}
+
+// Uses one-shot stats requests whatever the interval. Regular requests wait about a second for the daemon to take a
+// second sample, so each daemon makes at most one per second, one-shot requests return at once. Sub-second intervals
+// always use them.
+func WithOneShot(oneShot bool) Option {
+	return func(c *Collector) {
+		c.oneShot = oneShot
+	}
+}
+
+// Stretches the polling intervals, up to max times, while the moving average latency of the stats requests is above
+// slow, shrinking them back once it falls below half of it, so statspout backs off an overloaded Docker daemon.
+// Regular stats requests take about a second, since Docker samples twice, slow must be above that unless one-shot
+// requests are used. Disabled by default.
+func WithAdaptive(slow time.Duration, max float64) Option {
+	return func(c *Collector) {
+		c.slow = slow
+		c.maxStretch = max
+	}
+}
+
+// Queries at most k of the due containers each tick, rotating through them, for hosts where polling every container
+// each interval is unnecessary. Each container is then polled about every interval times the containers divided by
+// k, with a bounded load per tick. Defaults to 0, querying every due container.
+func WithSample(k int) Option {
+	return func(c *Collector) {
+		c.sample = k
+	}
+}
+
+// Pushes the exec and attach events of the containers to the repository, along the command run, for auditing the
+// access to the containers. Disabled by default.
+func WithExecEvents(enabled bool) Option {
+	return func(c *Collector) {
+		c.execEvents = enabled
+	}
+}
+
+// Measures the disk usage of the named volumes mounted by the containers every interval, pushing it to the
+// repository if it can store volumes (see repo.VolumePusher). Docker walks every volume to measure it, so the
+// interval should be far longer than the stats one. Disabled by default.
+func WithVolumes(interval time.Duration) Option {
+	return func(c *Collector) {
+		c.volumes = interval
+	}
+}
+
+// Reads the file descriptors open by the init process of each container and their limit from the given /proc, to
+// catch descriptor leaks, which the Docker Stats API doesn't report. It must be the /proc of the Docker host, so the
+// Collector must run on the host, or in a container sharing its PID namespace or mounting it. Disabled by default.
+func WithProc(path string) Option {
+	return func(c *Collector) {
+		c.proc = path
+	}
+}
+
+// Counts the established and time-wait TCP connections of each container from the /proc given to WithProc, to catch
+// connection leaks. Reading them is proportional to the connections, so it is disabled by default.
+func WithConnections(enabled bool) Option {
+	return func(c *Collector) {
+		c.tcp = enabled
+	}
+}
+
+// Lists the processes of the containers every interval through the Docker Top API, pushing their process and thread
+// counts to the repository if it can store processes (see repo.ProcessPusher), along the names of up to names
+// processes using the most CPU. Docker runs ps for each container, so the interval should be far longer than the
+// stats one. Disabled by default.
+func WithTop(interval time.Duration, names int) Option {
+	return func(c *Collector) {
+		c.top = interval
+		c.topNames = names
+	}
+}
+
+// Identifies the containers in the pushed samples, events, volumes and processes by the given identity, for example
+// by a label for Swarm tasks, whose generated names change on each deployment. Identities are not checked to be
+// unique, containers sharing one are pushed under the same name. By name by default.
+func WithIdentity(identity *backend.Identity) Option {
+	return func(c *Collector) {
+		c.identity = identity
+	}
+}
+
+// Leaves the interfaces matching any of the patterns, in the path.Match syntax, out of the network totals, for
+// example lo, veth* and docker_gwbridge, since bridges and overlays count the same traffic twice on Swarm nodes.
+// Every interface is counted by default.
+func WithExcludedInterfaces(patterns ...string) Option {
+	return func(c *Collector) {
+		c.excluded = patterns
+	}
+}
+
+// Pushes the network stats of each interface, but the excluded ones, along the totals, so containers attached to
+// several networks can be diagnosed. Only the totals are pushed by default.
+func WithPerInterface(enabled bool) Option {
+	return func(c *Collector) {
+		c.perIface = enabled
+	}
+}
+
+// Adds the labels to every sample and event, for example to tell the host they come from. They take precedence over
+// the container labels of the same name.
+func WithLabels(labels map[string]string) Option {
+	return func(c *Collector) {
+		c.labels = labels
+	}
+}
+
+// Keeps the counter baselines of the containers in the state file under the given key, usually the host name,
+// so rates are derived across restarts instead of starting from zero. The file is not saved by the Collector.
+func WithState(state *backend.StateFile, key string) Option {
+	return func(c *Collector) {
+		c.state = state
+		c.stateKey = key
+	}
+}
+
+// Creates a new Collector with the given options.
+func NewCollector(options ...Option) (*Collector, error) {
+	c := &Collector{
+		network:  "unix",
+		address:  "/var/run/docker.sock",
+		interval: 5 * time.Second,
+		daemons:  10,
+		pipeline: 1,
+		filter: func(backend.Container) bool {
+			return true
+		},
+		paused: make(map[string]bool),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.repo == nil {
+		return nil, errors.New("A repository is needed.")
+	}
+
+	if c.network != "unix" && c.network != "tcp" && c.network != "npipe" {
+		return nil, errors.New("Unknown network: " + c.network)
+	}
+
+	if c.interval <= 0 {
+		return nil, errors.New("Interval must be positive.")
+	}
+
+	if c.daemons < 1 {
+		return nil, errors.New("At least one daemon is needed.")
+	}
+
+	if c.sample < 0 {
+		return nil, errors.New("Sample cannot be negative.")
+	}
+
+	if c.volumes < 0 {
+		return nil, errors.New("Volumes interval cannot be negative.")
+	}
+
+	if c.top < 0 {
+		return nil, errors.New("Top interval cannot be negative.")
+	}
+
+	for _, pattern := range c.excluded {
+		if err := backend.CheckInterfacePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.topNames < 0 {
+		return nil, errors.New("Top names cannot be negative.")
+	}
+
+	sched, err := schedule.New(c.interval, c.rules)
+	if err != nil {
+		return nil, err
+	}
+	sched.SetSample(c.sample)
+	sched.SetWindows(c.windows)
+	c.sched = sched
+
+	// sub-second ticks use one-shot requests, see Start.
+	if c.pipeline > 1 && !c.oneShot && sched.Tick() >= time.Second {
+		return nil, errors.New("Sharing connections needs one-shot stats requests, regular ones hold them for a second.")
+	}
+
+	return c, nil
+}
+
+// Connects to the Docker endpoint and starts collecting stats in the background.
+func (c *Collector) Start() error {
+	repository := c.repo
+	if len(c.labels) > 0 {
+		repository = repo.NewLabeled(c.repo, c.labels)
+	}
+
+	client, err := backend.New(repository, c.network, c.address, c.daemons, c.maxDaemons, c.pipeline)
+	if err != nil {
+		return err
+	}
+
+	// sub-second intervals cannot wait for the daemon to take a second sample, labels never go below the tick.
+	client.SetOneShot(c.oneShot || c.sched.Tick() < time.Second)
+	client.SetExecEvents(c.execEvents)
+	client.SetProc(c.proc)
+	client.SetConnections(c.tcp)
+	client.SetIdentity(c.identity)
+	client.SetExcludedInterfaces(c.excluded)
+	client.SetPerInterface(c.perIface)
+
+	if c.state != nil {
+		client.Restore(c.state.Baselines(c.stateKey))
+	}
+
+	containers, err := client.GetContainers()
+	if err != nil {
+		client.Close()
+		return err
+	}
+
+	c.client = client
+	c.containers = backend.NewContainers(containers)
+	c.quit = make(chan bool)
+	c.done = make(chan bool)
+
+	// the monitor keeps the containers up to date from now on.
+	for _, container := range containers {
+		client.Added(container)
+	}
+	client.StartMonitor(c.containers)
+
+	c.measures = nil
+	if c.volumes > 0 {
+		c.measures = append(c.measures, &measure{what: "volumes", interval: c.volumes, run: client.PushVolumes})
+	}
+	if c.top > 0 {
+		c.measures = append(c.measures, &measure{what: "processes", interval: c.top, run: func(containers []backend.Container) error {
+			return client.PushProcesses(containers, c.topNames)
+		}})
+	}
+
+	go c.loop()
+
+	return nil
+}
+
+// Stops collecting stats, closing all connections and Goroutines.
+func (c *Collector) Stop() {
+	close(c.quit)
+	<-c.done
+	c.measuring.Wait()
+
+	// the containers of the host are no longer reported.
+	c.report(0, 0)
+
+	c.client.Close()
+	c.persist()
+}
+
+// Updates the state file with the current baselines, if any.
+func (c *Collector) persist() {
+	if c.state != nil {
+		c.state.Update(c.stateKey, c.client.Baselines())
+	}
+}
+
+// Clears the containers of the host from the repository, once stopped.
+func (c *Collector) clear() {
+	for _, container := range c.containers.All() {
+		c.client.Removed(container)
+	}
+}
+
+// Temporarily excludes the containers matching the selector from collection, until resumed. The
+// selector is a name pattern or a key=pattern label selector.
+func (c *Collector) Pause(selector string) error {
+	if selector == "" {
+		return errors.New("Selector cannot be empty.")
+	}
+
+	if err := schedule.CheckSelector(selector); err != nil {
+		return err
+	}
+
+	c.pauseMutex.Lock()
+	defer c.pauseMutex.Unlock()
+
+	c.paused[selector] = true
+	return nil
+}
+
+// Includes again the containers excluded by the selector, returns false if it was not paused.
+func (c *Collector) Resume(selector string) bool {
+	c.pauseMutex.Lock()
+	defer c.pauseMutex.Unlock()
+
+	if !c.paused[selector] {
+		return false
+	}
+
+	delete(c.paused, selector)
+	return true
+}
+
+// Selectors currently excluded from collection, sorted.
+func (c *Collector) Paused() []string {
+	c.pauseMutex.RLock()
+	defer c.pauseMutex.RUnlock()
+
+	selectors := make([]string, 0, len(c.paused))
+	for selector := range c.paused {
+		selectors = append(selectors, selector)
+	}
+	sort.Strings(selectors)
+
+	return selectors
+}
+
+// Checks if the container is matched by a paused selector.
+func (c *Collector) isPaused(container backend.Container) bool {
+	c.pauseMutex.RLock()
+	defer c.pauseMutex.RUnlock()
+
+	for selector := range c.paused {
+		if schedule.Match(selector, container) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Queries containers on every tick until stopped.
+func (c *Collector) loop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.sched.Tick())
+	defer ticker.Stop()
+
+	// initial loop.
+	if !c.queryAll() {
+		return
+	}
+
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+			// query containers.
+			if !c.queryAll() {
+				return
+			}
+			c.persist()
+			c.measureAll()
+
+			// back off a slow daemon, then adapt the pool to the current load.
+			c.adapt()
+
+			// only the sample is queried each tick.
+			containers := c.containers.Len()
+			if c.sample > 0 && c.sample < containers {
+				containers = c.sample
+			}
+
+			interval := time.Duration(float64(c.interval) * c.sched.Stretch())
+			if err := c.client.Autoscale(containers, interval); err != nil {
+				log.Error.Printf("Could not resize daemon pool: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Measure of the monitored containers slower than their stats, such as the disk usage of their volumes.
+type measure struct {
+	what     string                                     // what is measured, for the logs.
+	interval time.Duration                              // time between each run.
+	run      func(containers []backend.Container) error // measures the containers and pushes to the repository.
+	last     time.Time                                  // last time it ran.
+	busy     bool                                       // whether it is running.
+}
+
+// Runs the due measures of the monitored containers in the background, unless still running, since they may take
+// longer than a tick.
+func (c *Collector) measureAll() {
+	var monitored []backend.Container
+
+	c.measuresMutex.Lock()
+	defer c.measuresMutex.Unlock()
+
+	// half a tick of slack, so measures as often as the tick don't skip every other one.
+	slack := c.sched.Tick() / 2
+
+	for _, m := range c.measures {
+		if m.busy || time.Since(m.last) < m.interval-slack {
+			continue
+		}
+
+		if monitored == nil {
+			for _, container := range c.containers.All() {
+				if c.filter(container) && !c.isPaused(container) {
+					monitored = append(monitored, container)
+				}
+			}
+		}
+
+		m.busy = true
+		m.last = time.Now()
+		c.measuring.Add(1)
+
+		go func(m *measure) {
+			defer c.measuring.Done()
+
+			if err := m.run(monitored); err != nil {
+				log.Error.Printf("Could not measure the %s: %s", m.what, err.Error())
+			}
+
+			c.measuresMutex.Lock()
+			m.busy = false
+			c.measuresMutex.Unlock()
+		}(m)
+	}
+}
+
+// Stretches the intervals while Docker is slow and shrinks them back once it recovered, at most once per interval
+// so the latency reflects the previous change.
+func (c *Collector) adapt() {
+	if c.slow <= 0 || time.Since(c.adapted) < c.interval {
+		return
+	}
+
+	latency := c.client.Latency()
+	stretch := c.sched.Stretch()
+
+	switch {
+	case latency > c.slow && stretch < c.maxStretch:
+		stretch = math.Min(stretch*ADAPTIVE_BACKOFF, c.maxStretch)
+		log.Warning.Printf("Docker is slow (latency: %s), polling %.2fx less often.", latency, stretch)
+	case latency < c.slow/2 && stretch > 1:
+		stretch = math.Max(stretch/ADAPTIVE_RECOVERY, 1)
+		log.Info.Printf("Docker recovered (latency: %s), polling %.2fx less often.", latency, stretch)
+	default:
+		return
+	}
+
+	c.sched.SetStretch(stretch)
+	c.adapted = time.Now()
+}
+
+// Reports the containers discovered, monitored and excluded to the telemetry.
+func (c *Collector) count(containers map[string]backend.Container) {
+	total := len(containers)
+
+	n := 0
+	for _, container := range containers {
+		if c.filter(container) && !c.isPaused(container) {
+			n++
+		}
+	}
+
+	c.report(total, n)
+}
+
+// Updates the levels shared with the other collectors by the difference with the last report.
+func (c *Collector) report(total int, n int) {
+	counts := [3]int64{int64(total), int64(n), int64(total - n)}
+	levels := [3]*telemetry.Level{discovered, monitored, excluded}
+
+	for i, level := range levels {
+		level.Add(counts[i] - c.counted[i])
+	}
+
+	c.counted = counts
+}
+
+// Queries every container that is due according to the scheduler. If spread is enabled, queries are evenly
+// distributed along the tick instead of being fired all at once, returns false if the collector was
+// stopped while waiting.
+func (c *Collector) queryAll() bool {
+	containers := c.containers.All()
+	c.count(containers)
+
+	names := c.sched.Due(time.Now(), containers, func(container backend.Container) bool {
+		return c.filter(container) && !c.isPaused(container)
+	})
+
+	if len(names) == 0 {
+		return true
+	}
+
+	var step time.Duration
+	if c.spread {
+		step = c.sched.Tick() / time.Duration(len(names))
+	}
+
+	skipped := 0
+	for n, name := range names {
+		if n > 0 && step > 0 {
+			select {
+			case <-c.quit:
+				return false
+			case <-time.After(step):
+			}
+		}
+
+		// the container could have been stopped in the meantime.
+		if container, ok := c.containers.Get(name); ok && !c.client.Query(container) {
+			skipped++
+		}
+	}
+
+	// queries never block the loop, the containers whose previous query is still pending are skipped instead.
+	if skipped > 0 {
+		log.Warning.Printf("Skipped %d of %d queries, the daemons cannot keep up with the interval, "+
+			"consider more daemons (daemons.max) or one-shot requests (oneshot).", skipped, len(names))
+	}
+
+	return true
+}
@@ -0,0 +1,109 @@
+package statspout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mijara/statspout/dockertest"
+)
+
+func TestCollectorPipeline(t *testing.T) {
+	server := dockertest.NewServer()
+	defer server.Close()
+
+	server.AddContainer("web", map[string]string{"tier": "frontend"})
+	server.AddContainer("db", nil)
+
+	recorder := dockertest.NewRecorder()
+
+	collector, err := NewCollector(
+		WithEndpoint(server.Network(), server.Address()),
+		WithInterval(100*time.Millisecond),
+		WithDaemons(2, 2),
+		WithLabels(map[string]string{"host": "test"}),
+		WithRepo(recorder))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := collector.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Stop()
+
+	sampled := func(names ...string) func(*dockertest.Recorder) bool {
+		return func(r *dockertest.Recorder) bool {
+			for _, name := range names {
+				if len(r.Samples(name)) < 3 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	if !recorder.Wait(5*time.Second, sampled("test/web", "test/db")) {
+		t.Fatalf("expected 3 samples of each container, got %d of web and %d of db",
+			len(recorder.Samples("test/web")), len(recorder.Samples("test/db")))
+	}
+
+	samples := recorder.Samples("test/web")
+	last := samples[len(samples)-1]
+
+	if last.MemoryUsage != 64<<20 || last.MemoryLimit != 1<<30 {
+		t.Errorf("memory: expected %d of %d, got %d of %d", 64<<20, 1<<30, last.MemoryUsage, last.MemoryLimit)
+	}
+
+	if last.CpuPercent <= 0 {
+		t.Errorf("cpu: expected a positive percent, got %f", last.CpuPercent)
+	}
+
+	if last.RxBytesTotal == 0 || last.TxBytesTotal == 0 {
+		t.Errorf("network: expected bytes on eth0, got rx %d and tx %d", last.RxBytesTotal, last.TxBytesTotal)
+	}
+
+	if last.Labels["host"] != "test" || last.Labels["tier"] != "frontend" {
+		t.Errorf("labels: expected the host and container labels, got %v", last.Labels)
+	}
+
+	// containers coming and going are picked up from the events.
+	server.Run(dockertest.Scenario{
+		{Do: dockertest.Start("worker", nil)},
+		{Do: dockertest.Stop("web")},
+	})
+
+	if !recorder.Wait(5*time.Second, sampled("test/worker")) {
+		t.Fatalf("expected 3 samples of the started container, got %d", len(recorder.Samples("test/worker")))
+	}
+
+	cleared := func(r *dockertest.Recorder) bool {
+		for _, name := range r.Cleared() {
+			if name == "test/web" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !recorder.Wait(5*time.Second, cleared) {
+		t.Fatalf("expected the stopped container to be cleared, got %v", recorder.Cleared())
+	}
+}
+
+// Regular stats requests hold the connection for a second, sharing it needs one-shot ones.
+func TestCollectorPipelineNeedsOneShot(t *testing.T) {
+	recorder := dockertest.NewRecorder()
+
+	if _, err := NewCollector(WithInterval(10*time.Second), WithPipeline(4), WithRepo(recorder)); err == nil {
+		t.Error("expected shared connections to be refused with regular requests")
+	}
+
+	if _, err := NewCollector(WithInterval(10*time.Second), WithPipeline(4), WithOneShot(true),
+		WithRepo(recorder)); err != nil {
+		t.Errorf("expected shared connections with one-shot requests, got %s", err.Error())
+	}
+
+	if _, err := NewCollector(WithInterval(500*time.Millisecond), WithPipeline(4), WithRepo(recorder)); err != nil {
+		t.Errorf("expected shared connections with a sub-second interval, got %s", err.Error())
+	}
+}
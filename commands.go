@@ -0,0 +1,346 @@
+package statspout
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mijara/statspout/api"
+	"github.com/mijara/statspout/common"
+	"github.com/mijara/statspout/completion"
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/opts"
+	"github.com/mijara/statspout/secret"
+	"github.com/mijara/statspout/top"
+)
+
+// Minimum time given to the first round of queries of the snapshot command.
+const SNAPSHOT_WAIT = 2 * time.Second
+
+// Time given to the running instance to answer the healthcheck command.
+const HEALTHCHECK_TIMEOUT = 5 * time.Second
+
+// Subcommand given as the first non-flag argument.
+type command struct {
+	usage string
+	args  []string // fixed values for the first argument, if any.
+	run   func(cfg *opts.Config, args []string) error
+}
+
+// Subcommands by name, filled on init to avoid an initialization loop.
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"completion": {
+			usage: "Generate the shell completion script: bash, zsh or fish.",
+			args:  completion.Shells,
+			run:   completionCommand,
+		},
+		"healthcheck": {
+			usage: "Check the health of the running instance at -api.address, exiting with 1 if unhealthy.",
+			run:   healthcheckCommand,
+		},
+		"hosts": {
+			usage: "List, add or remove the Docker hosts of a running instance, through its admin API.",
+			args:  []string{"list", "add", "remove"},
+			run:   hostsCommand,
+		},
+		"snapshot": {
+			usage: "Print the latest stats of every container as json or csv.",
+			args:  api.SnapshotFormats,
+			run:   snapshotCommand,
+		},
+		"top": {
+			usage: "Show an interactive, top-like view of the containers stats.",
+			run:   topCommand,
+		},
+	}
+}
+
+// Runs the named subcommand with the remaining arguments.
+func runCommand(cfg *opts.Config, args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return errors.New("Unknown command: " + args[0])
+	}
+
+	return cmd.run(cfg, args[1:])
+}
+
+// Writes the completion script of the given shell to stdout.
+func completionCommand(cfg *opts.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: completion <bash|zsh|fish>")
+	}
+
+	return completion.Generate(os.Stdout, args[0], completionSpec(cfg))
+}
+
+// Collects stats into memory and shows them on the terminal until the user quits. The repository
+// given by the options is not used.
+func topCommand(cfg *opts.Config, args []string) error {
+	if len(args) != 0 {
+		return errors.New("Usage: top")
+	}
+
+	if err := opts.Validate(cfg, false); err != nil {
+		return err
+	}
+
+	memory, err := common.NewMemory(&common.MemoryOpts{Samples: top.SAMPLES})
+	if err != nil {
+		return err
+	}
+
+	collector, err := collectorFromFlags(memory)
+	if err != nil {
+		return err
+	}
+
+	if err := collector.Start(); err != nil {
+		return err
+	}
+	defer collector.Stop()
+
+	// logs would draw over the view.
+	restore := log.Redirect(ioutil.Discard)
+	defer restore()
+
+	return top.New(memory).Run()
+}
+
+// Queries the health endpoint of the instance at -api.address and fails unless healthy, so container images can
+// declare a HEALTHCHECK running it.
+func healthcheckCommand(cfg *opts.Config, args []string) error {
+	if len(args) != 0 {
+		return errors.New("Usage: healthcheck")
+	}
+
+	client, base, err := localAPI()
+	if err != nil {
+		return err
+	}
+
+	// the orchestrator has its own timeout, but a hung instance must not hang the check.
+	client = &http.Client{Transport: client.Transport, Timeout: HEALTHCHECK_TIMEOUT}
+
+	res, err := client.Get(base + api.HEALTH_PATH)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	health := api.Health{}
+	if err := json.NewDecoder(res.Body).Decode(&health); err != nil {
+		return errors.New("Unexpected health response: " + res.Status)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("Unhealthy: " + health.Status)
+	}
+
+	log.Info.Printf("Healthy: %d containers monitored.", health.Containers)
+	return nil
+}
+
+// Lists, adds or removes the Docker hosts of the instance at -api.address.
+func hostsCommand(cfg *opts.Config, args []string) error {
+	method := http.MethodGet
+	query := url.Values{}
+
+	switch {
+	case len(args) == 0 || len(args) == 1 && args[0] == "list":
+	case len(args) == 4 && args[0] == "add":
+		method = http.MethodPost
+		query.Set("name", args[1])
+		query.Set("network", args[2])
+		query.Set("address", args[3])
+	case len(args) == 2 && args[0] == "remove":
+		method = http.MethodDelete
+		query.Set("name", args[1])
+	default:
+		return errors.New("Usage: hosts [list | add <name> <unix|tcp> <address> | remove <name>]")
+	}
+
+	return adminRequest(method, "admin/hosts", query)
+}
+
+// Client and base URL of the HTTP API of the instance at -api.address.
+func localAPI() (*http.Client, string, error) {
+	address := opts.GetOpts().API.Address
+	if address == "" {
+		return nil, "", errors.New("The -api.address of the running instance is needed.")
+	}
+
+	// listening on all interfaces.
+	if strings.HasPrefix(address, ":") {
+		address = "localhost" + address
+	}
+
+	// the running instance serves over TLS if it was given a certificate.
+	tlsConfig, err := opts.ClientTLSFromFlags()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if tlsConfig != nil {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, "https://" + address, nil
+	}
+
+	return http.DefaultClient, "http://" + address, nil
+}
+
+// Sends a request to the admin endpoints of the instance at -api.address, authenticated with
+// -api.admin.token, and writes the response to stdout.
+func adminRequest(method string, path string, query url.Values) error {
+	client, base, err := localAPI()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, base+api.PREFIX+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+secret.New(opts.GetOpts().API.Admin.Token).Value())
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		reply := struct {
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal(body, &reply) != nil || reply.Error == "" {
+			reply.Error = res.Status
+		}
+
+		return errors.New("Admin request failed: " + reply.Error)
+	}
+
+	_, err = os.Stdout.Write(body)
+	return err
+}
+
+// Collects a round of stats into memory and writes a snapshot of them to stdout, in JSON by default.
+func snapshotCommand(cfg *opts.Config, args []string) error {
+	format := "json"
+	switch len(args) {
+	case 0:
+	case 1:
+		format = args[0]
+	default:
+		return errors.New("Usage: snapshot [json|csv]")
+	}
+
+	if !contains(api.SnapshotFormats, format) {
+		return errors.New("Unknown snapshot format: " + format)
+	}
+
+	if err := opts.Validate(cfg, false); err != nil {
+		return err
+	}
+
+	// stdout is left for the snapshot.
+	restore := log.Redirect(os.Stderr)
+	defer restore()
+
+	memory, err := common.NewMemory(&common.MemoryOpts{Samples: 1})
+	if err != nil {
+		return err
+	}
+
+	collector, err := collectorFromFlags(memory)
+	if err != nil {
+		return err
+	}
+
+	if err := collector.Start(); err != nil {
+		return err
+	}
+
+	// queries are sent right away, give them an interval to complete. Stats may take a second when
+	// Docker has to wait for a second CPU sample.
+	wait := opts.GetOpts().Interval
+	if wait < SNAPSHOT_WAIT {
+		wait = SNAPSHOT_WAIT
+	}
+	time.Sleep(wait)
+
+	collector.Stop()
+
+	return api.NewSnapshot(memory).Write(os.Stdout, format)
+}
+
+// Describes subcommands, flags and repositories for the completion scripts.
+func completionSpec(cfg *opts.Config) completion.Spec {
+	spec := completion.Spec{
+		Program: filepath.Base(os.Args[0]),
+	}
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		spec.Commands = append(spec.Commands, completion.Command{
+			Name:  name,
+			Usage: commands[name].usage,
+			Args:  commands[name].args,
+		})
+	}
+
+	repositories := make([]string, 0, len(cfg.Repositories))
+	for name := range cfg.Repositories {
+		repositories = append(repositories, name)
+	}
+	sort.Strings(repositories)
+
+	// flags are visited in lexicographical order.
+	flag.VisitAll(func(f *flag.Flag) {
+		c := completion.Flag{
+			Name:  f.Name,
+			Usage: f.Usage,
+		}
+
+		if b, ok := f.Value.(interface {
+			IsBoolFlag() bool
+		}); ok {
+			c.Bool = b.IsBoolFlag()
+		}
+
+		switch f.Name {
+		case "repository":
+			c.Values = repositories
+		case "mode":
+			c.Values = []string{"socket", "http", "npipe", "none"}
+		case "profile":
+			c.Values = opts.Profiles()
+		case "config", "socket.path", "state", "tls.cert", "tls.key", "tls.client.ca", "audit", "record", "replay":
+			c.Files = true
+		}
+
+		spec.Flags = append(spec.Flags, c)
+	})
+
+	return spec
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Time given to backends to accept a connection during the preflight checks.
+const CHECK_TIMEOUT = 5 * time.Second
+
+// Checks that a TCP connection can be made to the address, given as host:port or as a URL. The port defaults to the
+// given one if missing.
+func checkReachable(address string, port string) error {
+	host := address
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return err
+		}
+		host = u.Host
+
+		if u.Port() == "" && u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, port)
+	}
+
+	conn, err := net.DialTimeout("tcp", host, CHECK_TIMEOUT)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// Checks that the address can be listened on.
+func checkListen(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return listener.Close()
+}
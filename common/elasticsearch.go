@@ -0,0 +1,406 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/secret"
+	"github.com/mijara/statspout/stats"
+)
+
+func init() {
+	repo.Register(&Elasticsearch{}, func() interface{} {
+		return CreateElasticsearchOpts()
+	})
+}
+
+// Layout of the date suffix of the daily indices, as statspout-2006.01.02.
+const ELASTICSEARCH_DATE = "2006.01.02"
+
+// Fields of the stats mapped explicitly, numbers are otherwise mapped as the type of their first value, so a float
+// first seen as 0 would be a long.
+var elasticsearchFields = map[string]string{
+	"@timestamp":       "date",
+	"image_created":    "date",
+	"name":             "keyword",
+	"image":            "keyword",
+	"id":               "keyword",
+	"cpu_percent":      "double",
+	"cpu_limit":        "double",
+	"mem_percent":      "double",
+	"image_age_days":   "double",
+	"blkio_read_bps":   "double",
+	"blkio_write_bps":  "double",
+	"blkio_read_iops":  "double",
+	"blkio_write_iops": "double",
+}
+
+// Elasticsearch indexes each sample as a document of a daily index, through the bulk API. Documents are buffered
+// and indexed in bulks, once the index template is installed.
+type Elasticsearch struct {
+	url       string
+	prefix    string
+	user      string
+	password  secret.Secret
+	bulk      int
+	template  bool
+	templated bool // whether the index template was installed.
+	client    *http.Client
+
+	mutex   sync.Mutex
+	pending []stats.Stats
+	flush   chan bool
+	report  func(count int, err error) // called with the outcome of each bulk, if set.
+
+	quit chan bool
+	done chan bool
+}
+
+type ElasticsearchOpts struct {
+	Address  string
+	Index    string
+	User     string
+	Password string
+	Bulk     int
+	Interval time.Duration
+	Template bool
+	TLS      TLSOpts
+}
+
+// Creates a new Elasticsearch repository, flushing in the background on every interval.
+func NewElasticsearch(opts *ElasticsearchOpts) (*Elasticsearch, error) {
+	if opts.Address == "" {
+		return nil, errors.New("The address of Elasticsearch is needed.")
+	}
+
+	if opts.Index == "" || strings.ToLower(opts.Index) != opts.Index {
+		return nil, errors.New("The index prefix is needed, in lowercase.")
+	}
+
+	if opts.Bulk < 1 {
+		return nil, errors.New("Bulk must be positive.")
+	}
+
+	if opts.Interval <= 0 {
+		return nil, errors.New("Flush interval must be positive.")
+	}
+
+	if err := secret.Check(opts.Password); err != nil {
+		return nil, err
+	}
+
+	config, err := opts.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	address := opts.Address
+	if !strings.Contains(address, "://") {
+		if config != nil {
+			address = "https://" + address
+		} else {
+			address = "http://" + address
+		}
+	}
+
+	es := &Elasticsearch{
+		url:      strings.TrimSuffix(address, "/"),
+		prefix:   opts.Index,
+		user:     opts.User,
+		password: secret.New(opts.Password),
+		bulk:     opts.Bulk,
+		template: opts.Template,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: config, Proxy: http.ProxyFromEnvironment},
+		},
+		flush: make(chan bool, 1),
+		quit:  make(chan bool),
+		done:  make(chan bool),
+	}
+
+	go es.loop(opts.Interval)
+
+	return es, nil
+}
+
+func (*Elasticsearch) Name() string {
+	return "elasticsearch"
+}
+
+func (*Elasticsearch) Create(v interface{}) (repo.Interface, error) {
+	return NewElasticsearch(v.(*ElasticsearchOpts))
+}
+
+// Checks that Elasticsearch is reachable.
+func (*Elasticsearch) Check(v interface{}) error {
+	return checkReachable(v.(*ElasticsearchOpts).Address, "9200")
+}
+
+// Buffers the sample, a flush is triggered when a bulk is complete.
+func (es *Elasticsearch) Push(s *stats.Stats) error {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	es.pending = append(es.pending, *s)
+
+	if len(es.pending) >= es.bulk {
+		select {
+		case es.flush <- true:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Indexes the remaining samples and stops flushing.
+func (es *Elasticsearch) Close() {
+	close(es.quit)
+	<-es.done
+}
+
+func (es *Elasticsearch) Clear(name string) {
+	// not used.
+}
+
+// Samples waiting to be indexed.
+func (es *Elasticsearch) Queued() int {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	return len(es.pending)
+}
+
+// Sets the function called with the outcome of each bulk, pushes only buffer the samples.
+func (es *Elasticsearch) Report(report func(count int, err error)) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	es.report = report
+}
+
+func (es *Elasticsearch) loop(interval time.Duration) {
+	defer close(es.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-es.quit:
+			es.index()
+			return
+		case <-ticker.C:
+		case <-es.flush:
+		}
+
+		es.index()
+	}
+}
+
+// Indexes every pending sample in bulks. Bulks that cannot be indexed are dropped, Elasticsearch may have indexed
+// some of their samples already.
+func (es *Elasticsearch) index() {
+	for {
+		es.mutex.Lock()
+		n := len(es.pending)
+		if n > es.bulk {
+			n = es.bulk
+		}
+		bulk := es.pending[:n]
+		es.pending = append([]stats.Stats(nil), es.pending[n:]...)
+		report := es.report
+		es.mutex.Unlock()
+
+		if len(bulk) == 0 {
+			return
+		}
+
+		err := es.installTemplate()
+		if err == nil {
+			err = es.post(bulk)
+		}
+
+		if report != nil {
+			report(len(bulk), err)
+		}
+
+		if err != nil {
+			log.Error.Printf("Could not index %d samples in Elasticsearch: %s", len(bulk), err.Error())
+		}
+	}
+}
+
+// Installs the index template of the daily indices once, unless disabled, before indexing into them.
+func (es *Elasticsearch) installTemplate() error {
+	if !es.template || es.templated {
+		return nil
+	}
+
+	properties := map[string]interface{}{}
+	for field, kind := range elasticsearchFields {
+		properties[field] = map[string]string{"type": kind}
+	}
+
+	template := map[string]interface{}{
+		"index_patterns": []string{es.prefix + "-*"},
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"date_detection": false,
+				// labels and other strings are matched as they are.
+				"dynamic_templates": []interface{}{
+					map[string]interface{}{
+						"strings": map[string]interface{}{
+							"match_mapping_type": "string",
+							"mapping":            map[string]string{"type": "keyword"},
+						},
+					},
+				},
+				"properties": properties,
+			},
+		},
+	}
+
+	body, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+
+	if err := es.do("PUT", "/_index_template/"+es.prefix, "application/json", body); err != nil {
+		return errors.New("Could not install the index template: " + err.Error())
+	}
+
+	es.templated = true
+	return nil
+}
+
+// Indexes the samples through the bulk API, each into the index of its day.
+func (es *Elasticsearch) post(bulk []stats.Stats) error {
+	var body bytes.Buffer
+
+	encoder := json.NewEncoder(&body)
+	for i := range bulk {
+		action := map[string]string{"_index": es.prefix + "-" + bulk[i].Timestamp.UTC().Format(ELASTICSEARCH_DATE)}
+		if bulk[i].ID != "" {
+			action["_id"] = bulk[i].ID
+		}
+
+		if err := encoder.Encode(map[string]interface{}{"index": action}); err != nil {
+			return err
+		}
+
+		if err := encoder.Encode(&bulk[i]); err != nil {
+			return err
+		}
+	}
+
+	return es.do("POST", "/_bulk", "application/x-ndjson", body.Bytes())
+}
+
+// Sends the request, failing on any status but 2xx, and on bulks with failed items.
+func (es *Elasticsearch) do(method string, path string, contentType string, body []byte) error {
+	req, err := http.NewRequest(method, es.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	if es.user != "" {
+		req.SetBasicAuth(es.user, es.password.Value())
+	}
+
+	res, err := es.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	response, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.New("Elasticsearch replied " + res.Status + ": " + strings.TrimSpace(string(response)))
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(response, &result); err != nil || !result.Errors {
+		return nil
+	}
+
+	// the bulk is accepted as a whole, but some of its items may fail.
+	failed := 0
+	reason := ""
+	for _, item := range result.Items {
+		for _, outcome := range item {
+			if len(outcome.Error) > 0 {
+				if failed == 0 {
+					reason = string(outcome.Error)
+				}
+				failed++
+			}
+		}
+	}
+
+	return errors.New("Elasticsearch rejected " + strconv.Itoa(failed) + " documents, the first with: " + reason)
+}
+
+func CreateElasticsearchOpts() *ElasticsearchOpts {
+	o := &ElasticsearchOpts{}
+
+	flag.StringVar(&o.Address,
+		"elasticsearch.address",
+		"http://localhost:9200",
+		"Address of Elasticsearch, as host:port or URL")
+
+	flag.StringVar(&o.Index,
+		"elasticsearch.index",
+		"statspout",
+		"Prefix of the daily indices, followed by the date as in statspout-2006.01.02")
+
+	flag.StringVar(&o.User,
+		"elasticsearch.user",
+		"",
+		"User to authenticate with, none if empty")
+
+	flag.StringVar(&o.Password,
+		"elasticsearch.password",
+		"",
+		"Password of the user, or @file to read it from a file reloaded on changes")
+
+	flag.IntVar(&o.Bulk,
+		"elasticsearch.bulk",
+		1000,
+		"Maximum number of samples per bulk request")
+
+	flag.DurationVar(&o.Interval,
+		"elasticsearch.interval",
+		10*time.Second,
+		"Time between each flush")
+
+	flag.BoolVar(&o.Template,
+		"elasticsearch.template",
+		true,
+		"Install the index template mapping the fields of the daily indices")
+
+	AddTLSFlags(&o.TLS, "elasticsearch")
+
+	return o
+}
@@ -0,0 +1,352 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/secret"
+	"github.com/mijara/statspout/stats"
+)
+
+func init() {
+	repo.Register(&Forward{}, func() interface{} {
+		return CreateForwardOpts()
+	})
+}
+
+// Path on which a receiver accepts forwarded batches.
+const FORWARD_PATH = "/api/v1/ingest"
+
+// Header telling the receiver which agent sent the batch.
+const FORWARD_AGENT_HEADER = "X-Statspout-Agent"
+
+// Batch of stats, events and cleared containers sent by the forward repository to a receiver.
+type ForwardBatch struct {
+	Stats   []stats.Stats `json:"stats,omitempty"`
+	Events  []stats.Event `json:"events,omitempty"`
+	Cleared []string      `json:"cleared,omitempty"`
+}
+
+// Forward pushes stats to a central statspout receiver, in batches. Batches that cannot be sent are retried, up to a
+// limit of buffered samples.
+type Forward struct {
+	url    string
+	token  secret.Secret
+	agent  string
+	batch  int
+	buffer int
+	client *http.Client
+
+	mutex   sync.Mutex
+	pending ForwardBatch
+	flush   chan bool
+	report  func(count int, err error) // called with the outcome of each send, if set.
+
+	quit chan bool
+	done chan bool
+}
+
+type ForwardOpts struct {
+	Address  string
+	Token    string
+	Agent    string
+	Batch    int
+	Buffer   int
+	Interval time.Duration
+	TLS      TLSOpts
+}
+
+// Creates a new Forward repository, flushing in the background on every interval.
+func NewForward(opts *ForwardOpts) (*Forward, error) {
+	if opts.Address == "" {
+		return nil, errors.New("The address of the receiver is needed.")
+	}
+
+	if opts.Batch < 1 || opts.Buffer < opts.Batch {
+		return nil, errors.New("Batch must be positive and buffer at least as big as the batch.")
+	}
+
+	if opts.Interval <= 0 {
+		return nil, errors.New("Flush interval must be positive.")
+	}
+
+	if err := secret.Check(opts.Token); err != nil {
+		return nil, err
+	}
+
+	config, err := opts.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	address := opts.Address
+	if !strings.Contains(address, "://") {
+		if config != nil {
+			address = "https://" + address
+		} else {
+			address = "http://" + address
+		}
+	}
+
+	f := &Forward{
+		url:    strings.TrimSuffix(address, "/") + FORWARD_PATH,
+		token:  secret.New(opts.Token),
+		agent:  opts.Agent,
+		batch:  opts.Batch,
+		buffer: opts.Buffer,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: config, Proxy: http.ProxyFromEnvironment},
+		},
+		flush: make(chan bool, 1),
+		quit:  make(chan bool),
+		done:  make(chan bool),
+	}
+
+	go f.loop(opts.Interval)
+
+	return f, nil
+}
+
+func (*Forward) Name() string {
+	return "forward"
+}
+
+func (*Forward) Create(v interface{}) (repo.Interface, error) {
+	return NewForward(v.(*ForwardOpts))
+}
+
+// Checks that the receiver is reachable.
+func (*Forward) Check(v interface{}) error {
+	opts := v.(*ForwardOpts)
+	if opts.TLS.Enabled() {
+		return checkReachable(opts.Address, "443")
+	}
+
+	return checkReachable(opts.Address, "80")
+}
+
+// Buffers the stats, a flush is triggered when a batch is complete.
+func (f *Forward) Push(s *stats.Stats) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.pending.Stats = append(f.pending.Stats, *s)
+
+	// drop the oldest samples if the receiver is unreachable for too long.
+	if over := len(f.pending.Stats) - f.buffer; over > 0 {
+		f.pending.Stats = append([]stats.Stats(nil), f.pending.Stats[over:]...)
+		log.Warning.Printf("Forward buffer is full, dropped %d samples.", over)
+	}
+
+	if len(f.pending.Stats) >= f.batch {
+		f.trigger()
+	}
+
+	return nil
+}
+
+// Buffers the event, sent along the next batch.
+func (f *Forward) PushEvent(event *stats.Event) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.pending.Events = append(f.pending.Events, *event)
+	return nil
+}
+
+// Sends the remaining stats and stops flushing.
+func (f *Forward) Close() {
+	close(f.quit)
+	<-f.done
+}
+
+// Tells the receiver to clear the container, along the next batch.
+func (f *Forward) Clear(name string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.pending.Cleared = append(f.pending.Cleared, name)
+}
+
+// Samples waiting to be sent.
+func (f *Forward) Queued() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return len(f.pending.Stats)
+}
+
+// Sets the function called with the outcome of each send, pushes only buffer the samples.
+func (f *Forward) Report(report func(count int, err error)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.report = report
+}
+
+// Wakes up the loop without blocking, a flush may already be pending.
+func (f *Forward) trigger() {
+	select {
+	case f.flush <- true:
+	default:
+	}
+}
+
+func (f *Forward) loop(interval time.Duration) {
+	defer close(f.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.quit:
+			f.send()
+			return
+		case <-ticker.C:
+		case <-f.flush:
+		}
+
+		f.send()
+	}
+}
+
+// Sends everything pending in batches, what cannot be sent is put back for the next attempt.
+func (f *Forward) send() {
+	for {
+		f.mutex.Lock()
+		batch := f.take()
+		f.mutex.Unlock()
+
+		if len(batch.Stats) == 0 && len(batch.Events) == 0 && len(batch.Cleared) == 0 {
+			return
+		}
+
+		err := f.post(&batch)
+
+		f.mutex.Lock()
+		report := f.report
+		f.mutex.Unlock()
+
+		if report != nil {
+			report(len(batch.Stats)+len(batch.Events), err)
+		}
+
+		if err != nil {
+			log.Error.Printf("Could not forward stats: %s", err.Error())
+
+			f.mutex.Lock()
+			f.restore(batch)
+			f.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// Takes up to a batch of stats from the pending ones, along every pending event and cleared container.
+func (f *Forward) take() ForwardBatch {
+	n := len(f.pending.Stats)
+	if n > f.batch {
+		n = f.batch
+	}
+
+	batch := ForwardBatch{
+		Stats:   f.pending.Stats[:n],
+		Events:  f.pending.Events,
+		Cleared: f.pending.Cleared,
+	}
+
+	f.pending = ForwardBatch{Stats: append([]stats.Stats(nil), f.pending.Stats[n:]...)}
+	return batch
+}
+
+// Puts a batch back in front of the pending ones.
+func (f *Forward) restore(batch ForwardBatch) {
+	f.pending.Stats = append(batch.Stats, f.pending.Stats...)
+	f.pending.Events = append(batch.Events, f.pending.Events...)
+	f.pending.Cleared = append(batch.Cleared, f.pending.Cleared...)
+
+	if over := len(f.pending.Stats) - f.buffer; over > 0 {
+		f.pending.Stats = f.pending.Stats[over:]
+		log.Warning.Printf("Forward buffer is full, dropped %d samples.", over)
+	}
+}
+
+func (f *Forward) post(batch *ForwardBatch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", f.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(FORWARD_AGENT_HEADER, f.agent)
+	if token := f.token.Value(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return errors.New("Receiver replied " + res.Status)
+	}
+
+	return nil
+}
+
+func CreateForwardOpts() *ForwardOpts {
+	o := &ForwardOpts{}
+
+	flag.StringVar(&o.Address,
+		"forward.address",
+		"",
+		"Address of the statspout receiver to forward stats to, as host:port or URL")
+
+	flag.StringVar(&o.Token,
+		"forward.token",
+		"",
+		"Bearer token expected by the receiver, if any, or @file to read it from a file reloaded on changes")
+
+	hostname, _ := os.Hostname()
+	flag.StringVar(&o.Agent,
+		"forward.agent",
+		hostname,
+		"Name of this agent, added by the receiver to every sample as the statspout.agent label")
+
+	flag.IntVar(&o.Batch,
+		"forward.batch",
+		500,
+		"Maximum number of samples per request")
+
+	flag.IntVar(&o.Buffer,
+		"forward.buffer",
+		10000,
+		"Maximum number of samples buffered while the receiver is unreachable")
+
+	flag.DurationVar(&o.Interval,
+		"forward.interval",
+		5*time.Second,
+		"Time between each flush")
+
+	AddTLSFlags(&o.TLS, "forward")
+
+	return o
+}
@@ -0,0 +1,364 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+func init() {
+	repo.Register(&Graphite{}, func() interface{} {
+		return CreateGraphiteOpts()
+	})
+}
+
+// Largest UDP datagram sent, lines are packed into datagrams up to it so they are not fragmented.
+const GRAPHITE_DATAGRAM = 1400
+
+// Timeout of connecting to Carbon and of each write.
+const GRAPHITE_TIMEOUT = 10 * time.Second
+
+// Metric written to Carbon, with the group of metrics it belongs to.
+type graphiteMetric struct {
+	name  string
+	group string
+	value func(s *stats.Stats) float64
+}
+
+// Metrics written for each container, as <prefix>.<container>.<name>.
+var graphiteMetrics = []graphiteMetric{
+	{"cpu_percent", stats.GROUP_CPU, func(s *stats.Stats) float64 { return s.CpuPercent }},
+	{"cpu_limit", stats.GROUP_CPU, func(s *stats.Stats) float64 { return s.CpuLimit }},
+	{"cpu_shares", stats.GROUP_CPU, func(s *stats.Stats) float64 { return float64(s.CpuShares) }},
+	{"mem_usage", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.MemoryUsage) }},
+	{"mem_percent", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return s.MemoryPercent }},
+	{"mem_limit", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.MemoryLimit) }},
+	{"mem_failcnt", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.MemoryFailcnt) }},
+	{"swap_usage", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.SwapUsage) }},
+	{"swap_limit", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.SwapLimit) }},
+	{"tx_bytes", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxBytesTotal) }},
+	{"rx_bytes", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxBytesTotal) }},
+	{"tx_packets", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxPacketsTotal) }},
+	{"rx_packets", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxPacketsTotal) }},
+	{"tx_errors", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxErrorsTotal) }},
+	{"rx_errors", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxErrorsTotal) }},
+	{"tx_dropped", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxDroppedTotal) }},
+	{"rx_dropped", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxDroppedTotal) }},
+	{"blkio_service_time", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioServiceTime) }},
+	{"blkio_serviced", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioServiced) }},
+	{"blkio_queue", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioQueue) }},
+	{"blkio_read_bytes", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioReadBytes) }},
+	{"blkio_write_bytes", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioWriteBytes) }},
+	{"blkio_read_bps", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return s.BlkioReadBps }},
+	{"blkio_write_bps", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return s.BlkioWriteBps }},
+	{"blkio_read_iops", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return s.BlkioReadIops }},
+	{"blkio_write_iops", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return s.BlkioWriteIops }},
+}
+
+// Graphite writes the stats to Carbon in its plaintext protocol, over TCP or UDP. Lines are buffered and written on
+// every interval, the connection is dialed again once it fails, keeping the lines not written up to a limit.
+type Graphite struct {
+	network string
+	address string
+	prefix  string
+	buffer  int
+
+	conn net.Conn // connection to Carbon, nil until dialed or once it failed.
+
+	mutex   sync.Mutex
+	pending [][]byte // lines not written yet, oldest first.
+	report  func(count int, err error)
+
+	quit chan bool
+	done chan bool
+}
+
+type GraphiteOpts struct {
+	Address  string
+	Protocol string
+	Prefix   string
+	Interval time.Duration
+	Buffer   int
+}
+
+// Creates a new Graphite repository, flushing in the background on every interval.
+func NewGraphite(opts *GraphiteOpts) (*Graphite, error) {
+	if opts.Address == "" {
+		return nil, errors.New("The address of Carbon is needed.")
+	}
+
+	if opts.Protocol != "tcp" && opts.Protocol != "udp" {
+		return nil, errors.New("Unknown protocol " + opts.Protocol + ", use tcp or udp.")
+	}
+
+	if opts.Interval <= 0 {
+		return nil, errors.New("Flush interval must be positive.")
+	}
+
+	if opts.Buffer < 1 {
+		return nil, errors.New("Buffer must be positive.")
+	}
+
+	g := &Graphite{
+		network: opts.Protocol,
+		address: opts.Address,
+		prefix:  strings.Trim(opts.Prefix, "."),
+		buffer:  opts.Buffer,
+		quit:    make(chan bool),
+		done:    make(chan bool),
+	}
+
+	go g.loop(opts.Interval)
+
+	return g, nil
+}
+
+func (*Graphite) Name() string {
+	return "graphite"
+}
+
+func (*Graphite) Create(v interface{}) (repo.Interface, error) {
+	return NewGraphite(v.(*GraphiteOpts))
+}
+
+// Checks that Carbon is reachable, UDP is connectionless so it is only checked over TCP.
+func (*Graphite) Check(v interface{}) error {
+	opts := v.(*GraphiteOpts)
+	if opts.Protocol != "tcp" {
+		return nil
+	}
+
+	return checkReachable(opts.Address, "2003")
+}
+
+// Buffers a line for each metric of the groups pushed (see stats.Stats.Select), written on the next flush.
+func (g *Graphite) Push(s *stats.Stats) error {
+	base := g.path(graphiteName(s.Name))
+	timestamp := strconv.FormatInt(s.Timestamp.Unix(), 10)
+
+	var lines [][]byte
+	add := func(path string, value float64) {
+		lines = append(lines, []byte(path+" "+strconv.FormatFloat(value, 'f', -1, 64)+" "+timestamp+"\n"))
+	}
+
+	for _, metric := range graphiteMetrics {
+		if s.Groups.Has(metric.group) {
+			add(base+"."+metric.name, metric.value(s))
+		}
+	}
+
+	// only known if /proc is read.
+	if s.OpenFds > 0 {
+		add(base+".open_fds", float64(s.OpenFds))
+		add(base+".fd_limit", float64(s.FdLimit))
+	}
+
+	// only known if counted, left out along the network if not selected.
+	if s.Tcp != nil {
+		add(base+".tcp_established", float64(s.Tcp.Established))
+		add(base+".tcp_time_wait", float64(s.Tcp.TimeWait))
+	}
+
+	for name, i := range s.Interfaces {
+		path := base + ".interfaces." + graphiteName(name)
+		add(path+".tx_bytes", float64(i.TxBytes))
+		add(path+".rx_bytes", float64(i.RxBytes))
+		add(path+".tx_packets", float64(i.TxPackets))
+		add(path+".rx_packets", float64(i.RxPackets))
+		add(path+".tx_errors", float64(i.TxErrors))
+		add(path+".rx_errors", float64(i.RxErrors))
+		add(path+".tx_dropped", float64(i.TxDropped))
+		add(path+".rx_dropped", float64(i.RxDropped))
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.pending = append(g.pending, lines...)
+
+	// drop the oldest lines if Carbon is unreachable for too long.
+	if over := len(g.pending) - g.buffer; over > 0 {
+		g.pending = append([][]byte(nil), g.pending[over:]...)
+		log.Warning.Printf("Graphite buffer is full, dropped %d lines.", over)
+	}
+
+	return nil
+}
+
+// Writes the remaining lines and closes the connection.
+func (g *Graphite) Close() {
+	close(g.quit)
+	<-g.done
+}
+
+func (g *Graphite) Clear(name string) {
+	// not used.
+}
+
+// Lines waiting to be written.
+func (g *Graphite) Queued() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return len(g.pending)
+}
+
+// Sets the function called with the outcome of each flush, pushes only buffer the lines.
+func (g *Graphite) Report(report func(count int, err error)) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.report = report
+}
+
+func (g *Graphite) loop(interval time.Duration) {
+	defer close(g.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.quit:
+			g.flush()
+			if g.conn != nil {
+				g.conn.Close()
+			}
+			return
+		case <-ticker.C:
+			g.flush()
+		}
+	}
+}
+
+// Writes the pending lines, dialing Carbon if not connected. On failure, the connection is closed and the lines not
+// written are put back for the next flush.
+func (g *Graphite) flush() {
+	g.mutex.Lock()
+	lines := g.pending
+	g.pending = nil
+	report := g.report
+	g.mutex.Unlock()
+
+	if len(lines) == 0 {
+		return
+	}
+
+	written, err := g.write(lines)
+	if err != nil {
+		log.Error.Printf("Could not write to Carbon at %s: %s", g.address, err.Error())
+
+		if g.conn != nil {
+			g.conn.Close()
+			g.conn = nil
+		}
+
+		g.mutex.Lock()
+		g.pending = append(lines[written:], g.pending...)
+		if over := len(g.pending) - g.buffer; over > 0 {
+			g.pending = g.pending[over:]
+			log.Warning.Printf("Graphite buffer is full, dropped %d lines.", over)
+		}
+		g.mutex.Unlock()
+	}
+
+	if report != nil {
+		report(len(lines), err)
+	}
+}
+
+// Writes the lines, packed in datagrams over UDP, returning how many were written.
+func (g *Graphite) write(lines [][]byte) (int, error) {
+	if g.conn == nil {
+		conn, err := net.DialTimeout(g.network, g.address, GRAPHITE_TIMEOUT)
+		if err != nil {
+			return 0, err
+		}
+		g.conn = conn
+	}
+
+	limit := GRAPHITE_DATAGRAM
+	if g.network == "tcp" {
+		limit = 64 * 1024
+	}
+
+	var packet bytes.Buffer
+	written := 0
+	for i, line := range lines {
+		packet.Write(line)
+
+		if i < len(lines)-1 && packet.Len()+len(lines[i+1]) <= limit {
+			continue
+		}
+
+		g.conn.SetWriteDeadline(time.Now().Add(GRAPHITE_TIMEOUT))
+		if _, err := g.conn.Write(packet.Bytes()); err != nil {
+			return written, err
+		}
+
+		written = i + 1
+		packet.Reset()
+	}
+
+	return written, nil
+}
+
+// Path of the metric under the prefix, if any.
+func (g *Graphite) path(name string) string {
+	if g.prefix == "" {
+		return name
+	}
+
+	return g.prefix + "." + name
+}
+
+// Name as a single node of a metric path: dots, which separate nodes, and characters Carbon does not accept are
+// replaced by underscores.
+func graphiteName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(name, "/"))
+}
+
+func CreateGraphiteOpts() *GraphiteOpts {
+	o := &GraphiteOpts{}
+
+	flag.StringVar(&o.Address,
+		"graphite.address",
+		"localhost:2003",
+		"Address of the Carbon plaintext receiver, as host:port")
+
+	flag.StringVar(&o.Protocol,
+		"graphite.protocol",
+		"tcp",
+		"Protocol of the Carbon receiver: tcp or udp")
+
+	flag.StringVar(&o.Prefix,
+		"graphite.prefix",
+		"statspout",
+		"Prefix of the metric paths, followed by the container and the metric")
+
+	flag.DurationVar(&o.Interval,
+		"graphite.interval",
+		10*time.Second,
+		"Time between each flush")
+
+	flag.IntVar(&o.Buffer,
+		"graphite.buffer",
+		100000,
+		"Maximum number of lines buffered while Carbon is unreachable")
+
+	return o
+}
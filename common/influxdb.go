@@ -1,57 +1,209 @@
 package common
 
 import (
+	"errors"
 	"flag"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/influxdata/influxdb/client/v2"
+	"github.com/mijara/statspout/log"
 	"github.com/mijara/statspout/repo"
 	"github.com/mijara/statspout/stats"
 )
 
+func init() {
+	repo.Register(&InfluxDB{}, func() interface{} {
+		return CreateInfluxDBOpts()
+	})
+}
+
+// InfluxDB writes the stats through the line protocol, buffering their points to write them in batches. Events are
+// written as they come.
 type InfluxDB struct {
-	client   client.Client
-	database string
+	client    client.Client
+	database  string
+	retention string
+	batch     int
+
+	mutex   sync.Mutex
+	pending []*client.Point
+	flush   chan bool
+	report  func(count int, err error) // called with the outcome of each write of a batch, if set.
+
+	quit chan bool
+	done chan bool
 }
 
 type InfluxOpts struct {
-	Address  string
-	Database string
+	Address   string
+	Database  string
+	Retention string
+	Batch     int
+	Interval  time.Duration
+	TLS       TLSOpts
 }
 
-// Creates a new InfluxDB repository.
+// Creates a new InfluxDB repository, flushing in the background on every interval.
 func NewInfluxDB(opts *InfluxOpts) (*InfluxDB, error) {
-	c, err := client.NewHTTPClient(client.HTTPConfig{Addr: opts.Address})
+	if opts.Batch < 1 {
+		return nil, errors.New("Batch must be positive.")
+	}
+
+	if opts.Interval <= 0 {
+		return nil, errors.New("Flush interval must be positive.")
+	}
+
+	config, err := opts.TLS.Config()
 	if err != nil {
 		return nil, err
 	}
 
-	return &InfluxDB{
-		database: opts.Database,
-		client:   c,
-	}, nil
+	c, err := client.NewHTTPClient(client.HTTPConfig{Addr: opts.Address, TLSConfig: config})
+	if err != nil {
+		return nil, err
+	}
+
+	influx := &InfluxDB{
+		database:  opts.Database,
+		retention: opts.Retention,
+		batch:     opts.Batch,
+		client:    c,
+		flush:     make(chan bool, 1),
+		quit:      make(chan bool),
+		done:      make(chan bool),
+	}
+
+	go influx.loop(opts.Interval)
+
+	return influx, nil
 }
 
 func (*InfluxDB) Create(v interface{}) (repo.Interface, error) {
 	return NewInfluxDB(v.(*InfluxOpts))
 }
 
+// Checks that InfluxDB is reachable.
+func (*InfluxDB) Check(v interface{}) error {
+	return checkReachable(v.(*InfluxOpts).Address, "8086")
+}
+
+// Buffers the metrics of the groups pushed (see stats.Stats.Select), a flush is triggered when a batch is complete.
 func (influx *InfluxDB) Push(s *stats.Stats) error {
-	if err := influx.pushResource(s, "cpu_usage", s.CpuPercent); err != nil {
-		return err
+	type resource struct {
+		name  string
+		value interface{}
 	}
 
-	if err := influx.pushResource(s, "mem_usage", s.MemoryPercent); err != nil {
-		return err
+	var resources []resource
+
+	if s.Groups.Has(stats.GROUP_CPU) {
+		resources = append(resources,
+			resource{"cpu_usage", s.CpuPercent},
+			resource{"cpu_limit", s.CpuLimit},
+			resource{"cpu_shares", s.CpuShares})
 	}
 
-	if err := influx.pushResource(s, "tx_bytes", s.TxBytesTotal); err != nil {
-		return err
+	if s.Groups.Has(stats.GROUP_MEMORY) {
+		resources = append(resources,
+			resource{"mem_usage", s.MemoryPercent},
+			resource{"mem_limit", s.MemoryLimit},
+			resource{"mem_limited", s.MemoryLimited},
+			resource{"mem_failcnt", s.MemoryFailcnt})
 	}
 
-	if err := influx.pushResource(s, "rx_bytes", s.RxBytesTotal); err != nil {
-		return err
+	// only known if /proc is read.
+	if s.OpenFds > 0 {
+		resources = append(resources,
+			resource{"open_fds", s.OpenFds},
+			resource{"fd_limit", s.FdLimit})
+	}
+
+	// only known if counted, left out along the network if not selected.
+	if s.Tcp != nil {
+		resources = append(resources,
+			resource{"tcp_established", s.Tcp.Established},
+			resource{"tcp_time_wait", s.Tcp.TimeWait})
+	}
+
+	if s.Groups.Has(stats.GROUP_MEMORY) {
+		resources = append(resources,
+			resource{"swap_usage", s.SwapUsage},
+			resource{"swap_limit", s.SwapLimit})
 	}
 
+	// only known once the image was inspected.
+	if !s.ImageCreated.IsZero() {
+		resources = append(resources,
+			resource{"image_created", s.ImageCreated.Unix()},
+			resource{"image_age_days", s.ImageAgeDays})
+	}
+
+	if s.Groups.Has(stats.GROUP_BLKIO) {
+		resources = append(resources,
+			resource{"blkio_service_time", s.BlkioServiceTime},
+			resource{"blkio_serviced", s.BlkioServiced},
+			resource{"blkio_queue", s.BlkioQueue},
+			resource{"blkio_read_bytes", s.BlkioReadBytes},
+			resource{"blkio_write_bytes", s.BlkioWriteBytes},
+			resource{"blkio_reads", s.BlkioReads},
+			resource{"blkio_writes", s.BlkioWrites},
+			resource{"blkio_read_bps", s.BlkioReadBps},
+			resource{"blkio_write_bps", s.BlkioWriteBps},
+			resource{"blkio_read_iops", s.BlkioReadIops},
+			resource{"blkio_write_iops", s.BlkioWriteIops})
+	}
+
+	if s.Groups.Has(stats.GROUP_NETWORK) {
+		resources = append(resources,
+			resource{"tx_bytes", s.TxBytesTotal},
+			resource{"rx_bytes", s.RxBytesTotal},
+			resource{"tx_packets", s.TxPacketsTotal},
+			resource{"rx_packets", s.RxPacketsTotal},
+			resource{"tx_errors", s.TxErrorsTotal},
+			resource{"rx_errors", s.RxErrorsTotal},
+			resource{"tx_dropped", s.TxDroppedTotal},
+			resource{"rx_dropped", s.RxDroppedTotal})
+	}
+
+	points := make([]*client.Point, 0, len(resources)+len(s.Interfaces))
+
+	// a measurement per resource, tagged by container.
+	tags := map[string]string{"container": s.Name}
+	for _, r := range resources {
+		pt, err := client.NewPoint(r.name, tags, map[string]interface{}{"value": r.value}, s.Timestamp)
+		if err != nil {
+			return err
+		}
+
+		points = append(points, pt)
+	}
+
+	// left out along the network if not selected.
+	for name, i := range s.Interfaces {
+		fields := map[string]interface{}{
+			"tx_bytes":   i.TxBytes,
+			"rx_bytes":   i.RxBytes,
+			"tx_packets": i.TxPackets,
+			"rx_packets": i.RxPackets,
+			"tx_errors":  i.TxErrors,
+			"rx_errors":  i.RxErrors,
+			"tx_dropped": i.TxDropped,
+			"rx_dropped": i.RxDropped,
+		}
+
+		pt, err := client.NewPoint("interfaces", map[string]string{"container": s.Name, "interface": name}, fields,
+			s.Timestamp)
+		if err != nil {
+			return err
+		}
+
+		points = append(points, pt)
+	}
+
+	influx.add(points...)
+
 	return nil
 }
 
@@ -68,6 +220,23 @@ func CreateInfluxDBOpts() *InfluxOpts {
 		"statspout",
 		"Database to store data")
 
+	flag.StringVar(&o.Retention,
+		"influxdb.retention",
+		"",
+		"Retention policy to write to, the default one of the database if empty")
+
+	flag.IntVar(&o.Batch,
+		"influxdb.batch",
+		5000,
+		"Maximum number of points per write")
+
+	flag.DurationVar(&o.Interval,
+		"influxdb.interval",
+		10*time.Second,
+		"Time between each flush")
+
+	AddTLSFlags(&o.TLS, "influxdb")
+
 	return o
 }
 
@@ -75,7 +244,11 @@ func (*InfluxDB) Name() string {
 	return "influxdb"
 }
 
+// Writes the remaining points and stops flushing.
 func (influx *InfluxDB) Close() {
+	close(influx.quit)
+	<-influx.done
+
 	influx.client.Close()
 }
 
@@ -83,31 +256,179 @@ func (influx *InfluxDB) Clear(name string) {
 	// not used.
 }
 
-// Pushes certain a single value to the database, using the resource as the name and
-// the name of the container as a tag.
-func (influx *InfluxDB) pushResource(s *stats.Stats, resource string, value interface{}) error {
+// Pushes the event as a point of the events measurement, tagged by container and action, to be shown as an
+// annotation along the stats.
+func (influx *InfluxDB) PushEvent(event *stats.Event) error {
 	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  influx.database,
-		Precision: "s",
+		Database:        influx.database,
+		RetentionPolicy: influx.retention,
 	})
 	if err != nil {
 		return err
 	}
 
-	tags := map[string]string{"container": s.Name}
-	fields := map[string]interface{}{"value": value}
+	tags := map[string]string{"container": event.Name, "action": event.Action}
+	fields := map[string]interface{}{"text": event.Name + " " + event.Action}
+
+	// transitions, such as health checks, are tagged by the state entered.
+	if event.State != "" {
+		tags["state"] = event.State
+		fields["text"] = event.Name + " " + event.State
+	}
+
+	if event.Previous != "" {
+		fields["previous"] = event.Previous
+		fields["duration"] = event.Duration
+	}
 
-	pt, err := client.NewPoint(resource, tags, fields, s.Timestamp)
+	if event.ExitCode != nil {
+		fields["exit_code"] = *event.ExitCode
+	}
+
+	if event.StartedAt != nil {
+		fields["started_at"] = event.StartedAt.UnixNano()
+	}
+
+	if event.FinishedAt != nil {
+		fields["finished_at"] = event.FinishedAt.UnixNano()
+	}
+
+	pt, err := client.NewPoint("events", tags, fields, event.Timestamp)
 	if err != nil {
 		return err
 	}
 
 	bp.AddPoint(pt)
 
-	err = influx.client.Write(bp)
+	return influx.client.Write(bp)
+}
+
+// Buffers the disk usage of the volume as a point of the volumes measurement, tagged by container and volume.
+func (influx *InfluxDB) PushVolume(volume *stats.Volume) error {
+	tags := map[string]string{"container": volume.Name, "volume": volume.Volume}
+	fields := map[string]interface{}{"size": volume.Size, "destination": volume.Destination}
+
+	pt, err := client.NewPoint("volumes", tags, fields, volume.Timestamp)
 	if err != nil {
 		return err
 	}
 
+	influx.add(pt)
+
 	return nil
 }
+
+// Buffers the process and thread counts as a point of the processes measurement, tagged by container, with the
+// names of the top processes joined by commas.
+func (influx *InfluxDB) PushProcesses(processes *stats.Processes) error {
+	tags := map[string]string{"container": processes.Name}
+	fields := map[string]interface{}{"processes": processes.Processes, "threads": processes.Threads}
+
+	if len(processes.Top) > 0 {
+		fields["top"] = strings.Join(processes.Top, ",")
+	}
+
+	pt, err := client.NewPoint("processes", tags, fields, processes.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	influx.add(pt)
+
+	return nil
+}
+
+// Points waiting to be written.
+func (influx *InfluxDB) Queued() int {
+	influx.mutex.Lock()
+	defer influx.mutex.Unlock()
+
+	return len(influx.pending)
+}
+
+// Sets the function called with the outcome of each write, pushes only buffer the points.
+func (influx *InfluxDB) Report(report func(count int, err error)) {
+	influx.mutex.Lock()
+	defer influx.mutex.Unlock()
+
+	influx.report = report
+}
+
+// Buffers the points, waking up the loop without blocking once a batch is complete.
+func (influx *InfluxDB) add(points ...*client.Point) {
+	influx.mutex.Lock()
+	defer influx.mutex.Unlock()
+
+	influx.pending = append(influx.pending, points...)
+
+	if len(influx.pending) >= influx.batch {
+		select {
+		case influx.flush <- true:
+		default:
+		}
+	}
+}
+
+func (influx *InfluxDB) loop(interval time.Duration) {
+	defer close(influx.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-influx.quit:
+			influx.write()
+			return
+		case <-ticker.C:
+		case <-influx.flush:
+		}
+
+		influx.write()
+	}
+}
+
+// Writes every pending point in batches. Batches that cannot be written are dropped, InfluxDB may have stored some
+// of their points already.
+func (influx *InfluxDB) write() {
+	for {
+		influx.mutex.Lock()
+		n := len(influx.pending)
+		if n > influx.batch {
+			n = influx.batch
+		}
+		points := influx.pending[:n]
+		influx.pending = append([]*client.Point(nil), influx.pending[n:]...)
+		report := influx.report
+		influx.mutex.Unlock()
+
+		if len(points) == 0 {
+			return
+		}
+
+		err := influx.writePoints(points)
+		if report != nil {
+			report(len(points), err)
+		}
+
+		if err != nil {
+			log.Error.Printf("Could not write %d points to InfluxDB: %s", len(points), err.Error())
+		}
+	}
+}
+
+// Writes the points to the database and retention policy, timestamps are truncated to seconds.
+func (influx *InfluxDB) writePoints(points []*client.Point) error {
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database:        influx.database,
+		RetentionPolicy: influx.retention,
+		Precision:       "s",
+	})
+	if err != nil {
+		return err
+	}
+
+	bp.AddPoints(points)
+
+	return influx.client.Write(bp)
+}
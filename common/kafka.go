@@ -0,0 +1,338 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+func init() {
+	repo.Register(&Kafka{}, func() interface{} {
+		return CreateKafkaOpts()
+	})
+}
+
+// Partitioners of the messages, hash keeps the messages of a container in order in the same partition.
+var KAFKA_PARTITIONERS = map[string]sarama.PartitionerConstructor{
+	"hash":       sarama.NewHashPartitioner,
+	"random":     sarama.NewRandomPartitioner,
+	"roundrobin": sarama.NewRoundRobinPartitioner,
+}
+
+// Compression codecs of the messages.
+var KAFKA_COMPRESSIONS = map[string]sarama.CompressionCodec{
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+// Acknowledgements awaited from the brokers for each message.
+var KAFKA_ACKS = map[string]sarama.RequiredAcks{
+	"none":  sarama.NoResponse,
+	"local": sarama.WaitForLocal,
+	"all":   sarama.WaitForAll,
+}
+
+// Header of the messages holding the idempotency key of the sample, see stats.Key.
+const KAFKA_ID_HEADER = "id"
+
+// Kafka publishes each sample as a JSON message to a topic, keyed by the name of its container. Messages are sent in
+// the background, failures are logged and reported. Pushes never block: once the queue of the producer is full, as
+// while the brokers are unreachable, the newest samples are dropped.
+type Kafka struct {
+	topic    string
+	producer sarama.AsyncProducer
+	headers  bool // whether the brokers take message headers, 0.11.0 at least.
+
+	mutex   sync.Mutex
+	report  func(count int, err error) // called with the outcome of each message, if set.
+	dropped int                        // samples dropped since the queue is full, logged once it has room again.
+
+	done chan bool
+}
+
+type KafkaOpts struct {
+	Brokers     string
+	Topic       string
+	Partitioner string
+	Compression string
+	Acks        string
+	Version     string
+	ClientID    string
+	Buffer      int
+	TLS         TLSOpts
+}
+
+// Creates a new Kafka repository, connected to the brokers.
+func NewKafka(opts *KafkaOpts) (*Kafka, error) {
+	brokers := kafkaBrokers(opts.Brokers)
+	if len(brokers) == 0 {
+		return nil, errors.New("The address of at least one broker is needed.")
+	}
+
+	if opts.Topic == "" {
+		return nil, errors.New("The topic is needed.")
+	}
+
+	if opts.Buffer < 1 {
+		return nil, errors.New("Buffer must be positive.")
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = opts.ClientID
+	config.ChannelBufferSize = opts.Buffer
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+
+	// message timestamps need 0.10 at least.
+	config.Version = sarama.V0_10_0_0
+	if opts.Version != "" {
+		version, err := sarama.ParseKafkaVersion(opts.Version)
+		if err != nil {
+			return nil, errors.New("Invalid Kafka version " + opts.Version + ", use dotted numbers as 2.1.0.")
+		}
+		config.Version = version
+	}
+
+	partitioner, ok := KAFKA_PARTITIONERS[opts.Partitioner]
+	if !ok {
+		return nil, errors.New("Unknown partitioner " + opts.Partitioner + ", use hash, random or roundrobin.")
+	}
+	config.Producer.Partitioner = partitioner
+
+	compression, ok := KAFKA_COMPRESSIONS[opts.Compression]
+	if !ok {
+		return nil, errors.New("Unknown compression " + opts.Compression + ", use none, gzip, snappy, lz4 or zstd.")
+	}
+	config.Producer.Compression = compression
+
+	if compression == sarama.CompressionZSTD && !config.Version.IsAtLeast(sarama.V2_1_0_0) {
+		return nil, errors.New("Compression zstd needs Kafka 2.1.0 at least, see -kafka.version.")
+	}
+
+	acks, ok := KAFKA_ACKS[opts.Acks]
+	if !ok {
+		return nil, errors.New("Unknown acks " + opts.Acks + ", use none, local or all.")
+	}
+	config.Producer.RequiredAcks = acks
+
+	tlsConfig, err := opts.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	producer, err := sarama.NewAsyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	kafka := &Kafka{
+		topic:    opts.Topic,
+		producer: producer,
+		headers:  config.Version.IsAtLeast(sarama.V0_11_0_0),
+		done:     make(chan bool),
+	}
+
+	go kafka.drain()
+
+	return kafka, nil
+}
+
+func (*Kafka) Name() string {
+	return "kafka"
+}
+
+func (*Kafka) Create(v interface{}) (repo.Interface, error) {
+	return NewKafka(v.(*KafkaOpts))
+}
+
+// Checks that the first broker is reachable.
+func (*Kafka) Check(v interface{}) error {
+	brokers := kafkaBrokers(v.(*KafkaOpts).Brokers)
+	if len(brokers) == 0 {
+		return errors.New("The address of at least one broker is needed.")
+	}
+
+	return checkReachable(brokers[0], "9092")
+}
+
+// Queues the sample as a message keyed by the name of its container, sent in the background, along its idempotency
+// key if the brokers take headers. The sample is dropped if the queue is full.
+func (kafka *Kafka) Push(s *stats.Stats) error {
+	value, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	message := &sarama.ProducerMessage{
+		Topic:     kafka.topic,
+		Key:       sarama.StringEncoder(s.Name),
+		Value:     sarama.ByteEncoder(value),
+		Timestamp: s.Timestamp,
+	}
+
+	if kafka.headers && s.ID != "" {
+		message.Headers = []sarama.RecordHeader{{Key: []byte(KAFKA_ID_HEADER), Value: []byte(s.ID)}}
+	}
+
+	select {
+	case kafka.producer.Input() <- message:
+	default:
+		kafka.drop()
+		return nil
+	}
+
+	kafka.mutex.Lock()
+	dropped := kafka.dropped
+	kafka.dropped = 0
+	kafka.mutex.Unlock()
+
+	if dropped > 0 {
+		log.Warning.Printf("Kafka queue was full, dropped %d samples.", dropped)
+	}
+
+	return nil
+}
+
+// Counts a sample dropped because the queue is full and reports it, the first one of a row is logged.
+func (kafka *Kafka) drop() {
+	kafka.mutex.Lock()
+	kafka.dropped++
+	first := kafka.dropped == 1
+	report := kafka.report
+	kafka.mutex.Unlock()
+
+	if first {
+		log.Warning.Printf("Kafka queue is full, dropping samples until it has room.")
+	}
+
+	if report != nil {
+		report(1, errors.New("Kafka queue is full, sample dropped."))
+	}
+}
+
+// Sends the queued messages and closes the producer.
+func (kafka *Kafka) Close() {
+	kafka.producer.AsyncClose()
+	<-kafka.done
+}
+
+func (kafka *Kafka) Clear(name string) {
+	// not used.
+}
+
+// Sets the function called with the outcome of each message, pushes only queue them.
+func (kafka *Kafka) Report(report func(count int, err error)) {
+	kafka.mutex.Lock()
+	defer kafka.mutex.Unlock()
+
+	kafka.report = report
+}
+
+// Reports the outcome of the messages until the producer is closed.
+func (kafka *Kafka) drain() {
+	defer close(kafka.done)
+
+	successes, errs := kafka.producer.Successes(), kafka.producer.Errors()
+	for successes != nil || errs != nil {
+		var err error
+
+		select {
+		case _, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+		case e, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+
+			err = e.Err
+			log.Error.Printf("Could not publish stats to Kafka: %s", err.Error())
+		}
+
+		kafka.mutex.Lock()
+		report := kafka.report
+		kafka.mutex.Unlock()
+
+		if report != nil {
+			report(1, err)
+		}
+	}
+}
+
+// Addresses of the brokers, separated by comma.
+func kafkaBrokers(spec string) []string {
+	var brokers []string
+	for _, broker := range strings.Split(spec, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+
+	return brokers
+}
+
+func CreateKafkaOpts() *KafkaOpts {
+	o := &KafkaOpts{}
+
+	flag.StringVar(&o.Brokers,
+		"kafka.brokers",
+		"localhost:9092",
+		"Addresses of the Kafka brokers, separated by comma")
+
+	flag.StringVar(&o.Topic,
+		"kafka.topic",
+		"statspout",
+		"Topic the stats are published to")
+
+	flag.StringVar(&o.Partitioner,
+		"kafka.partitioner",
+		"hash",
+		"Partitioner of the messages: hash (by container name), random or roundrobin")
+
+	flag.StringVar(&o.Compression,
+		"kafka.compression",
+		"none",
+		"Compression of the messages: none, gzip, snappy, lz4 or zstd")
+
+	flag.StringVar(&o.Acks,
+		"kafka.acks",
+		"local",
+		"Acknowledgements awaited for each message: none, local (the leader) or all (every in-sync replica)")
+
+	flag.StringVar(&o.Version,
+		"kafka.version",
+		"",
+		"Version of the brokers, such as 2.1.0, 0.10.0 if empty")
+
+	flag.StringVar(&o.ClientID,
+		"kafka.client-id",
+		"statspout",
+		"Client ID sent to the brokers")
+
+	flag.IntVar(&o.Buffer,
+		"kafka.buffer",
+		10000,
+		"Maximum number of samples queued while the brokers are unreachable, newer ones are dropped")
+
+	AddTLSFlags(&o.TLS, "kafka")
+
+	return o
+}
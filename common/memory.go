@@ -0,0 +1,248 @@
+package common
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+func init() {
+	repo.Register(&Memory{}, func() interface{} {
+		return CreateMemoryOpts()
+	})
+}
+
+// Events retained by memory repositories, the newest ones within the retention.
+const MEMORY_EVENTS = 1000
+
+// Memory retains the last samples of each container, and the last events, useful for tests and debugging.
+type Memory struct {
+	samples   int                      // samples to retain per container, unlimited if 0.
+	retention time.Duration            // age of the oldest sample and event to retain, unlimited if 0.
+	registry  map[string][]stats.Stats // samples of each container, by qualified name (see stats.QualifiedName).
+	events    []stats.Event
+	mutex     sync.RWMutex
+}
+
+type MemoryOpts struct {
+	Samples   int
+	Retention time.Duration
+}
+
+func NewMemory(opts *MemoryOpts) (*Memory, error) {
+	if opts.Retention < 0 {
+		return nil, errors.New("Retention cannot be negative.")
+	}
+
+	// without a retention, at least the latest sample is kept.
+	samples := opts.Samples
+	if samples < 1 && opts.Retention == 0 {
+		samples = 1
+	}
+	if samples < 0 {
+		samples = 0
+	}
+
+	return &Memory{
+		samples:   samples,
+		retention: opts.Retention,
+		registry:  map[string][]stats.Stats{},
+	}, nil
+}
+
+func (*Memory) Name() string {
+	return "memory"
+}
+
+func (*Memory) Create(v interface{}) (repo.Interface, error) {
+	return NewMemory(v.(*MemoryOpts))
+}
+
+func (memory *Memory) Push(s *stats.Stats) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	name := s.QualifiedName()
+	list := append(memory.registry[name], *s)
+
+	// drop the oldest samples, by count and by age.
+	first := 0
+	if memory.samples > 0 && len(list) > memory.samples {
+		first = len(list) - memory.samples
+	}
+	if memory.retention > 0 {
+		oldest := s.Timestamp.Add(-memory.retention)
+		for first < len(list)-1 && list[first].Timestamp.Before(oldest) {
+			first++
+		}
+	}
+
+	if first > 0 {
+		list = append([]stats.Stats(nil), list[first:]...)
+	}
+
+	memory.registry[name] = list
+	return nil
+}
+
+// Retains the event, dropping the oldest ones by count and by age.
+func (memory *Memory) PushEvent(event *stats.Event) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	list := append(memory.events, *event)
+
+	first := 0
+	if len(list) > MEMORY_EVENTS {
+		first = len(list) - MEMORY_EVENTS
+	}
+	if memory.retention > 0 {
+		oldest := event.Timestamp.Add(-memory.retention)
+		for first < len(list) && list[first].Timestamp.Before(oldest) {
+			first++
+		}
+	}
+
+	if first > 0 {
+		list = append([]stats.Event(nil), list[first:]...)
+	}
+
+	memory.events = list
+	return nil
+}
+
+func (memory *Memory) Close() {
+}
+
+// Clears the container on every host.
+func (memory *Memory) Clear(name string) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	for qualified, list := range memory.registry {
+		if len(list) > 0 && list[0].Name == name {
+			delete(memory.registry, qualified)
+		}
+	}
+}
+
+func (memory *Memory) ClearHost(host string, name string) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	delete(memory.registry, stats.QualifiedName(host, name))
+}
+
+// Samples of the named container, from oldest to newest. The name is qualified by its host as host/name, a bare name
+// stands for the container of that name on the first host, by name, holding one.
+func (memory *Memory) Get(name string) []stats.Stats {
+	memory.mutex.RLock()
+	defer memory.mutex.RUnlock()
+
+	return append([]stats.Stats(nil), memory.lookup(name)...)
+}
+
+// Latest sample of the named container, false if there is none. The name is qualified as in Get.
+func (memory *Memory) Latest(name string) (stats.Stats, bool) {
+	memory.mutex.RLock()
+	defer memory.mutex.RUnlock()
+
+	list := memory.lookup(name)
+	if len(list) == 0 {
+		return stats.Stats{}, false
+	}
+
+	return list[len(list)-1], true
+}
+
+// Samples of the named container taken between from and to, both inclusive, from oldest to newest. A
+// zero bound is not applied. The name is qualified as in Get.
+func (memory *Memory) Range(name string, from time.Time, to time.Time) []stats.Stats {
+	memory.mutex.RLock()
+	defer memory.mutex.RUnlock()
+
+	list := []stats.Stats{}
+	for _, s := range memory.lookup(name) {
+		if !from.IsZero() && s.Timestamp.Before(from) {
+			continue
+		}
+		if !to.IsZero() && s.Timestamp.After(to) {
+			continue
+		}
+
+		list = append(list, s)
+	}
+
+	return list
+}
+
+// Events taken between from and to, both inclusive, from oldest to newest. A zero bound is not applied.
+func (memory *Memory) Events(from time.Time, to time.Time) []stats.Event {
+	memory.mutex.RLock()
+	defer memory.mutex.RUnlock()
+
+	list := []stats.Event{}
+	for _, event := range memory.events {
+		if !from.IsZero() && event.Timestamp.Before(from) {
+			continue
+		}
+		if !to.IsZero() && event.Timestamp.After(to) {
+			continue
+		}
+
+		list = append(list, event)
+	}
+
+	return list
+}
+
+// Copy of the samples of every container, by qualified name.
+func (memory *Memory) Snapshot() map[string][]stats.Stats {
+	memory.mutex.RLock()
+	defer memory.mutex.RUnlock()
+
+	snapshot := make(map[string][]stats.Stats, len(memory.registry))
+	for name, list := range memory.registry {
+		snapshot[name] = append([]stats.Stats(nil), list...)
+	}
+
+	return snapshot
+}
+
+// Samples of the container given by qualified name, or by bare name on the first host holding one. Must be called
+// with the mutex held.
+func (memory *Memory) lookup(name string) []stats.Stats {
+	if list, ok := memory.registry[name]; ok || strings.Contains(name, "/") {
+		return list
+	}
+
+	first := ""
+	for qualified := range memory.registry {
+		if strings.HasSuffix(qualified, "/"+name) && (first == "" || qualified < first) {
+			first = qualified
+		}
+	}
+
+	return memory.registry[first]
+}
+
+func CreateMemoryOpts() *MemoryOpts {
+	o := &MemoryOpts{}
+
+	flag.IntVar(&o.Samples,
+		"memory.samples",
+		60,
+		"Number of samples to retain per container, unlimited if 0 and a retention is given")
+
+	flag.DurationVar(&o.Retention,
+		"memory.retention",
+		0,
+		"Age of the oldest sample to retain per container, unlimited if 0")
+
+	return o
+}
@@ -1,7 +1,10 @@
 package common
 
 import (
+	"crypto/tls"
 	"flag"
+	"net"
+	"strings"
 
 	"gopkg.in/mgo.v2"
 
@@ -9,20 +12,45 @@ import (
 	"github.com/mijara/statspout/stats"
 )
 
+func init() {
+	repo.Register(&Mongo{}, func() interface{} {
+		return CreateMongoOpts()
+	})
+}
+
 type Mongo struct {
 	session    *mgo.Session
 	database   string
 	collection string
+	events     string
 }
 
 type MongoOpts struct {
 	Address    string
 	Database   string
 	Collection string
+	Events     string
+	TLS        TLSOpts
 }
 
 func NewMongo(opts *MongoOpts) (*Mongo, error) {
-	session, err := mgo.Dial(opts.Address)
+	config, err := opts.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := mgo.ParseURL(opts.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil {
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return tls.Dial("tcp", addr.String(), config)
+		}
+	}
+
+	session, err := mgo.DialWithInfo(info)
 	if err != nil {
 		return nil, err
 	}
@@ -31,6 +59,7 @@ func NewMongo(opts *MongoOpts) (*Mongo, error) {
 		session:    session,
 		database:   opts.Database,
 		collection: opts.Collection,
+		events:     opts.Events,
 	}, nil
 }
 
@@ -38,6 +67,17 @@ func (*Mongo) Create(v interface{}) (repo.Interface, error) {
 	return NewMongo(v.(*MongoOpts))
 }
 
+// Checks that the first MongoDB server is reachable.
+func (*Mongo) Check(v interface{}) error {
+	address := strings.TrimPrefix(v.(*MongoOpts).Address, "mongodb://")
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		address = address[at+1:]
+	}
+	address = strings.Split(strings.Split(address, "/")[0], ",")[0]
+
+	return checkReachable(address, "27017")
+}
+
 func (mongo *Mongo) Push(s *stats.Stats) error {
 	c := mongo.session.DB(mongo.database).C(mongo.collection)
 
@@ -49,6 +89,11 @@ func (mongo *Mongo) Push(s *stats.Stats) error {
 	return nil
 }
 
+// Inserts the event in the events collection.
+func (mongo *Mongo) PushEvent(event *stats.Event) error {
+	return mongo.session.DB(mongo.database).C(mongo.events).Insert(event)
+}
+
 func (*Mongo) Name() string {
 	return "mongodb"
 }
@@ -79,5 +124,12 @@ func CreateMongoOpts() *MongoOpts {
 		"stats",
 		"Collection for the stats")
 
+	flag.StringVar(&o.Events,
+		"mongo.events",
+		"events",
+		"Collection for the container lifecycle events")
+
+	AddTLSFlags(&o.TLS, "mongo")
+
 	return o
 }
@@ -0,0 +1,435 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/secret"
+	"github.com/mijara/statspout/stats"
+)
+
+func init() {
+	repo.Register(&MQTT{}, func() interface{} {
+		return CreateMQTTOpts()
+	})
+}
+
+// Time to connect to the broker and to publish each message.
+const MQTT_TIMEOUT = 10 * time.Second
+
+// Label the receiver adds to forwarded samples, naming the agent they come from.
+const MQTT_AGENT_LABEL = "statspout.agent"
+
+// Level of the status topic, container names cannot start with an underscore.
+const MQTT_STATUS = "_status"
+
+// Schemes of the broker URLs connecting over TLS.
+var MQTT_TLS_SCHEMES = map[string]bool{"ssl": true, "tls": true, "mqtts": true, "wss": true}
+
+// Metric announced to Home Assistant as a sensor of each container.
+type mqttSensor struct {
+	field  string // field of the JSON of the sample.
+	name   string // name of the sensor, after the name of the container.
+	unit   string
+	device string // device class, none if empty.
+	state  string // state class, measurement or total_increasing for counters.
+	group  string // group of the field, the sensor is left out if not pushed.
+}
+
+// Sensors of each container, read from the JSON of its samples, see mqtt.discovery.
+var MQTT_SENSORS = []mqttSensor{
+	{"cpu_percent", "CPU", "%", "", "measurement", "cpu"},
+	{"mem_usage", "Memory", "B", "data_size", "measurement", "memory"},
+	{"mem_percent", "Memory percent", "%", "", "measurement", "memory"},
+	{"rx_bytes", "Received", "B", "data_size", "total_increasing", "network"},
+	{"tx_bytes", "Sent", "B", "data_size", "total_increasing", "network"},
+	{"blkio_read_bytes", "Disk read", "B", "data_size", "total_increasing", "blkio"},
+	{"blkio_write_bytes", "Disk written", "B", "data_size", "total_increasing", "blkio"},
+}
+
+// MQTT publishes the stats of each container as JSON to the topic <prefix>/<host>/<container>, and whether this
+// instance is online to <prefix>/<host>/_status, retained and set to offline by the broker once the connection is lost.
+// Optionally announces a Home Assistant sensor of each metric of the containers, see mqtt.discovery.
+type MQTT struct {
+	client    mqtt.Client
+	prefix    string
+	host      string
+	qos       byte
+	retained  bool
+	discovery string // prefix of the Home Assistant discovery topics, disabled if empty.
+
+	mutex     sync.Mutex
+	topics    map[string]string   // topic of each container with retained messages, cleared once it stops.
+	configs   map[string][]string // discovery topics of each container announced, cleared once it stops.
+	announced map[string]bool     // containers announced since connected, announced again on the next connection.
+}
+
+type MQTTOpts struct {
+	Broker   string
+	Topic    string
+	Host     string
+	QoS      int
+	Retained bool
+	ClientID string
+	User     string
+	Password string
+	TLS      TLSOpts
+
+	Discovery       bool
+	DiscoveryPrefix string
+}
+
+// Creates a new MQTT repository, connected to the broker. The connection is restored in the background once lost.
+func NewMQTT(opts *MQTTOpts) (*MQTT, error) {
+	broker, err := url.Parse(opts.Broker)
+	if err != nil || broker.Scheme == "" || broker.Host == "" {
+		return nil, errors.New("Invalid broker " + opts.Broker + ", use a URL as tcp://host:1883 or ssl://host:8883.")
+	}
+
+	if opts.QoS < 0 || opts.QoS > 2 {
+		return nil, errors.New("QoS must be 0, 1 or 2.")
+	}
+
+	if strings.ContainsAny(opts.Topic, "+#") {
+		return nil, errors.New("The topic prefix cannot hold wildcards.")
+	}
+
+	discovery := ""
+	if opts.Discovery {
+		discovery = strings.Trim(opts.DiscoveryPrefix, "/")
+		if discovery == "" || strings.ContainsAny(discovery, "+#") {
+			return nil, errors.New("The discovery prefix cannot be empty nor hold wildcards.")
+		}
+	}
+
+	if err := secret.Check(opts.Password); err != nil {
+		return nil, err
+	}
+
+	config, err := opts.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil && !MQTT_TLS_SCHEMES[broker.Scheme] {
+		return nil, errors.New("TLS is only used with ssl://, tls://, mqtts:// or wss:// brokers.")
+	}
+
+	host := opts.Host
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+
+	m := &MQTT{
+		prefix:    strings.Trim(opts.Topic, "/"),
+		host:      mqttLevel(host),
+		qos:       byte(opts.QoS),
+		retained:  opts.Retained,
+		discovery: discovery,
+		topics:    make(map[string]string),
+		configs:   make(map[string][]string),
+		announced: make(map[string]bool),
+	}
+
+	clientID := opts.ClientID
+	if clientID == "" {
+		clientID = "statspout-" + m.host
+	}
+
+	password := secret.New(opts.Password)
+
+	options := mqtt.NewClientOptions()
+	options.AddBroker(opts.Broker)
+	options.SetClientID(clientID)
+	options.SetTLSConfig(config)
+	options.SetConnectTimeout(MQTT_TIMEOUT)
+	options.SetAutoReconnect(true)
+	options.SetWill(m.topic(m.host, MQTT_STATUS), "offline", 1, true)
+
+	// read on every connection, so a rotated password is used once reconnecting.
+	options.SetCredentialsProvider(func() (string, string) {
+		return opts.User, password.Value()
+	})
+
+	options.SetOnConnectHandler(func(client mqtt.Client) {
+		log.Info.Printf("Connected to the MQTT broker %s.", opts.Broker)
+		client.Publish(m.topic(m.host, MQTT_STATUS), 1, true, "online")
+
+		// a broker restarted without persistence lost the retained configs.
+		m.mutex.Lock()
+		m.announced = make(map[string]bool)
+		m.mutex.Unlock()
+	})
+
+	options.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Warning.Printf("Lost the connection to the MQTT broker, reconnecting: %s", err.Error())
+	})
+
+	m.client = mqtt.NewClient(options)
+
+	token := m.client.Connect()
+	if !token.WaitTimeout(MQTT_TIMEOUT) {
+		return nil, errors.New("Could not connect to the MQTT broker in time.")
+	}
+
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (*MQTT) Name() string {
+	return "mqtt"
+}
+
+func (*MQTT) Create(v interface{}) (repo.Interface, error) {
+	return NewMQTT(v.(*MQTTOpts))
+}
+
+// Checks that the broker is reachable.
+func (*MQTT) Check(v interface{}) error {
+	broker := v.(*MQTTOpts).Broker
+
+	port := "1883"
+	if u, err := url.Parse(broker); err == nil && MQTT_TLS_SCHEMES[u.Scheme] {
+		port = "8883"
+	}
+
+	return checkReachable(broker, port)
+}
+
+// Publishes the sample to the topic of its container, once the broker acknowledges it for QoS 1 and 2. Samples are
+// dropped while the connection is lost.
+func (m *MQTT) Push(s *stats.Stats) error {
+	if !m.client.IsConnectionOpen() {
+		return errors.New("Not connected to the MQTT broker.")
+	}
+
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	// forwarded samples are published under the host they come from.
+	host := m.host
+	if agent := s.Labels[MQTT_AGENT_LABEL]; agent != "" {
+		host = mqttLevel(agent)
+	}
+
+	topic := m.topic(host, mqttLevel(s.Name))
+
+	if m.retained {
+		m.mutex.Lock()
+		m.topics[s.Name] = topic
+		m.mutex.Unlock()
+	}
+
+	if m.discovery != "" {
+		if err := m.announce(s, host, topic); err != nil {
+			return err
+		}
+	}
+
+	return m.wait(m.client.Publish(topic, m.qos, m.retained, payload))
+}
+
+// Publishes the retained Home Assistant discovery config of each sensor of the container, once per connection, so
+// its metrics show up as sensors of a device named after the container.
+func (m *MQTT) announce(s *stats.Stats, host string, topic string) error {
+	m.mutex.Lock()
+	announced := m.announced[s.Name]
+	m.announced[s.Name] = true
+	m.mutex.Unlock()
+
+	if announced {
+		return nil
+	}
+
+	name := strings.TrimPrefix(s.Name, "/")
+	device := mqttObjectID("statspout", host, name)
+
+	var configs []string
+	for _, sensor := range MQTT_SENSORS {
+		if !s.Groups.Has(sensor.group) {
+			continue
+		}
+
+		id := mqttObjectID(host, name, sensor.field)
+		config := map[string]interface{}{
+			"name":                sensor.name,
+			"unique_id":           "statspout_" + id,
+			"state_topic":         topic,
+			"value_template":      "{{ value_json." + sensor.field + " }}",
+			"unit_of_measurement": sensor.unit,
+			"state_class":         sensor.state,
+			"availability_topic":  m.topic(m.host, MQTT_STATUS),
+			"device": map[string]interface{}{
+				"identifiers":  []string{device},
+				"name":         name,
+				"model":        "Docker container",
+				"manufacturer": "statspout",
+			},
+		}
+		if sensor.device != "" {
+			config["device_class"] = sensor.device
+		}
+
+		payload, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+
+		configTopic := m.discovery + "/sensor/" + id + "/config"
+		if err := m.wait(m.client.Publish(configTopic, 1, true, payload)); err != nil {
+			// announced again on the next push.
+			m.mutex.Lock()
+			delete(m.announced, s.Name)
+			m.mutex.Unlock()
+			return err
+		}
+
+		configs = append(configs, configTopic)
+	}
+
+	m.mutex.Lock()
+	m.configs[s.Name] = configs
+	m.mutex.Unlock()
+
+	return nil
+}
+
+// Sets the status offline and disconnects, waiting for the messages in flight.
+func (m *MQTT) Close() {
+	m.wait(m.client.Publish(m.topic(m.host, MQTT_STATUS), 1, true, "offline"))
+	m.client.Disconnect(uint(MQTT_TIMEOUT / time.Millisecond))
+}
+
+// Removes the retained message of the container, so it is not delivered to new subscribers once it stopped, and its
+// discovery configs, so Home Assistant removes its sensors.
+func (m *MQTT) Clear(name string) {
+	m.mutex.Lock()
+	topic, ok := m.topics[name]
+	configs := m.configs[name]
+	delete(m.topics, name)
+	delete(m.configs, name)
+	delete(m.announced, name)
+	m.mutex.Unlock()
+
+	if !m.client.IsConnectionOpen() {
+		return
+	}
+
+	if ok {
+		m.wait(m.client.Publish(topic, m.qos, true, []byte{}))
+	}
+
+	for _, config := range configs {
+		m.wait(m.client.Publish(config, 1, true, []byte{}))
+	}
+}
+
+// Topic of the prefix and the host, followed by the level.
+func (m *MQTT) topic(host string, level string) string {
+	if m.prefix == "" {
+		return host + "/" + level
+	}
+
+	return m.prefix + "/" + host + "/" + level
+}
+
+func (m *MQTT) wait(token mqtt.Token) error {
+	if !token.WaitTimeout(MQTT_TIMEOUT) {
+		return errors.New("The MQTT broker did not acknowledge in time.")
+	}
+
+	return token.Error()
+}
+
+// Name as a single topic level, without the separator and wildcards of topics.
+func mqttLevel(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '+', '#':
+			return '_'
+		}
+		return r
+	}, strings.TrimPrefix(name, "/"))
+}
+
+// Object ID of the parts joined by underscores, with what Home Assistant does not take in IDs replaced by underscores.
+func mqttObjectID(parts ...string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.Join(parts, "_"))
+}
+
+func CreateMQTTOpts() *MQTTOpts {
+	o := &MQTTOpts{}
+
+	flag.StringVar(&o.Broker,
+		"mqtt.broker",
+		"tcp://localhost:1883",
+		"URL of the broker, as tcp://host:port, ssl://host:port for TLS, or ws:// and wss:// for WebSockets")
+
+	flag.StringVar(&o.Topic,
+		"mqtt.topic",
+		"statspout",
+		"Prefix of the topics, followed by /<host>/<container>")
+
+	flag.StringVar(&o.Host,
+		"mqtt.host",
+		"",
+		"Host level of the topics, the hostname if empty")
+
+	flag.IntVar(&o.QoS,
+		"mqtt.qos",
+		0,
+		"QoS of the messages: 0 (at most once), 1 (at least once) or 2 (exactly once)")
+
+	flag.BoolVar(&o.Retained,
+		"mqtt.retained",
+		false,
+		"Publish retained messages, so new subscribers get the last stats of each container")
+
+	flag.StringVar(&o.ClientID,
+		"mqtt.client-id",
+		"",
+		"Client ID sent to the broker, statspout-<host> if empty")
+
+	flag.StringVar(&o.User,
+		"mqtt.user",
+		"",
+		"User to authenticate with, none if empty")
+
+	flag.StringVar(&o.Password,
+		"mqtt.password",
+		"",
+		"Password of the user, or @file to read it from a file reloaded on changes")
+
+	flag.BoolVar(&o.Discovery,
+		"mqtt.discovery",
+		false,
+		"Announce the metrics of each container to Home Assistant through MQTT discovery")
+
+	flag.StringVar(&o.DiscoveryPrefix,
+		"mqtt.discovery.prefix",
+		"homeassistant",
+		"Prefix of the Home Assistant discovery topics")
+
+	AddTLSFlags(&o.TLS, "mqtt")
+
+	return o
+}
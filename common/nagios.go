@@ -0,0 +1,490 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/secret"
+	"github.com/mijara/statspout/stats"
+)
+
+func init() {
+	repo.Register(&Nagios{}, func() interface{} {
+		return CreateNagiosOpts()
+	})
+}
+
+// APIs passive check results are submitted to.
+const (
+	NAGIOS_NRDP    = "nrdp"
+	NAGIOS_ICINGA2 = "icinga2"
+)
+
+// Path of the Icinga 2 API action processing check results.
+const ICINGA2_PATH = "/v1/actions/process-check-result"
+
+// States of the check results, as plugins return them.
+var NAGIOS_STATES = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// Metrics checked against thresholds, with the unit of their performance data.
+var nagiosMetrics = []struct {
+	name  string
+	unit  string
+	value func(s *stats.Stats) float64
+}{
+	{"cpu_percent", "%", func(s *stats.Stats) float64 { return s.CpuPercent }},
+	{"mem_percent", "%", func(s *stats.Stats) float64 { return s.MemoryPercent }},
+	{"mem_usage", "B", func(s *stats.Stats) float64 { return float64(s.MemoryUsage) }},
+	{"swap_usage", "B", func(s *stats.Stats) float64 { return float64(s.SwapUsage) }},
+	{"open_fds", "", func(s *stats.Stats) float64 { return float64(s.OpenFds) }},
+	{"blkio_queue", "", func(s *stats.Stats) float64 { return float64(s.BlkioQueue) }},
+}
+
+// Warning and critical thresholds of a metric, the state is raised once the value reaches them. Zero if not set.
+type Threshold struct {
+	Metric   string
+	Warning  float64
+	Critical float64
+}
+
+// Nagios submits the stats of each container as the result of a passive service check, through NRDP or the Icinga 2
+// API, warning or critical once a metric reaches its thresholds.
+type Nagios struct {
+	api        string
+	url        string
+	token      secret.Secret // NRDP token, or Icinga 2 API password.
+	user       string
+	host       string
+	service    string // prefix of the service of each container.
+	interval   time.Duration
+	thresholds []Threshold
+	client     *http.Client
+
+	mutex     sync.Mutex
+	submitted map[string]time.Time // last submission of each container.
+}
+
+type NagiosOpts struct {
+	API        string
+	Address    string
+	Token      string
+	User       string
+	Password   string
+	Host       string
+	Service    string
+	Interval   time.Duration
+	Thresholds string
+	TLS        TLSOpts
+}
+
+// Creates a new Nagios repository.
+func NewNagios(opts *NagiosOpts) (*Nagios, error) {
+	if opts.API != NAGIOS_NRDP && opts.API != NAGIOS_ICINGA2 {
+		return nil, errors.New("Unknown API " + opts.API + ", use " + NAGIOS_NRDP + " or " + NAGIOS_ICINGA2 + ".")
+	}
+
+	if opts.Address == "" {
+		return nil, errors.New("The address of the API is needed.")
+	}
+
+	if opts.Interval < 0 {
+		return nil, errors.New("Interval cannot be negative.")
+	}
+
+	credential := opts.Token
+	if opts.API == NAGIOS_ICINGA2 {
+		credential = opts.Password
+
+		if opts.User == "" {
+			return nil, errors.New("The user of the Icinga 2 API is needed.")
+		}
+	}
+
+	if err := secret.Check(credential); err != nil {
+		return nil, err
+	}
+
+	thresholds, err := ParseThresholds(opts.Thresholds)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := opts.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	address := opts.Address
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+
+	address = strings.TrimSuffix(address, "/")
+	if opts.API == NAGIOS_ICINGA2 {
+		address += ICINGA2_PATH
+	} else {
+		// NRDP is served on its directory.
+		address += "/"
+	}
+
+	host := opts.Host
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+
+	return &Nagios{
+		api:        opts.API,
+		url:        address,
+		token:      secret.New(credential),
+		user:       opts.User,
+		host:       host,
+		service:    opts.Service,
+		interval:   opts.Interval,
+		thresholds: thresholds,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: config, Proxy: http.ProxyFromEnvironment},
+		},
+		submitted: map[string]time.Time{},
+	}, nil
+}
+
+// Parses thresholds given as metric:warning:critical, separated by comma, such as "cpu_percent:80:95". Either
+// threshold may be left empty, as in "mem_percent::95".
+func ParseThresholds(spec string) ([]Threshold, error) {
+	var thresholds []Threshold
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.Split(part, ":")
+		if len(fields) != 3 {
+			return nil, errors.New("Invalid threshold " + part + ", use metric:warning:critical as cpu_percent:80:95.")
+		}
+
+		if nagiosMetric(fields[0]) < 0 {
+			names := make([]string, len(nagiosMetrics))
+			for i, metric := range nagiosMetrics {
+				names[i] = metric.name
+			}
+			return nil, errors.New("Unknown metric " + fields[0] + ", use " + strings.Join(names, ", ") + ".")
+		}
+
+		threshold := Threshold{Metric: fields[0]}
+		for i, target := range []*float64{&threshold.Warning, &threshold.Critical} {
+			if fields[i+1] == "" {
+				continue
+			}
+
+			value, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil || value <= 0 {
+				return nil, errors.New("Invalid threshold " + part + ", thresholds must be positive numbers.")
+			}
+			*target = value
+		}
+
+		if threshold.Warning > 0 && threshold.Critical > 0 && threshold.Warning > threshold.Critical {
+			return nil, errors.New("Invalid threshold " + part + ", warning cannot be above critical.")
+		}
+
+		thresholds = append(thresholds, threshold)
+	}
+
+	return thresholds, nil
+}
+
+func (*Nagios) Create(v interface{}) (repo.Interface, error) {
+	return NewNagios(v.(*NagiosOpts))
+}
+
+// Checks that the API is reachable.
+func (*Nagios) Check(v interface{}) error {
+	opts := v.(*NagiosOpts)
+	if strings.HasPrefix(opts.Address, "http://") {
+		return checkReachable(opts.Address, "80")
+	}
+
+	if !strings.Contains(opts.Address, "://") && opts.API == NAGIOS_ICINGA2 {
+		return checkReachable(opts.Address, "5665")
+	}
+
+	return checkReachable(opts.Address, "443")
+}
+
+func CreateNagiosOpts() *NagiosOpts {
+	o := &NagiosOpts{}
+
+	flag.StringVar(&o.API,
+		"nagios.api",
+		NAGIOS_NRDP,
+		"API the check results are submitted to: nrdp or icinga2")
+
+	flag.StringVar(&o.Address,
+		"nagios.address",
+		"",
+		"URL of NRDP, or address of the Icinga 2 API")
+
+	flag.StringVar(&o.Token,
+		"nagios.token",
+		"",
+		"NRDP token, or @file to read it from a file reloaded on changes")
+
+	flag.StringVar(&o.User,
+		"nagios.user",
+		"",
+		"User of the Icinga 2 API")
+
+	flag.StringVar(&o.Password,
+		"nagios.password",
+		"",
+		"Password of the Icinga 2 API user, or @file to read it from a file reloaded on changes")
+
+	flag.StringVar(&o.Host,
+		"nagios.host",
+		"",
+		"Host of the services, the hostname if empty")
+
+	flag.StringVar(&o.Service,
+		"nagios.service",
+		"docker_",
+		"Prefix of the service of each container, followed by its name")
+
+	flag.DurationVar(&o.Interval,
+		"nagios.interval",
+		time.Minute,
+		"Minimum time between the check results of each container, every sample is submitted if 0")
+
+	flag.StringVar(&o.Thresholds,
+		"nagios.thresholds",
+		"cpu_percent:80:95,mem_percent:85:95",
+		"Thresholds of the metrics, as metric:warning:critical separated by comma")
+
+	AddTLSFlags(&o.TLS, "nagios")
+
+	return o
+}
+
+func (*Nagios) Name() string {
+	return "nagios"
+}
+
+// Submits the check result of the container, unless one was submitted within the interval.
+func (nagios *Nagios) Push(s *stats.Stats) error {
+	nagios.mutex.Lock()
+	last, ok := nagios.submitted[s.Name]
+	due := !ok || s.Timestamp.Sub(last) >= nagios.interval
+	if due {
+		nagios.submitted[s.Name] = s.Timestamp
+	}
+	nagios.mutex.Unlock()
+
+	if !due {
+		return nil
+	}
+
+	state, output, perfdata := nagios.check(s)
+
+	var err error
+	if nagios.api == NAGIOS_ICINGA2 {
+		err = nagios.processCheckResult(s.Name, state, output, perfdata)
+	} else {
+		err = nagios.submitCheck(s.Name, state, output, perfdata)
+	}
+
+	if err != nil {
+		// submitted again on the next sample.
+		nagios.mutex.Lock()
+		delete(nagios.submitted, s.Name)
+		nagios.mutex.Unlock()
+	}
+
+	return err
+}
+
+func (nagios *Nagios) Close() {
+}
+
+func (nagios *Nagios) Clear(name string) {
+	nagios.mutex.Lock()
+	defer nagios.mutex.Unlock()
+
+	delete(nagios.submitted, name)
+}
+
+// State of the sample, the worst of its metrics, with the plugin output and the performance data of every metric
+// with thresholds.
+func (nagios *Nagios) check(s *stats.Stats) (int, string, []string) {
+	state := 0
+	var problems []string
+	var perfdata []string
+
+	for _, threshold := range nagios.thresholds {
+		metric := nagiosMetrics[nagiosMetric(threshold.Metric)]
+		value := metric.value(s)
+
+		metricState := 0
+		switch {
+		case threshold.Critical > 0 && value >= threshold.Critical:
+			metricState = 2
+			problems = append(problems, fmt.Sprintf("%s %.2f >= %g", metric.name, value, threshold.Critical))
+		case threshold.Warning > 0 && value >= threshold.Warning:
+			metricState = 1
+			problems = append(problems, fmt.Sprintf("%s %.2f >= %g", metric.name, value, threshold.Warning))
+		}
+
+		if metricState > state {
+			state = metricState
+		}
+
+		perfdata = append(perfdata, fmt.Sprintf("%s=%s%s;%s;%s;0", metric.name,
+			strconv.FormatFloat(value, 'f', -1, 64), metric.unit,
+			nagiosThreshold(threshold.Warning), nagiosThreshold(threshold.Critical)))
+	}
+
+	output := NAGIOS_STATES[state] + " - " + s.Name
+	if len(problems) > 0 {
+		output += ": " + strings.Join(problems, ", ")
+	}
+
+	return state, output, perfdata
+}
+
+// Submits the check result to NRDP, as XML.
+func (nagios *Nagios) submitCheck(name string, state int, output string, perfdata []string) error {
+	type checkResult struct {
+		Type        string `xml:"type,attr"`
+		CheckType   string `xml:"checktype,attr"`
+		Hostname    string `xml:"hostname"`
+		ServiceName string `xml:"servicename"`
+		State       int    `xml:"state"`
+		Output      string `xml:"output"`
+	}
+
+	results := struct {
+		XMLName xml.Name      `xml:"checkresults"`
+		Results []checkResult `xml:"checkresult"`
+	}{
+		Results: []checkResult{{
+			Type:        "service",
+			CheckType:   "1", // passive.
+			Hostname:    nagios.host,
+			ServiceName: nagios.service + name,
+			State:       state,
+			Output:      output + "|" + strings.Join(perfdata, " "),
+		}},
+	}
+
+	data, err := xml.Marshal(results)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{
+		"token":   {nagios.token.Value()},
+		"cmd":     {"submitcheck"},
+		"XMLDATA": {xml.Header + string(data)},
+	}
+
+	res, err := nagios.client.PostForm(nagios.url, form)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("NRDP replied " + res.Status)
+	}
+
+	// errors are told by the status of the result, not the one of the response.
+	var result struct {
+		Status  int    `xml:"status"`
+		Message string `xml:"message"`
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return errors.New("Invalid NRDP response: " + err.Error())
+	}
+
+	if result.Status != 0 {
+		return errors.New("NRDP replied " + result.Message)
+	}
+
+	return nil
+}
+
+// Submits the check result to the Icinga 2 API.
+func (nagios *Nagios) processCheckResult(name string, state int, output string, perfdata []string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"type":             "Service",
+		"service":          nagios.host + "!" + nagios.service + name,
+		"exit_status":      state,
+		"plugin_output":    output,
+		"performance_data": perfdata,
+		"check_source":     "statspout",
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", nagios.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(nagios.user, nagios.token.Value())
+
+	res, err := nagios.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("Icinga 2 replied " + res.Status)
+	}
+
+	return nil
+}
+
+// Index of the metric, -1 if unknown.
+func nagiosMetric(name string) int {
+	for i, metric := range nagiosMetrics {
+		if metric.name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Threshold as performance data, empty if not set.
+func nagiosThreshold(value float64) string {
+	if value == 0 {
+		return ""
+	}
+
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -4,19 +4,111 @@ import (
 	"net/http"
 	"log"
 	"flag"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/mijara/statspout/backend"
 	"github.com/mijara/statspout/repo"
 	"github.com/mijara/statspout/stats"
+	"github.com/mijara/statspout/telemetry"
+	"github.com/mijara/statspout/version"
 )
 
+func init() {
+	repo.Register(&Prometheus{}, func() interface{} {
+		return CreatePrometheusOpts()
+	})
+}
+
+// Label set by Docker Compose on the containers of a project.
+const COMPOSE_PROJECT_LABEL = "com.docker.compose.project"
+
 type Prometheus struct {
+	server *http.Server
+
 	cpuUsagePercent    *prometheus.GaugeVec
 	memoryUsagePercent *prometheus.GaugeVec
 	txBytesTotal       *prometheus.GaugeVec
 	rxBytesTotal       *prometheus.GaugeVec
+	lastExitCode       *prometheus.GaugeVec
+	startTime          *prometheus.GaugeVec
+	finishTime         *prometheus.GaugeVec
+	memoryLimitBytes   *prometheus.GaugeVec
+	cpuLimit           *prometheus.GaugeVec
+	cpuShares          *prometheus.GaugeVec
+	swapUsageBytes     *prometheus.GaugeVec
+	blkioQueue         *prometheus.GaugeVec
+	swapLimitBytes     *prometheus.GaugeVec
+	imageCreated       *prometheus.GaugeVec
+	imageAgeDays       *prometheus.GaugeVec
+	openFds            *prometheus.GaugeVec
+	fdLimit            *prometheus.GaugeVec
+	tcpConnections     *prometheus.GaugeVec
+	counters           *containerCounters
+	interfaces         *interfaceCounters
+	containerInfo      *prometheus.GaugeVec
+
+	infoMutex sync.Mutex
+	info      map[containerKey]containerInfo // labels of the info metric of each container, to delete it.
+
+	volumeUsageBytes *prometheus.GaugeVec
+	volumesMutex     sync.Mutex
+	volumes          map[containerKey]map[string]bool // volumes of each container, to delete them.
+
+	processes    *prometheus.GaugeVec
+	threads      *prometheus.GaugeVec
+	topProcess   *prometheus.GaugeVec
+	topMutex     sync.Mutex
+	topProcesses map[containerKey][]string // names of the top processes of each container, to delete them.
+
+	hostsMutex sync.Mutex
+	hosts      map[string]map[string]bool // hosts of each container name, to clear it on every host.
+}
+
+// Container of a Docker host, its series are labeled by both since names are only unique within a host.
+type containerKey struct {
+	name string
+	host string
+}
+
+// Label values of the series of the container, followed by the given ones.
+func (key containerKey) values(values ...string) []string {
+	return append([]string{key.name, key.host}, values...)
+}
+
+// Static labels of a container, published by the info metric.
+type containerInfo struct {
+	image         string
+	project       string
+	restartPolicy string
+	privileged    string
+	networkMode   string
+}
+
+// Label values of the info metric.
+func (info containerInfo) values(key containerKey) []string {
+	return key.values(info.image, info.project, info.restartPolicy, info.privileged, info.networkMode)
+}
+
+// Totals reported by Docker of each container, published as counters: network packets, errors and dropped packets,
+// the memory failcnt, and the block I/O service time and requests.
+type containerCounters struct {
+	mutex  sync.Mutex
+	totals map[containerKey][]float64    // totals of each container, in the order of descs.
+	pushed map[containerKey]stats.Groups // groups of metrics pushed of each container.
+	descs  []*prometheus.Desc
+	groups []string // group of each desc.
+}
+
+// Network stats of each interface of each container, published as counters labeled by interface.
+type interfaceCounters struct {
+	mutex  sync.Mutex
+	latest map[containerKey]map[string]stats.Interface // interfaces of each container, as last pushed.
+	descs  []*prometheus.Desc
 }
 
 type PrometheusOpts struct {
@@ -31,23 +123,100 @@ func (*Prometheus) Create(v interface{}) (repo.Interface, error) {
 	return NewPrometheus(v.(*PrometheusOpts))
 }
 
+// Checks that the metrics address can be listened on.
+func (*Prometheus) Check(v interface{}) error {
+	return checkListen(v.(*PrometheusOpts).Address)
+}
+
+// Deletes the series of the container on every host.
 func (prom *Prometheus) Clear(name string) {
-	prom.cpuUsagePercent.DeleteLabelValues(name)
-	prom.memoryUsagePercent.DeleteLabelValues(name)
-	prom.txBytesTotal.DeleteLabelValues(name)
-	prom.rxBytesTotal.DeleteLabelValues(name)
+	prom.hostsMutex.Lock()
+	hosts := prom.hosts[name]
+	delete(prom.hosts, name)
+	prom.hostsMutex.Unlock()
+
+	for host := range hosts {
+		prom.clear(containerKey{name: name, host: host})
+	}
+}
+
+// Deletes the series of the container of the host only.
+func (prom *Prometheus) ClearHost(host string, name string) {
+	prom.hostsMutex.Lock()
+	delete(prom.hosts[name], host)
+	if len(prom.hosts[name]) == 0 {
+		delete(prom.hosts, name)
+	}
+	prom.hostsMutex.Unlock()
+
+	prom.clear(containerKey{name: name, host: host})
+}
+
+func (prom *Prometheus) clear(key containerKey) {
+	prom.cpuUsagePercent.DeleteLabelValues(key.values()...)
+	prom.memoryUsagePercent.DeleteLabelValues(key.values()...)
+	prom.txBytesTotal.DeleteLabelValues(key.values()...)
+	prom.rxBytesTotal.DeleteLabelValues(key.values()...)
+	prom.memoryLimitBytes.DeleteLabelValues(key.values("true")...)
+	prom.memoryLimitBytes.DeleteLabelValues(key.values("false")...)
+	prom.cpuLimit.DeleteLabelValues(key.values()...)
+	prom.cpuShares.DeleteLabelValues(key.values()...)
+	prom.swapUsageBytes.DeleteLabelValues(key.values()...)
+	prom.blkioQueue.DeleteLabelValues(key.values()...)
+	prom.swapLimitBytes.DeleteLabelValues(key.values()...)
+	prom.imageCreated.DeleteLabelValues(key.values()...)
+	prom.imageAgeDays.DeleteLabelValues(key.values()...)
+	prom.openFds.DeleteLabelValues(key.values()...)
+	prom.fdLimit.DeleteLabelValues(key.values()...)
+	prom.tcpConnections.DeleteLabelValues(key.values("established")...)
+	prom.tcpConnections.DeleteLabelValues(key.values("time_wait")...)
+	prom.counters.delete(key)
+	prom.interfaces.delete(key)
+
+	prom.processes.DeleteLabelValues(key.values()...)
+	prom.threads.DeleteLabelValues(key.values()...)
+	prom.setTop(key, nil)
+
+	prom.volumesMutex.Lock()
+	for volume := range prom.volumes[key] {
+		prom.volumeUsageBytes.DeleteLabelValues(key.values(volume)...)
+	}
+	delete(prom.volumes, key)
+	prom.volumesMutex.Unlock()
+
+	prom.infoMutex.Lock()
+	if info, ok := prom.info[key]; ok {
+		prom.containerInfo.DeleteLabelValues(info.values(key)...)
+		delete(prom.info, key)
+	}
+	prom.infoMutex.Unlock()
+}
+
+// Key of the named container of the host given by the labels, see stats.LABEL_HOST, recording the host.
+func (prom *Prometheus) key(name string, labels map[string]string) containerKey {
+	key := containerKey{name: name, host: labels[stats.LABEL_HOST]}
+
+	prom.hostsMutex.Lock()
+	defer prom.hostsMutex.Unlock()
+
+	if prom.hosts[name] == nil {
+		prom.hosts[name] = make(map[string]bool)
+	}
+	prom.hosts[name][key.host] = true
+
+	return key
 }
 
 func NewPrometheus(opts *PrometheusOpts) (*Prometheus, error) {
-	// hacky way of removing the default Go Collector.
-	prometheus.Unregister(prometheus.NewGoCollector())
+	// use a dedicated registry, so the default one is left untouched.
+	registry := prometheus.NewRegistry()
 
 	cpuUsagePercent := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "cpu_usage_percent",
 			Help: "Current CPU usage percent.",
 		},
-		[]string{"container"},
+		[]string{"container", "host"},
 	)
 
 	memoryUsagePercent := prometheus.NewGaugeVec(
@@ -55,7 +224,7 @@ func NewPrometheus(opts *PrometheusOpts) (*Prometheus, error) {
 			Name: "memory_usage_percent",
 			Help: "Current memory usage percent.",
 		},
-		[]string{"container"},
+		[]string{"container", "host"},
 	)
 
 	txBytesTotal := prometheus.NewGaugeVec(
@@ -63,7 +232,7 @@ func NewPrometheus(opts *PrometheusOpts) (*Prometheus, error) {
 			Name: "tx_bytes",
 			Help: "TX Bytes Total.",
 		},
-		[]string{"container"},
+		[]string{"container", "host"},
 	)
 
 	rxBytesTotal := prometheus.NewGaugeVec(
@@ -71,43 +240,630 @@ func NewPrometheus(opts *PrometheusOpts) (*Prometheus, error) {
 			Name: "rx_bytes",
 			Help: "RX Bytes Total.",
 		},
-		[]string{"container"},
+		[]string{"container", "host"},
+	)
+
+	// labeled by whether the container has a limit, otherwise it is the memory of the host.
+	memoryLimitBytes := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "memory_limit_bytes",
+			Help: "Memory limit in bytes, the memory of the host if not limited.",
+		},
+		[]string{"container", "host", "limited"},
+	)
+
+	cpuLimit := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cpu_limit",
+			Help: "CPUs the container may use, 0 if unlimited.",
+		},
+		[]string{"container", "host"},
+	)
+
+	cpuShares := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cpu_shares",
+			Help: "Relative CPU weight of the container, 0 if the default.",
+		},
+		[]string{"container", "host"},
+	)
+
+	blkioQueue := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "blkio_queue",
+			Help: "Block I/O requests of the container waiting to be served.",
+		},
+		[]string{"container", "host"},
 	)
 
-	prometheus.MustRegister(cpuUsagePercent)
-	prometheus.MustRegister(memoryUsagePercent)
-	prometheus.MustRegister(txBytesTotal)
-	prometheus.MustRegister(rxBytesTotal)
+	swapUsageBytes := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swap_usage_bytes",
+			Help: "Current swap usage in bytes.",
+		},
+		[]string{"container", "host"},
+	)
+
+	swapLimitBytes := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swap_limit_bytes",
+			Help: "Swap the container may use on top of its memory limit, 0 if unlimited.",
+		},
+		[]string{"container", "host"},
+	)
+
+	// kept when the container is cleared, like the exit code.
+	startTime := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_start_time_seconds",
+			Help: "Time the container last started, in seconds since the epoch.",
+		},
+		[]string{"container", "host"},
+	)
+
+	finishTime := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_finish_time_seconds",
+			Help: "Time the container last finished, in seconds since the epoch.",
+		},
+		[]string{"container", "host"},
+	)
+
+	imageCreated := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "image_created_seconds",
+			Help: "Creation time of the image of the container, in seconds since the epoch.",
+		},
+		[]string{"container", "host"},
+	)
+
+	imageAgeDays := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "image_age_days",
+			Help: "Age of the image of the container, in days.",
+		},
+		[]string{"container", "host"},
+	)
+
+	openFds := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "open_fds",
+			Help: "File descriptors open by the init process of the container.",
+		},
+		[]string{"container", "host"},
+	)
+
+	fdLimit := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fd_limit",
+			Help: "Soft limit of file descriptors of the init process of the container, 0 if unlimited.",
+		},
+		[]string{"container", "host"},
+	)
+
+	tcpConnections := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tcp_connections",
+			Help: "TCP connections of the network namespace of the container, by state.",
+		},
+		[]string{"container", "host", "state"},
+	)
+
+	processes := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_processes",
+			Help: "Processes running in the container.",
+		},
+		[]string{"container", "host"},
+	)
+
+	threads := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_threads",
+			Help: "Threads of the processes running in the container.",
+		},
+		[]string{"container", "host"},
+	)
+
+	// always 1, the rank is 1 for the process using the most CPU.
+	topProcess := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "container_top_process_info",
+			Help: "Names of the processes using the most CPU in the container, by rank, always 1.",
+		},
+		[]string{"container", "host", "rank", "command"},
+	)
+
+	volumeUsageBytes := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "volume_usage_bytes",
+			Help: "Disk usage of the named volumes mounted by the container.",
+		},
+		[]string{"container", "host", "volume"},
+	)
+
+	// kept when the container is cleared, since it stops right after dying.
+	lastExitCode := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "last_exit_code",
+			Help: "Exit code of the last time the container died.",
+		},
+		[]string{"container", "host"},
+	)
+
+	// always 1, carries the static labels of the containers to join them with the other metrics.
+	containerInfoVec := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "statspout_container_info",
+			Help: "Static labels of each monitored container, always 1.",
+		},
+		[]string{"container", "host", "image", "project", "restart_policy", "privileged", "network_mode"},
+	)
+
+	// containers of every collector, see the telemetry.
+	containers := func(name string, help string) prometheus.GaugeFunc {
+		return prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name: "statspout_" + name,
+				Help: help,
+			},
+			func() float64 {
+				return telemetry.Default.Snapshot().Gauges[name]
+			},
+		)
+	}
+
+	buildInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "statspout_build_info",
+			Help: "Build information of statspout, always 1.",
+		},
+		[]string{"version", "commit", "build_date", "go_version"},
+	)
+	buildInfo.WithLabelValues(version.Version, version.Commit, version.BuildDate, version.GoVersion()).Set(1)
+
+	registry.MustRegister(buildInfo)
+	registry.MustRegister(containerInfoVec)
+	registry.MustRegister(containers("containers_discovered", "Containers running on the Docker hosts."))
+	registry.MustRegister(containers("containers_monitored", "Containers collected, once filtered."))
+	registry.MustRegister(containers("containers_excluded", "Containers excluded by the filters or paused."))
+	registry.MustRegister(cpuUsagePercent)
+	registry.MustRegister(memoryUsagePercent)
+	registry.MustRegister(txBytesTotal)
+	registry.MustRegister(rxBytesTotal)
+	registry.MustRegister(lastExitCode)
+	registry.MustRegister(startTime)
+	registry.MustRegister(finishTime)
+	registry.MustRegister(memoryLimitBytes)
+	registry.MustRegister(cpuLimit)
+	registry.MustRegister(cpuShares)
+	registry.MustRegister(swapUsageBytes)
+	registry.MustRegister(blkioQueue)
+	registry.MustRegister(swapLimitBytes)
+	registry.MustRegister(imageCreated)
+	registry.MustRegister(imageAgeDays)
+	registry.MustRegister(volumeUsageBytes)
+	registry.MustRegister(processes)
+	registry.MustRegister(threads)
+	registry.MustRegister(topProcess)
+	registry.MustRegister(openFds)
+	registry.MustRegister(fdLimit)
+	registry.MustRegister(tcpConnections)
+
+	counters := newContainerCounters()
+	registry.MustRegister(counters)
+	interfaces := newInterfaceCounters()
+	registry.MustRegister(interfaces)
+	registry.MustRegister(newRepositoryStatuses())
+	registry.MustRegister(newParseErrors())
 
 	// set handler for default Prometheus collection path.
-	http.Handle("/metrics", promhttp.Handler())
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{
+		Addr:    opts.Address,
+		Handler: mux,
+	}
 
 	// start HTTP Server.
-	go serve(opts.Address)
+	go serve(server)
 
 	return &Prometheus{
+		server:             server,
 		cpuUsagePercent:    cpuUsagePercent,
 		memoryUsagePercent: memoryUsagePercent,
 		txBytesTotal:       txBytesTotal,
 		rxBytesTotal:       rxBytesTotal,
+		lastExitCode:       lastExitCode,
+		startTime:          startTime,
+		finishTime:         finishTime,
+		memoryLimitBytes:   memoryLimitBytes,
+		cpuLimit:           cpuLimit,
+		cpuShares:          cpuShares,
+		swapUsageBytes:     swapUsageBytes,
+		blkioQueue:         blkioQueue,
+		swapLimitBytes:     swapLimitBytes,
+		imageCreated:       imageCreated,
+		imageAgeDays:       imageAgeDays,
+		openFds:            openFds,
+		fdLimit:            fdLimit,
+		tcpConnections:     tcpConnections,
+		counters:           counters,
+		interfaces:         interfaces,
+		containerInfo:      containerInfoVec,
+		info:               make(map[containerKey]containerInfo),
+		volumeUsageBytes:   volumeUsageBytes,
+		volumes:            make(map[containerKey]map[string]bool),
+		processes:          processes,
+		threads:            threads,
+		topProcess:         topProcess,
+		topProcesses:       make(map[containerKey][]string),
+		hosts:              make(map[string]map[string]bool),
 	}, nil
 }
 
+// Sets the metrics of the groups pushed, see stats.Stats.Select.
 func (prom *Prometheus) Push(s *stats.Stats) error {
-	prom.cpuUsagePercent.WithLabelValues(s.Name).Set(s.CpuPercent)
-	prom.memoryUsagePercent.WithLabelValues(s.Name).Set(s.MemoryPercent)
-	prom.txBytesTotal.WithLabelValues(s.Name).Set(s.MemoryPercent)
-	prom.rxBytesTotal.WithLabelValues(s.Name).Set(s.MemoryPercent)
+	key := prom.key(s.Name, s.Labels)
+
+	if s.Groups.Has(stats.GROUP_CPU) {
+		prom.cpuUsagePercent.WithLabelValues(key.values()...).Set(s.CpuPercent)
+		prom.cpuLimit.WithLabelValues(key.values()...).Set(s.CpuLimit)
+		prom.cpuShares.WithLabelValues(key.values()...).Set(float64(s.CpuShares))
+	}
+
+	if s.Groups.Has(stats.GROUP_MEMORY) {
+		prom.memoryUsagePercent.WithLabelValues(key.values()...).Set(s.MemoryPercent)
+		prom.memoryLimitBytes.WithLabelValues(key.values(strconv.FormatBool(s.MemoryLimited))...).Set(float64(s.MemoryLimit))
+		prom.memoryLimitBytes.DeleteLabelValues(key.values(strconv.FormatBool(!s.MemoryLimited))...)
+		prom.swapUsageBytes.WithLabelValues(key.values()...).Set(float64(s.SwapUsage))
+		prom.swapLimitBytes.WithLabelValues(key.values()...).Set(float64(s.SwapLimit))
+	}
+
+	if s.Groups.Has(stats.GROUP_NETWORK) {
+		prom.txBytesTotal.WithLabelValues(key.values()...).Set(float64(s.TxBytesTotal))
+		prom.rxBytesTotal.WithLabelValues(key.values()...).Set(float64(s.RxBytesTotal))
+	}
+
+	if s.Groups.Has(stats.GROUP_BLKIO) {
+		prom.blkioQueue.WithLabelValues(key.values()...).Set(float64(s.BlkioQueue))
+	}
+
+	// only known once the image was inspected.
+	if !s.ImageCreated.IsZero() {
+		prom.imageCreated.WithLabelValues(key.values()...).Set(float64(s.ImageCreated.Unix()))
+		prom.imageAgeDays.WithLabelValues(key.values()...).Set(s.ImageAgeDays)
+	}
+
+	// only known if /proc is read, a process has at least its standard streams open.
+	if s.OpenFds > 0 {
+		prom.openFds.WithLabelValues(key.values()...).Set(float64(s.OpenFds))
+		prom.fdLimit.WithLabelValues(key.values()...).Set(float64(s.FdLimit))
+	}
+
+	// left out along the network, if not selected.
+	if s.Tcp != nil {
+		prom.tcpConnections.WithLabelValues(key.values("established")...).Set(float64(s.Tcp.Established))
+		prom.tcpConnections.WithLabelValues(key.values("time_wait")...).Set(float64(s.Tcp.TimeWait))
+	}
+	prom.counters.set(key, s)
+	prom.interfaces.set(key, s)
+	prom.setInfo(key, s)
+
+	return nil
+}
+
+// Sets the last exit code of the container on die events, and its start and finish times on state transitions,
+// other events are ignored.
+func (prom *Prometheus) PushEvent(event *stats.Event) error {
+	key := prom.key(event.Name, event.Attributes)
+
+	if event.Action == "die" && event.ExitCode != nil {
+		prom.lastExitCode.WithLabelValues(key.values()...).Set(float64(*event.ExitCode))
+	}
+
+	if event.Action == "state" {
+		if event.StartedAt != nil {
+			prom.startTime.WithLabelValues(key.values()...).Set(float64(event.StartedAt.UnixNano()) / 1e9)
+		}
+
+		if event.FinishedAt != nil {
+			prom.finishTime.WithLabelValues(key.values()...).Set(float64(event.FinishedAt.UnixNano()) / 1e9)
+		}
+	}
+
+	return nil
+}
+
+// Sets the disk usage of the volume of the container.
+func (prom *Prometheus) PushVolume(volume *stats.Volume) error {
+	key := prom.key(volume.Name, volume.Labels)
+
+	prom.volumesMutex.Lock()
+	defer prom.volumesMutex.Unlock()
+
+	if prom.volumes[key] == nil {
+		prom.volumes[key] = make(map[string]bool)
+	}
+	prom.volumes[key][volume.Volume] = true
+
+	prom.volumeUsageBytes.WithLabelValues(key.values(volume.Volume)...).Set(float64(volume.Size))
+
+	return nil
+}
+
+// Sets the process and thread counts of the container, replacing its top processes.
+func (prom *Prometheus) PushProcesses(processes *stats.Processes) error {
+	key := prom.key(processes.Name, processes.Labels)
+
+	prom.processes.WithLabelValues(key.values()...).Set(float64(processes.Processes))
+	prom.threads.WithLabelValues(key.values()...).Set(float64(processes.Threads))
+	prom.setTop(key, processes.Top)
 
 	return nil
 }
 
+// Replaces the top processes of the container, deleting the previous ones.
+func (prom *Prometheus) setTop(key containerKey, top []string) {
+	prom.topMutex.Lock()
+	defer prom.topMutex.Unlock()
+
+	for i, command := range prom.topProcesses[key] {
+		prom.topProcess.DeleteLabelValues(key.values(strconv.Itoa(i+1), command)...)
+	}
+
+	for i, command := range top {
+		prom.topProcess.WithLabelValues(key.values(strconv.Itoa(i+1), command)...).Set(1)
+	}
+
+	if len(top) > 0 {
+		prom.topProcesses[key] = append([]string(nil), top...)
+	} else {
+		delete(prom.topProcesses, key)
+	}
+}
+
+// Publishes the info metric of the container, replacing the previous one if its labels changed.
+func (prom *Prometheus) setInfo(key containerKey, s *stats.Stats) {
+	info := containerInfo{
+		image:         s.Image,
+		project:       s.Labels[COMPOSE_PROJECT_LABEL],
+		restartPolicy: s.RestartPolicy,
+		privileged:    strconv.FormatBool(s.Privileged),
+		networkMode:   s.NetworkMode,
+	}
+
+	prom.infoMutex.Lock()
+	defer prom.infoMutex.Unlock()
+
+	previous, ok := prom.info[key]
+	if ok && previous == info {
+		return
+	}
+
+	if ok {
+		prom.containerInfo.DeleteLabelValues(previous.values(key)...)
+	}
+
+	prom.containerInfo.WithLabelValues(info.values(key)...).Set(1)
+	prom.info[key] = info
+}
+
+func newContainerCounters() *containerCounters {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(name, help, []string{"container", "host"}, nil)
+	}
+
+	return &containerCounters{
+		totals: make(map[containerKey][]float64),
+		pushed: make(map[containerKey]stats.Groups),
+		descs: []*prometheus.Desc{
+			desc("tx_packets_total", "TX Packets Total."),
+			desc("rx_packets_total", "RX Packets Total."),
+			desc("tx_errors_total", "TX Errors Total."),
+			desc("rx_errors_total", "RX Errors Total."),
+			desc("tx_dropped_total", "TX Dropped Packets Total."),
+			desc("rx_dropped_total", "RX Dropped Packets Total."),
+			desc("memory_failcnt_total", "Times the memory usage hit the limit."),
+			desc("blkio_service_seconds_total", "Time spent serving block I/O requests."),
+			desc("blkio_serviced_total", "Block I/O requests served."),
+			desc("blkio_read_bytes_total", "Bytes read from block devices."),
+			desc("blkio_write_bytes_total", "Bytes written to block devices."),
+			desc("blkio_reads_total", "Block I/O read requests served."),
+			desc("blkio_writes_total", "Block I/O write requests served."),
+		},
+		groups: []string{
+			stats.GROUP_NETWORK, stats.GROUP_NETWORK, stats.GROUP_NETWORK, stats.GROUP_NETWORK, stats.GROUP_NETWORK,
+			stats.GROUP_NETWORK, stats.GROUP_MEMORY, stats.GROUP_BLKIO, stats.GROUP_BLKIO, stats.GROUP_BLKIO,
+			stats.GROUP_BLKIO, stats.GROUP_BLKIO, stats.GROUP_BLKIO,
+		},
+	}
+}
+
+func (n *containerCounters) set(key containerKey, s *stats.Stats) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.totals[key] = []float64{
+		float64(s.TxPacketsTotal), float64(s.RxPacketsTotal), float64(s.TxErrorsTotal), float64(s.RxErrorsTotal),
+		float64(s.TxDroppedTotal), float64(s.RxDroppedTotal), float64(s.MemoryFailcnt),
+		float64(s.BlkioServiceTime) / 1e9, float64(s.BlkioServiced), float64(s.BlkioReadBytes),
+		float64(s.BlkioWriteBytes), float64(s.BlkioReads), float64(s.BlkioWrites),
+	}
+	n.pushed[key] = s.Groups
+}
+
+func (n *containerCounters) delete(key containerKey) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	delete(n.totals, key)
+	delete(n.pushed, key)
+}
+
+func (n *containerCounters) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range n.descs {
+		ch <- desc
+	}
+}
+
+func (n *containerCounters) Collect(ch chan<- prometheus.Metric) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for key, totals := range n.totals {
+		for i, desc := range n.descs {
+			if n.pushed[key].Has(n.groups[i]) {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, totals[i], key.values()...)
+			}
+		}
+	}
+}
+
+func newInterfaceCounters() *interfaceCounters {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc("interface_"+name, help, []string{"container", "host", "interface"}, nil)
+	}
+
+	return &interfaceCounters{
+		latest: make(map[containerKey]map[string]stats.Interface),
+		descs: []*prometheus.Desc{
+			desc("tx_bytes_total", "TX Bytes of the interface."),
+			desc("rx_bytes_total", "RX Bytes of the interface."),
+			desc("tx_packets_total", "TX Packets of the interface."),
+			desc("rx_packets_total", "RX Packets of the interface."),
+			desc("tx_errors_total", "TX Errors of the interface."),
+			desc("rx_errors_total", "RX Errors of the interface."),
+			desc("tx_dropped_total", "TX Dropped Packets of the interface."),
+			desc("rx_dropped_total", "RX Dropped Packets of the interface."),
+		},
+	}
+}
+
+// Replaces the interfaces of the container, so the ones gone are no longer published. Left untouched if the
+// network is not pushed.
+func (n *interfaceCounters) set(key containerKey, s *stats.Stats) {
+	if !s.Groups.Has(stats.GROUP_NETWORK) {
+		return
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if len(s.Interfaces) == 0 {
+		delete(n.latest, key)
+		return
+	}
+
+	n.latest[key] = s.Interfaces
+}
+
+func (n *interfaceCounters) delete(key containerKey) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	delete(n.latest, key)
+}
+
+func (n *interfaceCounters) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range n.descs {
+		ch <- desc
+	}
+}
+
+func (n *interfaceCounters) Collect(ch chan<- prometheus.Metric) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for key, interfaces := range n.latest {
+		for iface, i := range interfaces {
+			values := []uint64{i.TxBytes, i.RxBytes, i.TxPackets, i.RxPackets, i.TxErrors, i.RxErrors, i.TxDropped,
+				i.RxDropped}
+			for j, desc := range n.descs {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(values[j]),
+					key.values(iface)...)
+			}
+		}
+	}
+}
+
+// State of the repositories pushed to, see repo.Statuses.
+type repositoryStatuses struct {
+	lastSuccess         *prometheus.Desc
+	consecutiveFailures *prometheus.Desc
+	failures            *prometheus.Desc
+	queued              *prometheus.Desc
+}
+
+func newRepositoryStatuses() *repositoryStatuses {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc("statspout_repository_"+name, help, []string{"repository"}, nil)
+	}
+
+	return &repositoryStatuses{
+		lastSuccess:         desc("last_success_timestamp_seconds", "Last push that succeeded, since the epoch."),
+		consecutiveFailures: desc("consecutive_failures", "Pushes failed since the last success."),
+		failures:            desc("failures_total", "Pushes failed."),
+		queued:              desc("queued", "Samples waiting to be sent."),
+	}
+}
+
+func (r *repositoryStatuses) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.lastSuccess
+	ch <- r.consecutiveFailures
+	ch <- r.failures
+	ch <- r.queued
+}
+
+func (r *repositoryStatuses) Collect(ch chan<- prometheus.Metric) {
+	for _, status := range repo.Statuses() {
+		// left out until a push succeeds.
+		if status.LastSuccess != nil {
+			seconds := float64(status.LastSuccess.UnixNano()) / 1e9
+			ch <- prometheus.MustNewConstMetric(r.lastSuccess, prometheus.GaugeValue, seconds, status.Name)
+		}
+
+		ch <- prometheus.MustNewConstMetric(r.consecutiveFailures, prometheus.GaugeValue,
+			float64(status.ConsecutiveFailures), status.Name)
+		ch <- prometheus.MustNewConstMetric(r.failures, prometheus.CounterValue, float64(status.Failures), status.Name)
+		ch <- prometheus.MustNewConstMetric(r.queued, prometheus.GaugeValue, float64(status.Queued), status.Name)
+	}
+}
+
+// Responses of each Docker endpoint that could not be parsed, see the telemetry.
+type parseErrors struct {
+	desc *prometheus.Desc
+}
+
+func newParseErrors() *parseErrors {
+	return &parseErrors{
+		desc: prometheus.NewDesc("statspout_parse_errors_total", "Docker API responses that could not be parsed.",
+			[]string{"endpoint"}, nil),
+	}
+}
+
+func (p *parseErrors) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.desc
+}
+
+func (p *parseErrors) Collect(ch chan<- prometheus.Metric) {
+	for name, value := range telemetry.Default.Snapshot().Gauges {
+		if strings.HasPrefix(name, backend.PARSE_ERRORS_PREFIX) {
+			endpoint := strings.TrimPrefix(name, backend.PARSE_ERRORS_PREFIX)
+			ch <- prometheus.MustNewConstMetric(p.desc, prometheus.CounterValue, value, endpoint)
+		}
+	}
+}
+
 func (prom *Prometheus) Close() {
-	// TODO
+	prom.server.Close()
 }
 
-func serve(address string) {
-	log.Fatal(http.ListenAndServe(address, nil))
+func serve(server *http.Server) {
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
 
 func CreatePrometheusOpts() *PrometheusOpts {
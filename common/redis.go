@@ -0,0 +1,229 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/secret"
+	"github.com/mijara/statspout/stats"
+)
+
+func init() {
+	repo.Register(&Redis{}, func() interface{} {
+		return CreateRedisOpts()
+	})
+}
+
+// Timeout of dialing, reading from and writing to Redis.
+const REDIS_TIMEOUT = 10 * time.Second
+
+// Redis appends each sample to a stream per container, trimmed to a maximum length, and keeps the latest sample of
+// each container in a hash, so dashboards can read the current values or a recent history without Prometheus.
+type Redis struct {
+	pool   *redis.Pool
+	prefix string
+	maxLen int
+}
+
+type RedisOpts struct {
+	Address  string
+	Password string
+	DB       int
+	MaxLen   int
+	Prefix   string
+	TLS      TLSOpts
+}
+
+// Creates a new Redis repository, connecting on the first push.
+func NewRedis(opts *RedisOpts) (*Redis, error) {
+	if opts.Address == "" {
+		return nil, errors.New("The address of Redis is needed.")
+	}
+
+	if opts.DB < 0 {
+		return nil, errors.New("DB must not be negative.")
+	}
+
+	if opts.MaxLen < 1 {
+		return nil, errors.New("Stream max length must be positive.")
+	}
+
+	if err := secret.Check(opts.Password); err != nil {
+		return nil, err
+	}
+
+	config, err := opts.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	password := secret.New(opts.Password)
+
+	dial := func() (redis.Conn, error) {
+		options := []redis.DialOption{
+			redis.DialDatabase(opts.DB),
+			redis.DialConnectTimeout(REDIS_TIMEOUT),
+			redis.DialReadTimeout(REDIS_TIMEOUT),
+			redis.DialWriteTimeout(REDIS_TIMEOUT),
+		}
+
+		// read on every connection, so a rotated password is used once the previous connection fails.
+		if value := password.Value(); value != "" {
+			options = append(options, redis.DialPassword(value))
+		}
+
+		if config != nil {
+			options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(config))
+		}
+
+		return redis.Dial("tcp", opts.Address, options...)
+	}
+
+	return &Redis{
+		pool: &redis.Pool{
+			Dial:    dial,
+			MaxIdle: 1,
+		},
+		prefix: strings.TrimSuffix(opts.Prefix, ":"),
+		maxLen: opts.MaxLen,
+	}, nil
+}
+
+func (*Redis) Name() string {
+	return "redis"
+}
+
+func (*Redis) Create(v interface{}) (repo.Interface, error) {
+	return NewRedis(v.(*RedisOpts))
+}
+
+// Checks that Redis is reachable.
+func (*Redis) Check(v interface{}) error {
+	return checkReachable(v.(*RedisOpts).Address, "6379")
+}
+
+// Appends the sample to the stream of its container and replaces its latest values, in a single round trip.
+func (r *Redis) Push(s *stats.Stats) error {
+	fields, err := redisFields(s)
+	if err != nil {
+		return err
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("XADD", redis.Args{r.key("stream", s.Name), "MAXLEN", "~", r.maxLen, "*"}.Add(fields...)...)
+	conn.Send("DEL", r.key("latest", s.Name))
+	conn.Send("HSET", redis.Args{r.key("latest", s.Name)}.Add(fields...)...)
+	conn.Send("SADD", r.key("containers"), s.Name)
+
+	// commands failing inside the transaction are only told in its reply.
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Redis) Close() {
+	r.pool.Close()
+}
+
+// Removes the latest values of the container, its stream is kept as history.
+func (r *Redis) Clear(name string) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("DEL", r.key("latest", name))
+	conn.Send("SREM", r.key("containers"), name)
+	conn.Do("EXEC")
+}
+
+// Key of the prefix, followed by the parts separated by colons.
+func (r *Redis) key(parts ...string) string {
+	if r.prefix == "" {
+		return strings.Join(parts, ":")
+	}
+
+	return r.prefix + ":" + strings.Join(parts, ":")
+}
+
+// Fields of the sample as they are in its JSON, as name and value pairs sorted by name. Strings are written as they
+// are, objects such as the labels as JSON.
+func redisFields(s *stats.Stats) ([]interface{}, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []interface{}
+	for _, name := range names {
+		var value string
+		if err := json.Unmarshal(values[name], &value); err != nil {
+			value = string(values[name])
+		}
+
+		fields = append(fields, name, value)
+	}
+
+	return fields, nil
+}
+
+func CreateRedisOpts() *RedisOpts {
+	o := &RedisOpts{}
+
+	flag.StringVar(&o.Address,
+		"redis.address",
+		"localhost:6379",
+		"Address of Redis, as host:port")
+
+	flag.StringVar(&o.Password,
+		"redis.password",
+		"",
+		"Password of Redis, or @file to read it from a file reloaded on changes, none if empty")
+
+	flag.IntVar(&o.DB,
+		"redis.db",
+		0,
+		"Number of the database")
+
+	flag.IntVar(&o.MaxLen,
+		"redis.maxlen",
+		10000,
+		"Approximate maximum number of samples kept in the stream of each container")
+
+	flag.StringVar(&o.Prefix,
+		"redis.prefix",
+		"statspout",
+		"Prefix of the keys, followed by a colon, none if empty")
+
+	AddTLSFlags(&o.TLS, "redis")
+
+	return o
+}
@@ -4,14 +4,23 @@ import (
 	"net/http"
 	"encoding/json"
 	"flag"
+	"sync"
 
 	"github.com/prometheus/common/log"
 	"github.com/mijara/statspout/repo"
 	"github.com/mijara/statspout/stats"
 )
 
+func init() {
+	repo.Register(&Rest{}, func() interface{} {
+		return CreateRestOpts()
+	})
+}
+
 type Rest struct {
-	registry map[string]stats.Stats
+	registry map[string]stats.Stats // latest sample of each container, by qualified name.
+	mutex    sync.RWMutex
+	server   *http.Server
 }
 
 type RestOpts struct {
@@ -19,9 +28,6 @@ type RestOpts struct {
 	Path    string
 }
 
-// instance of this repository, due to the handler callback limitations.
-var rest Rest
-
 func (*Rest) Name() string {
 	return "rest"
 }
@@ -30,17 +36,31 @@ func (*Rest) Create(v interface{}) (repo.Interface, error) {
 	return NewRest(v.(*RestOpts))
 }
 
+// Checks that the address can be listened on.
+func (*Rest) Check(v interface{}) error {
+	return checkListen(v.(*RestOpts).Address)
+}
+
 func NewRest(opts *RestOpts) (*Rest, error) {
-	http.HandleFunc(checkAndFixPrefixSlash(opts.Path), handler)
+	rest := &Rest{
+		registry: map[string]stats.Stats{},
+	}
 
-	rest.registry = map[string]stats.Stats{}
+	// use a dedicated mux, so the default one is left untouched.
+	mux := http.NewServeMux()
+	mux.HandleFunc(checkAndFixPrefixSlash(opts.Path), rest.handler)
+
+	rest.server = &http.Server{
+		Addr:    opts.Address,
+		Handler: mux,
+	}
 
-	go serveRest(opts.Address)
+	go serveRest(rest.server)
 
-	return &rest, nil
+	return rest, nil
 }
 
-func handler(w http.ResponseWriter, r *http.Request) {
+func (rest *Rest) handler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 
@@ -48,6 +68,9 @@ func handler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (rest *Rest) asListOfValues() []stats.Stats {
+	rest.mutex.RLock()
+	defer rest.mutex.RUnlock()
+
 	var list []stats.Stats
 
 	for _, value := range rest.registry {
@@ -58,15 +81,34 @@ func (rest *Rest) asListOfValues() []stats.Stats {
 }
 
 func (rest *Rest) Push(s *stats.Stats) error {
-	rest.registry[s.Name] = *s
+	rest.mutex.Lock()
+	defer rest.mutex.Unlock()
+
+	rest.registry[s.QualifiedName()] = *s
 	return nil
 }
 
 func (rest *Rest) Close() {
+	rest.server.Close()
 }
 
+// Clears the container on every host.
 func (rest *Rest) Clear(name string) {
-	delete(rest.registry, name)
+	rest.mutex.Lock()
+	defer rest.mutex.Unlock()
+
+	for qualified, s := range rest.registry {
+		if s.Name == name {
+			delete(rest.registry, qualified)
+		}
+	}
+}
+
+func (rest *Rest) ClearHost(host string, name string) {
+	rest.mutex.Lock()
+	defer rest.mutex.Unlock()
+
+	delete(rest.registry, stats.QualifiedName(host, name))
 }
 
 func CreateRestOpts() *RestOpts {
@@ -85,8 +127,10 @@ func CreateRestOpts() *RestOpts {
 	return o
 }
 
-func serveRest(address string) {
-	log.Fatal(http.ListenAndServe(address, nil))
+func serveRest(server *http.Server) {
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
 
 func checkAndFixPrefixSlash(path string) string {
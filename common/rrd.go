@@ -0,0 +1,412 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+func init() {
+	repo.Register(&RRD{}, func() interface{} {
+		return CreateRRDOpts()
+	})
+}
+
+// Consolidation functions of the archives.
+var RRD_FUNCTIONS = []string{"AVERAGE", "MIN", "MAX", "LAST"}
+
+// Fraction of the primary points of a consolidated one that may be unknown, see rrdcreate.
+const RRD_XFF = "0.5"
+
+// Data source of the files, with the group of metrics it belongs to.
+type rrdSource struct {
+	name  string
+	kind  string // GAUGE, or DERIVE for totals, whose resets are left unknown.
+	group string
+	value func(s *stats.Stats) float64
+}
+
+// Data sources of the files, in the order they are created and updated. Names are up to 19 characters.
+var rrdSources = []rrdSource{
+	{"cpu_percent", "GAUGE", stats.GROUP_CPU, func(s *stats.Stats) float64 { return s.CpuPercent }},
+	{"cpu_limit", "GAUGE", stats.GROUP_CPU, func(s *stats.Stats) float64 { return s.CpuLimit }},
+	{"mem_usage", "GAUGE", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.MemoryUsage) }},
+	{"mem_percent", "GAUGE", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return s.MemoryPercent }},
+	{"mem_limit", "GAUGE", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.MemoryLimit) }},
+	{"swap_usage", "GAUGE", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.SwapUsage) }},
+	{"tx_bytes", "DERIVE", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxBytesTotal) }},
+	{"rx_bytes", "DERIVE", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxBytesTotal) }},
+	{"tx_packets", "DERIVE", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxPacketsTotal) }},
+	{"rx_packets", "DERIVE", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxPacketsTotal) }},
+	{"tx_errors", "DERIVE", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxErrorsTotal) }},
+	{"rx_errors", "DERIVE", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxErrorsTotal) }},
+	{"tx_dropped", "DERIVE", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxDroppedTotal) }},
+	{"rx_dropped", "DERIVE", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxDroppedTotal) }},
+	{"blkio_service_time", "DERIVE", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioServiceTime) }},
+	{"blkio_serviced", "DERIVE", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioServiced) }},
+	{"blkio_queue", "GAUGE", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioQueue) }},
+	{"blkio_read_bytes", "DERIVE", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioReadBytes) }},
+	{"blkio_write_bytes", "DERIVE", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioWriteBytes) }},
+	{"blkio_reads", "DERIVE", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioReads) }},
+	{"blkio_writes", "DERIVE", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioWrites) }},
+}
+
+// RRD writes the stats of each container into its own round robin database, updated through rrdtool, for tools
+// graphing RRD files such as Cacti or Smokeping, without running any other service.
+type RRD struct {
+	dir       string
+	step      time.Duration
+	heartbeat time.Duration
+	archives  []string // RRA definitions.
+
+	binary string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+
+	last  map[string]int64 // last update of each file, in seconds.
+	mutex sync.Mutex
+}
+
+type RRDOpts struct {
+	Dir       string
+	Step      time.Duration
+	Heartbeat time.Duration
+	Archives  string
+	Binary    string
+}
+
+// Creates a new RRD repository, starting rrdtool.
+func NewRRD(opts *RRDOpts) (*RRD, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("The directory of the files is needed.")
+	}
+
+	if opts.Step < time.Second {
+		return nil, errors.New("Step must be at least a second.")
+	}
+
+	heartbeat := opts.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = 2 * opts.Step
+	}
+
+	if heartbeat < opts.Step {
+		return nil, errors.New("Heartbeat cannot be shorter than the step.")
+	}
+
+	archives, err := ParseArchives(opts.Archives)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	rrd := &RRD{
+		dir:       opts.Dir,
+		step:      opts.Step,
+		heartbeat: heartbeat,
+		archives:  archives,
+		binary:    opts.Binary,
+		last:      map[string]int64{},
+	}
+
+	if err := rrd.start(); err != nil {
+		return nil, err
+	}
+
+	return rrd, nil
+}
+
+// Parses archives given as consolidation function, steps per row and rows, separated by comma, such as
+// "AVERAGE:1:17280,MAX:60:2016", into RRA definitions.
+func ParseArchives(spec string) ([]string, error) {
+	var archives []string
+
+	for _, archive := range strings.Split(spec, ",") {
+		archive = strings.TrimSpace(archive)
+		if archive == "" {
+			continue
+		}
+
+		parts := strings.Split(archive, ":")
+		if len(parts) != 3 {
+			return nil, errors.New("Invalid archive " + archive + ", use function:steps:rows as AVERAGE:1:17280.")
+		}
+
+		function := strings.ToUpper(parts[0])
+		known := false
+		for _, f := range RRD_FUNCTIONS {
+			known = known || f == function
+		}
+
+		if !known {
+			return nil, errors.New("Unknown consolidation function " + parts[0] + ", use " +
+				strings.Join(RRD_FUNCTIONS, ", ") + ".")
+		}
+
+		steps, errSteps := strconv.Atoi(parts[1])
+		rows, errRows := strconv.Atoi(parts[2])
+		if errSteps != nil || errRows != nil || steps < 1 || rows < 1 {
+			return nil, errors.New("Invalid archive " + archive + ", steps and rows must be positive.")
+		}
+
+		archives = append(archives, fmt.Sprintf("RRA:%s:%s:%d:%d", function, RRD_XFF, steps, rows))
+	}
+
+	if len(archives) == 0 {
+		return nil, errors.New("At least an archive is needed.")
+	}
+
+	return archives, nil
+}
+
+func (*RRD) Create(v interface{}) (repo.Interface, error) {
+	return NewRRD(v.(*RRDOpts))
+}
+
+// Checks that rrdtool can be run and the directory written.
+func (*RRD) Check(v interface{}) error {
+	opts := v.(*RRDOpts)
+
+	if _, err := exec.LookPath(opts.Binary); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(opts.Dir, ".statspout")
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	return os.Remove(file.Name())
+}
+
+func CreateRRDOpts() *RRDOpts {
+	o := &RRDOpts{}
+
+	flag.StringVar(&o.Dir,
+		"rrd.dir",
+		"",
+		"Directory of the RRD files, one per container.")
+
+	flag.DurationVar(&o.Step,
+		"rrd.step",
+		5*time.Second,
+		"Time between the primary points of the files, usually the interval.")
+
+	flag.DurationVar(&o.Heartbeat,
+		"rrd.heartbeat",
+		0,
+		"Time without updates after which the values are unknown. Twice the step if 0.")
+
+	flag.StringVar(&o.Archives,
+		"rrd.archives",
+		"AVERAGE:1:17280,AVERAGE:60:2016,MAX:60:2016,AVERAGE:720:1460",
+		"Archives of the files, as function:steps:rows separated by comma.")
+
+	flag.StringVar(&o.Binary,
+		"rrd.binary",
+		"rrdtool",
+		"Path of rrdtool.")
+
+	return o
+}
+
+func (*RRD) Name() string {
+	return "rrd"
+}
+
+// Updates the file of the container, created if missing. Metrics of the groups not pushed are unknown. Samples not
+// newer than the last update, in seconds, are dropped.
+func (rrd *RRD) Push(s *stats.Stats) error {
+	rrd.mutex.Lock()
+	defer rrd.mutex.Unlock()
+
+	file := rrdFile(s.Name)
+	timestamp := s.Timestamp.Unix()
+
+	if err := rrd.create(file, timestamp-1); err != nil {
+		return err
+	}
+
+	if timestamp <= rrd.last[file] {
+		return nil
+	}
+
+	values := []string{strconv.FormatInt(timestamp, 10)}
+	for _, source := range rrdSources {
+		if !s.Groups.Has(source.group) {
+			values = append(values, "U")
+			continue
+		}
+		values = append(values, strconv.FormatFloat(source.value(s), 'f', -1, 64))
+	}
+
+	if err := rrd.run("update", file, strings.Join(values, ":")); err != nil {
+		return err
+	}
+
+	rrd.last[file] = timestamp
+	return nil
+}
+
+// Creates the file of the container ahead of its first sample.
+func (rrd *RRD) ContainerAdded(container repo.Container) {
+	rrd.mutex.Lock()
+	defer rrd.mutex.Unlock()
+
+	rrd.create(rrdFile(container.Name), time.Now().Add(-rrd.heartbeat).Unix())
+}
+
+func (rrd *RRD) ContainerRemoved(container repo.Container) {
+	// the file is kept, as its history, see Clear.
+}
+
+func (rrd *RRD) Close() {
+	rrd.mutex.Lock()
+	defer rrd.mutex.Unlock()
+
+	rrd.stop()
+}
+
+// Forgets the last update of the file of the container, which is kept.
+func (rrd *RRD) Clear(name string) {
+	rrd.mutex.Lock()
+	defer rrd.mutex.Unlock()
+
+	delete(rrd.last, rrdFile(name))
+}
+
+// Creates the file unless known or existing, starting just before the given time.
+func (rrd *RRD) create(file string, start int64) error {
+	if _, ok := rrd.last[file]; ok {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(rrd.dir, file)); err == nil {
+		// written before, rrdtool rejects samples older than its last update.
+		rrd.last[file] = 0
+		return nil
+	}
+
+	args := []string{"create", file,
+		"--start", strconv.FormatInt(start, 10),
+		"--step", strconv.Itoa(int(rrd.step / time.Second))}
+
+	heartbeat := strconv.Itoa(int(rrd.heartbeat / time.Second))
+	for _, source := range rrdSources {
+		min := "U"
+		if source.kind == "DERIVE" {
+			min = "0"
+		}
+		args = append(args, "DS:"+source.name+":"+source.kind+":"+heartbeat+":"+min+":U")
+	}
+	args = append(args, rrd.archives...)
+
+	if err := rrd.run(args...); err != nil {
+		return err
+	}
+
+	rrd.last[file] = start
+	return nil
+}
+
+// Starts rrdtool, reading commands from its standard input.
+func (rrd *RRD) start() error {
+	cmd := exec.Command(rrd.binary, "-")
+	cmd.Dir = rrd.dir
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	rrd.cmd = cmd
+	rrd.stdin = stdin
+	rrd.stdout = bufio.NewReader(stdout)
+
+	return nil
+}
+
+func (rrd *RRD) stop() {
+	if rrd.cmd == nil {
+		return
+	}
+
+	io.WriteString(rrd.stdin, "quit\n")
+	rrd.stdin.Close()
+	rrd.cmd.Wait()
+
+	rrd.cmd = nil
+}
+
+// Runs a command, restarting rrdtool if it exited. Its output is discarded until the OK or ERROR line.
+func (rrd *RRD) run(args ...string) error {
+	if rrd.cmd == nil {
+		if err := rrd.start(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(rrd.stdin, strings.Join(args, " ")+"\n"); err != nil {
+		rrd.stop()
+		return err
+	}
+
+	for {
+		line, err := rrd.stdout.ReadString('\n')
+		if err != nil {
+			rrd.stop()
+			return err
+		}
+
+		if strings.HasPrefix(line, "OK") {
+			return nil
+		}
+
+		if strings.HasPrefix(line, "ERROR:") {
+			return errors.New("rrdtool " + args[0] + " " + args[1] + ": " + strings.TrimSpace(line[len("ERROR:"):]))
+		}
+	}
+}
+
+// Name of the file of the container, with characters other than letters, digits, dots, dashes and underscores
+// replaced.
+func rrdFile(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(name, "/")) + ".rrd"
+}
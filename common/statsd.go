@@ -0,0 +1,268 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+func init() {
+	repo.Register(&StatsD{}, func() interface{} {
+		return CreateStatsDOpts()
+	})
+}
+
+// Largest UDP datagram sent, metrics are packed into datagrams up to it so they are not fragmented.
+const STATSD_DATAGRAM = 1400
+
+// Metric sent to StatsD, with the group of metrics it belongs to.
+type statsdMetric struct {
+	name  string
+	group string
+	value func(s *stats.Stats) float64
+}
+
+// Metrics sent as gauges, their current value.
+var statsdGauges = []statsdMetric{
+	{"cpu_percent", stats.GROUP_CPU, func(s *stats.Stats) float64 { return s.CpuPercent }},
+	{"cpu_limit", stats.GROUP_CPU, func(s *stats.Stats) float64 { return s.CpuLimit }},
+	{"mem_usage", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.MemoryUsage) }},
+	{"mem_percent", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return s.MemoryPercent }},
+	{"mem_limit", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.MemoryLimit) }},
+	{"swap_usage", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.SwapUsage) }},
+	{"blkio_queue", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioQueue) }},
+}
+
+// Totals sent as counters, incremented by their growth since the previous sample of the container.
+var statsdCounters = []statsdMetric{
+	{"tx_bytes", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxBytesTotal) }},
+	{"rx_bytes", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxBytesTotal) }},
+	{"tx_packets", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxPacketsTotal) }},
+	{"rx_packets", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxPacketsTotal) }},
+	{"tx_errors", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxErrorsTotal) }},
+	{"rx_errors", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxErrorsTotal) }},
+	{"tx_dropped", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.TxDroppedTotal) }},
+	{"rx_dropped", stats.GROUP_NETWORK, func(s *stats.Stats) float64 { return float64(s.RxDroppedTotal) }},
+	{"mem_failcnt", stats.GROUP_MEMORY, func(s *stats.Stats) float64 { return float64(s.MemoryFailcnt) }},
+	{"blkio_read_bytes", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioReadBytes) }},
+	{"blkio_write_bytes", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioWriteBytes) }},
+	{"blkio_reads", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioReads) }},
+	{"blkio_writes", stats.GROUP_BLKIO, func(s *stats.Stats) float64 { return float64(s.BlkioWrites) }},
+}
+
+// StatsD sends the stats to StatsD over UDP, gauges as they are and totals as counters. With tags, the container and
+// its labels are sent as DogStatsD tags, otherwise the container is part of the name of each metric.
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+	tags   bool
+	labels map[string]bool // labels sent as tags, every one if it holds *.
+
+	mutex sync.Mutex
+
+	// totals of the previous sample of each container by name and host, in the order of statsdCounters.
+	previous map[string]map[string][]float64
+}
+
+type StatsDOpts struct {
+	Address string
+	Prefix  string
+	Tags    bool
+	Labels  string
+}
+
+// Creates a new StatsD repository.
+func NewStatsD(opts *StatsDOpts) (*StatsD, error) {
+	if opts.Address == "" {
+		return nil, errors.New("The address of StatsD is needed.")
+	}
+
+	labels := map[string]bool{}
+	for _, label := range strings.Split(opts.Labels, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			labels[label] = true
+		}
+	}
+
+	if len(labels) > 0 && !opts.Tags {
+		return nil, errors.New("Labels are sent as tags, which need -statsd.tags.")
+	}
+
+	// UDP is connectionless, dialing only resolves the address.
+	conn, err := net.Dial("udp", opts.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.Trim(opts.Prefix, ".")
+	if prefix != "" {
+		prefix += "."
+	}
+
+	return &StatsD{
+		conn:     conn,
+		prefix:   prefix,
+		tags:     opts.Tags,
+		labels:   labels,
+		previous: make(map[string]map[string][]float64),
+	}, nil
+}
+
+func (*StatsD) Name() string {
+	return "statsd"
+}
+
+func (*StatsD) Create(v interface{}) (repo.Interface, error) {
+	return NewStatsD(v.(*StatsDOpts))
+}
+
+// Sends the gauges and counters of the groups pushed (see stats.Stats.Select). Counters are first sent with the
+// second sample of a container, and skipped when its totals are reset.
+func (sd *StatsD) Push(s *stats.Stats) error {
+	name, tags := sd.prefix+graphiteName(s.Name)+".", ""
+	if sd.tags {
+		name, tags = sd.prefix, "|#"+sd.tagsOf(s)
+	}
+
+	var metrics [][]byte
+	for _, gauge := range statsdGauges {
+		if s.Groups.Has(gauge.group) {
+			metrics = append(metrics, sd.metric(name+gauge.name, gauge.value(s), "g", tags))
+		}
+	}
+
+	totals := make([]float64, len(statsdCounters))
+	for i, counter := range statsdCounters {
+		totals[i] = counter.value(s)
+	}
+
+	host := s.Labels[stats.LABEL_HOST]
+
+	sd.mutex.Lock()
+	if sd.previous[s.Name] == nil {
+		sd.previous[s.Name] = make(map[string][]float64)
+	}
+	previous, ok := sd.previous[s.Name][host]
+	sd.previous[s.Name][host] = totals
+	sd.mutex.Unlock()
+
+	if ok {
+		for i, counter := range statsdCounters {
+			if s.Groups.Has(counter.group) && totals[i] >= previous[i] {
+				metrics = append(metrics, sd.metric(name+counter.name, totals[i]-previous[i], "c", tags))
+			}
+		}
+	}
+
+	return sd.send(metrics)
+}
+
+func (sd *StatsD) Close() {
+	sd.conn.Close()
+}
+
+// Forgets the totals of the container on every host.
+func (sd *StatsD) Clear(name string) {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+
+	delete(sd.previous, name)
+}
+
+// Forgets the totals of the container of the host.
+func (sd *StatsD) ClearHost(host string, name string) {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+
+	delete(sd.previous[name], host)
+	if len(sd.previous[name]) == 0 {
+		delete(sd.previous, name)
+	}
+}
+
+func (sd *StatsD) metric(name string, value float64, kind string, tags string) []byte {
+	return []byte(name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + kind + tags)
+}
+
+// Tags of the sample, the container and the labels sent, sorted by key.
+func (sd *StatsD) tagsOf(s *stats.Stats) string {
+	var keys []string
+	for key := range s.Labels {
+		if sd.labels["*"] || sd.labels[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	tags := []string{"container:" + statsdTag(s.Name)}
+	for _, key := range keys {
+		tags = append(tags, strings.Replace(statsdTag(key), ":", "_", -1)+":"+statsdTag(s.Labels[key]))
+	}
+
+	return strings.Join(tags, ",")
+}
+
+// Sends the metrics, one per line, packed into datagrams.
+func (sd *StatsD) send(metrics [][]byte) error {
+	var packet bytes.Buffer
+	for i, metric := range metrics {
+		packet.Write(metric)
+
+		if i < len(metrics)-1 && packet.Len()+1+len(metrics[i+1]) <= STATSD_DATAGRAM {
+			packet.WriteByte('\n')
+			continue
+		}
+
+		if _, err := sd.conn.Write(packet.Bytes()); err != nil {
+			return err
+		}
+		packet.Reset()
+	}
+
+	return nil
+}
+
+// Tag key or value without the characters separating tags and metrics.
+func statsdTag(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', '\n':
+			return '_'
+		}
+		return r
+	}, strings.TrimPrefix(value, "/"))
+}
+
+func CreateStatsDOpts() *StatsDOpts {
+	o := &StatsDOpts{}
+
+	flag.StringVar(&o.Address,
+		"statsd.address",
+		"localhost:8125",
+		"Address of StatsD, as host:port, reached over UDP")
+
+	flag.StringVar(&o.Prefix,
+		"statsd.prefix",
+		"statspout",
+		"Prefix of the metric names")
+
+	flag.BoolVar(&o.Tags,
+		"statsd.tags",
+		false,
+		"Send the container as a DogStatsD tag, instead of in the metric names")
+
+	flag.StringVar(&o.Labels,
+		"statsd.labels",
+		"",
+		"Labels of the containers sent as tags, separated by comma, * for every one. Needs -statsd.tags")
+
+	return o
+}
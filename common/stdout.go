@@ -1,13 +1,26 @@
 package common
 
 import (
+	"flag"
 	"fmt"
 
 	"github.com/mijara/statspout/repo"
 	"github.com/mijara/statspout/stats"
 )
 
+func init() {
+	repo.Register(&Stdout{}, func() interface{} {
+		return CreateStdoutOpts()
+	})
+}
+
 type Stdout struct {
+	format stats.TimeFormat // format of the timestamps.
+}
+
+type StdoutOpts struct {
+	TimeZone   string // utc, local or empty to keep the zone reported.
+	TimeFormat string // layout of the timestamps, see stats.ParseTimeFormat.
 }
 
 func (*Stdout) Name() string {
@@ -15,7 +28,28 @@ func (*Stdout) Name() string {
 }
 
 func (*Stdout) Create(v interface{}) (repo.Interface, error) {
-	return NewStdout(), nil
+	// created without options when embedded.
+	opts, ok := v.(*StdoutOpts)
+	if !ok || opts == nil {
+		return NewStdout(), nil
+	}
+
+	format, err := stats.ParseTimeFormat(opts.TimeZone, opts.TimeFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stdout{format: format}, nil
+}
+
+func (*Stdout) Check(v interface{}) error {
+	opts, ok := v.(*StdoutOpts)
+	if !ok || opts == nil {
+		return nil
+	}
+
+	_, err := stats.ParseTimeFormat(opts.TimeZone, opts.TimeFormat)
+	return err
 }
 
 func (*Stdout) Clear(name string) {
@@ -26,10 +60,41 @@ func NewStdout() *Stdout {
 }
 
 func (stdout *Stdout) Push(s *stats.Stats) error {
-	fmt.Println(s)
+	fmt.Println(s.Text(stdout.format))
+	return nil
+}
+
+func (stdout *Stdout) PushEvent(event *stats.Event) error {
+	fmt.Println(event.Text(stdout.format))
+	return nil
+}
+
+func (stdout *Stdout) PushVolume(volume *stats.Volume) error {
+	fmt.Println(volume.Text(stdout.format))
+	return nil
+}
+
+func (stdout *Stdout) PushProcesses(processes *stats.Processes) error {
+	fmt.Println(processes.Text(stdout.format))
 	return nil
 }
 
 func (stdout *Stdout) Close() {
 
 }
+
+func CreateStdoutOpts() *StdoutOpts {
+	o := &StdoutOpts{}
+
+	flag.StringVar(&o.TimeZone,
+		"stdout.timezone",
+		"",
+		"Time zone of the timestamps: utc or local. Kept as reported if empty")
+
+	flag.StringVar(&o.TimeFormat,
+		"stdout.timeformat",
+		"default",
+		"Layout of the timestamps: default, rfc3339, rfc3339nano, epoch or epoch_ms")
+
+	return o
+}
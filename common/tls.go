@@ -0,0 +1,113 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"io/ioutil"
+
+	"github.com/mijara/statspout/secret"
+)
+
+// Minimum TLS versions, by name.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLS options of the repositories connecting to a backend over the network, shared so every repository gets the same
+// flags under its own prefix, see AddTLSFlags.
+type TLSOpts struct {
+	CA       string // CA certificates verifying the server, the system ones if empty.
+	Cert     string // client certificate, for mutual TLS.
+	Key      string // private key of the client certificate.
+	Insecure bool   // skips verifying the server certificate.
+	Version  string // minimum TLS version.
+}
+
+// Registers the TLS flags of a repository: <prefix>.tls.ca, .tls.cert, .tls.key, .tls.insecure and .tls.version.
+func AddTLSFlags(o *TLSOpts, prefix string) {
+	flag.StringVar(&o.CA,
+		prefix+".tls.ca",
+		"",
+		"CA certificates file verifying the server, the system ones if empty")
+
+	flag.StringVar(&o.Cert,
+		prefix+".tls.cert",
+		"",
+		"Client certificate file, for mutual TLS")
+
+	flag.StringVar(&o.Key,
+		prefix+".tls.key",
+		"",
+		"Private key file of the client certificate")
+
+	flag.BoolVar(&o.Insecure,
+		prefix+".tls.insecure",
+		false,
+		"Skip verifying the server certificate, for testing only")
+
+	flag.StringVar(&o.Version,
+		prefix+".tls.version",
+		"1.2",
+		"Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+}
+
+// Whether an option asks for TLS, the minimum version alone doesn't.
+func (o *TLSOpts) Enabled() bool {
+	return o.CA != "" || o.Cert != "" || o.Key != "" || o.Insecure
+}
+
+// Builds the client TLS configuration, which is nil if no option asks for TLS.
+func (o *TLSOpts) Config() (*tls.Config, error) {
+	if !o.Enabled() {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: o.Insecure}
+
+	if o.Version != "" {
+		version, ok := tlsVersions[o.Version]
+		if !ok {
+			return nil, errors.New("Unknown TLS version: " + o.Version + ", use 1.0, 1.1, 1.2 or 1.3")
+		}
+		config.MinVersion = version
+	}
+
+	if o.CA != "" {
+		pool, err := LoadCertPool(o.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	// the client certificate is read again when its files change, so new connections present the rotated one.
+	if o.Cert != "" || o.Key != "" {
+		pair, err := secret.NewKeyPair(o.Cert, o.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = pair.GetClientCertificate
+	}
+
+	return config, nil
+}
+
+// Loads the PEM certificates of the file into a pool.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("No PEM certificates found in " + path)
+	}
+
+	return pool, nil
+}
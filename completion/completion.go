@@ -0,0 +1,240 @@
+/*
+Shell completion:
+Generates completion scripts for bash, zsh and fish from a description of the subcommands and flags
+of the program.
+
+Example
+
+	spec := completion.Spec{
+		Program:  "statspout",
+		Commands: []completion.Command{{Name: "completion", Usage: "Generate completion.", Args: completion.Shells}},
+		Flags:    []completion.Flag{{Name: "mode", Usage: "Client mode.", Values: []string{"socket", "http"}}},
+	}
+
+	completion.Generate(os.Stdout, "bash", spec)
+*/
+package completion
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Supported shells.
+var Shells = []string{"bash", "zsh", "fish"}
+
+// Subcommand of the program.
+type Command struct {
+	Name  string
+	Usage string
+	Args  []string // fixed values for the first argument, if any.
+}
+
+// Flag of the program.
+type Flag struct {
+	Name   string
+	Usage  string
+	Bool   bool     // the flag takes no value.
+	Values []string // fixed values for the flag, if any.
+	Files  bool     // the value is a path.
+}
+
+// Description of the program to complete.
+type Spec struct {
+	Program  string
+	Commands []Command
+	Flags    []Flag
+}
+
+// Writes the completion script for the given shell.
+func Generate(w io.Writer, shell string, spec Spec) error {
+	switch shell {
+	case "bash":
+		return Bash(w, spec)
+	case "zsh":
+		return Zsh(w, spec)
+	case "fish":
+		return Fish(w, spec)
+	}
+
+	return errors.New("Unknown shell: " + shell + ", use one of: " + strings.Join(Shells, ", "))
+}
+
+// Writes the bash completion script.
+func Bash(w io.Writer, spec Spec) error {
+	fn := "_" + identifier(spec.Program)
+
+	var flags, commands []string
+	for _, f := range spec.Flags {
+		flags = append(flags, "-"+f.Name)
+	}
+	for _, c := range spec.Commands {
+		commands = append(commands, c.Name)
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "# bash completion for %s\n", spec.Program)
+	fmt.Fprintf(b, "%s() {\n", fn)
+	b.WriteString("    local cur prev\n")
+	b.WriteString("    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n\n")
+
+	// bash splits -flag=value in three words when = is in COMP_WORDBREAKS.
+	b.WriteString("    if [[ \"$cur\" == \"=\" ]]; then\n")
+	b.WriteString("        cur=\"\"\n")
+	b.WriteString("    elif [[ \"$prev\" == \"=\" ]]; then\n")
+	b.WriteString("        prev=\"${COMP_WORDS[COMP_CWORD-2]}\"\n")
+	b.WriteString("    fi\n\n")
+
+	// values given after an equal sign.
+	b.WriteString("    case \"$cur\" in\n")
+	for _, f := range spec.Flags {
+		if len(f.Values) > 0 {
+			fmt.Fprintf(b, "        -%[1]s=*|--%[1]s=*)\n", f.Name)
+			fmt.Fprintf(b, "            COMPREPLY=( $(compgen -P \"${cur%%%%=*}=\" -W %q -- \"${cur#*=}\") )\n",
+				strings.Join(f.Values, " "))
+			b.WriteString("            return ;;\n")
+		}
+	}
+	b.WriteString("    esac\n\n")
+
+	// values given as the next word.
+	b.WriteString("    case \"$prev\" in\n")
+	for _, f := range spec.Flags {
+		switch {
+		case len(f.Values) > 0:
+			fmt.Fprintf(b, "        -%[1]s|--%[1]s)\n", f.Name)
+			fmt.Fprintf(b, "            COMPREPLY=( $(compgen -W %q -- \"$cur\") )\n", strings.Join(f.Values, " "))
+			b.WriteString("            return ;;\n")
+		case f.Files:
+			fmt.Fprintf(b, "        -%[1]s|--%[1]s)\n", f.Name)
+			b.WriteString("            COMPREPLY=( $(compgen -f -- \"$cur\") )\n")
+			b.WriteString("            return ;;\n")
+		case !f.Bool:
+			fmt.Fprintf(b, "        -%[1]s|--%[1]s)\n", f.Name)
+			b.WriteString("            return ;;\n")
+		}
+	}
+	for _, c := range spec.Commands {
+		if len(c.Args) > 0 {
+			fmt.Fprintf(b, "        %s)\n", c.Name)
+			fmt.Fprintf(b, "            COMPREPLY=( $(compgen -W %q -- \"$cur\") )\n", strings.Join(c.Args, " "))
+			b.WriteString("            return ;;\n")
+		}
+	}
+	b.WriteString("    esac\n\n")
+
+	b.WriteString("    if [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(b, "        COMPREPLY=( $(compgen -W %q -- \"$cur\") )\n", strings.Join(flags, " "))
+	b.WriteString("    else\n")
+	fmt.Fprintf(b, "        COMPREPLY=( $(compgen -W %q -- \"$cur\") )\n", strings.Join(commands, " "))
+	b.WriteString("    fi\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(b, "complete -F %s %s\n", fn, spec.Program)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Writes the zsh completion script.
+func Zsh(w io.Writer, spec Spec) error {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "#compdef %s\n\n", spec.Program)
+	fmt.Fprintf(b, "_%s() {\n", identifier(spec.Program))
+	b.WriteString("    _arguments \\\n")
+
+	for _, f := range spec.Flags {
+		arg := fmt.Sprintf("-%s[%s]", f.Name, zshEscape(f.Usage))
+		switch {
+		case f.Bool:
+		case len(f.Values) > 0:
+			arg += fmt.Sprintf(":%s:(%s)", f.Name, strings.Join(f.Values, " "))
+		case f.Files:
+			arg += fmt.Sprintf(":%s:_files", f.Name)
+		default:
+			arg += fmt.Sprintf(":%s: ", f.Name)
+		}
+		fmt.Fprintf(b, "        %s \\\n", zshQuote(arg))
+	}
+
+	var commands []string
+	for _, c := range spec.Commands {
+		commands = append(commands, fmt.Sprintf("%s\\:%s", c.Name, zshEscape(c.Usage)))
+	}
+	fmt.Fprintf(b, "        %s \\\n", zshQuote("1:command:(("+strings.Join(commands, " ")+"))"))
+	b.WriteString("        '*::arg:->args'\n\n")
+
+	b.WriteString("    case $words[1] in\n")
+	for _, c := range spec.Commands {
+		if len(c.Args) > 0 {
+			fmt.Fprintf(b, "        %s)\n", c.Name)
+			fmt.Fprintf(b, "            _values %s %s ;;\n", zshQuote(c.Name), strings.Join(c.Args, " "))
+		}
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(b, "compdef _%s %s\n", identifier(spec.Program), spec.Program)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Writes the fish completion script.
+func Fish(w io.Writer, spec Spec) error {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "# fish completion for %s\n", spec.Program)
+	fmt.Fprintf(b, "complete -c %s -f\n", spec.Program)
+
+	for _, c := range spec.Commands {
+		fmt.Fprintf(b, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n",
+			spec.Program, c.Name, fishQuote(c.Usage))
+
+		if len(c.Args) > 0 {
+			fmt.Fprintf(b, "complete -c %s -n '__fish_seen_subcommand_from %s' -a %s\n",
+				spec.Program, c.Name, fishQuote(strings.Join(c.Args, " ")))
+		}
+	}
+
+	for _, f := range spec.Flags {
+		line := fmt.Sprintf("complete -c %s -o %s -d %s", spec.Program, f.Name, fishQuote(f.Usage))
+		switch {
+		case f.Bool:
+		case len(f.Values) > 0:
+			line += fmt.Sprintf(" -x -a %s", fishQuote(strings.Join(f.Values, " ")))
+		case f.Files:
+			line += " -r -F"
+		default:
+			line += " -x"
+		}
+		b.WriteString(line + "\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Turns the program name into a valid shell function name.
+func identifier(program string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, program)
+}
+
+// Escapes characters with special meaning in zsh descriptions.
+func zshEscape(s string) string {
+	return strings.NewReplacer("[", "\\[", "]", "\\]", ":", "\\:").Replace(s)
+}
+
+func zshQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(s) + "'"
+}
@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/mijara/statspout/backend"
+)
+
+// Consul lists the healthy instances of a service, each one named by its node.
+type Consul struct {
+	address string
+	service string
+	client  *http.Client
+}
+
+// Entry of the Consul health API.
+type consulEntry struct {
+	Node struct {
+		Node    string `json:"Node"`
+		Address string `json:"Address"`
+	} `json:"Node"`
+
+	Service struct {
+		ID      string `json:"ID"`
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+func (c *Consul) Endpoints() (map[string]backend.Endpoint, error) {
+	res, err := c.client.Get("http://" + c.address + "/v1/health/service/" + url.PathEscape(c.service) + "?passing=true")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("Consul replied " + res.Status)
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[string]backend.Endpoint, len(entries))
+	for _, entry := range entries {
+		// the service address defaults to the node address.
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+
+		// several instances on the same node are told apart by service ID.
+		name := entry.Node.Node
+		if _, ok := endpoints[name]; ok {
+			name += "/" + entry.Service.ID
+		}
+
+		endpoints[name] = backend.Endpoint{
+			Network: "tcp",
+			Address: net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)),
+		}
+	}
+
+	return endpoints, nil
+}
@@ -0,0 +1,169 @@
+/*
+Package discovery keeps the Docker hosts of a fleet in sync with a service registry, so new hosts are collected
+automatically and removed ones are dropped.
+
+Sources
+
+	consul://host:port/service    healthy instances of a Consul service, named by node.
+	etcd://host:port/prefix       keys under an etcd v3 prefix, named by the rest of the key, whose values
+	                              are endpoints (tcp://host:port, unix:///path or host:port).
+	swarm://host:port?port=2375   ready nodes of the Swarm managed at host:port, named by hostname, whose
+	                              engines are reached at their node address and the given port.
+
+Example
+
+	source, err := discovery.NewSource("consul://localhost:8500/docker")
+	watcher := discovery.NewWatcher(source, fleet, 30*time.Second)
+	watcher.Start()
+	defer watcher.Stop()
+*/
+package discovery
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/log"
+)
+
+// Timeout of each request to the registry.
+const TIMEOUT = 10 * time.Second
+
+// Registry listing Docker hosts.
+type Source interface {
+	// Current endpoints of the hosts, by name.
+	Endpoints() (map[string]backend.Endpoint, error)
+}
+
+// Set of hosts being collected, implemented by statspout.Fleet.
+type Hoster interface {
+	Add(name string, endpoint backend.Endpoint) error
+	Remove(name string) bool
+}
+
+// Creates a source from its URL: consul://host:port/service, etcd://host:port/prefix or swarm://host:port.
+func NewSource(address string) (Source, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, errors.New("Discovery source needs a host: " + address)
+	}
+
+	client := &http.Client{Timeout: TIMEOUT}
+
+	switch u.Scheme {
+	case "consul":
+		if len(u.Path) < 2 {
+			return nil, errors.New("Consul source must be given as consul://host:port/service.")
+		}
+		return &Consul{address: u.Host, service: u.Path[1:], client: client}, nil
+
+	case "etcd":
+		if len(u.Path) < 2 {
+			return nil, errors.New("etcd source must be given as etcd://host:port/prefix.")
+		}
+		return &Etcd{address: u.Host, prefix: u.Path, client: client}, nil
+
+	case "swarm":
+		port := u.Query().Get("port")
+		if port == "" {
+			port = SWARM_ENGINE_PORT
+		}
+		return &Swarm{address: u.Host, port: port, client: client}, nil
+	}
+
+	return nil, errors.New("Unknown discovery source: " + address)
+}
+
+// Watcher polls a source and adds or removes hosts accordingly. Only hosts added by the watcher are removed by it.
+type Watcher struct {
+	source   Source
+	target   Hoster
+	interval time.Duration
+
+	managed map[string]backend.Endpoint // hosts added by the watcher.
+
+	quit chan bool
+	done chan bool
+}
+
+// Creates a watcher that syncs the target with the source on every interval.
+func NewWatcher(source Source, target Hoster, interval time.Duration) *Watcher {
+	return &Watcher{
+		source:   source,
+		target:   target,
+		interval: interval,
+		managed:  make(map[string]backend.Endpoint),
+	}
+}
+
+// Syncs once, then keeps syncing in the background.
+func (w *Watcher) Start() {
+	w.quit = make(chan bool)
+	w.done = make(chan bool)
+
+	w.sync()
+
+	go w.loop()
+}
+
+// Stops syncing, discovered hosts are left in the target.
+func (w *Watcher) Stop() {
+	close(w.quit)
+	<-w.done
+}
+
+func (w *Watcher) loop() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-ticker.C:
+			w.sync()
+		}
+	}
+}
+
+// Adds new hosts, replaces moved ones and removes the gone ones. Hosts that cannot be added are retried on the
+// next sync.
+func (w *Watcher) sync() {
+	endpoints, err := w.source.Endpoints()
+	if err != nil {
+		log.Error.Printf("Discovery failed: %s", err.Error())
+		return
+	}
+
+	for name, endpoint := range w.managed {
+		if current, ok := endpoints[name]; !ok || !reflect.DeepEqual(current, endpoint) {
+			log.Info.Printf("Host %s is gone, removing.", name)
+			w.target.Remove(name)
+			delete(w.managed, name)
+		}
+	}
+
+	for name, endpoint := range endpoints {
+		if _, ok := w.managed[name]; ok {
+			continue
+		}
+
+		if err := w.target.Add(name, endpoint); err != nil {
+			log.Error.Printf("Cannot add discovered host %s: %s", name, err.Error())
+			continue
+		}
+
+		log.Info.Printf("Discovered host %s at %s://%s.", name, endpoint.Network, endpoint.Address)
+		w.managed[name] = endpoint
+	}
+}
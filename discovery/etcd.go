@@ -0,0 +1,74 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/log"
+)
+
+// Etcd lists the keys under a prefix through the etcd v3 JSON gateway, each key names a host and its value is the
+// endpoint.
+type Etcd struct {
+	address string
+	prefix  string
+	client  *http.Client
+}
+
+func (e *Etcd) Endpoints() (map[string]backend.Endpoint, error) {
+	// the range end of a prefix is the prefix with its last byte incremented.
+	end := []byte(e.prefix)
+	end[len(end)-1]++
+
+	request, err := json.Marshal(map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(e.prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(end),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := e.client.Post("http://"+e.address+"/v3/kv/range", "application/json", bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("etcd replied " + res.Status)
+	}
+
+	// keys and values are base64 encoded by the gateway, decoded into []byte.
+	response := struct {
+		Kvs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[string]backend.Endpoint, len(response.Kvs))
+	for _, kv := range response.Kvs {
+		name := strings.TrimPrefix(strings.TrimPrefix(string(kv.Key), e.prefix), "/")
+		if name == "" {
+			continue
+		}
+
+		endpoint, err := backend.ParseEndpoint(strings.TrimSpace(string(kv.Value)))
+		if err != nil {
+			log.Warning.Printf("Invalid endpoint for host %s in etcd: %s", name, err.Error())
+			continue
+		}
+
+		endpoints[name] = endpoint
+	}
+
+	return endpoints, nil
+}
@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/mijara/statspout/backend"
+)
+
+// Default port of the Docker engines of the Swarm nodes.
+const SWARM_ENGINE_PORT = "2375"
+
+// Labels added to the samples of each Swarm node.
+const (
+	LABEL_NODE_ID       = "swarm.node.id"
+	LABEL_NODE_HOSTNAME = "swarm.node.hostname"
+)
+
+// Swarm lists the ready nodes of a Swarm through the nodes API of a manager. Each node engine is reached directly,
+// at the node address and the configured port.
+type Swarm struct {
+	address string // manager, host:port.
+	port    string // port of the node engines.
+	client  *http.Client
+}
+
+// Node as returned by the nodes API.
+type swarmNode struct {
+	ID          string `json:"ID"`
+	Description struct {
+		Hostname string `json:"Hostname"`
+	} `json:"Description"`
+	Status struct {
+		State string `json:"State"`
+		Addr  string `json:"Addr"`
+	} `json:"Status"`
+	ManagerStatus *struct {
+		Addr string `json:"Addr"`
+	} `json:"ManagerStatus"`
+}
+
+func (s *Swarm) Endpoints() (map[string]backend.Endpoint, error) {
+	res, err := s.client.Get("http://" + s.address + "/nodes")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("Swarm manager replied " + res.Status)
+	}
+
+	var nodes []swarmNode
+	if err := json.NewDecoder(res.Body).Decode(&nodes); err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[string]backend.Endpoint, len(nodes))
+	for _, node := range nodes {
+		if node.Status.State != "ready" {
+			continue
+		}
+
+		// managers may report an unspecified address, their manager address is used instead.
+		host := node.Status.Addr
+		if (host == "" || host == "0.0.0.0") && node.ManagerStatus != nil {
+			host, _, _ = net.SplitHostPort(node.ManagerStatus.Addr)
+		}
+		if host == "" {
+			continue
+		}
+
+		name := node.Description.Hostname
+		if name == "" {
+			name = node.ID
+		}
+
+		endpoints[name] = backend.Endpoint{
+			Network: "tcp",
+			Address: net.JoinHostPort(host, s.port),
+			Labels: map[string]string{
+				LABEL_NODE_ID:       node.ID,
+				LABEL_NODE_HOSTNAME: node.Description.Hostname,
+			},
+		}
+	}
+
+	return endpoints, nil
+}
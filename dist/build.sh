@@ -1,7 +1,15 @@
 #!/bin/sh
 
+VERSION=${VERSION:-$(git describe --tags --always 2>/dev/null || echo dev)}
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo unknown)
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+
+LDFLAGS="-X github.com/mijara/statspout/version.Version=${VERSION}"
+LDFLAGS="${LDFLAGS} -X github.com/mijara/statspout/version.Commit=${COMMIT}"
+LDFLAGS="${LDFLAGS} -X github.com/mijara/statspout/version.BuildDate=${BUILD_DATE}"
+
 # compile sources with linux target.
-CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -o statspout-linux github.com/mijara/statspout/cmd
+CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -ldflags "${LDFLAGS}" -o statspout-linux github.com/mijara/statspout/cmd
 
 # build the docker image.
 docker build -t mijara/statspout .
@@ -0,0 +1,530 @@
+/*
+Package dockertest provides an in-process fake of the Docker API, implementing the endpoints used by
+statspout: ping, info, containers list, container inspect, stats and events. The Recorder repository keeps what
+went through the pipeline, to be checked by the tests.
+
+Example
+
+	server := dockertest.NewServer()
+	defer server.Close()
+
+	server.AddContainer("web", map[string]string{"tier": "frontend"})
+	recorder := dockertest.NewRecorder()
+
+	collector, err := statspout.NewCollector(
+		statspout.WithEndpoint(server.Network(), server.Address()),
+		statspout.WithRepo(recorder))
+
+	// script what happens while collecting.
+	server.Run(dockertest.Scenario{
+		{After: 2 * time.Second, Do: dockertest.Start("worker", nil)},
+		{After: 5 * time.Second, Do: dockertest.Stop("web")},
+	})
+*/
+package dockertest
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+)
+
+// Fake Docker API server.
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+
+	mutex       sync.Mutex
+	gzip        bool          // compress responses for clients accepting it.
+	latency     time.Duration // delay of the stats responses.
+	realistic   bool          // report stats as a real daemon, see SetRealistic.
+	seed        int64         // seed of the realistic stats, see SetSeed.
+	replay      *replay       // recorded exchanges served instead, see Replay.
+	containers  map[string]*container
+	created     uint64 // containers created so far.
+	subscribers map[chan event]bool
+	closed      chan bool
+}
+
+type container struct {
+	id      string
+	created uint64 // order of creation, the list is sorted from the newest.
+	labels  map[string]string
+	stats   *backend.ContainerStats
+	reads   uint64 // number of stats requests, used to fabricate stats.
+	exited  bool   // stopped but not removed, only listed with all or limit, see Exit.
+
+	simulation *simulation // realistic counters, once simulated.
+}
+
+// Event as sent by the Docker events API.
+type event struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+
+	Time     int64 `json:"time"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+// Scripted action to apply to the server after some time.
+type Step struct {
+	After time.Duration // time to wait since the previous step.
+	Do    func(*Server) // action to apply.
+}
+
+// Sequence of steps, see Run.
+type Scenario []Step
+
+// Creates and starts a server listening on a random local TCP port.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("dockertest: failed to listen: " + err.Error())
+	}
+
+	return newServer(listener)
+}
+
+// Creates and starts a server listening on a Unix socket at the given path.
+func NewUnixServer(path string) (*Server, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newServer(listener), nil
+}
+
+func newServer(listener net.Listener) *Server {
+	s := &Server{
+		listener:    listener,
+		containers:  make(map[string]*container),
+		subscribers: make(map[chan event]bool),
+		closed:      make(chan bool),
+	}
+
+	s.server = &http.Server{Handler: http.HandlerFunc(s.handle)}
+	go s.server.Serve(listener)
+
+	return s
+}
+
+// Network of the server, to be used with statspout.WithEndpoint.
+func (s *Server) Network() string {
+	return s.listener.Addr().Network()
+}
+
+// Address of the server, to be used with statspout.WithEndpoint.
+func (s *Server) Address() string {
+	return s.listener.Addr().String()
+}
+
+// Stops the server, closing all connections.
+func (s *Server) Close() {
+	close(s.closed)
+	s.server.Close()
+}
+
+// Compresses the responses of the clients accepting gzip, as a proxy in front of the daemon may. The daemon itself
+// never does.
+func (s *Server) SetGzip(enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.gzip = enabled
+}
+
+// Adds a running container, without emitting events.
+func (s *Server) AddContainer(name string, labels map[string]string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.created++
+
+	hash := sha256.Sum256([]byte(name))
+	s.containers[name] = &container{id: hex.EncodeToString(hash[:]), created: s.created, labels: labels}
+}
+
+// Stops the container without removing it nor emitting events. As on the daemon, it is still listed when asking for
+// every container or for a limited number of them, unless filtered by status.
+func (s *Server) Exit(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if c, ok := s.containers[name]; ok {
+		c.exited = true
+	}
+}
+
+// Fixes the stats reported for the container, otherwise they are fabricated on each request.
+func (s *Server) SetStats(name string, stats backend.ContainerStats) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if c, ok := s.containers[name]; ok {
+		c.stats = &stats
+	}
+}
+
+// Sends a container event to every events subscriber.
+func (s *Server) Emit(action string, attributes map[string]string) {
+	now := time.Now()
+
+	e := event{Type: "container", Action: action, Time: now.Unix(), TimeNano: now.UnixNano()}
+	e.Actor.Attributes = attributes
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// slow subscribers lose events instead of blocking the server.
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- e:
+		default:
+		}
+	}
+}
+
+// Runs the scenario in the background, steps are applied in order.
+func (s *Server) Run(scenario Scenario) {
+	go func() {
+		for _, step := range scenario {
+			select {
+			case <-s.closed:
+				return
+			case <-time.After(step.After):
+				step.Do(s)
+			}
+		}
+	}()
+}
+
+// Step action that adds a container and emits its start event.
+func Start(name string, labels map[string]string) func(*Server) {
+	return func(s *Server) {
+		s.AddContainer(name, labels)
+		s.Emit("start", map[string]string{"name": name})
+	}
+}
+
+// Step action that removes a container and emits its stop event.
+func Stop(name string) func(*Server) {
+	return func(s *Server) {
+		s.mutex.Lock()
+		delete(s.containers, name)
+		s.mutex.Unlock()
+
+		s.Emit("stop", map[string]string{"name": name})
+	}
+}
+
+// Step action that renames a container and emits its rename event.
+func Rename(oldName string, newName string) func(*Server) {
+	return func(s *Server) {
+		s.mutex.Lock()
+		if c, ok := s.containers[oldName]; ok {
+			delete(s.containers, oldName)
+			s.containers[newName] = c
+		}
+		s.mutex.Unlock()
+
+		s.Emit("rename", map[string]string{"name": newName, "oldName": "/" + oldName})
+	}
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	s.mutex.Lock()
+	replaying := s.replay != nil
+	s.mutex.Unlock()
+
+	if replaying {
+		s.replayed(w, r)
+		return
+	}
+
+	// events are streamed, and left uncompressed.
+	s.mutex.Lock()
+	compress := s.gzip && parts[0] != "events" && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+	s.mutex.Unlock()
+
+	if compress {
+		writer := gzip.NewWriter(w)
+		defer writer.Close()
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w = gzipResponseWriter{ResponseWriter: w, writer: writer}
+	}
+
+	switch {
+	case len(parts) == 2 && parts[0] == "containers" && parts[1] == "json":
+		s.list(w, r)
+	case len(parts) == 3 && parts[0] == "containers" && parts[2] == "json":
+		s.inspect(w, parts[1])
+	case len(parts) == 3 && parts[0] == "containers" && parts[2] == "stats":
+		s.stats(w, parts[1])
+	case len(parts) == 1 && parts[0] == "events":
+		s.events(w, r)
+	case len(parts) == 1 && parts[0] == "_ping":
+		w.Write([]byte("OK"))
+	case len(parts) == 1 && parts[0] == "info":
+		writeJSON(w, map[string]string{"ID": "dockertest:" + s.Address()})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Lists the containers from the newest, supporting the limit and all parameters and the id (regular expressions),
+// before (ID or name) and status filters, as the daemon.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	var filters map[string][]string
+	if raw := r.URL.Query().Get("filters"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &filters); err != nil {
+			http.Error(w, `{"message": "Invalid filters"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var patterns []*regexp.Regexp
+	for _, id := range filters["id"] {
+		pattern, err := regexp.Compile(id)
+		if err != nil {
+			http.Error(w, `{"message": "Invalid id filter"}`, http.StatusBadRequest)
+			return
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	// as the daemon, a limit lists the stopped containers too, unless filtered by status.
+	all := r.URL.Query().Get("all") == "1" || r.URL.Query().Get("all") == "true" || limit > 0
+
+	s.mutex.Lock()
+	before := uint64(math.MaxUint64)
+	for _, ref := range filters["before"] {
+		for name, c := range s.containers {
+			if c.id == ref || name == ref {
+				before = c.created
+			}
+		}
+	}
+
+	type entry struct {
+		created   uint64
+		container backend.Container
+	}
+
+	entries := make([]entry, 0, len(s.containers))
+	for name, c := range s.containers {
+		if c.created >= before || !matchAny(patterns, c.id) || !matchStatus(filters["status"], c.exited, all) {
+			continue
+		}
+
+		entries = append(entries, entry{c.created, backend.Container{
+			ID:     c.id,
+			Names:  []string{"/" + name},
+			Labels: c.labels,
+		}})
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].created > entries[j].created
+	})
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	list := make([]backend.Container, len(entries))
+	for i, e := range entries {
+		list[i] = e.container
+	}
+
+	writeJSON(w, list)
+}
+
+// Whether the container is listed: its status is one of the filtered ones, or it is running unless listing all.
+func matchStatus(statuses []string, exited bool, all bool) bool {
+	status := "running"
+	if exited {
+		status = "exited"
+	}
+
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return len(statuses) == 0 && (all || !exited)
+}
+
+// Whether the ID matches any of the patterns, or there are none.
+func matchAny(patterns []*regexp.Regexp, id string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(id) {
+			return true
+		}
+	}
+
+	return len(patterns) == 0
+}
+
+func (s *Server) inspect(w http.ResponseWriter, name string) {
+	s.mutex.Lock()
+	c, ok := s.containers[name]
+	s.mutex.Unlock()
+
+	if !ok {
+		http.Error(w, `{"message": "No such container: `+name+`"}`, http.StatusNotFound)
+		return
+	}
+
+	inspect := backend.ContainerInspect{ID: c.id, Name: "/" + name}
+	inspect.Config.Labels = c.labels
+
+	// always sent by Docker, and required by strict clients. No limits are set.
+	writeJSON(w, struct {
+		backend.ContainerInspect
+		HostConfig struct{} `json:"HostConfig"`
+		State      struct {
+			Running bool `json:"Running"`
+		} `json:"State"`
+	}{ContainerInspect: inspect})
+}
+
+func (s *Server) stats(w http.ResponseWriter, name string) {
+	s.mutex.Lock()
+	latency := s.latency
+	s.mutex.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-s.closed:
+			return
+		case <-time.After(latency):
+		}
+	}
+
+	s.mutex.Lock()
+	c, ok := s.containers[name]
+	var stats interface{}
+	if ok && s.realistic && c.stats == nil {
+		stats = c.simulate(name, s.seed)
+	} else if ok {
+		c.reads++
+		stats = c.fabricate()
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		http.Error(w, `{"message": "No such container: `+name+`"}`, http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, stats)
+}
+
+// Streams events to the client until it disconnects or the server is closed.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	subscriber := make(chan event, 16)
+
+	s.mutex.Lock()
+	s.subscribers[subscriber] = true
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.subscribers, subscriber)
+		s.mutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	encoder := json.NewEncoder(w)
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-r.Context().Done():
+			return
+		case e := <-subscriber:
+			if err := encoder.Encode(e); err != nil {
+				return
+			}
+
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// Stats of the container, the fixed ones or fabricated from the number of reads.
+func (c *container) fabricate() backend.ContainerStats {
+	if c.stats != nil {
+		stats := *c.stats
+		stats.Read = time.Now()
+		return stats
+	}
+
+	stats := backend.ContainerStats{Read: time.Now()}
+
+	stats.Cpu.Usage.Total = c.reads * 2e8
+	stats.Cpu.Usage.PerCpu = []uint64{c.reads * 1e8, c.reads * 1e8}
+	stats.Cpu.SystemCpuUsage = c.reads * 2e9
+	stats.PreCpu.Usage.Total = (c.reads - 1) * 2e8
+	stats.PreCpu.SystemCpuUsage = (c.reads - 1) * 2e9
+
+	stats.Memory.Usage = 64 << 20
+	stats.Memory.Limit = 1 << 30
+
+	stats.Networks = map[string]backend.InterfaceStats{
+		"eth0": {
+			RxBytes: c.reads * 1024,
+			TxBytes: c.reads * 512,
+		},
+	}
+
+	return stats
+}
+
+// Response writer compressing the body.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	writer *gzip.Writer
+}
+
+func (w gzipResponseWriter) Write(data []byte) (int, error) {
+	return w.writer.Write(data)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,95 @@
+package dockertest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+// Repository keeping every sample pushed and the containers cleared, to check what went through the pipeline.
+type Recorder struct {
+	mutex   sync.Mutex
+	samples map[string][]stats.Stats // samples of each container by qualified name, in order.
+	cleared []string                 // containers cleared, qualified by host if cleared on one only, in order.
+	changed chan bool                // signaled on each push or clear, see Wait.
+}
+
+// Creates an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		samples: make(map[string][]stats.Stats),
+		changed: make(chan bool, 1),
+	}
+}
+
+func (r *Recorder) Create(v interface{}) (repo.Interface, error) {
+	return r, nil
+}
+
+func (r *Recorder) Push(s *stats.Stats) error {
+	r.mutex.Lock()
+	r.samples[s.QualifiedName()] = append(r.samples[s.QualifiedName()], *s)
+	r.mutex.Unlock()
+
+	r.signal()
+	return nil
+}
+
+func (r *Recorder) Close() {
+}
+
+func (r *Recorder) Clear(name string) {
+	r.mutex.Lock()
+	r.cleared = append(r.cleared, name)
+	r.mutex.Unlock()
+
+	r.signal()
+}
+
+func (r *Recorder) ClearHost(host string, name string) {
+	r.Clear(stats.QualifiedName(host, name))
+}
+
+func (r *Recorder) Name() string {
+	return "recorder"
+}
+
+// Samples pushed for the container so far, given by qualified name, see stats.QualifiedName.
+func (r *Recorder) Samples(name string) []stats.Stats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]stats.Stats(nil), r.samples[name]...)
+}
+
+// Containers cleared so far, by qualified name if cleared on their host only.
+func (r *Recorder) Cleared() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string(nil), r.cleared...)
+}
+
+// Waits until the condition holds, checking it on each push or clear, returns false if the timeout expired first.
+func (r *Recorder) Wait(timeout time.Duration, condition func(*Recorder) bool) bool {
+	deadline := time.After(timeout)
+
+	for !condition(r) {
+		select {
+		case <-deadline:
+			return condition(r)
+		case <-r.changed:
+		}
+	}
+
+	return true
+}
+
+func (r *Recorder) signal() {
+	select {
+	case r.changed <- true:
+	default:
+	}
+}
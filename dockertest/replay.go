@@ -0,0 +1,155 @@
+package dockertest
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mijara/statspout/backend"
+)
+
+// Response recorded by backend.SetRecordDir.
+type exchange struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// Recorded responses by request (method and URI), served in the order they were recorded.
+type replay struct {
+	exchanges map[string][]*exchange
+	served    map[string]int // responses served of each request.
+}
+
+// Serves the exchanges recorded to the directory (see backend.SetRecordDir) instead of the containers of the server,
+// so a trace captured from a real daemon can be reproduced. Each request gets the recorded responses to the same
+// method and URI in order, then the last one again. The events stream gets the recorded events once, then stays
+// open. Requests never recorded get a 404.
+func (s *Server) Replay(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+backend.RECORD_EXTENSION))
+	if err != nil {
+		return err
+	}
+
+	if len(paths) == 0 {
+		return errors.New("No recorded exchanges in " + dir + ".")
+	}
+
+	// files are numbered in the order they were recorded.
+	sort.Strings(paths)
+
+	r := &replay{
+		exchanges: make(map[string][]*exchange),
+		served:    make(map[string]int),
+	}
+
+	for _, path := range paths {
+		key, e, err := readExchange(path)
+		if err != nil {
+			return errors.New("Could not read " + path + ": " + err.Error())
+		}
+
+		r.exchanges[key] = append(r.exchanges[key], e)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.replay = r
+	return nil
+}
+
+// Reads a recorded exchange, keyed by its request.
+func readExchange(path string) (string, *exchange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return "", nil, err
+	}
+
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer res.Body.Close()
+
+	// streams recorded until the client stopped reading may end abruptly, what was read is kept.
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil && len(body) == 0 {
+		return "", nil, err
+	}
+
+	return requestKey(req), &exchange{status: res.StatusCode, header: res.Header, body: body}, nil
+}
+
+func requestKey(r *http.Request) string {
+	return r.Method + " " + r.URL.RequestURI()
+}
+
+// Serves the next recorded response to the request.
+func (s *Server) replayed(w http.ResponseWriter, r *http.Request) {
+	key := requestKey(r)
+	stream := strings.Trim(r.URL.Path, "/") == "events"
+
+	s.mutex.Lock()
+	exchanges := s.replay.exchanges[key]
+	n := s.replay.served[key]
+	s.replay.served[key]++
+	s.mutex.Unlock()
+
+	if len(exchanges) == 0 {
+		http.Error(w, `{"message": "No recorded response to `+key+`"}`, http.StatusNotFound)
+		return
+	}
+
+	// recorded events are sent once.
+	if stream && n >= len(exchanges) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		s.hold(w, r)
+		return
+	}
+
+	if n >= len(exchanges) {
+		n = len(exchanges) - 1
+	}
+	e := exchanges[n]
+
+	for name, values := range e.header {
+		if name == "Connection" {
+			continue
+		}
+		w.Header()[name] = values
+	}
+
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+
+	if stream {
+		s.hold(w, r)
+	}
+}
+
+// Keeps a stream open until the client disconnects or the server is closed.
+func (s *Server) hold(w http.ResponseWriter, r *http.Request) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	select {
+	case <-s.closed:
+	case <-r.Context().Done():
+	}
+}
@@ -0,0 +1,210 @@
+package dockertest
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+)
+
+// Number of CPUs of the simulated host.
+const SIMULATED_CPUS = 4
+
+// Stats as reported by a real daemon, including the fields statspout skips, so decoding them costs the same.
+type realisticStats struct {
+	Read     time.Time `json:"read"`
+	PreRead  time.Time `json:"preread"`
+	Name     string    `json:"name"`
+	ID       string    `json:"id"`
+	NumProcs int       `json:"num_procs"`
+
+	Pids struct {
+		Current uint64 `json:"current"`
+		Limit   uint64 `json:"limit"`
+	} `json:"pids_stats"`
+
+	Blkio   map[string][]blkioEntry `json:"blkio_stats"`
+	Storage struct{}                `json:"storage_stats"`
+
+	Cpu    realisticCpu    `json:"cpu_stats"`
+	PreCpu realisticCpu    `json:"precpu_stats"`
+	Memory realisticMemory `json:"memory_stats"`
+
+	Networks map[string]backend.InterfaceStats `json:"networks"`
+}
+
+type blkioEntry struct {
+	Major uint64 `json:"major"`
+	Minor uint64 `json:"minor"`
+	Op    string `json:"op"`
+	Value uint64 `json:"value"`
+}
+
+type realisticCpu struct {
+	Usage struct {
+		Total  uint64   `json:"total_usage"`
+		PerCpu []uint64 `json:"percpu_usage"`
+		Kernel uint64   `json:"usage_in_kernelmode"`
+		User   uint64   `json:"usage_in_usermode"`
+	} `json:"cpu_usage"`
+	System     uint64 `json:"system_cpu_usage"`
+	OnlineCpus int    `json:"online_cpus"`
+	Throttling struct {
+		Periods          uint64 `json:"periods"`
+		ThrottledPeriods uint64 `json:"throttled_periods"`
+		ThrottledTime    uint64 `json:"throttled_time"`
+	} `json:"throttling_data"`
+}
+
+type realisticMemory struct {
+	Usage    uint64            `json:"usage"`
+	MaxUsage uint64            `json:"max_usage"`
+	Stats    map[string]uint64 `json:"stats"`
+	Failcnt  uint64            `json:"failcnt"`
+	Limit    uint64            `json:"limit"`
+}
+
+// Counters of a simulated container, each stats request moves them by a second of work.
+type simulation struct {
+	random *rand.Rand
+	load   float64 // share of the host CPUs used on average.
+	memory uint64  // memory used on average.
+
+	read   time.Time
+	cpu    realisticCpu
+	rx, tx uint64
+	blkio  uint64
+}
+
+// Delays every stats response, as the daemon does while it gathers the stats (about a second, unless one-shot).
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.latency = latency
+}
+
+// Reports stats as a real daemon does: usage varies around a level drawn for each container, and the payload carries
+// every field of the stats API. Otherwise only the fields used by statspout are sent, growing steadily.
+func (s *Server) SetRealistic(enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.realistic = enabled
+}
+
+// Seeds the realistic stats along the container names, runs with the same seed and containers report the same
+// levels. Default 0.
+func (s *Server) SetSeed(seed int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seed = seed
+}
+
+// Moves the counters of the simulated container a second forward and reports them, the simulation starts on the
+// first call, seeded by the container name and the seed so runs are comparable.
+func (c *container) simulate(name string, seed int64) *realisticStats {
+	if c.simulation == nil {
+		hash := fnv.New64a()
+		hash.Write([]byte(name))
+
+		random := rand.New(rand.NewSource(int64(hash.Sum64()) ^ seed))
+		c.simulation = &simulation{
+			random: random,
+			load:   0.02 + random.Float64()*0.6,
+			memory: uint64(32+random.Intn(480)) << 20,
+			read:   time.Now(),
+		}
+		c.simulation.cpu.Usage.PerCpu = make([]uint64, SIMULATED_CPUS)
+		c.simulation.cpu.OnlineCpus = SIMULATED_CPUS
+	}
+
+	sim := c.simulation
+	jitter := 0.5 + sim.random.Float64()
+
+	stats := &realisticStats{
+		PreRead:  sim.read,
+		PreCpu:   sim.cpu,
+		Name:     "/" + name,
+		ID:       name,
+		Networks: make(map[string]backend.InterfaceStats, 1),
+	}
+	stats.PreCpu.Usage.PerCpu = append([]uint64(nil), sim.cpu.Usage.PerCpu...)
+
+	// a second of work on every CPU of the host.
+	used := uint64(sim.load * jitter * SIMULATED_CPUS * 1e9)
+	for i := range sim.cpu.Usage.PerCpu {
+		sim.cpu.Usage.PerCpu[i] += used / SIMULATED_CPUS
+	}
+	sim.cpu.Usage.Total += used
+	sim.cpu.Usage.User += used * 4 / 5
+	sim.cpu.Usage.Kernel += used / 5
+	sim.cpu.System += SIMULATED_CPUS * 1e9
+	sim.cpu.Throttling.Periods += 10
+
+	sim.rx += uint64(sim.random.Intn(64 << 10))
+	sim.tx += uint64(sim.random.Intn(16 << 10))
+	sim.blkio += uint64(sim.random.Intn(256 << 10))
+	sim.read = time.Now()
+
+	stats.Read = sim.read
+	stats.Cpu = sim.cpu
+	stats.Cpu.Usage.PerCpu = append([]uint64(nil), sim.cpu.Usage.PerCpu...)
+	stats.Pids.Current = uint64(4 + sim.random.Intn(60))
+	stats.Pids.Limit = 4096
+
+	usage := uint64(float64(sim.memory) * (0.9 + sim.random.Float64()*0.2))
+	stats.Memory = realisticMemory{
+		Usage:    usage,
+		MaxUsage: sim.memory * 6 / 5,
+		Limit:    2 << 30,
+		Stats: map[string]uint64{
+			"active_anon":   usage * 3 / 4,
+			"active_file":   usage / 8,
+			"cache":         usage / 8,
+			"dirty":         0,
+			"inactive_anon": usage / 16,
+			"inactive_file": usage / 16,
+			"mapped_file":   usage / 32,
+			"pgfault":       sim.cpu.Usage.Total / 1e5,
+			"pgmajfault":    0,
+			"pgpgin":        sim.cpu.Usage.Total / 1e6,
+			"pgpgout":       sim.cpu.Usage.Total / 2e6,
+			"rss":           usage * 7 / 8,
+			"rss_huge":      usage / 2,
+			"unevictable":   0,
+			"writeback":     0,
+		},
+	}
+
+	stats.Networks["eth0"] = backend.InterfaceStats{
+		RxBytes:   sim.rx,
+		RxPackets: sim.rx / 1024,
+		TxBytes:   sim.tx,
+		TxPackets: sim.tx / 1024,
+	}
+
+	stats.Blkio = map[string][]blkioEntry{
+		"io_service_bytes_recursive": {
+			{Major: 8, Op: "Read", Value: sim.blkio / 4},
+			{Major: 8, Op: "Write", Value: sim.blkio * 3 / 4},
+			{Major: 8, Op: "Sync", Value: sim.blkio / 2},
+			{Major: 8, Op: "Async", Value: sim.blkio / 2},
+			{Major: 8, Op: "Total", Value: sim.blkio},
+		},
+		"io_serviced_recursive": {
+			{Major: 8, Op: "Read", Value: sim.blkio / 4096},
+			{Major: 8, Op: "Write", Value: sim.blkio / 1024},
+		},
+		"io_queue_recursive":        {},
+		"io_service_time_recursive": {},
+		"io_wait_time_recursive":    {},
+		"io_merged_recursive":       {},
+		"io_time_recursive":         {},
+		"sectors_recursive":         {},
+	}
+
+	return stats
+}
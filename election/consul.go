@@ -0,0 +1,138 @@
+package election
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Timeout of each request to Consul.
+const CONSUL_TIMEOUT = 5 * time.Second
+
+// ConsulLock is a Consul KV lock bound to a session, released by Consul when the session is not renewed in time.
+type ConsulLock struct {
+	address string
+	key     string
+	ttl     time.Duration
+	client  *http.Client
+
+	mutex   sync.Mutex
+	session string // current session, empty if none.
+}
+
+// Creates a lock on the key of the Consul agent at the given address (host:port).
+func NewConsulLock(address string, key string, ttl time.Duration) *ConsulLock {
+	return &ConsulLock{
+		address: address,
+		key:     key,
+		ttl:     ttl,
+		client:  &http.Client{Timeout: CONSUL_TIMEOUT},
+	}
+}
+
+func (l *ConsulLock) Acquire() (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// renew the session, or create a new one if it expired.
+	if l.session != "" {
+		status, _, err := l.request("PUT", "/v1/session/renew/"+l.session, nil)
+		if err != nil {
+			return false, err
+		}
+		if status == http.StatusNotFound {
+			l.session = ""
+		}
+	}
+
+	if l.session == "" {
+		if err := l.createSession(); err != nil {
+			return false, err
+		}
+	}
+
+	hostname, _ := os.Hostname()
+
+	status, body, err := l.request("PUT", "/v1/kv/"+l.key+"?acquire="+l.session, []byte(hostname))
+	if err != nil {
+		return false, err
+	}
+	if status != http.StatusOK {
+		return false, errors.New("Consul lock acquire failed: " + string(body))
+	}
+
+	var acquired bool
+	if err := json.Unmarshal(body, &acquired); err != nil {
+		return false, err
+	}
+
+	return acquired, nil
+}
+
+func (l *ConsulLock) Release() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.session == "" {
+		return nil
+	}
+
+	// destroying the session releases the lock.
+	_, _, err := l.request("PUT", "/v1/session/destroy/"+l.session, nil)
+	l.session = ""
+
+	return err
+}
+
+// Creates a session that releases its locks when it expires.
+func (l *ConsulLock) createSession() error {
+	request, err := json.Marshal(map[string]string{
+		"Name":      "statspout",
+		"TTL":       l.ttl.String(),
+		"Behavior":  "release",
+		"LockDelay": "0s",
+	})
+	if err != nil {
+		return err
+	}
+
+	status, body, err := l.request("PUT", "/v1/session/create", request)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return errors.New("Consul session creation failed: " + string(body))
+	}
+
+	session := struct {
+		ID string `json:"ID"`
+	}{}
+	if err := json.Unmarshal(body, &session); err != nil {
+		return err
+	}
+
+	l.session = session.ID
+	return nil
+}
+
+// Sends a request to the Consul HTTP API, returns the status and body of the response.
+func (l *ConsulLock) request(method string, path string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, "http://"+l.address+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	response, err := ioutil.ReadAll(res.Body)
+	return res.StatusCode, response, err
+}
@@ -0,0 +1,240 @@
+/*
+Package election lets several statspout instances run for redundancy, while only the leader pushes stats to the
+repository. Leadership is given by a lock, either a file lock shared by the instances or a Consul lock.
+
+Example
+
+	lock, err := election.NewLock("consul://localhost:8500/statspout/leader", 15*time.Second)
+	elector := election.New(lock, 15*time.Second)
+	elector.Start()
+	defer elector.Stop()
+
+	repository = election.NewGate(repository, elector)
+*/
+package election
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+// Lock held by a single instance at a time.
+type Lock interface {
+	// Acquires the lock, or keeps it if already held. Returns whether the lock is held.
+	Acquire() (bool, error)
+
+	// Releases the lock, if held.
+	Release() error
+}
+
+// Creates a lock from its URL: file:///path/to/lock or consul://host:port/key. The TTL is the time after which
+// the lock of an instance that stopped renewing it is released, if the lock supports it.
+func NewLock(address string, ttl time.Duration) (Lock, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, errors.New("Lock file path cannot be empty.")
+		}
+		return NewFileLock(u.Path), nil
+
+	case "consul":
+		if u.Host == "" || len(u.Path) < 2 {
+			return nil, errors.New("Consul lock must be given as consul://host:port/key.")
+		}
+		return NewConsulLock(u.Host, u.Path[1:], ttl), nil
+	}
+
+	return nil, errors.New("Unknown lock: " + address + ", use file:///path or consul://host:port/key")
+}
+
+// Elector keeps trying to acquire the lock, and tells whether this instance is the leader.
+type Elector struct {
+	lock   Lock
+	period time.Duration
+
+	mutex  sync.RWMutex
+	leader bool
+
+	quit chan bool
+	done chan bool
+}
+
+// Creates an elector, the lock is acquired or renewed every third of the TTL.
+func New(lock Lock, ttl time.Duration) *Elector {
+	return &Elector{
+		lock:   lock,
+		period: ttl / 3,
+	}
+}
+
+// Runs the first election, then keeps renewing in the background.
+func (e *Elector) Start() {
+	e.quit = make(chan bool)
+	e.done = make(chan bool)
+
+	e.campaign()
+
+	go e.loop()
+}
+
+// Stops campaigning and releases the lock, so a standby instance can take over right away.
+func (e *Elector) Stop() {
+	close(e.quit)
+	<-e.done
+
+	if err := e.lock.Release(); err != nil {
+		log.Error.Printf("Could not release leader lock: %s", err.Error())
+	}
+
+	e.set(false)
+}
+
+// Whether this instance is the leader.
+func (e *Elector) Leader() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.leader
+}
+
+func (e *Elector) loop() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.quit:
+			return
+		case <-ticker.C:
+			e.campaign()
+		}
+	}
+}
+
+// Acquires or renews the lock. Errors lose the leadership, since the lock may have been taken by another instance.
+func (e *Elector) campaign() {
+	held, err := e.lock.Acquire()
+	if err != nil {
+		log.Error.Printf("Leader election failed: %s", err.Error())
+		held = false
+	}
+
+	e.set(held)
+}
+
+// Sets the leadership, logging transitions.
+func (e *Elector) set(leader bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if leader == e.leader {
+		return
+	}
+
+	e.leader = leader
+	if leader {
+		log.Info.Printf("Became the leader, pushing stats.")
+	} else {
+		log.Info.Printf("Standing by, not pushing stats.")
+	}
+}
+
+// Gate forwards stats to a repository only while the elector is the leader.
+type Gate struct {
+	repo    repo.Interface
+	elector *Elector
+}
+
+// Creates a repository that forwards to the given one while the elector is the leader.
+func NewGate(repository repo.Interface, elector *Elector) *Gate {
+	return &Gate{
+		repo:    repository,
+		elector: elector,
+	}
+}
+
+func (*Gate) Name() string {
+	return "gate"
+}
+
+func (g *Gate) Create(v interface{}) (repo.Interface, error) {
+	return NewGate(g.repo, g.elector), nil
+}
+
+// Pushes the stats only if leader, they are dropped otherwise.
+func (g *Gate) Push(s *stats.Stats) error {
+	if !g.elector.Leader() {
+		return nil
+	}
+
+	return g.repo.Push(s)
+}
+
+// Pushes the event only if leader and the repository can store events.
+func (g *Gate) PushEvent(event *stats.Event) error {
+	pusher, ok := g.repo.(repo.EventPusher)
+	if !ok || !g.elector.Leader() {
+		return nil
+	}
+
+	return pusher.PushEvent(event)
+}
+
+// Pushes the volume only if leader and the repository can store volumes.
+func (g *Gate) PushVolume(volume *stats.Volume) error {
+	pusher, ok := g.repo.(repo.VolumePusher)
+	if !ok || !g.elector.Leader() {
+		return nil
+	}
+
+	return pusher.PushVolume(volume)
+}
+
+// Pushes the processes only if leader and the repository can store processes.
+func (g *Gate) PushProcesses(processes *stats.Processes) error {
+	pusher, ok := g.repo.(repo.ProcessPusher)
+	if !ok || !g.elector.Leader() {
+		return nil
+	}
+
+	return pusher.PushProcesses(processes)
+}
+
+func (g *Gate) Close() {
+	g.repo.Close()
+}
+
+// Clears are always forwarded, a standby may have pushed before losing the leadership.
+func (g *Gate) Clear(name string) {
+	g.repo.Clear(name)
+}
+
+func (g *Gate) ClearHost(host string, name string) {
+	repo.ClearHost(g.repo, host, name)
+}
+
+// Containers are always announced, a standby may take the leadership and push them.
+func (g *Gate) ContainerAdded(container repo.Container) {
+	if lifecycle, ok := g.repo.(repo.Lifecycle); ok {
+		lifecycle.ContainerAdded(container)
+	}
+}
+
+func (g *Gate) ContainerRemoved(container repo.Container) {
+	if lifecycle, ok := g.repo.(repo.Lifecycle); ok {
+		lifecycle.ContainerRemoved(container)
+	}
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package election
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// FileLock is an exclusive lock on a file shared by the instances, released by the system if the holder dies.
+type FileLock struct {
+	path string
+
+	mutex sync.Mutex
+	file  *os.File // open while the lock is held.
+}
+
+// Creates a lock on the file at the given path, which is created if needed.
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path}
+}
+
+func (l *FileLock) Acquire() (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file != nil {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return false, nil
+	}
+	if err != nil {
+		file.Close()
+		return false, err
+	}
+
+	// record the holder, for humans.
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+	l.file = file
+	return true, nil
+}
+
+func (l *FileLock) Release() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	err := l.file.Close()
+	l.file = nil
+
+	return err
+}
@@ -0,0 +1,20 @@
+package election
+
+import (
+	"errors"
+)
+
+// FileLock is not supported on Windows, use a Consul lock instead.
+type FileLock struct{}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{}
+}
+
+func (*FileLock) Acquire() (bool, error) {
+	return false, errors.New("File locks are not supported on Windows.")
+}
+
+func (*FileLock) Release() error {
+	return nil
+}
@@ -0,0 +1,229 @@
+package statspout
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/schedule"
+	"github.com/mijara/statspout/stats"
+)
+
+// Fleet runs a Collector per Docker host, all of them sharing the same options and repository. Hosts can be
+// added and removed while running, and paused selectors apply to every host. Everything pushed is labeled with the
+// name of its host (see stats.LABEL_HOST), since container names are only unique within a host.
+type Fleet struct {
+	options []Option
+
+	mutex      sync.Mutex
+	collectors map[string]*Collector
+	starting   map[string]*Collector // collectors of the hosts being added, until started.
+	endpoints  map[string]backend.Endpoint
+	paused     map[string]bool
+	labels     map[string]map[string]string
+	state      *backend.StateFile
+}
+
+// Creates an empty fleet, the options are given to the Collector of every host.
+func NewFleet(options ...Option) *Fleet {
+	return &Fleet{
+		options:    options,
+		collectors: make(map[string]*Collector),
+		starting:   make(map[string]*Collector),
+		endpoints:  make(map[string]backend.Endpoint),
+		paused:     make(map[string]bool),
+		labels:     make(map[string]map[string]string),
+	}
+}
+
+// Starts collecting the stats of the host at the given endpoint, identified by name. The collector is started
+// without holding the fleet, other hosts can be added, removed and paused meanwhile.
+func (f *Fleet) Add(name string, endpoint backend.Endpoint) error {
+	if name == "" {
+		return errors.New("Host name cannot be empty.")
+	}
+
+	collector, err := f.prepare(name, &endpoint)
+	if err != nil {
+		return err
+	}
+
+	err = collector.Start()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	delete(f.starting, name)
+	if err != nil {
+		return err
+	}
+
+	f.collectors[name] = collector
+	f.endpoints[name] = endpoint
+
+	return nil
+}
+
+// Creates the collector of the host, paused as the fleet, reserving the name until started.
+func (f *Fleet) prepare(name string, endpoint *backend.Endpoint) (*Collector, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.collectors[name]; ok {
+		return nil, errors.New("Host already exists: " + name)
+	}
+	if _, ok := f.starting[name]; ok {
+		return nil, errors.New("Host already exists: " + name)
+	}
+
+	labels := make(map[string]string, len(endpoint.Labels)+len(f.labels[name])+1)
+	for key, value := range endpoint.Labels {
+		labels[key] = value
+	}
+	for key, value := range f.labels[name] {
+		labels[key] = value
+	}
+	labels[stats.LABEL_HOST] = name
+	endpoint.Labels = labels
+
+	options := append(append([]Option(nil), f.options...),
+		WithEndpoint(endpoint.Network, endpoint.Address),
+		WithLabels(endpoint.Labels))
+
+	if f.state != nil {
+		options = append(options, WithState(f.state, name))
+	}
+
+	collector, err := NewCollector(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	for selector := range f.paused {
+		collector.Pause(selector)
+	}
+
+	f.starting[name] = collector
+	return collector, nil
+}
+
+// Keeps the counter baselines of every host in the state file, under the host name. Must be set before adding hosts.
+func (f *Fleet) Persist(state *backend.StateFile) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.state = state
+}
+
+// Sets labels, such as a tenant or environment, for the named host. They are attached to every stat of the host
+// once it is added, taking precedence over the labels of its endpoint. The host label is always its name.
+func (f *Fleet) Label(name string, labels map[string]string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.labels[name] = labels
+}
+
+// Stops collecting the stats of the named host and clears its containers from the repository, the containers of the
+// same name on other hosts are left untouched. Returns false if there is no such host.
+func (f *Fleet) Remove(name string) bool {
+	f.mutex.Lock()
+	collector, ok := f.collectors[name]
+	delete(f.collectors, name)
+	delete(f.endpoints, name)
+	f.mutex.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	collector.Stop()
+	collector.clear()
+
+	return true
+}
+
+// Endpoints of the hosts, by name.
+func (f *Fleet) Hosts() map[string]backend.Endpoint {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	hosts := make(map[string]backend.Endpoint, len(f.endpoints))
+	for name, endpoint := range f.endpoints {
+		hosts[name] = endpoint
+	}
+
+	return hosts
+}
+
+// Excludes the containers matching the selector from collection on every host, see Collector.Pause.
+func (f *Fleet) Pause(selector string) error {
+	if selector == "" {
+		return errors.New("Selector cannot be empty.")
+	}
+
+	if err := schedule.CheckSelector(selector); err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.paused[selector] = true
+	for _, collector := range f.collectors {
+		collector.Pause(selector)
+	}
+	for _, collector := range f.starting {
+		collector.Pause(selector)
+	}
+
+	return nil
+}
+
+// Includes again the containers excluded by the selector, returns false if it was not paused.
+func (f *Fleet) Resume(selector string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.paused[selector] {
+		return false
+	}
+
+	delete(f.paused, selector)
+	for _, collector := range f.collectors {
+		collector.Resume(selector)
+	}
+	for _, collector := range f.starting {
+		collector.Resume(selector)
+	}
+
+	return true
+}
+
+// Selectors currently excluded from collection, sorted.
+func (f *Fleet) Paused() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	selectors := make([]string, 0, len(f.paused))
+	for selector := range f.paused {
+		selectors = append(selectors, selector)
+	}
+	sort.Strings(selectors)
+
+	return selectors
+}
+
+// Stops collecting on every host.
+func (f *Fleet) Stop() {
+	f.mutex.Lock()
+	collectors := f.collectors
+	f.collectors = make(map[string]*Collector)
+	f.endpoints = make(map[string]backend.Endpoint)
+	f.mutex.Unlock()
+
+	for _, collector := range collectors {
+		collector.Stop()
+	}
+}
@@ -0,0 +1,64 @@
+package statspout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/dockertest"
+)
+
+func TestFleetHostsSharingNames(t *testing.T) {
+	east, west := dockertest.NewServer(), dockertest.NewServer()
+	defer east.Close()
+	defer west.Close()
+
+	east.AddContainer("web", nil)
+	west.AddContainer("web", nil)
+
+	recorder := dockertest.NewRecorder()
+
+	fleet := NewFleet(WithInterval(100*time.Millisecond), WithRepo(recorder))
+	defer fleet.Stop()
+
+	if err := fleet.Add("east", backend.Endpoint{Network: east.Network(), Address: east.Address()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fleet.Add("west", backend.Endpoint{Network: west.Network(), Address: west.Address()}); err != nil {
+		t.Fatal(err)
+	}
+
+	sampled := func(r *dockertest.Recorder) bool {
+		return len(r.Samples("east/web")) >= 2 && len(r.Samples("west/web")) >= 2
+	}
+
+	if !recorder.Wait(5*time.Second, sampled) {
+		t.Fatalf("expected samples of web on both hosts, got %d on east and %d on west",
+			len(recorder.Samples("east/web")), len(recorder.Samples("west/web")))
+	}
+
+	for _, host := range []string{"east", "west"} {
+		samples := recorder.Samples(host + "/web")
+		if label := samples[len(samples)-1].Labels["host"]; label != host {
+			t.Errorf("%s: expected the host label, got %q", host, label)
+		}
+	}
+
+	if !fleet.Remove("east") {
+		t.Fatal("expected east to be removed")
+	}
+
+	if cleared := recorder.Cleared(); len(cleared) != 1 || cleared[0] != "east/web" {
+		t.Fatalf("expected web to be cleared on east only, got %v", cleared)
+	}
+
+	// the container of the same name on the other host is still collected.
+	before := len(recorder.Samples("west/web"))
+	more := func(r *dockertest.Recorder) bool {
+		return len(r.Samples("west/web")) > before
+	}
+
+	if !recorder.Wait(5*time.Second, more) {
+		t.Fatal("expected web to be still collected on west")
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Package gossip lets statspout instances discover each other through a gossip protocol (memberlist), keeping the shard
+ring up to date with the live instances, so containers are split among them and rebalanced when one dies, without
+external coordination services.
+
+Example
+
+	ring := shard.New("statspout-1", []string{"statspout-1"})
+
+	cluster, err := gossip.New("statspout-1", "0.0.0.0:7946", []string{"10.0.0.2:7946"}, ring)
+	if err != nil {
+		...
+	}
+
+	cluster.Start()
+	defer cluster.Stop()
+*/
+package gossip
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/shard"
+)
+
+// Port used when the bind address doesn't give one.
+const DEFAULT_PORT = 7946
+
+// Time between attempts to join the seeds, while no other member is known.
+const JOIN_RETRY = 10 * time.Second
+
+// Time given to the other members to learn that this instance is leaving.
+const LEAVE_TIMEOUT = 5 * time.Second
+
+// Cluster of statspout instances, updating a shard ring as members join and leave.
+type Cluster struct {
+	name  string
+	host  string
+	port  int
+	seeds []string
+	ring  *shard.Ring
+
+	list *memberlist.Memberlist
+	done chan struct{}
+
+	mutex   sync.Mutex
+	members map[string]bool
+}
+
+// Creates a cluster member named name, gossiping on the bind address (host:port, or host alone to use the default
+// port) and joining the members at the seed addresses. Members are kept on the given ring.
+func New(name string, bind string, seeds []string, ring *shard.Ring) (*Cluster, error) {
+	if name == "" {
+		return nil, errors.New("Member name cannot be empty.")
+	}
+
+	host, port, err := splitAddress(bind)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cluster{
+		name:    name,
+		host:    host,
+		port:    port,
+		seeds:   seeds,
+		ring:    ring,
+		members: map[string]bool{name: true},
+	}, nil
+}
+
+// Starts gossiping and joins the seeds. If no seed can be reached, this instance keeps running on its own and
+// retries in the background, since the seeds may not be up yet.
+func (c *Cluster) Start() error {
+	conf := memberlist.DefaultLANConfig()
+	conf.Name = c.name
+	conf.BindAddr = c.host
+	conf.BindPort = c.port
+	conf.Events = &events{cluster: c}
+	conf.Logger = log.Debug
+
+	list, err := memberlist.Create(conf)
+	if err != nil {
+		return err
+	}
+
+	c.list = list
+	c.done = make(chan struct{})
+
+	if len(c.seeds) > 0 && !c.join() {
+		go c.retry()
+	}
+
+	return nil
+}
+
+// Leaves the cluster, the other members take over the containers of this instance.
+func (c *Cluster) Stop() {
+	if c.list == nil {
+		return
+	}
+
+	close(c.done)
+
+	if err := c.list.Leave(LEAVE_TIMEOUT); err != nil {
+		log.Warning.Println(err)
+	}
+
+	c.list.Shutdown()
+}
+
+// Names of the live members, sorted.
+func (c *Cluster) Members() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	names := make([]string, 0, len(c.members))
+	for name := range c.members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Joins the seeds, returns false if none was reached.
+func (c *Cluster) join() bool {
+	if _, err := c.list.Join(c.seeds); err != nil {
+		log.Warning.Printf("Cannot join the cluster at %s: %s", strings.Join(c.seeds, ", "), err.Error())
+		return false
+	}
+
+	return true
+}
+
+// Retries joining the seeds until another member is known.
+func (c *Cluster) retry() {
+	ticker := time.NewTicker(JOIN_RETRY)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+
+		case <-ticker.C:
+			if len(c.Members()) > 1 || c.join() {
+				return
+			}
+		}
+	}
+}
+
+// Adds or removes a member and updates the ring.
+func (c *Cluster) update(name string, alive bool) {
+	c.mutex.Lock()
+	if alive {
+		c.members[name] = true
+	} else if name != c.name {
+		delete(c.members, name)
+	}
+	c.mutex.Unlock()
+
+	members := c.Members()
+	c.ring.SetMembers(members)
+
+	log.Info.Printf("Cluster members: %s", strings.Join(members, ", "))
+}
+
+// Receives the membership changes. Memberlist may hold its own locks while notifying, so the members are tracked
+// here instead of asking the list.
+type events struct {
+	cluster *Cluster
+}
+
+func (e *events) NotifyJoin(node *memberlist.Node) {
+	e.cluster.update(node.Name, true)
+}
+
+func (e *events) NotifyLeave(node *memberlist.Node) {
+	e.cluster.update(node.Name, false)
+}
+
+func (e *events) NotifyUpdate(node *memberlist.Node) {
+}
+
+// Splits host:port, using the default port if not given.
+func splitAddress(address string) (string, int, error) {
+	if address == "" {
+		return "", 0, errors.New("Gossip address cannot be empty.")
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, DEFAULT_PORT, nil
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return "", 0, errors.New("Invalid gossip port: " + port)
+	}
+
+	if host == "" {
+		host = "0.0.0.0"
+	}
+
+	return host, n, nil
+}
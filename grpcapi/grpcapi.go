@@ -0,0 +1,153 @@
+/*
+gRPC API:
+Streams samples to subscribed clients as soon as they are collected, see statspout.proto for the service
+definition. Messages are encoded without generated code, so clients in any language can be generated
+from statspout.proto. The tests check the messages against it, field by field.
+*/
+package grpcapi
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/mijara/statspout/api"
+	"github.com/mijara/statspout/log"
+)
+
+// Server of the Statspout service, fed by a hub.
+type Server struct {
+	hub      *api.Hub
+	address  string
+	server   *grpc.Server
+	listener net.Listener
+	tls      *tls.Config
+}
+
+// Message that knows its own protocol buffers encoding.
+type message interface {
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+// Codec for the messages of this package, registered under the name of the standard proto codec
+// so clients see a regular application/grpc+proto service.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("grpcapi: cannot marshal %T", v)
+	}
+	return m.Marshal()
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(message)
+	if !ok {
+		return fmt.Errorf("grpcapi: cannot unmarshal %T", v)
+	}
+	return m.Unmarshal(data)
+}
+
+func (codec) Name() string {
+	return "proto"
+}
+
+// Implemented by the service handler, as generated code would.
+type statspoutServer interface {
+	subscribe(req *SubscribeRequest, stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "statspout.v1.Statspout",
+	HandlerType: (*statspoutServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       subscribeHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "grpcapi/statspout.proto",
+}
+
+func subscribeHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := &SubscribeRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+
+	return srv.(statspoutServer).subscribe(req, stream)
+}
+
+// Creates the server, which will stream the samples pushed to the hub.
+func New(address string, hub *api.Hub) *Server {
+	return &Server{
+		hub:     hub,
+		address: address,
+	}
+}
+
+// Serves over TLS with the given configuration, which may require client certificates. Must be called before Start.
+func (s *Server) UseTLS(config *tls.Config) {
+	s.tls = config
+}
+
+// Starts serving in the background.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+
+	s.listener = listener
+	options := []grpc.ServerOption{grpc.ForceServerCodec(codec{})}
+	if s.tls != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(s.tls)))
+	}
+
+	s.server = grpc.NewServer(options...)
+	s.server.RegisterService(&serviceDesc, s)
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil {
+			log.Error.Printf("gRPC server stopped: %s", err.Error())
+		}
+	}()
+
+	log.Info.Printf("gRPC API listening on %s", s.address)
+
+	return nil
+}
+
+// Stops the server, closing every stream.
+func (s *Server) Close() {
+	if s.server != nil {
+		s.server.Stop()
+	}
+}
+
+// Streams samples until the client goes away or the hub is closed.
+func (s *Server) subscribe(req *SubscribeRequest, stream grpc.ServerStream) error {
+	sub := s.hub.Subscribe(&api.Filter{Names: req.Names, Labels: req.Labels})
+	defer sub.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case sample, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+
+			if err := stream.SendMsg(FromStats(sample)); err != nil {
+				return err
+			}
+		}
+	}
+}
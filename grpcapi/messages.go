@@ -0,0 +1,504 @@
+package grpcapi
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/mijara/statspout/stats"
+)
+
+// Messages of statspout.proto.
+
+type SubscribeRequest struct {
+	Names  []string
+	Labels map[string]string
+}
+
+type Stats struct {
+	Timestamp        int64
+	Name             string
+	CpuPercent       float64
+	MemoryUsage      uint64
+	MemoryPercent    float64
+	TxBytesTotal     uint64
+	RxBytesTotal     uint64
+	Labels           map[string]string
+	Id               string
+	TxPacketsTotal   uint64
+	RxPacketsTotal   uint64
+	TxErrorsTotal    uint64
+	RxErrorsTotal    uint64
+	TxDroppedTotal   uint64
+	RxDroppedTotal   uint64
+	MemoryFailcnt    uint64
+	SwapUsage        uint64
+	SwapLimit        uint64
+	CpuLimit         float64
+	CpuShares        uint64
+	MemoryLimit      uint64
+	MemoryLimited    bool
+	Image            string
+	ImageCreated     int64
+	ImageAgeDays     float64
+	OpenFds          uint64
+	FdLimit          uint64
+	Tcp              *Connections
+	RestartPolicy    string
+	Privileged       bool
+	NetworkMode      string
+	BlkioServiceTime uint64
+	BlkioServiced    uint64
+	BlkioQueue       uint64
+	BlkioReadBytes   uint64
+	BlkioWriteBytes  uint64
+	BlkioReads       uint64
+	BlkioWrites      uint64
+	BlkioReadBps     float64
+	BlkioWriteBps    float64
+	BlkioReadIops    float64
+	BlkioWriteIops   float64
+	Interfaces       map[string]*Interface
+}
+
+type Interface struct {
+	TxBytes   uint64
+	RxBytes   uint64
+	TxPackets uint64
+	RxPackets uint64
+	TxErrors  uint64
+	RxErrors  uint64
+	TxDropped uint64
+	RxDropped uint64
+}
+
+type Connections struct {
+	Established uint64
+	TimeWait    uint64
+}
+
+func (m *SubscribeRequest) Marshal() ([]byte, error) {
+	var b []byte
+	for _, name := range m.Names {
+		b = appendBytes(b, 1, []byte(name))
+	}
+	b = appendMap(b, 2, m.Labels)
+
+	return b, nil
+}
+
+func (m *SubscribeRequest) Unmarshal(b []byte) error {
+	*m = SubscribeRequest{Labels: map[string]string{}}
+
+	return readFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			m.Names = append(m.Names, string(f.bytes))
+		case 2:
+			return readEntry(f.bytes, m.Labels)
+		}
+		return nil
+	})
+}
+
+func (m *Stats) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendUint(b, 1, uint64(m.Timestamp))
+	b = appendString(b, 2, m.Name)
+	b = appendDouble(b, 3, m.CpuPercent)
+	b = appendUint(b, 4, m.MemoryUsage)
+	b = appendDouble(b, 5, m.MemoryPercent)
+	b = appendUint(b, 6, m.TxBytesTotal)
+	b = appendUint(b, 7, m.RxBytesTotal)
+	b = appendMap(b, 8, m.Labels)
+	b = appendString(b, 9, m.Id)
+	b = appendUint(b, 10, m.TxPacketsTotal)
+	b = appendUint(b, 11, m.RxPacketsTotal)
+	b = appendUint(b, 12, m.TxErrorsTotal)
+	b = appendUint(b, 13, m.RxErrorsTotal)
+	b = appendUint(b, 14, m.TxDroppedTotal)
+	b = appendUint(b, 15, m.RxDroppedTotal)
+	b = appendUint(b, 16, m.MemoryFailcnt)
+	b = appendUint(b, 17, m.SwapUsage)
+	b = appendUint(b, 18, m.SwapLimit)
+	b = appendDouble(b, 19, m.CpuLimit)
+	b = appendUint(b, 20, m.CpuShares)
+	b = appendUint(b, 21, m.MemoryLimit)
+	b = appendBool(b, 22, m.MemoryLimited)
+	b = appendString(b, 23, m.Image)
+	b = appendUint(b, 24, uint64(m.ImageCreated))
+	b = appendDouble(b, 25, m.ImageAgeDays)
+	b = appendUint(b, 26, m.OpenFds)
+	b = appendUint(b, 27, m.FdLimit)
+	if m.Tcp != nil {
+		b = appendBytes(b, 28, m.Tcp.marshal())
+	}
+	b = appendString(b, 29, m.RestartPolicy)
+	b = appendBool(b, 30, m.Privileged)
+	b = appendString(b, 31, m.NetworkMode)
+	b = appendUint(b, 32, m.BlkioServiceTime)
+	b = appendUint(b, 33, m.BlkioServiced)
+	b = appendUint(b, 34, m.BlkioQueue)
+	b = appendUint(b, 35, m.BlkioReadBytes)
+	b = appendUint(b, 36, m.BlkioWriteBytes)
+	b = appendUint(b, 37, m.BlkioReads)
+	b = appendUint(b, 38, m.BlkioWrites)
+	b = appendDouble(b, 39, m.BlkioReadBps)
+	b = appendDouble(b, 40, m.BlkioWriteBps)
+	b = appendDouble(b, 41, m.BlkioReadIops)
+	b = appendDouble(b, 42, m.BlkioWriteIops)
+
+	names := make([]string, 0, len(m.Interfaces))
+	for name := range m.Interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var entry []byte
+		entry = appendString(entry, 1, name)
+		entry = appendBytes(entry, 2, m.Interfaces[name].marshal())
+		b = appendBytes(b, 43, entry)
+	}
+
+	return b, nil
+}
+
+func (m *Stats) Unmarshal(b []byte) error {
+	*m = Stats{Labels: map[string]string{}}
+
+	return readFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			m.Timestamp = int64(f.varint)
+		case 2:
+			m.Name = string(f.bytes)
+		case 3:
+			m.CpuPercent = math.Float64frombits(f.varint)
+		case 4:
+			m.MemoryUsage = f.varint
+		case 5:
+			m.MemoryPercent = math.Float64frombits(f.varint)
+		case 6:
+			m.TxBytesTotal = f.varint
+		case 7:
+			m.RxBytesTotal = f.varint
+		case 8:
+			return readEntry(f.bytes, m.Labels)
+		case 9:
+			m.Id = string(f.bytes)
+		case 10:
+			m.TxPacketsTotal = f.varint
+		case 11:
+			m.RxPacketsTotal = f.varint
+		case 12:
+			m.TxErrorsTotal = f.varint
+		case 13:
+			m.RxErrorsTotal = f.varint
+		case 14:
+			m.TxDroppedTotal = f.varint
+		case 15:
+			m.RxDroppedTotal = f.varint
+		case 16:
+			m.MemoryFailcnt = f.varint
+		case 17:
+			m.SwapUsage = f.varint
+		case 18:
+			m.SwapLimit = f.varint
+		case 19:
+			m.CpuLimit = math.Float64frombits(f.varint)
+		case 20:
+			m.CpuShares = f.varint
+		case 21:
+			m.MemoryLimit = f.varint
+		case 22:
+			m.MemoryLimited = f.varint != 0
+		case 23:
+			m.Image = string(f.bytes)
+		case 24:
+			m.ImageCreated = int64(f.varint)
+		case 25:
+			m.ImageAgeDays = math.Float64frombits(f.varint)
+		case 26:
+			m.OpenFds = f.varint
+		case 27:
+			m.FdLimit = f.varint
+		case 28:
+			m.Tcp = &Connections{}
+			return m.Tcp.unmarshal(f.bytes)
+		case 29:
+			m.RestartPolicy = string(f.bytes)
+		case 30:
+			m.Privileged = f.varint != 0
+		case 31:
+			m.NetworkMode = string(f.bytes)
+		case 32:
+			m.BlkioServiceTime = f.varint
+		case 33:
+			m.BlkioServiced = f.varint
+		case 34:
+			m.BlkioQueue = f.varint
+		case 35:
+			m.BlkioReadBytes = f.varint
+		case 36:
+			m.BlkioWriteBytes = f.varint
+		case 37:
+			m.BlkioReads = f.varint
+		case 38:
+			m.BlkioWrites = f.varint
+		case 39:
+			m.BlkioReadBps = math.Float64frombits(f.varint)
+		case 40:
+			m.BlkioWriteBps = math.Float64frombits(f.varint)
+		case 41:
+			m.BlkioReadIops = math.Float64frombits(f.varint)
+		case 42:
+			m.BlkioWriteIops = math.Float64frombits(f.varint)
+		case 43:
+			return m.readInterface(f.bytes)
+		}
+		return nil
+	})
+}
+
+// Reads an entry of the interfaces map.
+func (m *Stats) readInterface(b []byte) error {
+	var name string
+	i := &Interface{}
+
+	err := readFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			name = string(f.bytes)
+		case 2:
+			return i.unmarshal(f.bytes)
+		}
+		return nil
+	})
+
+	if m.Interfaces == nil {
+		m.Interfaces = map[string]*Interface{}
+	}
+	m.Interfaces[name] = i
+	return err
+}
+
+func (m *Interface) marshal() []byte {
+	var b []byte
+	b = appendUint(b, 1, m.TxBytes)
+	b = appendUint(b, 2, m.RxBytes)
+	b = appendUint(b, 3, m.TxPackets)
+	b = appendUint(b, 4, m.RxPackets)
+	b = appendUint(b, 5, m.TxErrors)
+	b = appendUint(b, 6, m.RxErrors)
+	b = appendUint(b, 7, m.TxDropped)
+	b = appendUint(b, 8, m.RxDropped)
+
+	return b
+}
+
+func (m *Interface) unmarshal(b []byte) error {
+	return readFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			m.TxBytes = f.varint
+		case 2:
+			m.RxBytes = f.varint
+		case 3:
+			m.TxPackets = f.varint
+		case 4:
+			m.RxPackets = f.varint
+		case 5:
+			m.TxErrors = f.varint
+		case 6:
+			m.RxErrors = f.varint
+		case 7:
+			m.TxDropped = f.varint
+		case 8:
+			m.RxDropped = f.varint
+		}
+		return nil
+	})
+}
+
+func fromInterfaces(interfaces map[string]stats.Interface) map[string]*Interface {
+	if interfaces == nil {
+		return nil
+	}
+
+	result := make(map[string]*Interface, len(interfaces))
+	for name, i := range interfaces {
+		result[name] = &Interface{
+			TxBytes: i.TxBytes, RxBytes: i.RxBytes, TxPackets: i.TxPackets, RxPackets: i.RxPackets,
+			TxErrors: i.TxErrors, RxErrors: i.RxErrors, TxDropped: i.TxDropped, RxDropped: i.RxDropped,
+		}
+	}
+	return result
+}
+
+func toInterfaces(interfaces map[string]*Interface) map[string]stats.Interface {
+	if interfaces == nil {
+		return nil
+	}
+
+	result := make(map[string]stats.Interface, len(interfaces))
+	for name, i := range interfaces {
+		result[name] = stats.Interface{
+			TxBytes: i.TxBytes, RxBytes: i.RxBytes, TxPackets: i.TxPackets, RxPackets: i.RxPackets,
+			TxErrors: i.TxErrors, RxErrors: i.RxErrors, TxDropped: i.TxDropped, RxDropped: i.RxDropped,
+		}
+	}
+	return result
+}
+
+func (m *Connections) marshal() []byte {
+	var b []byte
+	b = appendUint(b, 1, m.Established)
+	b = appendUint(b, 2, m.TimeWait)
+
+	return b
+}
+
+func (m *Connections) unmarshal(b []byte) error {
+	return readFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			m.Established = f.varint
+		case 2:
+			m.TimeWait = f.varint
+		}
+		return nil
+	})
+}
+
+func fromConnections(c *stats.Connections) *Connections {
+	if c == nil {
+		return nil
+	}
+
+	return &Connections{Established: c.Established, TimeWait: c.TimeWait}
+}
+
+func (m *Connections) toConnections() *stats.Connections {
+	if m == nil {
+		return nil
+	}
+
+	return &stats.Connections{Established: m.Established, TimeWait: m.TimeWait}
+}
+
+// Converts a sample into its message.
+func FromStats(s *stats.Stats) *Stats {
+	return &Stats{
+		Timestamp:        s.Timestamp.UnixNano(),
+		Name:             s.Name,
+		CpuPercent:       s.CpuPercent,
+		MemoryUsage:      s.MemoryUsage,
+		MemoryPercent:    s.MemoryPercent,
+		TxBytesTotal:     s.TxBytesTotal,
+		RxBytesTotal:     s.RxBytesTotal,
+		Labels:           s.Labels,
+		Id:               s.ID,
+		TxPacketsTotal:   s.TxPacketsTotal,
+		RxPacketsTotal:   s.RxPacketsTotal,
+		TxErrorsTotal:    s.TxErrorsTotal,
+		RxErrorsTotal:    s.RxErrorsTotal,
+		TxDroppedTotal:   s.TxDroppedTotal,
+		RxDroppedTotal:   s.RxDroppedTotal,
+		MemoryFailcnt:    s.MemoryFailcnt,
+		SwapUsage:        s.SwapUsage,
+		SwapLimit:        s.SwapLimit,
+		CpuLimit:         s.CpuLimit,
+		CpuShares:        s.CpuShares,
+		MemoryLimit:      s.MemoryLimit,
+		MemoryLimited:    s.MemoryLimited,
+		Image:            s.Image,
+		ImageCreated:     imageCreated(s.ImageCreated),
+		ImageAgeDays:     s.ImageAgeDays,
+		OpenFds:          s.OpenFds,
+		FdLimit:          s.FdLimit,
+		Tcp:              fromConnections(s.Tcp),
+		RestartPolicy:    s.RestartPolicy,
+		Privileged:       s.Privileged,
+		NetworkMode:      s.NetworkMode,
+		BlkioServiceTime: s.BlkioServiceTime,
+		BlkioServiced:    s.BlkioServiced,
+		BlkioQueue:       s.BlkioQueue,
+		BlkioReadBytes:   s.BlkioReadBytes,
+		BlkioWriteBytes:  s.BlkioWriteBytes,
+		BlkioReads:       s.BlkioReads,
+		BlkioWrites:      s.BlkioWrites,
+		BlkioReadBps:     s.BlkioReadBps,
+		BlkioWriteBps:    s.BlkioWriteBps,
+		BlkioReadIops:    s.BlkioReadIops,
+		BlkioWriteIops:   s.BlkioWriteIops,
+		Interfaces:       fromInterfaces(s.Interfaces),
+	}
+}
+
+// Converts the message back into a sample.
+func (m *Stats) ToStats() *stats.Stats {
+	return &stats.Stats{
+		Timestamp:        time.Unix(0, m.Timestamp),
+		Name:             m.Name,
+		CpuPercent:       m.CpuPercent,
+		MemoryUsage:      m.MemoryUsage,
+		MemoryPercent:    m.MemoryPercent,
+		TxBytesTotal:     m.TxBytesTotal,
+		RxBytesTotal:     m.RxBytesTotal,
+		TxPacketsTotal:   m.TxPacketsTotal,
+		RxPacketsTotal:   m.RxPacketsTotal,
+		TxErrorsTotal:    m.TxErrorsTotal,
+		RxErrorsTotal:    m.RxErrorsTotal,
+		TxDroppedTotal:   m.TxDroppedTotal,
+		RxDroppedTotal:   m.RxDroppedTotal,
+		MemoryFailcnt:    m.MemoryFailcnt,
+		SwapUsage:        m.SwapUsage,
+		SwapLimit:        m.SwapLimit,
+		CpuLimit:         m.CpuLimit,
+		CpuShares:        m.CpuShares,
+		MemoryLimit:      m.MemoryLimit,
+		MemoryLimited:    m.MemoryLimited,
+		Image:            m.Image,
+		ImageCreated:     imageTime(m.ImageCreated),
+		ImageAgeDays:     m.ImageAgeDays,
+		OpenFds:          m.OpenFds,
+		FdLimit:          m.FdLimit,
+		Tcp:              m.Tcp.toConnections(),
+		RestartPolicy:    m.RestartPolicy,
+		Privileged:       m.Privileged,
+		NetworkMode:      m.NetworkMode,
+		BlkioServiceTime: m.BlkioServiceTime,
+		BlkioServiced:    m.BlkioServiced,
+		BlkioQueue:       m.BlkioQueue,
+		BlkioReadBytes:   m.BlkioReadBytes,
+		BlkioWriteBytes:  m.BlkioWriteBytes,
+		BlkioReads:       m.BlkioReads,
+		BlkioWrites:      m.BlkioWrites,
+		BlkioReadBps:     m.BlkioReadBps,
+		BlkioWriteBps:    m.BlkioWriteBps,
+		BlkioReadIops:    m.BlkioReadIops,
+		BlkioWriteIops:   m.BlkioWriteIops,
+		Interfaces:       toInterfaces(m.Interfaces),
+		Labels:           m.Labels,
+		ID:               m.Id,
+	}
+}
+
+// Nanoseconds since the epoch of the image creation time, 0 if unknown.
+func imageCreated(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+// Image creation time of the nanoseconds since the epoch, zero if unknown.
+func imageTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nanos)
+}
@@ -0,0 +1,274 @@
+package grpcapi
+
+import (
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// Field of a message of statspout.proto.
+type protoField struct {
+	number int
+	kind   string // scalar type, message name, "repeated <type>" or "map<key,value>".
+}
+
+var (
+	protoPackage = regexp.MustCompile(`(?m)^package\s+([\w.]+);`)
+	protoService = regexp.MustCompile(`(?s)service\s+(\w+)\s*\{(.*?)\n\}`)
+	protoRPC     = regexp.MustCompile(`rpc\s+(\w+)\s*\(\s*(\w+)\s*\)\s*returns\s*\(\s*(stream\s+)?(\w+)\s*\)`)
+	protoMessage = regexp.MustCompile(`(?s)message\s+(\w+)\s*\{(.*?)\n\}`)
+	protoLine    = regexp.MustCompile(`(?m)^\s*(repeated\s+)?(map\s*<\s*\w+\s*,\s*\w+\s*>|\w+)\s+(\w+)\s*=\s*(\d+)\s*;`)
+)
+
+// Reads the messages of statspout.proto, by name and field name.
+func readProto(t *testing.T) (string, map[string]map[string]protoField) {
+	data, err := os.ReadFile("statspout.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := string(data)
+
+	messages := make(map[string]map[string]protoField)
+	for _, message := range protoMessage.FindAllStringSubmatch(source, -1) {
+		fields := make(map[string]protoField)
+
+		for _, line := range protoLine.FindAllStringSubmatch(message[2], -1) {
+			number, _ := strconv.Atoi(line[4])
+
+			kind := strings.Join(strings.Fields(line[2]), "")
+			if line[1] != "" {
+				kind = "repeated " + kind
+			}
+
+			fields[line[3]] = protoField{number: number, kind: kind}
+		}
+
+		messages[message[1]] = fields
+	}
+
+	return source, messages
+}
+
+// Name of the field in statspout.proto, the snake case of the Go name.
+func snake(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// Type of the field in statspout.proto for the Go type.
+func protoKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Uint32:
+		return "uint32"
+	case reflect.Uint64:
+		return "uint64"
+	case reflect.Int64:
+		return "int64"
+	case reflect.Float64:
+		return "double"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Slice:
+		return "repeated " + protoKind(t.Elem())
+	case reflect.Map:
+		return "map<" + protoKind(t.Key()) + "," + protoKind(t.Elem()) + ">"
+	case reflect.Ptr:
+		return t.Elem().Name()
+	}
+
+	return t.String()
+}
+
+// Wire type the field is encoded with.
+func wireOf(t reflect.Type) int {
+	switch t.Kind() {
+	case reflect.Uint32, reflect.Uint64, reflect.Int64, reflect.Bool:
+		return wireVarint
+	case reflect.Float64:
+		return wireFixed64
+	}
+
+	return wireBytes
+}
+
+// Sets the value to a non-zero one, the largest for 32 bits so truncations show.
+func fill(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Uint32:
+		v.SetUint(math.MaxUint32)
+	case reflect.Uint64:
+		v.SetUint(1<<40 + 1)
+	case reflect.Int64:
+		v.SetInt(1<<40 + 3)
+	case reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.String:
+		v.SetString("value")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Slice:
+		v.Set(reflect.ValueOf([]string{"a", "b"}))
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		value := reflect.New(v.Type().Elem()).Elem()
+		fill(value)
+		m.SetMapIndex(reflect.ValueOf("key"), value)
+		v.Set(m)
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		for i := 0; i < p.Elem().NumField(); i++ {
+			fill(p.Elem().Field(i))
+		}
+		v.Set(p)
+	}
+}
+
+func encode(t *testing.T, m interface{}) []byte {
+	switch m := m.(type) {
+	case *SubscribeRequest:
+		b, err := m.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	case *Stats:
+		b, err := m.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	case *Interface:
+		return m.marshal()
+	case *Connections:
+		return m.marshal()
+	}
+
+	t.Fatalf("unknown message %T", m)
+	return nil
+}
+
+func decode(t *testing.T, b []byte, m interface{}) {
+	var err error
+	switch m := m.(type) {
+	case *SubscribeRequest:
+		err = m.Unmarshal(b)
+	case *Stats:
+		err = m.Unmarshal(b)
+	case *Interface:
+		err = m.unmarshal(b)
+	case *Connections:
+		err = m.unmarshal(b)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Every field of every message is encoded with the number and wire type of statspout.proto, and decoded back.
+func TestMessagesMatchProto(t *testing.T) {
+	_, messages := readProto(t)
+
+	for _, message := range []interface{}{&SubscribeRequest{}, &Stats{}, &Interface{}, &Connections{}} {
+		typ := reflect.TypeOf(message).Elem()
+
+		fields, ok := messages[typ.Name()]
+		if !ok {
+			t.Errorf("message %s is not in statspout.proto", typ.Name())
+			continue
+		}
+
+		seen := make(map[string]bool)
+
+		for i := 0; i < typ.NumField(); i++ {
+			goField := typ.Field(i)
+			name := snake(goField.Name)
+			seen[name] = true
+
+			expected, ok := fields[name]
+			if !ok {
+				t.Errorf("%s.%s is not in statspout.proto", typ.Name(), name)
+				continue
+			}
+
+			if kind := protoKind(goField.Type); kind != expected.kind {
+				t.Errorf("%s.%s: statspout.proto has %s, the message %s", typ.Name(), name, expected.kind, kind)
+				continue
+			}
+
+			original := reflect.New(typ)
+			fill(original.Elem().Field(i))
+			b := encode(t, original.Interface())
+
+			err := readFields(b, func(f field) error {
+				if f.number != expected.number || f.wire != wireOf(goField.Type) {
+					t.Errorf("%s.%s: encoded as field %d with wire type %d, statspout.proto has %d with %d",
+						typ.Name(), name, f.number, f.wire, expected.number, wireOf(goField.Type))
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded := reflect.New(typ)
+			decode(t, b, decoded.Interface())
+
+			value, actual := original.Elem().Field(i).Interface(), decoded.Elem().Field(i).Interface()
+			if !reflect.DeepEqual(value, actual) {
+				t.Errorf("%s.%s: expected %v back, got %v", typ.Name(), name, value, actual)
+			}
+		}
+
+		for name := range fields {
+			if !seen[name] {
+				t.Errorf("%s.%s of statspout.proto is missing from the message", typ.Name(), name)
+			}
+		}
+	}
+}
+
+// The service registered is the one of statspout.proto.
+func TestServiceMatchesProto(t *testing.T) {
+	source, _ := readProto(t)
+
+	pkg := protoPackage.FindStringSubmatch(source)
+	service := protoService.FindStringSubmatch(source)
+	if pkg == nil || service == nil {
+		t.Fatal("no package or service in statspout.proto")
+	}
+
+	if name := pkg[1] + "." + service[1]; serviceDesc.ServiceName != name {
+		t.Errorf("service: expected %s, got %s", name, serviceDesc.ServiceName)
+	}
+
+	rpcs := protoRPC.FindAllStringSubmatch(service[2], -1)
+	if len(rpcs) != len(serviceDesc.Streams) {
+		t.Fatalf("expected %d methods, got %d", len(rpcs), len(serviceDesc.Streams))
+	}
+
+	for i, rpc := range rpcs {
+		stream := serviceDesc.Streams[i]
+		if stream.StreamName != rpc[1] || stream.ServerStreams != (rpc[3] != "") {
+			t.Errorf("method %s: registered as %s, server streams: %t", rpc[1], stream.StreamName,
+				stream.ServerStreams)
+		}
+
+		if rpc[2] != "SubscribeRequest" || rpc[4] != "Stats" {
+			t.Errorf("method %s: expected SubscribeRequest and Stats, got %s and %s", rpc[1], rpc[2], rpc[4])
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package log
 
 import (
+	"io"
 	"log"
 	"os"
 )
@@ -18,3 +19,19 @@ func init() {
 	Debug   = log.New(os.Stdout, "DEBUG: ", log.LstdFlags|log.Lmicroseconds)
 	Warning = log.New(os.Stdout, "WARNING: ", log.LstdFlags|log.Lmicroseconds)
 }
+
+// Redirects every logger to w, for example to keep them from drawing over a terminal UI. Returns a
+// function restoring the standard outputs.
+func Redirect(w io.Writer) func() {
+	Info.SetOutput(w)
+	Error.SetOutput(w)
+	Debug.SetOutput(w)
+	Warning.SetOutput(w)
+
+	return func() {
+		Info.SetOutput(os.Stdout)
+		Error.SetOutput(os.Stderr)
+		Debug.SetOutput(os.Stdout)
+		Warning.SetOutput(os.Stdout)
+	}
+}
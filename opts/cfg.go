@@ -37,3 +37,15 @@ func (cfg *Config) AddRepository(repo repo.Interface, options interface{}) {
 		Options: options,
 	}
 }
+
+// Adds every registered repository (see repo.Register), creating their options.
+func (cfg *Config) AddRegistered() {
+	for _, r := range repo.Registered() {
+		var options interface{}
+		if r.Options != nil {
+			options = r.Options()
+		}
+
+		cfg.AddRepository(r.Repository, options)
+	}
+}
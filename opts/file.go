@@ -0,0 +1,128 @@
+package opts
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/schedule"
+)
+
+// Configuration file given with the config flag, as JSON.
+//
+// Example
+//
+//	{
+//	    "intervals": [
+//	        {"selector": "*-canary", "interval": "2s"},
+//	        {"selector": "tier=batch", "interval": "60s"}
+//	    ],
+//	    "windows": [
+//	        {"selector": "tier=batch", "when": "mon-fri 09:00-18:00", "timezone": "Europe/Madrid"}
+//	    ],
+//	    "hosts": [
+//	        {"name": "default", "labels": {"tenant": "platform"}},
+//	        {"name": "team-a", "endpoint": "tcp://10.0.0.1:2375", "labels": {"tenant": "team-a"}}
+//	    ]
+//	}
+type File struct {
+	// Polling interval overrides, the first matching selector wins.
+	Intervals []struct {
+		Selector string `json:"selector"`
+		Interval string `json:"interval"`
+	} `json:"intervals"`
+
+	// Times the containers matching the selectors are collected, always if none matches.
+	Windows []struct {
+		Selector string `json:"selector"`
+		When     string `json:"when"`
+		Timezone string `json:"timezone"`
+	} `json:"windows"`
+
+	// Docker hosts and their labels.
+	Hosts []struct {
+		Name     string            `json:"name"`
+		Endpoint string            `json:"endpoint"`
+		Labels   map[string]string `json:"labels"`
+	} `json:"hosts"`
+}
+
+// Host of the configuration file. Hosts with an endpoint are collected from the start, otherwise the labels apply
+// to the host of the same name, such as the one given by the mode (named default) or a discovered one.
+type Host struct {
+	Name     string
+	Endpoint *backend.Endpoint // nil if not given.
+	Labels   map[string]string
+}
+
+// Reads the configuration file at the given path.
+func LoadFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file := &File{}
+	if err := json.NewDecoder(f).Decode(file); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Parses the hosts entries.
+func (file *File) ParseHosts() ([]Host, error) {
+	hosts := make([]Host, 0, len(file.Hosts))
+
+	for _, entry := range file.Hosts {
+		host := Host{Name: entry.Name, Labels: entry.Labels}
+
+		if entry.Endpoint != "" {
+			endpoint, err := backend.ParseEndpoint(entry.Endpoint)
+			if err != nil {
+				return nil, err
+			}
+			host.Endpoint = &endpoint
+		}
+
+		hosts = append(hosts, host)
+	}
+
+	return hosts, nil
+}
+
+// Parses the interval overrides into scheduler rules.
+func (file *File) Rules() ([]schedule.Rule, error) {
+	rules := make([]schedule.Rule, 0, len(file.Intervals))
+
+	for _, entry := range file.Intervals {
+		interval, err := schedule.ParseInterval(entry.Interval)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, schedule.Rule{
+			Selector: entry.Selector,
+			Interval: interval,
+		})
+	}
+
+	return rules, nil
+}
+
+// Parses the collection windows.
+func (file *File) ParseWindows() ([]schedule.Window, error) {
+	windows := make([]schedule.Window, 0, len(file.Windows))
+
+	for _, entry := range file.Windows {
+		window, err := schedule.ParseWindow(entry.Selector, entry.When, entry.Timezone)
+		if err != nil {
+			return nil, err
+		}
+
+		windows = append(windows, window)
+	}
+
+	return windows, nil
+}
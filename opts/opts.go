@@ -3,19 +3,49 @@ package opts
 import (
 	"errors"
 	"flag"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mijara/statspout/backend"
 	"github.com/mijara/statspout/common"
 	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/schedule"
+	"github.com/mijara/statspout/shard"
+	"github.com/mijara/statspout/snmp"
 )
 
 // Structure to hold different options given by the client.
 type options struct {
-	Interval   int      // Seconds between each stats query.
-	Repository string   // Which repository to use.
-	Daemons    int      // Number of daemons to handle requests.
-	Ignore     []string // Container names to ignore, as an array.
+	Interval   time.Duration // Time between each stats query.
+	Repository string        // Which repository to use.
+	Daemons    int           // Number of daemons to handle requests.
+	MaxDaemons int           // Maximum number of daemons when autoscaling.
+	Pipeline   int           // Number of daemons sharing each Docker connection.
+	Ignore     []string      // Container names to ignore, as an array.
+	Spread     bool          // Spread queries along the interval instead of querying all at once.
+	OneShot    bool          // Use one-shot stats requests whatever the interval.
+	Sample     int           // Containers queried each tick, in round-robin, all of them if 0.
+	Volumes    time.Duration // Time between each measure of the volumes, disabled if 0.
+	Top        time.Duration // Time between each listing of the processes, disabled if 0.
+	TopNames   int           // Names of the processes using the most CPU pushed along their counts.
+	Proc       string        // Path to the /proc of the Docker host, file descriptors are not read if empty.
+	ProcTcp    bool          // Count the TCP connections of the containers from Proc.
+	Identity   string        // Identity of the containers in what is pushed, see backend.ParseIdentity.
+	ConfigPath string        // Path to the configuration file.
+	StatePath  string        // Path to the file keeping counter baselines across restarts.
+	Record     string        // Directory where the exchanges with Docker are recorded, disabled if empty.
+	Replay     string        // Directory of recorded exchanges served instead of querying Docker, disabled if empty.
+	Strict     bool          // Check that the responses of Docker have the fields in use.
+	User       string        // User to switch to once the Docker socket is open.
+	Group      string        // Group to switch to once the Docker socket is open.
+	Validate   bool          // Only validate the options and exit.
+	Probe      bool          // Run the preflight checks while validating.
+	Preflight  bool          // Run the preflight checks before starting.
+	Version    bool          // Only print the version and exit.
+	Profile    string        // Preset of defaults, see Profiles.
 
 	ignoreBuff string // Container names to ignore, separated by comma.
 
@@ -29,6 +59,109 @@ type options struct {
 		HTTP struct {
 			Address string // Docker API address
 		}
+
+		NPipe struct {
+			Path string // Windows named pipe to connect Docker
+		}
+	}
+
+	API struct {
+		Address string        // Address of the HTTP API, disabled if empty.
+		History time.Duration // Time of history retained for range queries.
+
+		Admin struct {
+			Token string // Bearer token of the admin endpoints, disabled if empty.
+		}
+
+		Read struct {
+			Tokens []string // Read-only bearer tokens of the other endpoints, open if empty.
+
+			tokensBuff string // Tokens, separated by comma.
+		}
+
+		Origins     []string // Origins of the pages allowed to open the WebSocket, besides the API itself.
+		originsBuff string   // Origins, separated by comma.
+	}
+
+	GRPC struct {
+		Address string // Address of the gRPC API, disabled if empty.
+	}
+
+	SNMP struct {
+		Address   string // UDP address of the SNMP agent, disabled if empty.
+		Community string // Community required by the agent.
+		Root      string // OID under which the objects are exposed.
+	}
+
+	TLS struct {
+		Cert     string // Certificate of the HTTP and gRPC APIs and the receiver, TLS is disabled if empty.
+		Key      string // Private key of the certificate.
+		ClientCA string // CA certificates verifying the clients, client certificates are not required if empty.
+	}
+
+	Receiver struct {
+		Address string // Address on which forwarded stats are received, disabled if empty.
+		Token   string // Bearer token required from the agents, none if empty.
+	}
+
+	Network struct {
+		Exclude      []string // Patterns of the interfaces left out of the network totals.
+		PerInterface bool     // Push the network stats of each interface along the totals.
+
+		excludeBuff string // Exclude, separated by comma.
+	}
+
+	Events struct {
+		Exec bool // Push exec and attach events.
+	}
+
+	Metrics struct {
+		Allow []string // Groups of metrics pushed to the repository, every one if empty.
+		Deny  []string // Groups of metrics not pushed to the repository.
+
+		allowBuff string // Allow, separated by comma.
+		denyBuff  string // Deny, separated by comma.
+	}
+
+	Simulate struct {
+		Containers int   // Number of fake containers collected instead of querying Docker, disabled if 0.
+		Seed       int64 // Seed of the stats of the fake containers.
+	}
+
+	Adaptive struct {
+		Latency time.Duration // Latency of the Docker API above which intervals are stretched, disabled if 0.
+		Max     float64       // Maximum factor intervals are stretched by.
+	}
+
+	Discovery struct {
+		Source   string        // URL of the registry listing Docker hosts, disabled if empty.
+		Interval time.Duration // Time between each sync with the registry.
+	}
+
+	Shard struct {
+		Count   int      // Number of shards, disabled if 0.
+		Index   int      // Shard of this instance, from 0 to Count-1.
+		Members []string // Names of every instance, instead of Count and Index.
+		Self    string   // Name of this instance among Members.
+
+		membersBuff string // Members, separated by comma.
+	}
+
+	Gossip struct {
+		Bind string   // Address on which to gossip with the other instances, disabled if empty.
+		Join []string // Addresses of instances to join.
+
+		joinBuff string // Join, separated by comma.
+	}
+
+	Election struct {
+		Lock string        // URL of the leader lock, disabled if empty.
+		TTL  time.Duration // Time after which the lock of an unresponsive leader is released.
+	}
+
+	Audit struct {
+		Target   string        // File or syslog address of the audit log, disabled if empty.
+		Interval time.Duration // Time between each record of the pushes.
 	}
 
 	Influx     common.InfluxOpts     // Influx specific options
@@ -37,6 +170,23 @@ type options struct {
 	Prometheus common.PrometheusOpts // Prometheus specific options.
 }
 
+// Flag value accepting durations or plain seconds.
+type interval time.Duration
+
+func (v *interval) String() string {
+	return time.Duration(*v).String()
+}
+
+func (v *interval) Set(value string) error {
+	d, err := schedule.ParseInterval(value)
+	if err != nil {
+		return err
+	}
+
+	*v = interval(d)
+	return nil
+}
+
 // Single instance of this package.
 var i *options
 
@@ -48,30 +198,190 @@ func GetOpts() *options {
 
 	i = &options{}
 
-	flag.IntVar(&i.Interval,
+	i.Interval = 5 * time.Second
+	flag.Var((*interval)(&i.Interval),
 		"interval",
-		5,
-		"Interval between each stats query.")
+		"Interval between each stats query, as a duration (500ms, 2s, 1m) or seconds.")
+
+	flag.StringVar(&i.Profile,
+		"profile",
+		PROFILE_DEFAULT,
+		"Preset of defaults: default, or edge for hosts with little memory and CPU. Flags given explicitly take precedence.")
 
 	flag.IntVar(&i.Daemons,
 		"daemons",
 		10,
 		"Number of daemons to handle requests.")
 
+	flag.IntVar(&i.MaxDaemons,
+		"daemons.max",
+		0,
+		"Maximum number of daemons, enables autoscaling from daemons up to this number.")
+
+	flag.IntVar(&i.Pipeline,
+		"daemons.pipeline",
+		1,
+		"Number of daemons sharing each Docker connection. Requests on a connection are serialized, not pipelined, so more than 1 needs -oneshot or a sub-second interval.")
+
 	flag.StringVar(&i.Repository,
 		"repository",
 		"stdout",
-		"One of: stdout, mongodb, prometheus, influxdb, rest.")
+		"One of: stdout, mongodb, prometheus, influxdb, rest, memory, forward, rrd, nagios, kafka, elasticsearch, graphite, statsd, postgres, redis, amqp, mqtt.")
 
 	flag.StringVar(&i.ignoreBuff,
 		"ignore",
 		"",
 		"Repository names to ignore, separated by comma.")
 
+	flag.BoolVar(&i.Spread,
+		"spread",
+		false,
+		"Spread container queries evenly along the interval.")
+
+	flag.IntVar(&i.Sample,
+		"sample",
+		0,
+		"Number of containers queried each tick, rotating through them, for a bounded load. All of them if 0.")
+
+	flag.DurationVar(&i.Volumes,
+		"volumes",
+		0,
+		"Time between each measure of the disk usage of the named volumes of the containers, which is slow on large volumes. Disabled if 0.")
+
+	flag.DurationVar(&i.Top,
+		"top",
+		0,
+		"Time between each listing of the processes of the containers, to count their processes and threads. Disabled if 0.")
+
+	flag.IntVar(&i.TopNames,
+		"top.names",
+		0,
+		"Number of names of the processes using the most CPU pushed along their counts, needs -top.")
+
+	flag.StringVar(&i.Proc,
+		"proc",
+		"",
+		"Path to the /proc of the Docker host, to read the open file descriptors of the containers. Disabled if empty.")
+
+	flag.BoolVar(&i.ProcTcp,
+		"proc.tcp",
+		false,
+		"Count the established and time-wait TCP connections of the containers, needs -proc.")
+
+	flag.StringVar(&i.Identity,
+		"identity",
+		backend.IDENTITY_NAME,
+		"Identity of the containers in the pushed stats: name, id, label:<key> or a Go template such as {{.Label \"key\"}}.")
+
+	flag.StringVar(&i.Network.excludeBuff,
+		"network.exclude",
+		"",
+		"Patterns of the interfaces left out of the network totals, separated by comma, such as lo,veth*,docker_gwbridge.")
+
+	flag.BoolVar(&i.Network.PerInterface,
+		"network.per-interface",
+		false,
+		"Push the network stats of each interface, but the excluded ones, along the totals.")
+
+	flag.StringVar(&i.Metrics.allowBuff,
+		"metrics.allow",
+		"",
+		"Groups of metrics pushed to the repository, separated by comma: cpu, memory, network, blkio, pids, events. Every one if empty.")
+
+	flag.StringVar(&i.Metrics.denyBuff,
+		"metrics.deny",
+		"",
+		"Groups of metrics not pushed to the repository, separated by comma.")
+
+	flag.BoolVar(&i.Events.Exec,
+		"events.exec",
+		false,
+		"Push exec and attach events of the containers, with the command run, to audit access to them.")
+
+	flag.DurationVar(&i.Adaptive.Latency,
+		"adaptive.latency",
+		0,
+		"Latency of the stats requests above which polling intervals are stretched until Docker recovers. Disabled if 0.")
+
+	flag.Float64Var(&i.Adaptive.Max,
+		"adaptive.max",
+		4,
+		"Maximum factor polling intervals are stretched by when Docker is slow.")
+
+	flag.BoolVar(&i.OneShot,
+		"oneshot",
+		false,
+		"Use one-shot stats requests, which don't wait a second for the daemon to sample again. Always on for sub-second intervals.")
+
+	flag.StringVar(&i.ConfigPath,
+		"config",
+		"",
+		"Path to a JSON configuration file.")
+
+	flag.StringVar(&i.StatePath,
+		"state",
+		"",
+		"Path to a file keeping counter baselines across restarts, so rates don't spike or gap. Disabled if empty.")
+
+	flag.StringVar(&i.Record,
+		"record",
+		"",
+		"Directory where every Docker API request and response is saved, to be replayed with -replay. Disabled if empty.")
+
+	flag.StringVar(&i.Replay,
+		"replay",
+		"",
+		"Directory of Docker API exchanges saved by -record, served back instead of querying Docker. Disabled if empty.")
+
+	flag.BoolVar(&i.Strict,
+		"strict",
+		false,
+		"Check that the Docker API responses have the fields in use, reporting the endpoint and field that could not be parsed.")
+
+	flag.IntVar(&i.Simulate.Containers,
+		"simulate",
+		0,
+		"Number of fake containers with realistic stats collected instead of querying Docker, to try repositories. Disabled if 0.")
+
+	flag.Int64Var(&i.Simulate.Seed,
+		"simulate.seed",
+		1,
+		"Seed of the stats of the fake containers, the same seed gives the same stats.")
+
+	flag.StringVar(&i.User,
+		"user",
+		"",
+		"User to switch to once the Docker socket is open, by name or ID. Keeps running as is if empty.")
+
+	flag.StringVar(&i.Group,
+		"group",
+		"",
+		"Group to switch to once the Docker socket is open, by name or ID. Defaults to the primary group of user.")
+
+	flag.BoolVar(&i.Version,
+		"version",
+		false,
+		"Print the version and build information, then exit.")
+
+	flag.BoolVar(&i.Validate,
+		"validate",
+		false,
+		"Validate the options and the configuration file, then exit.")
+
+	flag.BoolVar(&i.Probe,
+		"probe",
+		false,
+		"Also run the preflight checks when validating.")
+
+	flag.BoolVar(&i.Preflight,
+		"preflight",
+		true,
+		"Check the Docker endpoints, the repository, the listen addresses and the written files before starting.")
+
 	flag.StringVar(&i.Mode.Name,
 		"mode",
 		"socket",
-		"Mode to create the client: socket, http, tls.")
+		"Mode to create the client: socket, http, npipe (Windows), or none to only collect discovered hosts.")
 
 	flag.StringVar(&i.Mode.Socket.Path,
 		"socket.path",
@@ -83,20 +393,170 @@ func GetOpts() *options {
 		"localhost:4243",
 		"Docker API Address.")
 
+	flag.StringVar(&i.Mode.NPipe.Path,
+		"npipe.path",
+		"//./pipe/docker_engine",
+		"Windows named pipe to connect to Docker.")
+
+	flag.StringVar(&i.API.Address,
+		"api.address",
+		"",
+		"Address on which the HTTP API publishes the latest stats, disabled if empty.")
+
+	flag.DurationVar(&i.API.History,
+		"api.history",
+		0,
+		"Time of history retained per container for the HTTP API range queries, only the latest sample if 0.")
+
+	flag.StringVar(&i.API.Admin.Token,
+		"api.admin.token",
+		"",
+		"Bearer token required by the HTTP API admin endpoints, or @file to read it from a file reloaded on changes. Disabled if empty.")
+
+	flag.StringVar(&i.API.Read.tokensBuff,
+		"api.read.tokens",
+		"",
+		"Read-only bearer tokens required by the other HTTP API endpoints, separated by comma, each may be @file. Open if empty.")
+
+	flag.StringVar(&i.API.originsBuff,
+		"api.origins",
+		"",
+		"Origins of the pages allowed to open the HTTP API WebSocket, as scheme://host[:port] separated by comma, or * for any. Same origin only if empty.")
+
+	flag.StringVar(&i.GRPC.Address,
+		"grpc.address",
+		"",
+		"Address on which the gRPC API streams stats, disabled if empty.")
+
+	flag.StringVar(&i.SNMP.Address,
+		"snmp.address",
+		"",
+		"UDP address on which the SNMP agent answers for the stats, disabled if empty.")
+
+	flag.StringVar(&i.SNMP.Community,
+		"snmp.community",
+		"public",
+		"Community required by the SNMP agent, or @file to read it from a file reloaded on changes.")
+
+	flag.StringVar(&i.SNMP.Root,
+		"snmp.oid",
+		snmp.DEFAULT_ROOT,
+		"OID under which the SNMP agent exposes the stats.")
+
+	flag.StringVar(&i.TLS.Cert,
+		"tls.cert",
+		"",
+		"Certificate file of the HTTP and gRPC APIs and the receiver, served over TLS if given.")
+
+	flag.StringVar(&i.TLS.Key,
+		"tls.key",
+		"",
+		"Private key file of the certificate.")
+
+	flag.StringVar(&i.TLS.ClientCA,
+		"tls.client.ca",
+		"",
+		"CA certificates file verifying the clients of the APIs and the receiver, which must present a certificate if given.")
+
+	flag.StringVar(&i.Receiver.Address,
+		"receiver.address",
+		"",
+		"Address on which stats forwarded by other instances are received, disabled if empty.")
+
+	flag.StringVar(&i.Receiver.Token,
+		"receiver.token",
+		"",
+		"Bearer token required from the forwarding instances, or @file to read it from a file reloaded on changes. None if empty.")
+
+	flag.StringVar(&i.Discovery.Source,
+		"discovery.source",
+		"",
+		"Registry listing Docker hosts to collect: consul://host:port/service, etcd://host:port/prefix or swarm://host:port.")
+
+	flag.DurationVar(&i.Discovery.Interval,
+		"discovery.interval",
+		30*time.Second,
+		"Time between each sync with the discovery source.")
+
+	flag.IntVar(&i.Shard.Count,
+		"shard.count",
+		0,
+		"Number of instances sharing the containers, each monitors only its shard. Disabled if 0.")
+
+	flag.IntVar(&i.Shard.Index,
+		"shard.index",
+		0,
+		"Shard of this instance, from 0 to shard.count - 1.")
+
+	flag.StringVar(&i.Shard.membersBuff,
+		"shard.members",
+		"",
+		"Names of the instances sharing the containers, separated by comma, instead of shard.count.")
+
+	hostname, _ := os.Hostname()
+	flag.StringVar(&i.Shard.Self,
+		"shard.self",
+		hostname,
+		"Name of this instance among shard.members.")
+
+	flag.StringVar(&i.Gossip.Bind,
+		"gossip.bind",
+		"",
+		"Address on which to gossip with other instances, splitting containers among the live ones. Disabled if empty.")
+
+	flag.StringVar(&i.Gossip.joinBuff,
+		"gossip.join",
+		"",
+		"Gossip addresses of instances to join, separated by comma.")
+
+	flag.StringVar(&i.Election.Lock,
+		"election.lock",
+		"",
+		"Leader lock, only the leader pushes to the repository: file:///path or consul://host:port/key.")
+
+	flag.DurationVar(&i.Election.TTL,
+		"election.ttl",
+		15*time.Second,
+		"Time after which the lock of an unresponsive leader is released.")
+
+	flag.StringVar(&i.Audit.Target,
+		"audit",
+		"",
+		"Audit log of the pushes and admin requests: a file, syslog or syslog://host:port. Disabled if empty.")
+
+	flag.DurationVar(&i.Audit.Interval,
+		"audit.interval",
+		time.Minute,
+		"Time between each audit record of the pushes to the repository.")
+
 	return i
 }
 
 func (*options) Parse() {
 	flag.Parse()
+	applyProfile(i.Profile)
 
-	names := strings.Split(i.ignoreBuff, ",")
-	i.Ignore = make([]string, 0)
+	i.Ignore = split(i.ignoreBuff)
+	i.Network.Exclude = split(i.Network.excludeBuff)
+	i.Metrics.Allow = split(i.Metrics.allowBuff)
+	i.Metrics.Deny = split(i.Metrics.denyBuff)
+	i.Shard.Members = split(i.Shard.membersBuff)
+	i.Gossip.Join = split(i.Gossip.joinBuff)
+	i.API.Read.Tokens = split(i.API.Read.tokensBuff)
+	i.API.Origins = split(i.API.originsBuff)
+}
+
+// Splits a list separated by comma, skipping empty items.
+func split(list string) []string {
+	items := make([]string, 0)
 
-	for _, name := range names {
-		if name != "" {
-			i.Ignore = append(i.Ignore, name)
+	for _, item := range strings.Split(list, ",") {
+		if item != "" {
+			items = append(items, item)
 		}
 	}
+
+	return items
 }
 
 // Creates the repository from the options given by the client.
@@ -110,14 +570,122 @@ func CreateRepositoryFromFlags(cfg *Config) (repo.Interface, error) {
 	return nil, errors.New("Unknown repository: " + i.Repository)
 }
 
-// Creates the client from the options given by the client.
-func CreateClientFromFlags(repo repo.Interface) (*backend.Client, error) {
+// Where the repository pushes to, or serves from, for the audit log: its address flag, without credentials. Empty if
+// the repository has no address.
+func DestinationFromFlags() string {
+	prefix := GetOpts().Repository
+	if prefix == "mongodb" {
+		prefix = "mongo"
+	}
+
+	f := flag.Lookup(prefix + ".address")
+	if f == nil {
+		return ""
+	}
+
+	address := f.Value.String()
+	if u, err := url.Parse(address); err == nil && u.User != nil {
+		u.User = nil
+		address = u.String()
+	}
+
+	return address
+}
+
+// Resolves the Docker endpoint from the options given by the client, as network and address.
+func EndpointFromFlags() (string, string, error) {
 	switch GetOpts().Mode.Name {
 	case "socket":
-		return backend.New(repo, false, GetOpts().Mode.Socket.Path, GetOpts().Daemons)
+		return "unix", GetOpts().Mode.Socket.Path, nil
 	case "http":
-		return backend.New(repo, true, GetOpts().Mode.HTTP.Address, GetOpts().Daemons)
+		return "tcp", GetOpts().Mode.HTTP.Address, nil
+	case "npipe":
+		return "npipe", GetOpts().Mode.NPipe.Path, nil
+	}
+
+	return "", "", errors.New("Unknown mode: " + GetOpts().Mode.Name)
+}
+
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Creates the ring assigning containers to this instance, nil if sharding is disabled.
+func RingFromFlags() (*shard.Ring, error) {
+	o := GetOpts()
+
+	switch {
+	case o.Gossip.Bind != "":
+		// members are given by gossip once running.
+		return shard.New(o.Shard.Self, []string{o.Shard.Self}), nil
+
+	case len(o.Shard.Members) > 0:
+		for _, member := range o.Shard.Members {
+			if member == o.Shard.Self {
+				return shard.New(o.Shard.Self, o.Shard.Members), nil
+			}
+		}
+		return nil, errors.New("This instance (" + o.Shard.Self + ") is not among the shard members.")
+
+	case o.Shard.Count > 0:
+		if o.Shard.Index < 0 || o.Shard.Index >= o.Shard.Count {
+			return nil, errors.New("Shard index must be between 0 and the shard count - 1.")
+		}
+
+		members := make([]string, o.Shard.Count)
+		for n := range members {
+			members[n] = strconv.Itoa(n)
+		}
+		return shard.New(strconv.Itoa(o.Shard.Index), members), nil
+	}
+
+	return nil, nil
+}
+
+// Reads the interval overrides of the configuration file, if any.
+func RulesFromFlags() ([]schedule.Rule, error) {
+	if GetOpts().ConfigPath == "" {
+		return nil, nil
+	}
+
+	file, err := LoadFile(GetOpts().ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return file.Rules()
+}
+
+// Reads the collection windows of the configuration file, if any.
+func WindowsFromFlags() ([]schedule.Window, error) {
+	if GetOpts().ConfigPath == "" {
+		return nil, nil
+	}
+
+	file, err := LoadFile(GetOpts().ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return file.ParseWindows()
+}
+
+// Hosts of the configuration file given by the config flag, if any.
+func HostsFromFlags() ([]Host, error) {
+	if GetOpts().ConfigPath == "" {
+		return nil, nil
+	}
+
+	file, err := LoadFile(GetOpts().ConfigPath)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, errors.New("Unknown mode: " + GetOpts().Mode.Name)
+	return file.ParseHosts()
 }
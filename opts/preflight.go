@@ -0,0 +1,195 @@
+package opts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/repo"
+)
+
+// Time given to the Docker endpoints to answer the preflight checks.
+const PREFLIGHT_TIMEOUT = 5 * time.Second
+
+// Checks what the options point to before starting: the Docker endpoints are reachable and allowed to list
+// containers, the repository backend is reachable, the listen addresses are free and the written paths are writable.
+func preflight(cfg *Config, add func(string, string, ...interface{})) {
+	o := GetOpts()
+
+	// the recorded trace or the fake containers are served instead of Docker, when replaying or simulating.
+	if o.Mode.Name != "none" && o.Replay == "" && o.Simulate.Containers == 0 {
+		if network, address, err := EndpointFromFlags(); err == nil {
+			if err := probeDocker(network, address); err != nil {
+				add("-mode", "Docker at %s://%s: %s", network, address, err.Error())
+			}
+		}
+	}
+
+	if o.ConfigPath != "" {
+		if file, err := LoadFile(o.ConfigPath); err == nil {
+			for n, host := range file.Hosts {
+				if endpoint, err := backend.ParseEndpoint(host.Endpoint); err == nil && host.Endpoint != "" {
+					if err := probeDocker(endpoint.Network, endpoint.Address); err != nil {
+						add(fmt.Sprintf("config.hosts[%d].endpoint", n), "Docker at %s://%s: %s",
+							endpoint.Network, endpoint.Address, err.Error())
+					}
+				}
+			}
+		}
+	}
+
+	if pair, ok := cfg.Repositories[o.Repository]; ok {
+		if checker, ok := pair.Repository.(repo.Checker); ok {
+			if err := checker.Check(pair.Options); err != nil {
+				add("-repository", "%s: %s", o.Repository, err.Error())
+			}
+		}
+	}
+
+	listeners := [][2]string{
+		{"-api.address", o.API.Address},
+		{"-grpc.address", o.GRPC.Address},
+		{"-receiver.address", o.Receiver.Address},
+	}
+	for _, listener := range listeners {
+		if listener[1] == "" {
+			continue
+		}
+
+		if err := checkListen(listener[1]); err != nil {
+			add(listener[0], "%s", err.Error())
+		}
+	}
+
+	if o.SNMP.Address != "" {
+		if err := checkListenUDP(o.SNMP.Address); err != nil {
+			add("-snmp.address", "%s", err.Error())
+		}
+	}
+
+	if o.StatePath != "" {
+		if err := checkWritable(o.StatePath); err != nil {
+			add("-state", "%s", err.Error())
+		}
+	}
+
+	// the directory is created when starting, if missing.
+	if o.Record != "" {
+		path := o.Record
+		if _, err := os.Stat(path); err == nil {
+			path = filepath.Join(path, "probe")
+		}
+
+		if err := checkWritable(path); err != nil {
+			add("-record", "%s", err.Error())
+		}
+	}
+
+	if target := o.Audit.Target; target != "" && target != "syslog" && !strings.Contains(target, "://") {
+		if err := checkWritable(target); err != nil {
+			add("-audit", "%s", err.Error())
+		}
+	}
+
+	if u, err := url.Parse(o.Election.Lock); err == nil && u.Scheme == "file" {
+		if err := checkWritable(u.Path); err != nil {
+			add("-election.lock", "%s", err.Error())
+		}
+	}
+}
+
+// Checks that the Docker API answers to a ping and allows listing containers.
+func probeDocker(network string, address string) error {
+	if network == "unix" {
+		if _, err := os.Stat(address); os.IsNotExist(err) {
+			return fmt.Errorf("socket %s not found, is Docker running?", address)
+		}
+	}
+
+	conn, err := backend.Dial(network, address, PREFLIGHT_TIMEOUT)
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("permission denied on %s, run as root or as a member of the docker group", address)
+	} else if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(PREFLIGHT_TIMEOUT))
+	reader := bufio.NewReader(conn)
+
+	for _, path := range []string{"/_ping", "/containers/json"} {
+		req, err := http.NewRequest("GET", "http://docker"+path, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := req.Write(conn); err != nil {
+			return err
+		}
+
+		res, err := http.ReadResponse(reader, req)
+		if err != nil {
+			return err
+		}
+
+		// drain the body to reuse the connection for the next request.
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		switch {
+		case res.StatusCode == http.StatusOK:
+		case path == "/_ping":
+			return fmt.Errorf("unexpected status %s", res.Status)
+		default:
+			return fmt.Errorf("listing containers is not allowed: %s", res.Status)
+		}
+	}
+
+	return nil
+}
+
+// Checks that the address can be listened on.
+func checkListen(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return listener.Close()
+}
+
+// Checks that the UDP address can be listened on.
+func checkListenUDP(address string) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// Checks that the file can be written, or created in its directory if it doesn't exist.
+func checkWritable(path string) error {
+	if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+		return f.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".statspout")
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
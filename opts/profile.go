@@ -0,0 +1,53 @@
+package opts
+
+import (
+	"flag"
+	"sort"
+)
+
+const (
+	PROFILE_DEFAULT = "default"
+	PROFILE_EDGE    = "edge"
+)
+
+// Defaults of each profile, by flag name, flags given explicitly take precedence. The edge profile keeps statspout
+// under a few MB of RSS on Raspberry Pi-class hosts: a single daemon, no autoscaling and no retained history, with
+// queries spread along the interval to avoid CPU spikes.
+var profiles = map[string]map[string]string{
+	PROFILE_DEFAULT: {},
+	PROFILE_EDGE: {
+		"daemons":          "1",
+		"daemons.max":      "0",
+		"daemons.pipeline": "1",
+		"spread":           "true",
+		"api.history":      "0",
+		"memory.samples":   "1",
+	},
+}
+
+// Names of the profiles, sorted.
+func Profiles() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Sets the defaults of the profile on the flags not given explicitly. Unknown profiles are left to Validate.
+func applyProfile(name string) {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for key, value := range profiles[name] {
+		if given[key] || flag.Lookup(key) == nil {
+			continue
+		}
+
+		flag.Set(key, value)
+	}
+}
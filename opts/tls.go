@@ -0,0 +1,92 @@
+package opts
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/mijara/statspout/common"
+	"github.com/mijara/statspout/secret"
+)
+
+// TLS configuration of the HTTP and gRPC APIs, nil if TLS is disabled. Clients must present a certificate signed by
+// the client CA, if given.
+func TLSFromFlags() (*tls.Config, error) {
+	o := GetOpts().TLS
+	if o.Cert == "" {
+		return nil, nil
+	}
+
+	// certificates are read again when their files change, so new connections use the rotated ones.
+	pair, err := secret.NewKeyPair(o.Cert, o.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		GetCertificate: pair.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if o.ClientCA != "" {
+		pool, err := secret.NewCertPool(o.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		// verified by hand against the current CAs, ClientCAs would keep the ones loaded at start.
+		config.ClientAuth = tls.RequireAnyClientCert
+		config.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			return verifyClient(raw, pool.Pool())
+		}
+	}
+
+	return config, nil
+}
+
+// Verifies a client certificate chain, leaf first, against the CAs.
+func verifyClient(raw [][]byte, roots *x509.CertPool) error {
+	if len(raw) == 0 {
+		return errors.New("No client certificate.")
+	}
+
+	certs := make([]*x509.Certificate, len(raw))
+	for n, data := range raw {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return err
+		}
+		certs[n] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+// TLS configuration to reach the HTTP API of a running instance with the same flags, as the commands do, nil if TLS
+// is disabled. The certificate is presented as the client certificate, and the client CA verifies the server, as
+// usual in mutual TLS setups with a single CA. Without a client CA, the system roots verify the server.
+func ClientTLSFromFlags() (*tls.Config, error) {
+	o := GetOpts().TLS
+	if o.Cert == "" {
+		return nil, nil
+	}
+
+	client := &common.TLSOpts{
+		CA:      o.ClientCA,
+		Cert:    o.Cert,
+		Key:     o.Key,
+		Version: "1.2",
+	}
+
+	return client.Config()
+}
@@ -0,0 +1,475 @@
+package opts
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mijara/statspout/audit"
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/discovery"
+	"github.com/mijara/statspout/election"
+	"github.com/mijara/statspout/gossip"
+	"github.com/mijara/statspout/schedule"
+	"github.com/mijara/statspout/secret"
+	"github.com/mijara/statspout/snmp"
+	"github.com/mijara/statspout/stats"
+)
+
+// Problem found in a single option, identified by its path.
+type FieldError struct {
+	Path    string // flag (-interval) or configuration file field (config.intervals[0].selector).
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// Every problem found while validating, reported at once.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+
+	return fmt.Sprintf("%d invalid option(s):\n  %s", len(e), strings.Join(lines, "\n  "))
+}
+
+// Validates the parsed options and the configuration file, collecting every problem instead of
+// stopping at the first one. If probe is true, the preflight checks are also run on valid options: the
+// Docker endpoints, the repository, the listen addresses and the written paths are checked.
+func Validate(cfg *Config, probe bool) error {
+	var errs ValidationError
+
+	add := func(path string, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	o := GetOpts()
+
+	if !contains(Profiles(), o.Profile) {
+		add("-profile", "unknown profile %q, use one of: %s", o.Profile, strings.Join(Profiles(), ", "))
+	}
+
+	if o.Interval <= 0 {
+		add("-interval", "must be positive, got %s", o.Interval)
+	}
+
+	if o.Daemons < 1 {
+		add("-daemons", "at least one daemon is needed, got %d", o.Daemons)
+	}
+
+	if o.MaxDaemons != 0 && o.MaxDaemons < o.Daemons {
+		add("-daemons.max", "must be 0 (disabled) or at least -daemons (%d), got %d", o.Daemons, o.MaxDaemons)
+	}
+
+	if o.Pipeline < 1 {
+		add("-daemons.pipeline", "at least one daemon per connection is needed, got %d", o.Pipeline)
+	} else if o.Pipeline > 1 && !o.OneShot && o.Interval >= time.Second {
+		add("-daemons.pipeline", "sharing connections needs one-shot stats requests (-oneshot or a sub-second "+
+			"-interval), regular ones hold them for a second, got %d", o.Pipeline)
+	}
+
+	if o.Sample < 0 {
+		add("-sample", "cannot be negative, got %d", o.Sample)
+	}
+
+	if o.Volumes < 0 {
+		add("-volumes", "cannot be negative, got %s", o.Volumes)
+	}
+
+	if o.Top < 0 {
+		add("-top", "cannot be negative, got %s", o.Top)
+	}
+
+	if o.TopNames < 0 {
+		add("-top.names", "cannot be negative, got %d", o.TopNames)
+	}
+
+	if o.TopNames > 0 && o.Top == 0 {
+		add("-top.names", "needs -top to be set")
+	}
+
+	if o.Adaptive.Latency < 0 {
+		add("-adaptive.latency", "cannot be negative, got %s", o.Adaptive.Latency)
+	}
+
+	if o.Adaptive.Max < 1 {
+		add("-adaptive.max", "must be at least 1, got %g", o.Adaptive.Max)
+	}
+
+	if o.API.History < 0 {
+		add("-api.history", "cannot be negative, got %s", o.API.History)
+	}
+
+	if o.API.Admin.Token != "" && o.API.Address == "" {
+		add("-api.admin.token", "needs -api.address to be set")
+	}
+
+	if len(o.API.Read.Tokens) > 0 && o.API.Address == "" {
+		add("-api.read.tokens", "needs -api.address to be set")
+	}
+
+	if len(o.API.Origins) > 0 && o.API.Address == "" {
+		add("-api.origins", "needs -api.address to be set")
+	}
+
+	for _, origin := range o.API.Origins {
+		if origin == "*" {
+			continue
+		}
+
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			add("-api.origins", "expected scheme://host[:port] or *, got %q", origin)
+		}
+	}
+
+	if err := secret.Check(o.API.Admin.Token); err != nil {
+		add("-api.admin.token", "%s", err.Error())
+	}
+
+	for _, token := range o.API.Read.Tokens {
+		if err := secret.Check(token); err != nil {
+			add("-api.read.tokens", "%s", err.Error())
+		}
+	}
+
+	// compared by value, tokens may be read from files.
+	if admin := secret.New(o.API.Admin.Token).Value(); admin != "" {
+		for _, token := range o.API.Read.Tokens {
+			if secret.New(token).Value() == admin {
+				add("-api.read.tokens", "the admin token cannot also be a read-only token")
+			}
+		}
+	}
+
+	if o.Shard.Count < 0 {
+		add("-shard.count", "cannot be negative, got %d", o.Shard.Count)
+	}
+
+	if o.Shard.Count > 0 && (o.Shard.Index < 0 || o.Shard.Index >= o.Shard.Count) {
+		add("-shard.index", "must be between 0 and %d, got %d", o.Shard.Count-1, o.Shard.Index)
+	}
+
+	if len(o.Shard.Members) > 0 && o.Shard.Count > 0 {
+		add("-shard.members", "cannot be used along -shard.count")
+	}
+
+	if len(o.Shard.Members) > 0 && !contains(o.Shard.Members, o.Shard.Self) {
+		add("-shard.self", "%q is not among -shard.members", o.Shard.Self)
+	}
+
+	if o.Gossip.Bind != "" && (o.Shard.Count > 0 || len(o.Shard.Members) > 0) {
+		add("-gossip.bind", "cannot be used along -shard.count or -shard.members")
+	}
+
+	if o.Gossip.Bind != "" {
+		if o.Shard.Self == "" {
+			add("-shard.self", "cannot be empty when gossiping")
+		} else if _, err := gossip.New(o.Shard.Self, o.Gossip.Bind, o.Gossip.Join, nil); err != nil {
+			add("-gossip.bind", "%s", err.Error())
+		}
+	}
+
+	if len(o.Gossip.Join) > 0 && o.Gossip.Bind == "" {
+		add("-gossip.join", "needs -gossip.bind to be set")
+	}
+
+	if (o.TLS.Cert == "") != (o.TLS.Key == "") {
+		add("-tls.cert", "-tls.cert and -tls.key must be given together")
+	} else if o.TLS.Cert != "" {
+		if _, err := TLSFromFlags(); err != nil {
+			add("-tls.cert", "%s", err.Error())
+		}
+
+		if o.API.Address == "" && o.GRPC.Address == "" && o.Receiver.Address == "" {
+			add("-tls.cert", "needs -api.address, -grpc.address or -receiver.address to be set")
+		}
+	}
+
+	if o.TLS.ClientCA != "" && o.TLS.Cert == "" {
+		add("-tls.client.ca", "needs -tls.cert and -tls.key to be set")
+	}
+
+	if o.Receiver.Token != "" && o.Receiver.Address == "" {
+		add("-receiver.token", "needs -receiver.address to be set")
+	}
+
+	if err := secret.Check(o.Receiver.Token); err != nil {
+		add("-receiver.token", "%s", err.Error())
+	}
+
+	if o.SNMP.Address != "" {
+		if _, err := snmp.ParseOID(o.SNMP.Root); err != nil {
+			add("-snmp.oid", "%s", err.Error())
+		}
+
+		if o.SNMP.Community == "" {
+			add("-snmp.community", "cannot be empty")
+		}
+	}
+
+	if err := secret.Check(o.SNMP.Community); err != nil {
+		add("-snmp.community", "%s", err.Error())
+	}
+
+	if o.Election.Lock != "" {
+		if _, err := election.NewLock(o.Election.Lock, o.Election.TTL); err != nil {
+			add("-election.lock", "%s", err.Error())
+		}
+
+		if o.Election.TTL <= 0 {
+			add("-election.ttl", "must be positive, got %s", o.Election.TTL)
+		}
+	}
+
+	if o.Audit.Target != "" {
+		if err := audit.CheckTarget(o.Audit.Target); err != nil {
+			add("-audit", "%s", err.Error())
+		}
+
+		if o.Audit.Interval <= 0 {
+			add("-audit.interval", "must be positive, got %s", o.Audit.Interval)
+		}
+	}
+
+	if _, ok := cfg.Repositories[o.Repository]; !ok {
+		add("-repository", "unknown repository %q, use one of: %s", o.Repository, repositoryNames(cfg))
+	}
+
+	if o.Mode.Name != "socket" && o.Mode.Name != "http" && o.Mode.Name != "npipe" && o.Mode.Name != "none" {
+		add("-mode", "unknown mode %q, use one of: socket, http, npipe, none", o.Mode.Name)
+	} else if o.Mode.Name == "npipe" && runtime.GOOS != "windows" {
+		add("-mode", "named pipes are only supported on Windows")
+	}
+
+	if o.Discovery.Source != "" {
+		if _, err := discovery.NewSource(o.Discovery.Source); err != nil {
+			add("-discovery.source", "%s", err.Error())
+		}
+
+		if o.Discovery.Interval <= 0 {
+			add("-discovery.interval", "must be positive, got %s", o.Discovery.Interval)
+		}
+	}
+
+	if _, err := stats.ParseGroups(o.Metrics.Allow, nil); err != nil {
+		add("-metrics.allow", "%s", err.Error())
+	}
+
+	if _, err := stats.ParseGroups(nil, o.Metrics.Deny); err != nil {
+		add("-metrics.deny", "%s", err.Error())
+	}
+
+	for _, pattern := range o.Network.Exclude {
+		if err := backend.CheckInterfacePattern(pattern); err != nil {
+			add("-network.exclude", "%s", err.Error())
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, name := range o.Ignore {
+		if seen[name] {
+			add("-ignore", "container %q is listed more than once", name)
+		}
+		seen[name] = true
+	}
+
+	if o.User != "" || o.Group != "" {
+		validatePrivileges(o, add)
+	}
+
+	if o.StatePath != "" {
+		if _, err := backend.LoadStateFile(o.StatePath); err != nil {
+			add("-state", "cannot load %s: %s", o.StatePath, err.Error())
+		}
+	}
+
+	if o.Record != "" && o.Replay != "" {
+		add("-record", "cannot record while replaying")
+	}
+
+	if o.Simulate.Containers < 0 {
+		add("-simulate", "cannot be negative, got %d", o.Simulate.Containers)
+	} else if o.Simulate.Containers > 0 && o.Replay != "" {
+		add("-simulate", "cannot simulate while replaying")
+	}
+
+	if o.Record != "" {
+		if info, err := os.Stat(o.Record); err == nil && !info.IsDir() {
+			add("-record", "%s is not a directory", o.Record)
+		}
+	}
+
+	if o.Replay != "" {
+		if info, err := os.Stat(o.Replay); err != nil {
+			add("-replay", "cannot read %s: %s", o.Replay, err.Error())
+		} else if !info.IsDir() {
+			add("-replay", "%s is not a directory", o.Replay)
+		}
+	}
+
+	if _, err := backend.ParseIdentity(o.Identity); err != nil {
+		add("-identity", "%s", err.Error())
+	}
+
+	if o.ProcTcp && o.Proc == "" {
+		add("-proc.tcp", "needs -proc to be set")
+	}
+
+	if o.Proc != "" {
+		if info, err := os.Stat(o.Proc); err != nil {
+			add("-proc", "cannot read %s: %s", o.Proc, err.Error())
+		} else if !info.IsDir() {
+			add("-proc", "%s is not a directory", o.Proc)
+		}
+	}
+
+	if o.ConfigPath != "" {
+		validateFile(o.ConfigPath, seen, add)
+	}
+
+	if probe && len(errs) == 0 {
+		preflight(cfg, add)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Validates the configuration file at the given path.
+func validateFile(filePath string, ignored map[string]bool, add func(string, string, ...interface{})) {
+	file, err := LoadFile(filePath)
+	if err != nil {
+		add("-config", "cannot load %s: %s", filePath, err.Error())
+		return
+	}
+
+	selectors := map[string]int{}
+
+	for n, entry := range file.Intervals {
+		field := fmt.Sprintf("config.intervals[%d]", n)
+
+		if entry.Selector == "" {
+			add(field+".selector", "cannot be empty")
+		} else if err := schedule.CheckSelector(entry.Selector); err != nil {
+			add(field+".selector", "malformed pattern %q: %s", entry.Selector, err.Error())
+		}
+
+		if previous, ok := selectors[entry.Selector]; ok {
+			add(field+".selector", "duplicates config.intervals[%d], it will never match", previous)
+		} else {
+			selectors[entry.Selector] = n
+		}
+
+		if ignored[entry.Selector] {
+			add(field+".selector", "matches container %q, which is ignored by -ignore", entry.Selector)
+		}
+
+		if interval, err := schedule.ParseInterval(entry.Interval); err != nil {
+			add(field+".interval", "malformed duration %q, use values like 500ms, 2s or 1m", entry.Interval)
+		} else if interval <= 0 {
+			add(field+".interval", "must be positive, got %s", entry.Interval)
+		}
+	}
+
+	for n, entry := range file.Windows {
+		field := fmt.Sprintf("config.windows[%d]", n)
+
+		if entry.Selector == "" {
+			add(field+".selector", "cannot be empty")
+			continue
+		}
+
+		if _, err := schedule.ParseWindow(entry.Selector, entry.When, entry.Timezone); err != nil {
+			add(field, "%s", err.Error())
+		}
+	}
+
+	names := map[string]int{}
+
+	for n, entry := range file.Hosts {
+		field := fmt.Sprintf("config.hosts[%d]", n)
+
+		if entry.Name == "" {
+			add(field+".name", "cannot be empty")
+		} else if previous, ok := names[entry.Name]; ok {
+			add(field+".name", "duplicates config.hosts[%d]", previous)
+		} else {
+			names[entry.Name] = n
+		}
+
+		if entry.Endpoint != "" {
+			// the default host is the one given by the mode.
+			if entry.Name == "default" {
+				add(field+".endpoint", "host \"default\" is given by -mode, it cannot have an endpoint")
+			} else if _, err := backend.ParseEndpoint(entry.Endpoint); err != nil {
+				add(field+".endpoint", "%s", err.Error())
+			}
+		}
+
+		for key := range entry.Labels {
+			if key == "" {
+				add(field+".labels", "label names cannot be empty")
+			}
+		}
+	}
+}
+
+// Validates the user and group to switch to.
+func validatePrivileges(o *options, add func(string, string, ...interface{})) {
+	if runtime.GOOS == "windows" {
+		add("-user", "dropping privileges is not supported on Windows")
+		return
+	}
+
+	if o.User != "" {
+		lookup := user.Lookup
+		if _, err := strconv.Atoi(o.User); err == nil {
+			lookup = user.LookupId
+		}
+
+		if _, err := lookup(o.User); err != nil {
+			add("-user", "%s", err.Error())
+		}
+	}
+
+	if o.Group != "" {
+		lookup := user.LookupGroup
+		if _, err := strconv.Atoi(o.Group); err == nil {
+			lookup = user.LookupGroupId
+		}
+
+		if _, err := lookup(o.Group); err != nil {
+			add("-group", "%s", err.Error())
+		}
+	}
+
+	// new connections cannot be opened to the socket afterwards.
+	if o.MaxDaemons > o.Daemons && o.Mode.Name == "socket" {
+		add("-daemons.max", "the pool cannot grow once privileges are dropped, use a fixed -daemons in socket mode")
+	}
+}
+
+// Registered repository names, sorted.
+func repositoryNames(cfg *Config) string {
+	names := make([]string, 0, len(cfg.Repositories))
+	for name := range cfg.Repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,73 @@
+//go:build !windows
+// +build !windows
+
+package statspout
+
+import (
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Switches to the given user and group, by name or numeric ID, once the Docker socket is open. If only the user is
+// given, its primary group is used. Supplementary groups are dropped, so the process cannot reopen the socket through
+// the docker group.
+func dropPrivileges(username string, groupname string) error {
+	uid, gid := -1, -1
+
+	if username != "" {
+		u, err := lookupUser(username)
+		if err != nil {
+			return err
+		}
+
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+
+	if groupname != "" {
+		g, err := lookupGroup(groupname)
+		if err != nil {
+			return err
+		}
+
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	// the group goes first, the user may not change it afterwards.
+	if gid >= 0 {
+		if err := syscall.Setgroups([]int{}); err != nil {
+			return err
+		}
+
+		if err := syscall.Setgid(gid); err != nil {
+			return err
+		}
+	}
+
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Looks up a user by name, or by ID if numeric.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+
+	return user.Lookup(name)
+}
+
+// Looks up a group by name, or by ID if numeric.
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupGroupId(name)
+	}
+
+	return user.LookupGroup(name)
+}
@@ -0,0 +1,10 @@
+package statspout
+
+import (
+	"errors"
+)
+
+// Dropping privileges is not supported on Windows, run the service as the desired account instead.
+func dropPrivileges(username string, groupname string) error {
+	return errors.New("Dropping privileges is not supported on Windows.")
+}
@@ -0,0 +1,135 @@
+/*
+Package receiver accepts stats forwarded by other statspout instances, through the forward repository, and pushes them
+to the local repository. This way edge agents that cannot reach the backends can go through a central aggregator.
+
+Example
+
+	server := receiver.New(":9100", repository, secret.New("@/run/secrets/token"))
+	server.Start()
+	defer server.Close()
+*/
+package receiver
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mijara/statspout/common"
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/secret"
+)
+
+// Label telling which agent forwarded the sample.
+const LABEL_AGENT = "statspout.agent"
+
+// Maximum size of a batch, in bytes.
+const MAX_BATCH_SIZE = 64 << 20
+
+// Server receiving forwarded batches.
+type Server struct {
+	repo   repo.Interface
+	token  secret.Secret
+	server *http.Server
+}
+
+// Creates the server, pushing received stats to the repository. If token is not nil, requests must carry its current
+// value as a bearer token.
+func New(address string, repository repo.Interface, token secret.Secret) *Server {
+	s := &Server{
+		repo:  repository,
+		token: token,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(common.FORWARD_PATH, s.ingest)
+
+	s.server = &http.Server{
+		Addr:    address,
+		Handler: mux,
+	}
+
+	return s
+}
+
+// Serves over TLS with the given configuration, which may require client certificates. Must be called before Start.
+func (s *Server) UseTLS(config *tls.Config) {
+	s.server.TLSConfig = config
+}
+
+// Starts serving in the background.
+func (s *Server) Start() {
+	go func() {
+		var err error
+		if s.server.TLSConfig != nil {
+			err = s.server.ListenAndServeTLS("", "")
+		} else {
+			err = s.server.ListenAndServe()
+		}
+
+		if err != http.ErrServerClosed {
+			log.Error.Fatal(err)
+		}
+	}()
+
+	log.Info.Printf("Receiver listening on %s", s.server.Addr)
+}
+
+// Stops the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Pushes a forwarded batch, labeled with the agent that sent it. Push errors are logged instead of returned,
+// otherwise the agent would send the whole batch again.
+func (s *Server) ingest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.token != nil {
+		// an unreadable token file rejects every request, instead of accepting any.
+		token := s.token.Value()
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	batch := common.ForwardBatch{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, MAX_BATCH_SIZE)).Decode(&batch); err != nil {
+		http.Error(w, "invalid batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repository := s.repo
+	if agent := r.Header.Get(common.FORWARD_AGENT_HEADER); agent != "" {
+		repository = repo.NewLabeled(s.repo, map[string]string{LABEL_AGENT: agent})
+	}
+
+	for n := range batch.Stats {
+		if err := repository.Push(&batch.Stats[n]); err != nil {
+			log.Error.Printf("Could not push forwarded stats: %s", err.Error())
+		}
+	}
+
+	if pusher, ok := repository.(repo.EventPusher); ok {
+		for n := range batch.Events {
+			if err := pusher.PushEvent(&batch.Events[n]); err != nil {
+				log.Error.Printf("Could not push forwarded event: %s", err.Error())
+			}
+		}
+	}
+
+	for _, name := range batch.Cleared {
+		repository.Clear(name)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
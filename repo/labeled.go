@@ -0,0 +1,115 @@
+package repo
+
+import (
+	"github.com/mijara/statspout/stats"
+)
+
+// Labeled adds fixed labels to every sample and event before pushing them to a repository, such as the host they
+// come from. These labels take precedence over the container labels of the same name.
+type Labeled struct {
+	repo   Interface
+	labels map[string]string
+}
+
+// Creates a repository that labels samples and events before pushing them to the given one.
+func NewLabeled(repository Interface, labels map[string]string) *Labeled {
+	return &Labeled{
+		repo:   repository,
+		labels: labels,
+	}
+}
+
+func (*Labeled) Name() string {
+	return "labeled"
+}
+
+func (l *Labeled) Create(v interface{}) (Interface, error) {
+	return NewLabeled(l.repo, l.labels), nil
+}
+
+// Pushes a copy of the stats with the labels added, container labels are shared and never modified.
+func (l *Labeled) Push(s *stats.Stats) error {
+	labeled := *s
+	labeled.Labels = l.merge(s.Labels)
+
+	return l.repo.Push(&labeled)
+}
+
+// Pushes a copy of the event with the labels added to its attributes.
+func (l *Labeled) PushEvent(event *stats.Event) error {
+	pusher, ok := l.repo.(EventPusher)
+	if !ok {
+		return nil
+	}
+
+	labeled := *event
+	labeled.Attributes = l.merge(event.Attributes)
+
+	return pusher.PushEvent(&labeled)
+}
+
+// Pushes a copy of the volume with the labels added.
+func (l *Labeled) PushVolume(volume *stats.Volume) error {
+	pusher, ok := l.repo.(VolumePusher)
+	if !ok {
+		return nil
+	}
+
+	labeled := *volume
+	labeled.Labels = l.merge(volume.Labels)
+
+	return pusher.PushVolume(&labeled)
+}
+
+// Pushes a copy of the processes with the labels added.
+func (l *Labeled) PushProcesses(processes *stats.Processes) error {
+	pusher, ok := l.repo.(ProcessPusher)
+	if !ok {
+		return nil
+	}
+
+	labeled := *processes
+	labeled.Labels = l.merge(processes.Labels)
+
+	return pusher.PushProcesses(&labeled)
+}
+
+// Tells the repository about the container, with the labels added.
+func (l *Labeled) ContainerAdded(container Container) {
+	if lifecycle, ok := l.repo.(Lifecycle); ok {
+		container.Labels = l.merge(container.Labels)
+		lifecycle.ContainerAdded(container)
+	}
+}
+
+func (l *Labeled) ContainerRemoved(container Container) {
+	if lifecycle, ok := l.repo.(Lifecycle); ok {
+		container.Labels = l.merge(container.Labels)
+		lifecycle.ContainerRemoved(container)
+	}
+}
+
+func (l *Labeled) Close() {
+	l.repo.Close()
+}
+
+// Clears the container of the host given by the labels, if any, see stats.LABEL_HOST.
+func (l *Labeled) Clear(name string) {
+	ClearHost(l.repo, l.labels[stats.LABEL_HOST], name)
+}
+
+func (l *Labeled) ClearHost(host string, name string) {
+	ClearHost(l.repo, host, name)
+}
+
+func (l *Labeled) merge(labels map[string]string) map[string]string {
+	merged := make(map[string]string, len(labels)+len(l.labels))
+	for key, value := range labels {
+		merged[key] = value
+	}
+	for key, value := range l.labels {
+		merged[key] = value
+	}
+
+	return merged
+}
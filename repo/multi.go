@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mijara/statspout/stats"
+)
+
+// Multi pushes stats to several repositories at once.
+type Multi struct {
+	repos []Interface
+}
+
+// Creates a repository that fans out to the given ones, in order.
+func NewMulti(repos ...Interface) *Multi {
+	return &Multi{repos: repos}
+}
+
+func (*Multi) Name() string {
+	return "multi"
+}
+
+func (m *Multi) Create(v interface{}) (Interface, error) {
+	return NewMulti(m.repos...), nil
+}
+
+// Pushes to every repository, even if some of them fail. Errors are joined.
+func (m *Multi) Push(s *stats.Stats) error {
+	var messages []string
+
+	for _, r := range m.repos {
+		if err := r.Push(s); err != nil {
+			messages = append(messages, r.Name()+": "+err.Error())
+		}
+	}
+
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return nil
+}
+
+// Pushes the event to every repository that can store events.
+func (m *Multi) PushEvent(event *stats.Event) error {
+	var messages []string
+
+	for _, r := range m.repos {
+		if pusher, ok := r.(EventPusher); ok {
+			if err := pusher.PushEvent(event); err != nil {
+				messages = append(messages, r.Name()+": "+err.Error())
+			}
+		}
+	}
+
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return nil
+}
+
+// Pushes the volume to every repository that can store volumes.
+func (m *Multi) PushVolume(volume *stats.Volume) error {
+	var messages []string
+
+	for _, r := range m.repos {
+		if pusher, ok := r.(VolumePusher); ok {
+			if err := pusher.PushVolume(volume); err != nil {
+				messages = append(messages, r.Name()+": "+err.Error())
+			}
+		}
+	}
+
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return nil
+}
+
+// Pushes the processes to every repository that can store processes.
+func (m *Multi) PushProcesses(processes *stats.Processes) error {
+	var messages []string
+
+	for _, r := range m.repos {
+		if pusher, ok := r.(ProcessPusher); ok {
+			if err := pusher.PushProcesses(processes); err != nil {
+				messages = append(messages, r.Name()+": "+err.Error())
+			}
+		}
+	}
+
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return nil
+}
+
+// Tells every repository managing containers about the container.
+func (m *Multi) ContainerAdded(container Container) {
+	for _, r := range m.repos {
+		if lifecycle, ok := r.(Lifecycle); ok {
+			lifecycle.ContainerAdded(container)
+		}
+	}
+}
+
+func (m *Multi) ContainerRemoved(container Container) {
+	for _, r := range m.repos {
+		if lifecycle, ok := r.(Lifecycle); ok {
+			lifecycle.ContainerRemoved(container)
+		}
+	}
+}
+
+func (m *Multi) Close() {
+	for _, r := range m.repos {
+		r.Close()
+	}
+}
+
+func (m *Multi) Clear(name string) {
+	for _, r := range m.repos {
+		r.Clear(name)
+	}
+}
+
+func (m *Multi) ClearHost(host string, name string) {
+	for _, r := range m.repos {
+		ClearHost(r, host, name)
+	}
+}
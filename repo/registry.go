@@ -0,0 +1,44 @@
+package repo
+
+import "sync"
+
+// Repository registered to be selected by name, along the factory of its options.
+type Registration struct {
+	Repository Interface
+
+	// Creates the options given to Create, usually defining their command line flags, nil if it has none. Only
+	// called when the repository is added to a configuration, so importing a repository defines no flags.
+	Options func() interface{}
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      []Registration
+)
+
+// Registers a repository, usually from the init function of its package, so programs importing it can select it by
+// name. Registering an empty or taken name is a programming error and panics.
+func Register(repository Interface, options func() interface{}) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if repository.Name() == "" {
+		panic("Got empty repository name.")
+	}
+
+	for _, r := range registry {
+		if r.Repository.Name() == repository.Name() {
+			panic("Repository name taken: " + repository.Name())
+		}
+	}
+
+	registry = append(registry, Registration{Repository: repository, Options: options})
+}
+
+// Registered repositories, in registration order.
+func Registered() []Registration {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	return append([]Registration(nil), registry...)
+}
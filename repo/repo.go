@@ -14,6 +14,8 @@ type Interface interface {
 
 	// Push container stats to this service.
 	// The repository should return an error if it's not capable of pushing the stats.
+	// The stats are reused once Push returns (see stats.Acquire): repositories must not keep the pointer, nor hand it
+	// to other goroutines, and must copy what they keep.
 	Push(stats *stats.Stats) error
 
 	// Close the service.
@@ -25,3 +27,65 @@ type Interface interface {
 	// Canonical name of this repository, used to identify it in the command line flags.
 	Name() string
 }
+
+// Optionally implemented by repositories that can store lifecycle events of the containers.
+type EventPusher interface {
+	// Push a container event to this service.
+	PushEvent(event *stats.Event) error
+}
+
+// Optionally implemented by repositories that can store the disk usage of the volumes of the containers.
+type VolumePusher interface {
+	// Push the disk usage of a volume to this service.
+	PushVolume(volume *stats.Volume) error
+}
+
+// Optionally implemented by repositories that can store the processes of the containers.
+type ProcessPusher interface {
+	// Push the processes of a container to this service.
+	PushProcesses(processes *stats.Processes) error
+}
+
+// Container coming or going, given to the lifecycle callbacks.
+type Container struct {
+	Name   string            // identity of the container, as in the stats pushed.
+	ID     string            // full container ID.
+	Image  string            // image name.
+	Labels map[string]string // labels of the container, along the fixed ones of the host.
+}
+
+// Optionally implemented by repositories that keep state per container, such as series or tables, to create and
+// clean it up as the containers come and go, instead of on the first push.
+type Lifecycle interface {
+	// Called once a container is known: when the collection of its host starts, and when it starts or is renamed.
+	ContainerAdded(container Container)
+
+	// Called once a container is gone: when it stops or is renamed, and when its host is removed from the fleet.
+	// Clear is called too.
+	ContainerRemoved(container Container)
+}
+
+// Optionally implemented by repositories that can check their options before being created, for example that the
+// backend is reachable or the address can be listened on. Used by the startup preflight checks.
+type Checker interface {
+	// Checks the given options, as passed to Create.
+	Check(v interface{}) error
+}
+
+// Optionally implemented by repositories that keep state per container, keyed by host and name since container names
+// are only unique within a host (see stats.LABEL_HOST). Clear clears the name on every host.
+type HostClearer interface {
+	// Clears data of the named container of the given host only.
+	ClearHost(host string, name string)
+}
+
+// Clears the named container of the host from the repository, or the name on every host if it does not implement
+// HostClearer.
+func ClearHost(repository Interface, host string, name string) {
+	if clearer, ok := repository.(HostClearer); ok && host != "" {
+		clearer.ClearHost(host, name)
+		return
+	}
+
+	repository.Clear(name)
+}
@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"github.com/mijara/statspout/stats"
+)
+
+// Selected pushes only some groups of metrics to a repository, so expensive backends only get the series they
+// store. Stats are pushed with the metrics of the other groups zeroed, see stats.Stats.Select.
+type Selected struct {
+	repo   Interface
+	groups stats.Groups
+}
+
+// Creates a repository pushing only the given groups to the given one, every group if nil.
+func NewSelected(repository Interface, groups stats.Groups) *Selected {
+	return &Selected{
+		repo:   repository,
+		groups: groups,
+	}
+}
+
+func (*Selected) Name() string {
+	return "selected"
+}
+
+func (s *Selected) Create(v interface{}) (Interface, error) {
+	return NewSelected(s.repo, s.groups), nil
+}
+
+// Pushes a copy of the stats with the metrics of the other groups zeroed.
+func (s *Selected) Push(sample *stats.Stats) error {
+	selected := *sample
+	selected.Select(s.groups)
+
+	return s.repo.Push(&selected)
+}
+
+// Pushes the event if events are selected.
+func (s *Selected) PushEvent(event *stats.Event) error {
+	pusher, ok := s.repo.(EventPusher)
+	if !ok || !s.groups.Has(stats.GROUP_EVENTS) {
+		return nil
+	}
+
+	return pusher.PushEvent(event)
+}
+
+func (s *Selected) PushVolume(volume *stats.Volume) error {
+	pusher, ok := s.repo.(VolumePusher)
+	if !ok {
+		return nil
+	}
+
+	return pusher.PushVolume(volume)
+}
+
+// Pushes the processes if pids are selected.
+func (s *Selected) PushProcesses(processes *stats.Processes) error {
+	pusher, ok := s.repo.(ProcessPusher)
+	if !ok || !s.groups.Has(stats.GROUP_PIDS) {
+		return nil
+	}
+
+	return pusher.PushProcesses(processes)
+}
+
+func (s *Selected) ContainerAdded(container Container) {
+	if lifecycle, ok := s.repo.(Lifecycle); ok {
+		lifecycle.ContainerAdded(container)
+	}
+}
+
+func (s *Selected) ContainerRemoved(container Container) {
+	if lifecycle, ok := s.repo.(Lifecycle); ok {
+		lifecycle.ContainerRemoved(container)
+	}
+}
+
+func (s *Selected) Close() {
+	s.repo.Close()
+}
+
+// Forwards the sends reported by the repository, if it sends in the background.
+func (s *Selected) Report(report func(count int, err error)) {
+	if reporter, ok := s.repo.(Reporter); ok {
+		reporter.Report(report)
+	}
+}
+
+func (s *Selected) Clear(name string) {
+	s.repo.Clear(name)
+}
+
+func (s *Selected) ClearHost(host string, name string) {
+	ClearHost(s.repo, host, name)
+}
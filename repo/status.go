@@ -0,0 +1,216 @@
+package repo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/stats"
+)
+
+// State of a repository, as tracked by Tracked.
+type Status struct {
+	Name                string     `json:"name"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`    // last push that succeeded, if any.
+	ConsecutiveFailures int        `json:"consecutive_failures"`      // pushes failed since the last success.
+	Failures            uint64     `json:"failures"`                  // pushes failed since started.
+	Queued              int        `json:"queued"`                    // samples waiting to be sent, see Queuer.
+	LastError           string     `json:"last_error,omitempty"`      // error of the last push that failed.
+	LastErrorTime       *time.Time `json:"last_error_time,omitempty"` // when the last push failed.
+}
+
+// Optionally implemented by repositories that buffer samples before sending them, such as the forward one.
+type Queuer interface {
+	// Samples waiting to be sent.
+	Queued() int
+}
+
+// Optionally implemented by repositories that send in the background, whose pushes succeed once buffered. The
+// outcome of each send is reported to the given function instead.
+type Reporter interface {
+	// Sets the function called with the outcome of each send, nil if it succeeded, along the number of samples and
+	// events it carried (points or lines for repositories splitting samples).
+	Report(report func(count int, err error))
+}
+
+// Tracked records the outcome of the pushes to a repository, so the state of each repository is reported by
+// Statuses until closed.
+type Tracked struct {
+	repo  Interface
+	async bool // pushes are only buffered, sends are reported instead.
+
+	mutex   sync.Mutex
+	status  Status
+	reports []func(count int, err error) // called with the sends reported, see Report.
+}
+
+var (
+	trackedMutex sync.Mutex
+	tracked      []*Tracked
+)
+
+// Creates a repository tracking the pushes to the given one.
+func Track(repository Interface) *Tracked {
+	t := &Tracked{
+		repo:   repository,
+		status: Status{Name: repository.Name()},
+	}
+
+	if reporter, ok := repository.(Reporter); ok {
+		t.async = true
+		reporter.Report(func(count int, err error) {
+			t.record(err)
+
+			t.mutex.Lock()
+			reports := t.reports
+			t.mutex.Unlock()
+
+			for _, report := range reports {
+				report(count, err)
+			}
+		})
+	}
+
+	trackedMutex.Lock()
+	defer trackedMutex.Unlock()
+
+	tracked = append(tracked, t)
+	return t
+}
+
+// State of the tracked repositories, in the order they were tracked.
+func Statuses() []Status {
+	trackedMutex.Lock()
+	repos := append([]*Tracked(nil), tracked...)
+	trackedMutex.Unlock()
+
+	statuses := make([]Status, 0, len(repos))
+	for _, t := range repos {
+		statuses = append(statuses, t.Status())
+	}
+
+	return statuses
+}
+
+// State of the repository.
+func (t *Tracked) Status() Status {
+	t.mutex.Lock()
+	status := t.status
+	t.mutex.Unlock()
+
+	if queuer, ok := t.repo.(Queuer); ok {
+		status.Queued = queuer.Queued()
+	}
+
+	return status
+}
+
+func (t *Tracked) Name() string {
+	return t.repo.Name()
+}
+
+// Calls the function with the outcome of each send the repository reports, along the other ones, such as an audit
+// log. Never called if the repository does not send in the background.
+func (t *Tracked) Report(report func(count int, err error)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.reports = append(t.reports, report)
+}
+
+func (t *Tracked) Create(v interface{}) (Interface, error) {
+	return Track(t.repo), nil
+}
+
+func (t *Tracked) Push(s *stats.Stats) error {
+	return t.pushed(t.repo.Push(s))
+}
+
+func (t *Tracked) PushEvent(event *stats.Event) error {
+	pusher, ok := t.repo.(EventPusher)
+	if !ok {
+		return nil
+	}
+
+	return t.pushed(pusher.PushEvent(event))
+}
+
+func (t *Tracked) PushVolume(volume *stats.Volume) error {
+	pusher, ok := t.repo.(VolumePusher)
+	if !ok {
+		return nil
+	}
+
+	return t.pushed(pusher.PushVolume(volume))
+}
+
+func (t *Tracked) PushProcesses(processes *stats.Processes) error {
+	pusher, ok := t.repo.(ProcessPusher)
+	if !ok {
+		return nil
+	}
+
+	return t.pushed(pusher.PushProcesses(processes))
+}
+
+// Stops tracking the repository and closes it.
+func (t *Tracked) Close() {
+	trackedMutex.Lock()
+	for i, other := range tracked {
+		if other == t {
+			tracked = append(tracked[:i:i], tracked[i+1:]...)
+			break
+		}
+	}
+	trackedMutex.Unlock()
+
+	t.repo.Close()
+}
+
+func (t *Tracked) Clear(name string) {
+	t.repo.Clear(name)
+}
+
+func (t *Tracked) ClearHost(host string, name string) {
+	ClearHost(t.repo, host, name)
+}
+
+func (t *Tracked) ContainerAdded(container Container) {
+	if lifecycle, ok := t.repo.(Lifecycle); ok {
+		lifecycle.ContainerAdded(container)
+	}
+}
+
+func (t *Tracked) ContainerRemoved(container Container) {
+	if lifecycle, ok := t.repo.(Lifecycle); ok {
+		lifecycle.ContainerRemoved(container)
+	}
+}
+
+// Records the outcome of a push, and returns its error. Buffered pushes of background senders are not a success.
+func (t *Tracked) pushed(err error) error {
+	if t.async && err == nil {
+		return nil
+	}
+
+	return t.record(err)
+}
+
+// Records the outcome of a push or send, and returns its error.
+func (t *Tracked) record(err error) error {
+	now := time.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err != nil {
+		t.status.ConsecutiveFailures++
+		t.status.Failures++
+		t.status.LastError = err.Error()
+		t.status.LastErrorTime = &now
+	} else {
+		t.status.ConsecutiveFailures = 0
+		t.status.LastSuccess = &now
+	}
+
+	return err
+}
@@ -0,0 +1,274 @@
+/*
+Polling scheduler:
+Decides which containers have to be queried at each tick, allowing containers to be polled at different
+intervals than the default one, either by matching rules or by the statspout.interval label. Windows limit
+when the containers matching them are collected.
+
+The tick is the smallest of the default interval and the intervals of the rules, labels cannot poll a container
+more often than that, so the ones below the tick are ignored with a warning.
+
+# Selectors
+
+A selector containing '=' matches a label, as key=pattern, otherwise it matches the container name. Patterns
+follow the path.Match syntax, for example:
+
+	*-canary     containers whose name ends with -canary.
+	tier=batch   containers with the label tier set to batch.
+	tier=*       containers with the label tier set to anything.
+*/
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/log"
+)
+
+const (
+	// label used to override the interval of a single container.
+	LABEL_INTERVAL = "statspout.interval"
+)
+
+// Maps containers matching a selector to a polling interval.
+type Rule struct {
+	Selector string
+	Interval time.Duration
+}
+
+type Scheduler struct {
+	interval time.Duration        // default interval.
+	rules    []Rule               // rules in order of precedence.
+	tick     time.Duration        // resolution of the scheduler.
+	stretch  float64              // factor applied to every interval, see SetStretch.
+	sample   int                  // containers queried each tick, all of the due ones if 0.
+	windows  []Window             // when the containers matching them are collected, see SetWindows.
+	cursor   string               // last container sampled, the next tick starts after it.
+	next     map[string]time.Time // next time each container is due.
+}
+
+// Creates a scheduler with the given default interval and override rules, the first matching rule
+// wins. The tick of the scheduler will be the smallest of all the intervals.
+func New(interval time.Duration, rules []Rule) (*Scheduler, error) {
+	tick := interval
+
+	for _, rule := range rules {
+		if err := CheckSelector(rule.Selector); err != nil {
+			return nil, fmt.Errorf("Invalid selector %q: %s", rule.Selector, err.Error())
+		}
+
+		if rule.Interval <= 0 {
+			return nil, fmt.Errorf("Invalid interval for selector %q: %s", rule.Selector, rule.Interval)
+		}
+
+		if rule.Interval < tick {
+			tick = rule.Interval
+		}
+	}
+
+	return &Scheduler{
+		interval: interval,
+		rules:    rules,
+		tick:     tick,
+		stretch:  1,
+		next:     make(map[string]time.Time),
+	}, nil
+}
+
+// Time between each call to Due, containers with an interval smaller than this will be polled every tick.
+func (s *Scheduler) Tick() time.Duration {
+	return s.tick
+}
+
+// Interval of the given container, the label override takes precedence over the rules unless it is invalid or
+// below the tick.
+func (s *Scheduler) Interval(container backend.Container) time.Duration {
+	if interval, err := s.labelInterval(container); err == nil && interval > 0 {
+		return interval
+	}
+
+	for _, rule := range s.rules {
+		if Match(rule.Selector, container) {
+			return rule.Interval
+		}
+	}
+
+	return s.interval
+}
+
+// Stretches every interval by the factor, to poll less often while Docker is slow. Factors below 1 are taken as 1,
+// intervals are never shortened. Containers already scheduled keep their next time.
+func (s *Scheduler) SetStretch(factor float64) {
+	if factor < 1 {
+		factor = 1
+	}
+
+	s.stretch = factor
+}
+
+// Factor applied to every interval.
+func (s *Scheduler) Stretch() float64 {
+	return s.stretch
+}
+
+// Queries at most k of the due containers each tick, in round-robin by name, bounding the load of each tick. The
+// containers left out stay due for the next ticks, so each one is polled less often than its interval. All of the
+// due containers are queried if k is 0.
+func (s *Scheduler) SetSample(k int) {
+	s.sample = k
+}
+
+// Collects the containers matching the selector of any window only while one of their windows is open. Containers
+// matching none are always collected.
+func (s *Scheduler) SetWindows(windows []Window) {
+	s.windows = windows
+}
+
+// Names of the containers that should be queried now, skipping the ones rejected by the filter and the ones whose
+// windows are closed.
+func (s *Scheduler) Due(now time.Time, containers map[string]backend.Container,
+	filter func(backend.Container) bool) []string {
+	// forget containers that are gone.
+	for name := range s.next {
+		if _, ok := containers[name]; !ok {
+			delete(s.next, name)
+		}
+	}
+
+	names := make([]string, 0, len(containers))
+
+	for name, container := range containers {
+		if !filter(container) || !collecting(s.windows, container, now) {
+			continue
+		}
+
+		// tolerate some delay of the ticker, otherwise containers would skip whole ticks.
+		if next, ok := s.next[name]; ok && now.Before(next.Add(-s.tick/2)) {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	names = s.rotate(names)
+
+	for _, name := range names {
+		container := containers[name]
+		if _, ok := s.next[name]; !ok {
+			s.warn(container)
+		}
+
+		s.next[name] = now.Add(time.Duration(float64(s.Interval(container)) * s.stretch))
+	}
+
+	return names
+}
+
+// The sample of the due containers, starting after the last one sampled.
+func (s *Scheduler) rotate(names []string) []string {
+	if s.sample <= 0 || len(names) <= s.sample {
+		return names
+	}
+
+	sort.Strings(names)
+
+	start := sort.Search(len(names), func(i int) bool {
+		return names[i] > s.cursor
+	})
+
+	sample := make([]string, 0, s.sample)
+	for i := 0; i < s.sample; i++ {
+		sample = append(sample, names[(start+i)%len(names)])
+	}
+
+	s.cursor = sample[len(sample)-1]
+	return sample
+}
+
+// Interval given by the label of the container, 0 if it has none. Intervals below the tick are rejected, since the
+// container could not be polled that often.
+func (s *Scheduler) labelInterval(container backend.Container) (time.Duration, error) {
+	value, ok := container.Labels[LABEL_INTERVAL]
+	if !ok {
+		return 0, nil
+	}
+
+	interval, err := ParseInterval(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if interval <= 0 {
+		return 0, errors.New("not positive")
+	}
+
+	if interval < s.tick {
+		return 0, fmt.Errorf("below the tick of %s", s.tick)
+	}
+
+	return interval, nil
+}
+
+// Warns about invalid label overrides.
+func (s *Scheduler) warn(container backend.Container) {
+	if _, err := s.labelInterval(container); err != nil {
+		log.Warning.Printf("Invalid %s label on %s: %q (%s), using %s.", LABEL_INTERVAL,
+			container.CanonicalName, container.Labels[LABEL_INTERVAL], err.Error(), s.Interval(container))
+	}
+}
+
+// Parses an interval given as a Go duration (500ms, 2s, 1m), or as a plain number of seconds.
+func ParseInterval(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// Checks that the selector is well formed.
+func CheckSelector(selector string) error {
+	_, err := path.Match(pattern(selector), "")
+	return err
+}
+
+// Checks if the selector matches the container.
+func Match(selector string, container backend.Container) bool {
+	if key, value, ok := label(selector); ok {
+		actual, ok := container.Labels[key]
+		if !ok {
+			return false
+		}
+
+		matched, _ := path.Match(value, actual)
+		return matched
+	}
+
+	matched, _ := path.Match(selector, container.CanonicalName)
+	return matched
+}
+
+// Splits a label selector in key and pattern.
+func label(selector string) (string, string, bool) {
+	parts := strings.SplitN(selector, "=", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// The pattern part of a selector.
+func pattern(selector string) string {
+	if _, value, ok := label(selector); ok {
+		return value
+	}
+
+	return selector
+}
@@ -0,0 +1,75 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+)
+
+func TestIntervalLabel(t *testing.T) {
+	s, err := New(5*time.Second, []Rule{{Selector: "*-canary", Interval: 2 * time.Second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Tick() != 2*time.Second {
+		t.Fatalf("tick: expected 2s, got %s", s.Tick())
+	}
+
+	tests := []struct {
+		name     string
+		label    string
+		expected time.Duration
+	}{
+		{"web", "", 5 * time.Second},
+		{"web", "30s", 30 * time.Second},
+		{"web", "10", 10 * time.Second},
+		{"web", "2s", 2 * time.Second},
+		{"web", "500ms", 5 * time.Second},
+		{"web", "-1s", 5 * time.Second},
+		{"web", "often", 5 * time.Second},
+		{"web-canary", "1s", 2 * time.Second},
+		{"web-canary", "1m", time.Minute},
+	}
+
+	for _, test := range tests {
+		container := backend.Container{CanonicalName: test.name, Labels: map[string]string{}}
+		if test.label != "" {
+			container.Labels[LABEL_INTERVAL] = test.label
+		}
+
+		if interval := s.Interval(container); interval != test.expected {
+			t.Errorf("%s with %q: expected %s, got %s", test.name, test.label, test.expected, interval)
+		}
+	}
+}
+
+func TestDueRejectsLabelBelowTick(t *testing.T) {
+	s, err := New(time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containers := map[string]backend.Container{
+		"fast": {CanonicalName: "fast", Labels: map[string]string{LABEL_INTERVAL: "100ms"}},
+		"slow": {CanonicalName: "slow", Labels: map[string]string{LABEL_INTERVAL: "3s"}},
+	}
+	all := func(backend.Container) bool { return true }
+
+	now := time.Now()
+	due := 0
+	for i := 0; i < 6; i++ {
+		for _, name := range s.Due(now, containers, all) {
+			if name == "fast" {
+				due++
+			}
+		}
+		now = now.Add(s.Tick())
+	}
+
+	// polled once per tick, not once every 100ms.
+	if due != 6 {
+		t.Errorf("expected the container to be due on each of the 6 ticks, got %d", due)
+	}
+}
@@ -0,0 +1,179 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mijara/statspout/backend"
+)
+
+// Days of the week in windows, starting on Sunday as time.Weekday does.
+var DAYS = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Limits the collection of the containers matching the selector to some days and hours, such as business hours or
+// the window of a batch job. Containers matching the selectors of several windows are collected while any is open.
+type Window struct {
+	Selector string
+	Days     [7]bool        // days the window opens, by time.Weekday.
+	Start    time.Duration  // time of the day the window opens.
+	End      time.Duration  // time of the day the window closes, the next day if not after Start.
+	Location *time.Location // time zone of the days and times.
+}
+
+// Parses a window given as days and a time range, such as "mon-fri 09:00-18:00", "sat,sun 00:00-24:00" or
+// "22:00-06:00" (every day, closing the next morning). Days are lists and ranges of sun, mon, tue, wed, thu, fri
+// and sat, or * for every day. Times are given in the zone, an IANA name such as Europe/Madrid, local if empty.
+func ParseWindow(selector string, spec string, zone string) (Window, error) {
+	if err := CheckSelector(selector); err != nil {
+		return Window{}, fmt.Errorf("Invalid selector %q: %s", selector, err.Error())
+	}
+
+	window := Window{Selector: selector, Location: time.Local}
+
+	if zone != "" {
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return Window{}, err
+		}
+		window.Location = location
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 1:
+		fields = []string{"*", fields[0]}
+	case 2:
+	default:
+		return Window{}, errors.New("Invalid window " + strconv.Quote(spec) +
+			", use days and times as mon-fri 09:00-18:00.")
+	}
+
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return Window{}, err
+	}
+	window.Days = days
+
+	times := strings.SplitN(fields[1], "-", 2)
+	if len(times) != 2 {
+		return Window{}, errors.New("Invalid time range " + fields[1] + ", use times as 09:00-18:00.")
+	}
+
+	if window.Start, err = parseTime(times[0]); err != nil {
+		return Window{}, err
+	}
+
+	if window.Start == 24*time.Hour {
+		return Window{}, errors.New("Invalid start time " + times[0] + ", use 00:00.")
+	}
+
+	if window.End, err = parseTime(times[1]); err != nil {
+		return Window{}, err
+	}
+
+	if window.Start == window.End {
+		return Window{}, errors.New("Empty time range " + fields[1] + ".")
+	}
+
+	return window, nil
+}
+
+// Whether the window is open at the given time.
+func (w Window) Open(now time.Time) bool {
+	t := now.In(w.Location)
+	day := t.Weekday()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+
+	if w.Start < w.End {
+		return w.Days[day] && offset >= w.Start && offset < w.End
+	}
+
+	// opened the day before, or opening today.
+	return (w.Days[day] && offset >= w.Start) || (w.Days[(day+6)%7] && offset < w.End)
+}
+
+// Whether the container may be collected at the given time: if no window matches it, or any matching one is open.
+func collecting(windows []Window, container backend.Container, now time.Time) bool {
+	matched := false
+
+	for _, window := range windows {
+		if !Match(window.Selector, container) {
+			continue
+		}
+
+		if window.Open(now) {
+			return true
+		}
+		matched = true
+	}
+
+	return !matched
+}
+
+// Parses days such as mon-fri, sat,sun or *.
+func parseDays(spec string) ([7]bool, error) {
+	var days [7]bool
+
+	if spec == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		first, err := parseDay(bounds[0])
+		if err != nil {
+			return days, err
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			if last, err = parseDay(bounds[1]); err != nil {
+				return days, err
+			}
+		}
+
+		// ranges may wrap around the week, as fri-mon.
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+func parseDay(name string) (int, error) {
+	for i, day := range DAYS {
+		if strings.ToLower(name) == day {
+			return i, nil
+		}
+	}
+
+	return 0, errors.New("Unknown day " + name + ", use " + strings.Join(DAYS, ", ") + " or *.")
+}
+
+// Parses a time of the day as HH:MM, up to 24:00.
+func parseTime(value string) (time.Duration, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) == 2 {
+		hours, errHours := strconv.Atoi(parts[0])
+		minutes, errMinutes := strconv.Atoi(parts[1])
+
+		if errHours == nil && errMinutes == nil && hours >= 0 && minutes >= 0 && minutes < 60 &&
+			hours*60+minutes <= 24*60 {
+			return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+		}
+	}
+
+	return 0, errors.New("Invalid time " + value + ", use HH:MM.")
+}
@@ -0,0 +1,256 @@
+/*
+Package secret holds credentials that can be rotated without restarting: tokens, certificates and CAs read from files
+are loaded again whenever the files change, as when Kubernetes or Vault update a mounted secret.
+
+Example
+
+	token := secret.New("@/run/secrets/token") // or a literal value.
+	token.Value()
+
+	pair, err := secret.NewKeyPair("/run/secrets/tls.crt", "/run/secrets/tls.key")
+	config := &tls.Config{GetCertificate: pair.GetCertificate}
+*/
+package secret
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+)
+
+// Files are checked for changes at most once in this time.
+const CHECK_INTERVAL = 5 * time.Second
+
+// Prefix of values read from a file.
+const FILE_PREFIX = "@"
+
+// Secret value, which may change over time.
+type Secret interface {
+	Value() string
+}
+
+// Secret given as is.
+type Static string
+
+func (s Static) Value() string {
+	return string(s)
+}
+
+// Creates a secret from a flag value: @/path/to/file reads it from the file, trimming spaces, and again whenever the
+// file changes. Other values are taken as is.
+func New(value string) Secret {
+	if !strings.HasPrefix(value, FILE_PREFIX) {
+		return Static(value)
+	}
+
+	f := &File{}
+	f.paths = []string{strings.TrimPrefix(value, FILE_PREFIX)}
+	f.load = func(data [][]byte) error {
+		value := strings.TrimSpace(string(data[0]))
+		if value == "" {
+			return errors.New("Secret file " + f.paths[0] + " is empty.")
+		}
+
+		f.value = value
+		return nil
+	}
+	f.reload()
+
+	return f
+}
+
+// Checks that a flag value given to New can be read: files must exist and not be empty.
+func Check(value string) error {
+	if !strings.HasPrefix(value, FILE_PREFIX) {
+		return nil
+	}
+
+	path := strings.TrimPrefix(value, FILE_PREFIX)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(string(data)) == "" {
+		return errors.New("Secret file " + path + " is empty.")
+	}
+
+	return nil
+}
+
+// Secret read from a file.
+type File struct {
+	watch
+	value string
+}
+
+// Current value of the secret, empty if the file could never be read.
+func (f *File) Value() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.check()
+	return f.value
+}
+
+// Certificate and private key files, loaded again when either changes.
+type KeyPair struct {
+	watch
+	cert *tls.Certificate
+}
+
+// Loads the certificate and private key files.
+func NewKeyPair(certFile string, keyFile string) (*KeyPair, error) {
+	p := &KeyPair{}
+	p.paths = []string{certFile, keyFile}
+	p.load = func(data [][]byte) error {
+		cert, err := tls.X509KeyPair(data[0], data[1])
+		if err != nil {
+			return err
+		}
+
+		p.cert = &cert
+		return nil
+	}
+
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Current certificate.
+func (p *KeyPair) Certificate() *tls.Certificate {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.check()
+	return p.cert
+}
+
+// Current certificate, as tls.Config.GetCertificate on servers.
+func (p *KeyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return p.Certificate(), nil
+}
+
+// Current certificate, as tls.Config.GetClientCertificate on clients.
+func (p *KeyPair) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return p.Certificate(), nil
+}
+
+// File of PEM certificates, loaded again when it changes.
+type CertPool struct {
+	watch
+	pool *x509.CertPool
+}
+
+// Loads the PEM certificates of the file.
+func NewCertPool(path string) (*CertPool, error) {
+	p := &CertPool{}
+	p.paths = []string{path}
+	p.load = func(data [][]byte) error {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data[0]) {
+			return errors.New("No PEM certificates found in " + path)
+		}
+
+		p.pool = pool
+		return nil
+	}
+
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Current certificates.
+func (p *CertPool) Pool() *x509.CertPool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.check()
+	return p.pool
+}
+
+// Files loaded again when they change, guarded by mutex.
+type watch struct {
+	paths []string
+	load  func(data [][]byte) error // parses the contents of the files, in order.
+
+	mutex   sync.Mutex
+	checked time.Time // last check for changes.
+	stamp   string    // modification times and sizes of the files when last loaded.
+}
+
+// Loads the files again if they changed, at most once per CHECK_INTERVAL. Errors are logged and the previous value is
+// kept, since a rotation may update the files one at a time.
+func (w *watch) check() {
+	if time.Since(w.checked) < CHECK_INTERVAL {
+		return
+	}
+	w.checked = time.Now()
+
+	if stamp, err := w.stat(); err == nil && stamp == w.stamp {
+		return
+	}
+
+	if err := w.reload(); err != nil {
+		log.Warning.Printf("Could not reload %s, keeping the previous one: %s", strings.Join(w.paths, ", "), err.Error())
+	} else {
+		log.Info.Printf("Reloaded %s.", strings.Join(w.paths, ", "))
+	}
+}
+
+// Reads and loads the files.
+func (w *watch) reload() error {
+	w.checked = time.Now()
+
+	stamp, err := w.stat()
+	if err != nil {
+		return err
+	}
+
+	data := make([][]byte, len(w.paths))
+	for n, path := range w.paths {
+		if data[n], err = ioutil.ReadFile(path); err != nil {
+			return err
+		}
+	}
+
+	if err := w.load(data); err != nil {
+		return err
+	}
+
+	w.stamp = stamp
+	return nil
+}
+
+// Modification times and sizes of the files, following symbolic links as Kubernetes swaps them on updates.
+func (w *watch) stat() (string, error) {
+	var stamp strings.Builder
+
+	for _, path := range w.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+
+		stamp.WriteString(strconv.FormatInt(info.ModTime().UnixNano(), 10))
+		stamp.WriteString("/")
+		stamp.WriteString(strconv.FormatInt(info.Size(), 10))
+		stamp.WriteString(";")
+	}
+
+	return stamp.String(), nil
+}
@@ -0,0 +1,124 @@
+/*
+Package shard spreads containers across statspout instances with consistent hashing, so each instance monitors only
+the containers that hash to it, and few containers move when instances join or leave.
+
+Example
+
+	ring := shard.New("statspout-1", []string{"statspout-1", "statspout-2", "statspout-3"})
+
+	if ring.Owns(container.CanonicalName) {
+		...
+	}
+*/
+package shard
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Points of each member on the ring, more points spread containers more evenly.
+const REPLICAS = 128
+
+// Ring assigns keys to members by consistent hashing. Members can change while in use.
+type Ring struct {
+	self string
+
+	mutex  sync.RWMutex
+	points []uint64          // sorted hashes of the members points.
+	owners map[uint64]string // member of each point.
+}
+
+// Creates a ring with the given members, self is the member of this instance.
+func New(self string, members []string) *Ring {
+	r := &Ring{self: self}
+	r.SetMembers(members)
+
+	return r
+}
+
+// Replaces the members of the ring.
+func (r *Ring) SetMembers(members []string) {
+	points := make([]uint64, 0, len(members)*REPLICAS)
+	owners := make(map[uint64]string, len(members)*REPLICAS)
+
+	for _, member := range members {
+		for i := 0; i < REPLICAS; i++ {
+			point := hash(member + "#" + strconv.Itoa(i))
+			points = append(points, point)
+			owners[point] = member
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i] < points[j]
+	})
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.points = points
+	r.owners = owners
+}
+
+// Members of the ring, sorted.
+func (r *Ring) Members() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	seen := map[string]bool{}
+	members := []string{}
+	for _, member := range r.owners {
+		if !seen[member] {
+			seen[member] = true
+			members = append(members, member)
+		}
+	}
+	sort.Strings(members)
+
+	return members
+}
+
+// Member owning the key, the first point of the ring after the hash of the key. Empty if there are no members.
+func (r *Ring) Owner(key string) string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+
+	// wrap around.
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.owners[r.points[i]]
+}
+
+// Checks if the key is owned by this instance.
+func (r *Ring) Owns(key string) bool {
+	return r.Owner(key) == r.self
+}
+
+// FNV-1a followed by the MurmurHash3 finalizer, since FNV alone spreads similar keys poorly.
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
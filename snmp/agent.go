@@ -0,0 +1,478 @@
+/*
+SNMP agent:
+Exposes the latest stats of each container to SNMPv1 and SNMPv2c managers under a private MIB, see STATSPOUT-MIB.txt,
+so network management systems that only speak SNMP can monitor the containers. Only reads are answered (get,
+get-next and get-bulk), messages are encoded without an SNMP library.
+
+Objects, under the root given to New
+
+	<root>.1.1.<column>.<index>   statspoutContainerTable, one row per container, indexed by a number kept while
+	                              statspout runs.
+	<root>.2.0                    statspoutContainers, number of containers.
+
+along sysDescr, sysObjectID (the root), sysUpTime and sysName of the system group.
+*/
+package snmp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/secret"
+	"github.com/mijara/statspout/stats"
+	"github.com/mijara/statspout/version"
+)
+
+// Root of the objects by default, under the experimental arc of Net-SNMP meant for local use. Sites with their own
+// enterprise number should use a root under it.
+const DEFAULT_ROOT = "1.3.6.1.4.1.8072.9999.9999.1"
+
+// Variable bindings answered per get-bulk request, to keep responses within a datagram.
+const MAX_BINDINGS = 256
+
+// Error statuses of the responses.
+const (
+	errNoSuchName  = 2
+	errReadOnly    = 4
+	errNotWritable = 17
+)
+
+// Versions of the protocol, as encoded in messages.
+const (
+	version1  = 0
+	version2c = 1
+)
+
+// Prefix of the system group, of SNMPv2-MIB.
+var system = OID{1, 3, 6, 1, 2, 1, 1}
+
+// Columns of the container table, as its arcs, encoding the value of a sample.
+var columns = []struct {
+	arc   uint32
+	value func(index int, s *stats.Stats) []byte
+}{
+	{1, func(index int, s *stats.Stats) []byte { return encodeInt(int64(index)) }},
+	{2, func(index int, s *stats.Stats) []byte { return encodeString(s.QualifiedName()) }},
+	{3, func(index int, s *stats.Stats) []byte { return encodeString(s.Image) }},
+	{4, func(index int, s *stats.Stats) []byte { return encodeUint(tagGauge32, hundredths(s.CpuPercent)) }},
+	{5, func(index int, s *stats.Stats) []byte { return encodeUint(tagGauge32, kibibytes(s.MemoryUsage)) }},
+	{6, func(index int, s *stats.Stats) []byte { return encodeUint(tagGauge32, hundredths(s.MemoryPercent)) }},
+	{7, func(index int, s *stats.Stats) []byte { return encodeUint(tagGauge32, kibibytes(s.MemoryLimit)) }},
+	{8, func(index int, s *stats.Stats) []byte { return encodeUint(tagCounter32, counter32(s.TxBytesTotal)) }},
+	{9, func(index int, s *stats.Stats) []byte { return encodeUint(tagCounter32, counter32(s.RxBytesTotal)) }},
+	{10, func(index int, s *stats.Stats) []byte { return encodeUint(tagCounter32, counter32(s.TxPacketsTotal)) }},
+	{11, func(index int, s *stats.Stats) []byte { return encodeUint(tagCounter32, counter32(s.RxPacketsTotal)) }},
+}
+
+// Value of a Counter32 column, which wraps at 2^32 as the ifInOctets of IF-MIB, managers account for the wrap.
+func counter32(v uint64) uint64 {
+	return v & (1<<32 - 1)
+}
+
+// Agent is a repository keeping the latest sample of each container, answering SNMP requests for them.
+type Agent struct {
+	address   string
+	community secret.Secret
+	root      OID
+	conn      net.PacketConn
+	started   time.Time
+	hostname  string
+
+	mutex   sync.RWMutex
+	latest  map[string]stats.Stats // latest sample of each container, by qualified name.
+	indexes map[string]int         // row of each container, not reused while running.
+	next    int
+}
+
+// Variable of the MIB, with its encoded value.
+type variable struct {
+	oid   OID
+	value []byte
+}
+
+// Creates an agent for the given UDP address, answering requests carrying the community, with the objects under the
+// given root.
+func New(address string, community secret.Secret, root OID) *Agent {
+	hostname, _ := os.Hostname()
+
+	return &Agent{
+		address:   address,
+		community: community,
+		root:      root,
+		hostname:  hostname,
+		latest:    map[string]stats.Stats{},
+		indexes:   map[string]int{},
+		next:      1,
+	}
+}
+
+func (*Agent) Name() string {
+	return "snmp"
+}
+
+func (a *Agent) Create(v interface{}) (repo.Interface, error) {
+	return New(a.address, a.community, a.root), nil
+}
+
+// Keeps the sample as the latest of its container.
+func (a *Agent) Push(s *stats.Stats) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	name := s.QualifiedName()
+	if _, ok := a.indexes[name]; !ok {
+		a.indexes[name] = a.next
+		a.next++
+	}
+
+	a.latest[name] = *s
+	return nil
+}
+
+// Removes the rows of the container on every host.
+func (a *Agent) Clear(name string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for qualified, sample := range a.latest {
+		if sample.Name == name {
+			delete(a.latest, qualified)
+			delete(a.indexes, qualified)
+		}
+	}
+}
+
+// Removes the row of the container of the host.
+func (a *Agent) ClearHost(host string, name string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.latest, stats.QualifiedName(host, name))
+	delete(a.indexes, stats.QualifiedName(host, name))
+}
+
+// Starts answering requests in the background.
+func (a *Agent) Start() error {
+	conn, err := net.ListenPacket("udp", a.address)
+	if err != nil {
+		return err
+	}
+
+	a.conn = conn
+	a.started = time.Now()
+
+	go a.serve()
+
+	log.Info.Printf("SNMP agent listening on %s", conn.LocalAddr())
+	return nil
+}
+
+// Stops answering requests.
+func (a *Agent) Close() {
+	if a.conn != nil {
+		a.conn.Close()
+	}
+}
+
+func (a *Agent) serve() {
+	buffer := make([]byte, 65535)
+
+	for {
+		n, addr, err := a.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		response, err := a.handle(buffer[:n])
+		if err != nil {
+			log.Debug.Printf("Dropped SNMP request from %s: %s", addr, err.Error())
+			continue
+		}
+
+		if _, err := a.conn.WriteTo(response, addr); err != nil {
+			log.Warning.Printf("Could not answer SNMP request from %s: %s", addr, err.Error())
+		}
+	}
+}
+
+// Answers a request, an error if it must be dropped.
+func (a *Agent) handle(packet []byte) ([]byte, error) {
+	message, _, err := decodeTag(packet, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+
+	version, message, err := decodeInt(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != version1 && version != version2c {
+		return nil, errors.New("unsupported version")
+	}
+
+	community, message, err := decodeTag(message, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(community) != a.community.Value() {
+		return nil, errors.New("wrong community")
+	}
+
+	kind, pdu, _, err := decode(message)
+	if err != nil {
+		return nil, err
+	}
+
+	id, pdu, err := decodeInt(pdu)
+	if err != nil {
+		return nil, err
+	}
+
+	// error status and index, or non-repeaters and max-repetitions on get-bulk.
+	first, pdu, err := decodeInt(pdu)
+	if err != nil {
+		return nil, err
+	}
+
+	second, pdu, err := decodeInt(pdu)
+	if err != nil {
+		return nil, err
+	}
+
+	oids, err := decodeBindings(pdu)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := a.variables()
+
+	var bindings [][]byte
+	var status, index int
+
+	switch {
+	case kind == pduGet:
+		bindings, status, index = get(variables, oids, version)
+	case kind == pduGetNext:
+		bindings, status, index = getNext(variables, oids, version)
+	case kind == pduGetBulk && version == version2c:
+		bindings = getBulk(variables, oids, int(first), int(second))
+	case kind == pduSet:
+		bindings = nulls(oids)
+		status, index = errNotWritable, 1
+		if version == version1 {
+			status = errReadOnly
+		}
+	default:
+		return nil, errors.New("unsupported PDU")
+	}
+
+	// requests are echoed on errors.
+	if status != 0 {
+		bindings = nulls(oids)
+	}
+
+	var list []byte
+	for _, binding := range bindings {
+		list = append(list, binding...)
+	}
+
+	response := append(encodeInt(id), encodeInt(int64(status))...)
+	response = append(response, encodeInt(int64(index))...)
+	response = append(response, encode(tagSequence, list)...)
+
+	content := append(encodeInt(version), encodeString(string(community))...)
+	content = append(content, encode(pduResponse, response)...)
+
+	return encode(tagSequence, content), nil
+}
+
+// Variables of the system group and of the containers, sorted by identifier.
+func (a *Agent) variables() []variable {
+	variables := []variable{
+		{system.Append(1, 0), encodeString(version.String())},
+		{system.Append(2, 0), encodeOID(a.root)},
+		{system.Append(3, 0), encodeUint(tagTimeTicks, uint64(time.Since(a.started)/(10*time.Millisecond))&0xffffffff)},
+		{system.Append(5, 0), encodeString(a.hostname)},
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	variables = append(variables, variable{a.root.Append(2, 0), encodeUint(tagGauge32, uint64(len(a.latest)))})
+
+	for name, sample := range a.latest {
+		index := a.indexes[name]
+		for _, column := range columns {
+			variables = append(variables, variable{
+				a.root.Append(1, 1, column.arc, uint32(index)),
+				column.value(index, &sample),
+			})
+		}
+	}
+
+	sort.Slice(variables, func(i, j int) bool {
+		return variables[i].oid.Compare(variables[j].oid) < 0
+	})
+
+	return variables
+}
+
+func get(variables []variable, oids []OID, version int64) ([][]byte, int, int) {
+	var bindings [][]byte
+
+	for i, oid := range oids {
+		n := sort.Search(len(variables), func(j int) bool {
+			return variables[j].oid.Compare(oid) >= 0
+		})
+
+		if n < len(variables) && variables[n].oid.Compare(oid) == 0 {
+			bindings = append(bindings, binding(oid, variables[n].value))
+			continue
+		}
+
+		if version == version1 {
+			return nil, errNoSuchName, i + 1
+		}
+		bindings = append(bindings, binding(oid, encode(tagNoSuchInstance, nil)))
+	}
+
+	return bindings, 0, 0
+}
+
+func getNext(variables []variable, oids []OID, version int64) ([][]byte, int, int) {
+	var bindings [][]byte
+
+	for i, oid := range oids {
+		if v, ok := next(variables, oid); ok {
+			bindings = append(bindings, binding(v.oid, v.value))
+			continue
+		}
+
+		if version == version1 {
+			return nil, errNoSuchName, i + 1
+		}
+		bindings = append(bindings, binding(oid, encode(tagEndOfMibView, nil)))
+	}
+
+	return bindings, 0, 0
+}
+
+// Answers the first non-repeaters like get-next, and walks the rest up to max-repetitions times.
+func getBulk(variables []variable, oids []OID, nonRepeaters int, repetitions int) [][]byte {
+	if nonRepeaters < 0 {
+		nonRepeaters = 0
+	}
+	if nonRepeaters > len(oids) {
+		nonRepeaters = len(oids)
+	}
+
+	bindings, _, _ := getNext(variables, oids[:nonRepeaters], version2c)
+
+	repeaters := append([]OID(nil), oids[nonRepeaters:]...)
+	for r := 0; r < repetitions && len(repeaters) > 0; r++ {
+		ended := true
+
+		for i, oid := range repeaters {
+			if len(bindings) >= MAX_BINDINGS {
+				return bindings
+			}
+
+			v, ok := next(variables, oid)
+			if !ok {
+				bindings = append(bindings, binding(oid, encode(tagEndOfMibView, nil)))
+				continue
+			}
+
+			bindings = append(bindings, binding(v.oid, v.value))
+			repeaters[i] = v.oid
+			ended = false
+		}
+
+		if ended {
+			break
+		}
+	}
+
+	return bindings
+}
+
+// First variable after the identifier.
+func next(variables []variable, oid OID) (variable, bool) {
+	n := sort.Search(len(variables), func(j int) bool {
+		return variables[j].oid.Compare(oid) > 0
+	})
+
+	if n == len(variables) {
+		return variable{}, false
+	}
+
+	return variables[n], true
+}
+
+// Identifiers of the variable bindings of a request.
+func decodeBindings(data []byte) ([]OID, error) {
+	list, _, err := decodeTag(data, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+
+	var oids []OID
+	for len(list) > 0 {
+		var content []byte
+		if content, list, err = decodeTag(list, tagSequence); err != nil {
+			return nil, err
+		}
+
+		oid, _, err := decodeOID(content)
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, oid)
+	}
+
+	return oids, nil
+}
+
+func binding(oid OID, value []byte) []byte {
+	return encode(tagSequence, append(encodeOID(oid), value...))
+}
+
+// Bindings of the identifiers with null values.
+func nulls(oids []OID) [][]byte {
+	bindings := make([][]byte, len(oids))
+	for i, oid := range oids {
+		bindings[i] = binding(oid, encode(tagNull, nil))
+	}
+
+	return bindings
+}
+
+// Percents in hundredths, as Gauge32 has no decimals.
+func hundredths(percent float64) uint64 {
+	if percent < 0 {
+		return 0
+	}
+
+	return gauge(uint64(percent*100 + 0.5))
+}
+
+func kibibytes(bytes uint64) uint64 {
+	return gauge(bytes / 1024)
+}
+
+// Caps the value to the maximum of a Gauge32.
+func gauge(v uint64) uint64 {
+	if v > 0xffffffff {
+		return 0xffffffff
+	}
+
+	return v
+}
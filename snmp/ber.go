@@ -0,0 +1,259 @@
+package snmp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// BER tags of the types and PDUs of SNMPv1 and SNMPv2c.
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagCounter32   = 0x41
+	tagGauge32     = 0x42
+	tagTimeTicks   = 0x43
+
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+
+	pduGet      = 0xa0
+	pduGetNext  = 0xa1
+	pduResponse = 0xa2
+	pduSet      = 0xa3
+	pduGetBulk  = 0xa5
+)
+
+var errTruncated = errors.New("snmp: truncated message")
+
+// Minimal BER encoding, enough for the messages of SNMPv1 and SNMPv2c, which avoids depending on an SNMP library.
+
+func encode(tag byte, content []byte) []byte {
+	b := []byte{tag}
+
+	if n := len(content); n < 0x80 {
+		b = append(b, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		b = append(append(b, 0x80|byte(len(length))), length...)
+	}
+
+	return append(b, content...)
+}
+
+// Encodes a signed value in the fewest bytes of two's complement.
+func encodeInt(v int64) []byte {
+	var content []byte
+	for {
+		content = append([]byte{byte(v)}, content...)
+		if v >= -0x80 && v <= 0x7f {
+			break
+		}
+		v >>= 8
+	}
+
+	return encode(tagInteger, content)
+}
+
+// Encodes an unsigned value, such as a Counter32 or a Gauge32.
+func encodeUint(tag byte, v uint64) []byte {
+	content := []byte{byte(v)}
+	for v > 0xff {
+		v >>= 8
+		content = append([]byte{byte(v)}, content...)
+	}
+
+	// a leading bit would make it negative.
+	if content[0]&0x80 != 0 {
+		content = append([]byte{0}, content...)
+	}
+
+	return encode(tag, content)
+}
+
+func encodeString(v string) []byte {
+	return encode(tagOctetString, []byte(v))
+}
+
+func encodeOID(oid OID) []byte {
+	var content []byte
+	if len(oid) >= 2 {
+		content = appendBase128(content, oid[0]*40+oid[1])
+		for _, n := range oid[2:] {
+			content = appendBase128(content, n)
+		}
+	}
+
+	return encode(tagOID, content)
+}
+
+func appendBase128(b []byte, n uint32) []byte {
+	digits := []byte{byte(n & 0x7f)}
+	for n >>= 7; n > 0; n >>= 7 {
+		digits = append([]byte{byte(n&0x7f) | 0x80}, digits...)
+	}
+
+	return append(b, digits...)
+}
+
+// Reads the first value of the data, returning its tag, its content and the data after it.
+func decode(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errTruncated
+	}
+
+	tag, length, data := data[0], int(data[1]), data[2:]
+
+	if length&0x80 != 0 {
+		bytes := length & 0x7f
+		if bytes == 0 || bytes > 4 || len(data) < bytes {
+			return 0, nil, nil, errTruncated
+		}
+
+		length = 0
+		for _, b := range data[:bytes] {
+			length = length<<8 | int(b)
+		}
+		data = data[bytes:]
+	}
+
+	if length < 0 || len(data) < length {
+		return 0, nil, nil, errTruncated
+	}
+
+	return tag, data[:length], data[length:], nil
+}
+
+// Reads the first value of the data, which must have the given tag.
+func decodeTag(data []byte, tag byte) ([]byte, []byte, error) {
+	t, content, rest, err := decode(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if t != tag {
+		return nil, nil, errors.New("snmp: unexpected tag " + strconv.Itoa(int(t)))
+	}
+
+	return content, rest, nil
+}
+
+func decodeInt(data []byte) (int64, []byte, error) {
+	content, rest, err := decodeTag(data, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(content) == 0 || len(content) > 8 {
+		return 0, nil, errors.New("snmp: invalid integer")
+	}
+
+	// sign extended from the first byte.
+	v := int64(int8(content[0]))
+	for _, b := range content[1:] {
+		v = v<<8 | int64(b)
+	}
+
+	return v, rest, nil
+}
+
+func decodeOID(data []byte) (OID, []byte, error) {
+	content, rest, err := decodeTag(data, tagOID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var oid OID
+	var n uint32
+	for i, b := range content {
+		n = n<<7 | uint32(b&0x7f)
+		if b&0x80 != 0 {
+			if i == len(content)-1 {
+				return nil, nil, errTruncated
+			}
+			continue
+		}
+
+		if oid == nil {
+			// the first two arcs share the first number.
+			first := n / 40
+			if first > 2 {
+				first = 2
+			}
+			oid = OID{first, n - first*40}
+		} else {
+			oid = append(oid, n)
+		}
+		n = 0
+	}
+
+	return oid, rest, nil
+}
+
+// Object identifier, as its arcs.
+type OID []uint32
+
+// Parses an object identifier in dotted notation, such as 1.3.6.1.4.1, with an optional leading dot.
+func ParseOID(value string) (OID, error) {
+	parts := strings.Split(strings.TrimPrefix(value, "."), ".")
+	if len(parts) < 2 {
+		return nil, errors.New("Invalid OID " + value + ", use dotted numbers as 1.3.6.1.4.1.")
+	}
+
+	oid := make(OID, len(parts))
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, errors.New("Invalid OID " + value + ", use dotted numbers as 1.3.6.1.4.1.")
+		}
+		oid[i] = uint32(n)
+	}
+
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return nil, errors.New("Invalid OID " + value + ", it must start with 0, 1 or 2.")
+	}
+
+	return oid, nil
+}
+
+// Child of the identifier with the given arcs appended.
+func (oid OID) Append(arcs ...uint32) OID {
+	return append(append(OID(nil), oid...), arcs...)
+}
+
+// Compares two identifiers in lexicographic order, -1 if oid is first, 1 if other is, 0 if equal.
+func (oid OID) Compare(other OID) int {
+	for i := 0; i < len(oid) && i < len(other); i++ {
+		if oid[i] != other[i] {
+			if oid[i] < other[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(oid) < len(other):
+		return -1
+	case len(oid) > len(other):
+		return 1
+	}
+
+	return 0
+}
+
+func (oid OID) String() string {
+	parts := make([]string, len(oid))
+	for i, n := range oid {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,73 @@
+package stats
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time zones of the timestamps in text output, besides keeping them as reported.
+const (
+	TIME_ZONE_UTC   = "utc"
+	TIME_ZONE_LOCAL = "local"
+)
+
+// Layouts of the timestamps in text output.
+const (
+	TIME_LAYOUT_DEFAULT      = "default" // 02 Jan 06 15:04:05 MST
+	TIME_LAYOUT_RFC3339      = "rfc3339"
+	TIME_LAYOUT_RFC3339_NANO = "rfc3339nano"
+	TIME_LAYOUT_EPOCH        = "epoch"    // seconds since the Unix epoch.
+	TIME_LAYOUT_EPOCH_MS     = "epoch_ms" // milliseconds since the Unix epoch.
+)
+
+// Formatting of the timestamps in text output, the zero value keeps the zone they were reported in and uses the
+// default layout.
+type TimeFormat struct {
+	Zone   string // TIME_ZONE_UTC, TIME_ZONE_LOCAL or empty to keep the zone.
+	Layout string // one of the TIME_LAYOUT constants, the default one if empty.
+}
+
+// Parses a time format from the names of its zone and layout, both can be empty.
+func ParseTimeFormat(zone string, layout string) (TimeFormat, error) {
+	zone, layout = strings.ToLower(zone), strings.ToLower(layout)
+
+	switch zone {
+	case "", TIME_ZONE_UTC, TIME_ZONE_LOCAL:
+	default:
+		return TimeFormat{}, errors.New("Unknown time zone " + zone + ", use utc or local.")
+	}
+
+	switch layout {
+	case "", TIME_LAYOUT_DEFAULT, TIME_LAYOUT_RFC3339, TIME_LAYOUT_RFC3339_NANO, TIME_LAYOUT_EPOCH, TIME_LAYOUT_EPOCH_MS:
+	default:
+		return TimeFormat{}, errors.New("Unknown time layout " + layout +
+			", use default, rfc3339, rfc3339nano, epoch or epoch_ms.")
+	}
+
+	return TimeFormat{Zone: zone, Layout: layout}, nil
+}
+
+// Formats the timestamp.
+func (f TimeFormat) Format(t time.Time) string {
+	switch f.Zone {
+	case TIME_ZONE_UTC:
+		t = t.UTC()
+	case TIME_ZONE_LOCAL:
+		t = t.Local()
+	}
+
+	switch f.Layout {
+	case TIME_LAYOUT_RFC3339:
+		return t.Format(time.RFC3339)
+	case TIME_LAYOUT_RFC3339_NANO:
+		return t.Format(time.RFC3339Nano)
+	case TIME_LAYOUT_EPOCH:
+		return strconv.FormatInt(t.Unix(), 10)
+	case TIME_LAYOUT_EPOCH_MS:
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	return t.Format("02 Jan 06 15:04:05 MST")
+}
@@ -0,0 +1,238 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Groups of metrics that can be left out of what is pushed, see ParseGroups.
+const (
+	GROUP_CPU     = "cpu"     // CPU usage, limit and shares.
+	GROUP_MEMORY  = "memory"  // memory and swap usage and limits, and the failcnt.
+	GROUP_NETWORK = "network" // bytes, packets, errors and dropped packets, and the TCP connections.
+	GROUP_BLKIO   = "blkio"   // block I/O bytes, requests, service time and queue.
+	GROUP_PIDS    = "pids"    // processes and threads of the containers.
+	GROUP_EVENTS  = "events"  // lifecycle events of the containers.
+)
+
+// Every group of metrics, in the order they are documented.
+var GROUPS = []string{GROUP_CPU, GROUP_MEMORY, GROUP_NETWORK, GROUP_BLKIO, GROUP_PIDS, GROUP_EVENTS}
+
+// Groups of metrics pushed, every one if nil.
+type Groups map[string]bool
+
+// Parses the groups pushed: the allowed ones, every one if none is, but the denied ones. Nil if every group is
+// pushed.
+func ParseGroups(allow []string, deny []string) (Groups, error) {
+	for _, group := range append(append([]string(nil), allow...), deny...) {
+		if !contains(GROUPS, group) {
+			return nil, errors.New("Unknown metric group " + group + ", use " + strings.Join(GROUPS, ", ") + ".")
+		}
+	}
+
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+
+	if len(allow) == 0 {
+		allow = GROUPS
+	}
+
+	groups := make(Groups, len(allow))
+	for _, group := range allow {
+		if !contains(deny, group) {
+			groups[group] = true
+		}
+	}
+
+	return groups, nil
+}
+
+// Whether the group is pushed.
+func (groups Groups) Has(group string) bool {
+	return groups == nil || groups[group]
+}
+
+// Zeroes the metrics of the groups not pushed, which are kept along the stats so repositories storing series can
+// leave them out, and encoding leaves out their fields. Groups already left out, as by the agent a receiver got the
+// stats from, stay out.
+func (stats *Stats) Select(groups Groups) {
+	stats.Groups = stats.Groups.intersect(groups)
+
+	value := reflect.ValueOf(stats).Elem()
+	for _, group := range GROUPS {
+		if stats.Groups.Has(group) {
+			continue
+		}
+
+		for _, field := range fields[group] {
+			f := value.Field(field.index)
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+}
+
+// Groups in both, every one if both are nil.
+func (groups Groups) intersect(other Groups) Groups {
+	if groups == nil {
+		return other
+	}
+
+	if other == nil {
+		return groups
+	}
+
+	result := make(Groups, len(groups))
+	for group := range groups {
+		if other[group] {
+			result[group] = true
+		}
+	}
+
+	return result
+}
+
+// Groups pushed, in the order they are documented, nil if every one is.
+func (groups Groups) List() []string {
+	if groups == nil {
+		return nil
+	}
+
+	list := make([]string, 0, len(groups))
+	for _, group := range GROUPS {
+		if groups[group] {
+			list = append(list, group)
+		}
+	}
+
+	return list
+}
+
+// Field of the stats belonging to a group of metrics, by its group tag.
+type groupField struct {
+	index int
+	json  string // key when encoded as JSON.
+	bson  string // key when encoded as BSON, the lowercased name as the mgo driver does.
+}
+
+// Fields of the stats of each group of metrics.
+var fields = groupFields()
+
+func groupFields() map[string][]groupField {
+	result := make(map[string][]groupField)
+
+	typ := reflect.TypeOf(Stats{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+
+		group := field.Tag.Get("group")
+		if group == "" {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		result[group] = append(result[group], groupField{index: i, json: name, bson: strings.ToLower(field.Name)})
+	}
+
+	return result
+}
+
+// Stats without their encoding methods.
+type plainStats Stats
+
+// Encodes the stats as JSON, along the groups pushed if not every one is, without the fields of the others, so a
+// deselected metric is not read as zero.
+func (stats Stats) MarshalJSON() ([]byte, error) {
+	if stats.Groups == nil {
+		return json.Marshal((*plainStats)(&stats))
+	}
+
+	data, err := json.Marshal(struct {
+		*plainStats
+		Groups []string `json:"groups"`
+	}{(*plainStats)(&stats), stats.Groups.List()})
+	if err != nil {
+		return nil, err
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+
+	for _, group := range GROUPS {
+		if !stats.Groups.Has(group) {
+			for _, field := range fields[group] {
+				delete(object, field.json)
+			}
+		}
+	}
+
+	return json.Marshal(object)
+}
+
+// Decodes the stats from JSON, with the groups pushed if given, every one otherwise.
+func (stats *Stats) UnmarshalJSON(data []byte) error {
+	decoded := struct {
+		*plainStats
+		Groups *[]string `json:"groups"`
+	}{plainStats: (*plainStats)(stats)}
+
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	stats.Groups = nil
+	if decoded.Groups != nil {
+		stats.Groups = make(Groups, len(*decoded.Groups))
+		for _, group := range *decoded.Groups {
+			stats.Groups[group] = true
+		}
+	}
+
+	return nil
+}
+
+// Encodes the stats as BSON for the mongo repository, along the groups pushed if not every one is, without the fields
+// of the others.
+func (stats *Stats) GetBSON() (interface{}, error) {
+	if stats.Groups == nil {
+		return (*plainStats)(stats), nil
+	}
+
+	left := make(map[int]bool)
+	for _, group := range GROUPS {
+		if !stats.Groups.Has(group) {
+			for _, field := range fields[group] {
+				left[field.index] = true
+			}
+		}
+	}
+
+	value := reflect.ValueOf(stats).Elem()
+	typ := value.Type()
+
+	document := map[string]interface{}{"groups": stats.Groups.List()}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if left[i] || field.Tag.Get("bson") == "-" {
+			continue
+		}
+
+		document[strings.ToLower(field.Name)] = value.Field(i).Interface()
+	}
+
+	return document, nil
+}
+
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Deselected metrics are left out of the JSON instead of read as zero, and the groups come back when decoded.
+func TestSelectedJSON(t *testing.T) {
+	groups, err := ParseGroups([]string{GROUP_CPU, GROUP_MEMORY}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := Stats{Name: "web", CpuPercent: 12.5, MemoryUsage: 64 << 20, RxBytesTotal: 1024, BlkioReads: 3}
+	sample.Select(groups)
+
+	data, err := json.Marshal(&sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"rx_bytes", "tx_packets", "blkio_reads", "blkio_read_bps"} {
+		if _, ok := object[key]; ok {
+			t.Errorf("%s: expected to be left out, got %s", key, data)
+		}
+	}
+
+	for _, key := range []string{"name", "cpu_percent", "mem_usage", "open_fds"} {
+		if _, ok := object[key]; !ok {
+			t.Errorf("%s: expected to be encoded, got %s", key, data)
+		}
+	}
+
+	var decoded Stats
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded.Groups, groups) || decoded.CpuPercent != 12.5 || decoded.MemoryUsage != 64<<20 {
+		t.Errorf("expected the selected stats back, got %+v", decoded)
+	}
+
+	// selecting again, as a receiver does, keeps the groups the agent left out.
+	decoded.Select(Groups{GROUP_CPU: true, GROUP_NETWORK: true})
+	if !reflect.DeepEqual(decoded.Groups, Groups{GROUP_CPU: true}) || decoded.MemoryUsage != 0 {
+		t.Errorf("expected only cpu selected, got %v with memory %d", decoded.Groups, decoded.MemoryUsage)
+	}
+
+	// every group pushed, every field encoded and no groups.
+	data, err = json.Marshal(&Stats{Name: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	object = nil
+	if err := json.Unmarshal(data, &object); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := object["groups"]; ok || object["rx_bytes"] == nil {
+		t.Errorf("expected every field and no groups, got %s", data)
+	}
+
+	decoded = Stats{}
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Groups != nil {
+		t.Errorf("expected every group back, got %v (%v)", decoded.Groups, err)
+	}
+}
@@ -0,0 +1,24 @@
+package stats
+
+import (
+	"sync"
+)
+
+// Samples are pushed for every container on every interval, so they are taken from a pool instead of allocated each
+// time. Repositories only own a sample for the duration of Push, see repo.Interface.
+var pool = sync.Pool{
+	New: func() interface{} {
+		return new(Stats)
+	},
+}
+
+// Gets an empty sample from the pool.
+func Acquire() *Stats {
+	return pool.Get().(*Stats)
+}
+
+// Empties the sample and puts it back in the pool, it must not be used afterwards.
+func Release(s *Stats) {
+	*s = Stats{}
+	pool.Put(s)
+}
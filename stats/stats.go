@@ -2,6 +2,9 @@ package stats
 
 import (
 	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,26 +16,274 @@ type Stats struct {
 	// associated container of this stats.
 	Name string `json:"name"`
 
+	// Image of the container, as given when created.
+	Image string `json:"image,omitempty"`
+
+	// Creation time of the image and its age in days at the time of this stats, zero if unknown.
+	ImageCreated time.Time `json:"image_created"`
+	ImageAgeDays float64   `json:"image_age_days"`
+
+	// Restart policy, whether privileged, and network mode of the container, empty if not inspected.
+	RestartPolicy string `json:"restart_policy,omitempty"`
+	Privileged    bool   `json:"privileged"`
+	NetworkMode   string `json:"network_mode,omitempty"`
+
 	// CPU usage percent.
-	CpuPercent float64 `json:"cpu_percent"`
+	CpuPercent float64 `json:"cpu_percent" group:"cpu"`
+
+	// CPUs the container may use, 0 if unlimited, and its relative weight, 0 if the default. CpuPercent counts 100
+	// per CPU, so the percent of the limit is CpuPercent / CpuLimit.
+	CpuLimit  float64 `json:"cpu_limit" group:"cpu"`
+	CpuShares uint64  `json:"cpu_shares" group:"cpu"`
 
 	// Memory usage in bytes.
-	MemoryUsage uint64 `json:"mem_usage"`
+	MemoryUsage uint64 `json:"mem_usage" group:"memory"`
+
+	// Memory usage percent, of the limit.
+	MemoryPercent float64 `json:"mem_percent" group:"memory"`
+
+	// Memory limit in bytes, the memory of the host if the container has no limit, as told by MemoryLimited.
+	MemoryLimit   uint64 `json:"mem_limit" group:"memory"`
+	MemoryLimited bool   `json:"mem_limited" group:"memory"`
+
+	// Times the memory usage hit the limit, reclaimed without an OOM kill.
+	MemoryFailcnt uint64 `json:"mem_failcnt" group:"memory"`
+
+	// File descriptors open by the init process of the container and their soft limit, 0 if unlimited. Both are 0 if
+	// not read, see backend.Client.SetProc.
+	OpenFds uint64 `json:"open_fds"`
+	FdLimit uint64 `json:"fd_limit"`
+
+	// TCP connections of the network namespace of the container, nil if not read.
+	Tcp *Connections `json:"tcp,omitempty" group:"network"`
+
+	// Swap usage and the swap the container may use on top of its memory limit, in bytes. The limit is 0 if unlimited,
+	// both are 0 if not reported (cgroup v2 hosts, or without swap accounting).
+	SwapUsage uint64 `json:"swap_usage" group:"memory"`
+	SwapLimit uint64 `json:"swap_limit" group:"memory"`
 
-	// Memory usage percent.
-	MemoryPercent float64 `json:"mem_percent"`
+	// Time spent serving block I/O requests in nanoseconds and requests served, both totals, and requests waiting.
+	// Only reported on cgroup v1 hosts, all 0 otherwise. The rate of the time divided by the rate of the requests
+	// is the average latency.
+	BlkioServiceTime uint64 `json:"blkio_service_time" group:"blkio"`
+	BlkioServiced    uint64 `json:"blkio_serviced" group:"blkio"`
+	BlkioQueue       uint64 `json:"blkio_queue" group:"blkio"`
+
+	// Bytes read and written and read and write requests served, totals of every device, and their rates per second
+	// since the previous sample of the container, 0 on the first one. Reported on cgroup v1 and v2 hosts.
+	BlkioReadBytes  uint64  `json:"blkio_read_bytes" group:"blkio"`
+	BlkioWriteBytes uint64  `json:"blkio_write_bytes" group:"blkio"`
+	BlkioReads      uint64  `json:"blkio_reads" group:"blkio"`
+	BlkioWrites     uint64  `json:"blkio_writes" group:"blkio"`
+	BlkioReadBps    float64 `json:"blkio_read_bps" group:"blkio"`
+	BlkioWriteBps   float64 `json:"blkio_write_bps" group:"blkio"`
+	BlkioReadIops   float64 `json:"blkio_read_iops" group:"blkio"`
+	BlkioWriteIops  float64 `json:"blkio_write_iops" group:"blkio"`
 
 	// Transmit and Receive network stats, in bytes.
-	TxBytesTotal uint32 `json:"tx_bytes"`
-	RxBytesTotal uint32 `json:"rx_bytes"`
+	TxBytesTotal uint64 `json:"tx_bytes" group:"network"`
+	RxBytesTotal uint64 `json:"rx_bytes" group:"network"`
+
+	// Transmit and Receive packets, errors and dropped packets, of every interface.
+	TxPacketsTotal uint64 `json:"tx_packets" group:"network"`
+	RxPacketsTotal uint64 `json:"rx_packets" group:"network"`
+	TxErrorsTotal  uint64 `json:"tx_errors" group:"network"`
+	RxErrorsTotal  uint64 `json:"rx_errors" group:"network"`
+	TxDroppedTotal uint64 `json:"tx_dropped" group:"network"`
+	RxDroppedTotal uint64 `json:"rx_dropped" group:"network"`
+
+	// Network stats of each interface but the excluded ones, by name, nil unless asked (see
+	// backend.Client.SetPerInterface). The totals above are their sums.
+	Interfaces map[string]Interface `json:"interfaces,omitempty" group:"network"`
 
 	Labels map[string]string
+
+	// Groups of metrics pushed, every one if nil. The others are zero and left out when encoded, see Select.
+	Groups Groups `json:"-" bson:"-"`
+
+	// Idempotency key, the same for the copies of this sample taken by redundant collectors, see Key.
+	ID string `json:"id,omitempty"`
+}
+
+// Network stats of an interface, in bytes and packets.
+type Interface struct {
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	RxErrors  uint64 `json:"rx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+	RxDropped uint64 `json:"rx_dropped"`
+}
+
+// TCP connections by state.
+type Connections struct {
+	Established uint64 `json:"established"`
+	TimeWait    uint64 `json:"time_wait"`
+}
+
+// Label holding the name of the Docker host of the container, added to every sample, event, volume and process list
+// of a statspout.Fleet. Container names are only unique within a host, see QualifiedName.
+const LABEL_HOST = "host"
+
+// Name identifying a container across hosts: the name prefixed by the host and a slash, the bare name without host.
+func QualifiedName(host string, name string) string {
+	if host == "" {
+		return name
+	}
+
+	return host + "/" + name
+}
+
+// Qualified name of the container of the stats, by its host label, see QualifiedName.
+func (stats *Stats) QualifiedName() string {
+	return QualifiedName(stats.Labels[LABEL_HOST], stats.Name)
+}
+
+// Idempotency key of a sample of the named container, taken from the Docker host identified by host at the given
+// read time. The Docker daemon gathers stats once per second for every request, so collectors monitoring the same
+// host get the same read time and key, letting repositories capable of deduplication drop the copies.
+func Key(host string, name string, read time.Time) string {
+	h := fnv.New64a()
+	h.Write([]byte(host))
+	h.Write([]byte{0})
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatInt(read.UnixNano(), 10)))
+
+	return strconv.FormatUint(h.Sum64(), 16)
 }
 
 // Prints stats in a nice format.
 func (stats *Stats) String() string {
+	return stats.Text(TimeFormat{})
+}
+
+// Prints stats in a nice format, with the timestamp in the given format.
+func (stats *Stats) Text(format TimeFormat) string {
 	return fmt.Sprintf("[%s] {%s} CPU: %.2f%%, MEM: %.2f%% [%d B] Tx/Rx: %d/%d",
-		stats.Name, stats.Timestamp.Format("02 Jan 06 15:04:05 MST"),
+		stats.Name, format.Format(stats.Timestamp),
 		stats.CpuPercent, stats.MemoryPercent, stats.MemoryUsage,
 		stats.TxBytesTotal, stats.RxBytesTotal)
 }
+
+// Lifecycle event of a container, as reported by the Docker Events API.
+type Event struct {
+	// Timestamp of the event.
+	Timestamp time.Time `json:"@timestamp"`
+
+	// associated container of this event.
+	Name string `json:"name"`
+
+	// What happened to the container: start, stop, die, oom, restart, rename...
+	Action string `json:"action"`
+
+	// Attributes reported along the event, including the container labels.
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	// State entered, on transitions such as health_status (healthy, unhealthy).
+	State string `json:"state,omitempty"`
+
+	// State left and the time spent in it, in seconds, if known.
+	Previous string  `json:"previous,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+
+	// Exit code of the main process, on die events, or of the command, on exec_die events.
+	ExitCode *int `json:"exit_code,omitempty"`
+
+	// Times the container started and finished, as reported by Docker, on state transitions. The finish time is of
+	// the previous run when starting, if any.
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Prints the event in a nice format.
+func (event *Event) String() string {
+	return event.Text(TimeFormat{})
+}
+
+// Prints the event in a nice format, with the timestamp in the given format.
+func (event *Event) Text(format TimeFormat) string {
+	text := fmt.Sprintf("[%s] {%s} %s", event.Name, format.Format(event.Timestamp), event.Action)
+
+	if event.State != "" {
+		text += ": " + event.State
+	}
+
+	if event.Previous != "" {
+		text += fmt.Sprintf(" (was %s for %.0fs)", event.Previous, event.Duration)
+	}
+
+	if event.ExitCode != nil {
+		text += fmt.Sprintf(" (exit code %d)", *event.ExitCode)
+	}
+
+	return text
+}
+
+// Disk usage of a named volume mounted by a container, as reported by the Docker System DF API.
+type Volume struct {
+	// Timestamp of the measure.
+	Timestamp time.Time `json:"@timestamp"`
+
+	// associated container, a volume mounted by several containers is reported for each one.
+	Name string `json:"name"`
+
+	// Name of the volume and where it is mounted in the container.
+	Volume      string `json:"volume"`
+	Destination string `json:"destination"`
+
+	// Disk usage of the volume in bytes.
+	Size uint64 `json:"size"`
+
+	Labels map[string]string
+}
+
+// Prints the volume in a nice format.
+func (volume *Volume) String() string {
+	return volume.Text(TimeFormat{})
+}
+
+// Prints the volume in a nice format, with the timestamp in the given format.
+func (volume *Volume) Text(format TimeFormat) string {
+	return fmt.Sprintf("[%s] {%s} Volume %s at %s: %d B",
+		volume.Name, format.Format(volume.Timestamp),
+		volume.Volume, volume.Destination, volume.Size)
+}
+
+// Processes of a container, as reported by the Docker Top API.
+type Processes struct {
+	// Timestamp of the listing.
+	Timestamp time.Time `json:"@timestamp"`
+
+	// associated container.
+	Name string `json:"name"`
+
+	// Processes running in the container and their threads.
+	Processes uint64 `json:"processes"`
+	Threads   uint64 `json:"threads"`
+
+	// Names of the processes using the most CPU, from the most, if asked.
+	Top []string `json:"top,omitempty"`
+
+	Labels map[string]string
+}
+
+// Prints the processes in a nice format.
+func (processes *Processes) String() string {
+	return processes.Text(TimeFormat{})
+}
+
+// Prints the processes in a nice format, with the timestamp in the given format.
+func (processes *Processes) Text(format TimeFormat) string {
+	text := fmt.Sprintf("[%s] {%s} Processes: %d, Threads: %d",
+		processes.Name, format.Format(processes.Timestamp),
+		processes.Processes, processes.Threads)
+
+	if len(processes.Top) > 0 {
+		text += fmt.Sprintf(" (top: %s)", strings.Join(processes.Top, ", "))
+	}
+
+	return text
+}
@@ -1,46 +1,35 @@
 package statspout
 
 import (
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"runtime"
+	"runtime/debug"
 	"time"
 
+	"github.com/mijara/statspout/api"
+	"github.com/mijara/statspout/audit"
 	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/common"
+	"github.com/mijara/statspout/discovery"
+	"github.com/mijara/statspout/dockertest"
+	"github.com/mijara/statspout/election"
+	"github.com/mijara/statspout/gossip"
+	"github.com/mijara/statspout/grpcapi"
 	"github.com/mijara/statspout/log"
 	"github.com/mijara/statspout/opts"
+	"github.com/mijara/statspout/receiver"
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/secret"
+	"github.com/mijara/statspout/shard"
+	"github.com/mijara/statspout/snmp"
+	"github.com/mijara/statspout/stats"
+	"github.com/mijara/statspout/telemetry"
+	"github.com/mijara/statspout/version"
 )
 
-func loop(client *backend.Client, containers map[string]backend.Container) {
-	ticker := time.NewTicker(time.Duration(opts.GetOpts().Interval) * time.Second)
-
-	closeC := make(chan os.Signal, 1)
-	signal.Notify(closeC, os.Interrupt, os.Kill)
-
-	// initial loop.
-	for name := range containers {
-		if !contains(opts.GetOpts().Ignore, name) {
-			client.Query(containers[name])
-		}
-	}
-
-	for {
-		select {
-		case <-closeC:
-			log.Info.Printf("Stopping: closing Goroutines and Clients. Please wait...")
-			ticker.Stop()
-			return
-		case <-ticker.C:
-			// query containers.
-			for name := range containers {
-				if !contains(opts.GetOpts().Ignore, name) {
-					client.Query(containers[name])
-				}
-			}
-		}
-	}
-}
-
 func inspect() {
 	ticker := time.NewTicker(10 * time.Second)
 
@@ -50,51 +39,501 @@ func inspect() {
 	}
 }
 
+// Limits of the edge profile: garbage collection once the heap grows by this percent, instead of doubling, the
+// buffer reading stats frames, which fits a frame of a host with a few CPUs, and the queries queued for its daemon.
+const (
+	EDGE_GC_PERCENT        = 25
+	EDGE_FRAME_BUFFER_SIZE = 4 << 10
+	EDGE_QUEUE_SIZE        = 512
+)
+
+// Caps the resources used on edge hosts: a single CPU, frequent garbage collection, small buffers and no latency
+// telemetry. The defaults of the options are set by opts.
+func applyEdgeProfile() {
+	runtime.GOMAXPROCS(1)
+	debug.SetGCPercent(EDGE_GC_PERCENT)
+	backend.SetFrameBufferSize(EDGE_FRAME_BUFFER_SIZE)
+	backend.SetQueueSize(EDGE_QUEUE_SIZE)
+	telemetry.Default.SetEnabled(false)
+}
+
+// Name of the host given by the command line flags, when running a fleet.
+const DEFAULT_HOST = "default"
+
+// Time between each save of the state file.
+const STATE_SAVE_INTERVAL = 10 * time.Second
+
+// Options of the collectors configured by the command line flags, pushing stats to the given repository.
+// Containers owned by other instances on the ring are skipped, if not nil. The Docker endpoint is not included,
+// see opts.EndpointFromFlags.
+func optionsFromFlags(repository repo.Interface, ring *shard.Ring) ([]Option, error) {
+	// read interval overrides.
+	rules, err := opts.RulesFromFlags()
+	if err != nil {
+		return nil, err
+	}
+
+	windows, err := opts.WindowsFromFlags()
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := backend.ParseIdentity(opts.GetOpts().Identity)
+	if err != nil {
+		return nil, err
+	}
+
+	ignore := opts.GetOpts().Ignore
+
+	return []Option{
+		WithInterval(opts.GetOpts().Interval),
+		WithRules(rules...),
+		WithWindows(windows...),
+		WithRepo(repository),
+		WithDaemons(opts.GetOpts().Daemons, opts.GetOpts().MaxDaemons),
+		WithPipeline(opts.GetOpts().Pipeline),
+		WithSpread(opts.GetOpts().Spread),
+		WithOneShot(opts.GetOpts().OneShot),
+		WithSample(opts.GetOpts().Sample),
+		WithExecEvents(opts.GetOpts().Events.Exec),
+		WithVolumes(opts.GetOpts().Volumes),
+		WithTop(opts.GetOpts().Top, opts.GetOpts().TopNames),
+		WithProc(opts.GetOpts().Proc),
+		WithConnections(opts.GetOpts().ProcTcp),
+		WithIdentity(identity),
+		WithExcludedInterfaces(opts.GetOpts().Network.Exclude...),
+		WithPerInterface(opts.GetOpts().Network.PerInterface),
+		WithAdaptive(opts.GetOpts().Adaptive.Latency, opts.GetOpts().Adaptive.Max),
+		WithFilter(func(container backend.Container) bool {
+			if ring != nil && !ring.Owns(container.CanonicalName) {
+				return false
+			}
+
+			return !contains(ignore, container.CanonicalName)
+		}),
+	}, nil
+}
+
+// Creates a collector configured by the command line flags, pushing stats to the given repository.
+func collectorFromFlags(repository repo.Interface) (*Collector, error) {
+	ring, err := opts.RingFromFlags()
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := optionsFromFlags(repository, ring)
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve the Docker Endpoint.
+	network, address, err := opts.EndpointFromFlags()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCollector(append(options, WithEndpoint(network, address))...)
+}
+
 func Start(cfg *opts.Config) {
 	opts.GetOpts().Parse()
 
-	if opts.GetOpts().Interval < 1 {
-		log.Error.Fatal("Interval cannot be less than 1.")
+	if opts.GetOpts().Version {
+		fmt.Println(version.String())
+		return
+	}
+
+	// run a subcommand instead, if given.
+	if args := flag.Args(); len(args) > 0 {
+		if err := runCommand(cfg, args); err != nil {
+			log.Error.Fatal(err)
+		}
+		return
+	}
+
+	if opts.GetOpts().Validate {
+		if err := opts.Validate(cfg, opts.GetOpts().Probe); err != nil {
+			log.Error.Fatal(err)
+		}
+
+		log.Info.Printf("Configuration is valid.")
+		return
+	}
+
+	// problems are reported together, instead of as scattered runtime errors.
+	if err := opts.Validate(cfg, opts.GetOpts().Preflight); err != nil {
+		log.Error.Fatal(err)
+	}
+
+	edge := opts.GetOpts().Profile == opts.PROFILE_EDGE
+	if edge {
+		applyEdgeProfile()
 	}
 
+	// exchanges with Docker are saved as they happen, if recording.
+	if dir := opts.GetOpts().Record; dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Error.Fatal(err)
+		}
+
+		backend.SetRecordDir(dir)
+	}
+
+	// responses missing the fields in use are errors, instead of zeros.
+	backend.SetStrict(opts.GetOpts().Strict)
+
 	// start the Repo.
 	repository, err := opts.CreateRepositoryFromFlags(cfg)
 	if err != nil {
 		log.Error.Fatal(err)
 	}
+
+	// the outcome of the pushes is reported by the API and the self metrics.
+	repository = repo.Track(repository)
+
+	// only the selected groups of metrics are pushed, if any.
+	groups, err := stats.ParseGroups(opts.GetOpts().Metrics.Allow, opts.GetOpts().Metrics.Deny)
+	if err != nil {
+		log.Error.Fatal(err)
+	}
+	if groups != nil {
+		repository = repo.NewSelected(repository, groups)
+	}
+
+	// pushes are audited as they reach the repository, standbys push nothing.
+	var auditLog *audit.Log
+	if target := opts.GetOpts().Audit.Target; target != "" {
+		auditLog, err = audit.New(target)
+		if err != nil {
+			log.Error.Fatal(err)
+		}
+		defer auditLog.Close()
+
+		repository = audit.NewRepo(repository, auditLog, opts.GetOpts().Repository, opts.DestinationFromFlags(),
+			opts.GetOpts().Audit.Interval)
+	}
+
+	// only the leader pushes to the repository, APIs keep serving while standing by.
+	if address := opts.GetOpts().Election.Lock; address != "" {
+		lock, err := election.NewLock(address, opts.GetOpts().Election.TTL)
+		if err != nil {
+			log.Error.Fatal(err)
+		}
+
+		elector := election.New(lock, opts.GetOpts().Election.TTL)
+		elector.Start()
+		defer elector.Stop()
+
+		repository = election.NewGate(repository, elector)
+	}
+
+	// the APIs and the receiver share the TLS configuration, if any.
+	tlsConfig, err := opts.TLSFromFlags()
+	if err != nil {
+		log.Error.Fatal(err)
+	}
+
+	// streaming APIs share a single hub, fed along with the repository.
+	var hub *api.Hub
+	if opts.GetOpts().API.Address != "" || opts.GetOpts().GRPC.Address != "" {
+		hub = api.NewHub()
+		repository = repo.NewMulti(repository, hub)
+	}
+
+	// start the API, fed along with the repository.
+	var server *api.Server
+	if opts.GetOpts().API.Address != "" {
+		// only the latest sample is kept, unless history is retained for range queries.
+		samples := 1
+		if opts.GetOpts().API.History > 0 {
+			samples = 0
+		}
+
+		memory, err := common.NewMemory(&common.MemoryOpts{
+			Samples:   samples,
+			Retention: opts.GetOpts().API.History,
+		})
+		if err != nil {
+			log.Error.Fatal(err)
+		}
+
+		server = api.New(opts.GetOpts().API.Address, memory, hub)
+		server.SetStaleAfter(staleAfterFromFlags())
+		server.AllowOrigins(opts.GetOpts().API.Origins)
+		if tlsConfig != nil {
+			server.UseTLS(tlsConfig)
+		}
+		if auditLog != nil {
+			server.UseAudit(auditLog)
+		}
+		defer server.Close()
+
+		repository = repo.NewMulti(repository, memory)
+	}
+
+	// start the gRPC API.
+	if opts.GetOpts().GRPC.Address != "" {
+		grpcServer := grpcapi.New(opts.GetOpts().GRPC.Address, hub)
+		if tlsConfig != nil {
+			grpcServer.UseTLS(tlsConfig)
+		}
+		if err := grpcServer.Start(); err != nil {
+			log.Error.Fatal(err)
+		}
+		defer grpcServer.Close()
+	}
+
+	// answer SNMP managers, fed along with the repository.
+	if address := opts.GetOpts().SNMP.Address; address != "" {
+		root, err := snmp.ParseOID(opts.GetOpts().SNMP.Root)
+		if err != nil {
+			log.Error.Fatal(err)
+		}
+
+		agent := snmp.New(address, secret.New(opts.GetOpts().SNMP.Community), root)
+		if err := agent.Start(); err != nil {
+			log.Error.Fatal(err)
+		}
+
+		repository = repo.NewMulti(repository, agent)
+	}
+
+	// closes the hub, the memory and the agent along the repository, once composed.
 	defer repository.Close()
 
-	// start the Docker Endpoint.
-	client, err := opts.CreateClientFromFlags(repository)
+	// accept stats forwarded by other instances, pushed along the local ones.
+	if address := opts.GetOpts().Receiver.Address; address != "" {
+		var token secret.Secret
+		if value := opts.GetOpts().Receiver.Token; value != "" {
+			token = secret.New(value)
+		}
+
+		server := receiver.New(address, repository, token)
+		if tlsConfig != nil {
+			server.UseTLS(tlsConfig)
+		}
+		server.Start()
+		defer server.Close()
+	}
+
+	// containers owned by other instances are skipped, if sharding.
+	ring, err := opts.RingFromFlags()
 	if err != nil {
 		log.Error.Fatal(err)
 	}
 
-	// get containers.
-	containers, err := client.GetContainers()
+	// the ring follows the live instances, if gossiping.
+	if bind := opts.GetOpts().Gossip.Bind; bind != "" {
+		cluster, err := gossip.New(opts.GetOpts().Shard.Self, bind, opts.GetOpts().Gossip.Join, ring)
+		if err != nil {
+			log.Error.Fatal(err)
+		}
+
+		if err := cluster.Start(); err != nil {
+			log.Error.Fatal(err)
+		}
+		defer cluster.Stop()
+	}
+
+	options, err := optionsFromFlags(repository, ring)
 	if err != nil {
 		log.Error.Fatal(err)
 	}
 
-	// small goroutine inspector.
-	go inspect()
+	hosts, err := opts.HostsFromFlags()
+	if err != nil {
+		log.Error.Fatal(err)
+	}
+
+	// more hosts can be added at runtime through discovery and the admin endpoints.
+	fleet := NewFleet(options...)
+
+	var state *backend.StateFile
+	if path := opts.GetOpts().StatePath; path != "" {
+		state, err = backend.LoadStateFile(path)
+		if err != nil {
+			log.Error.Fatal(err)
+		}
+
+		fleet.Persist(state)
+		go persist(state)
+	}
+	for _, host := range hosts {
+		if len(host.Labels) > 0 {
+			fleet.Label(host.Name, host.Labels)
+		}
+	}
+
+	if server != nil {
+		if token := opts.GetOpts().API.Admin.Token; token != "" {
+			server.EnableAdmin(fleet, secret.New(token))
+		}
+
+		if tokens := opts.GetOpts().API.Read.Tokens; len(tokens) > 0 {
+			secrets := make([]secret.Secret, len(tokens))
+			for n, token := range tokens {
+				secrets[n] = secret.New(token)
+			}
+
+			server.RequireRead(secrets)
+		}
 
-	log.Info.Printf("Statspout started: %d daemons, %d interval, %s mode, %s repo",
+		server.Start()
+	}
+
+	// with mode none, hosts only come from discovery or the admin endpoints.
+	if opts.GetOpts().Mode.Name != "none" {
+		network, address, err := opts.EndpointFromFlags()
+		if err != nil {
+			log.Error.Fatal(err)
+		}
+
+		// the fake Docker API stands for Docker, if replaying or simulating.
+		fake, err := fakeDockerFromFlags()
+		if err != nil {
+			log.Error.Fatal(err)
+		}
+
+		if fake != nil {
+			defer fake.Close()
+			network, address = fake.Network(), fake.Address()
+		}
+
+		if err := fleet.Add(DEFAULT_HOST, backend.Endpoint{Network: network, Address: address}); err != nil {
+			log.Error.Fatal(err)
+		}
+	}
+
+	for _, host := range hosts {
+		if host.Endpoint == nil {
+			continue
+		}
+
+		if err := fleet.Add(host.Name, *host.Endpoint); err != nil {
+			log.Error.Fatal(err)
+		}
+	}
+
+	// the sockets and listeners are open, the rest can run unprivileged.
+	if username, group := opts.GetOpts().User, opts.GetOpts().Group; username != "" || group != "" {
+		if err := dropPrivileges(username, group); err != nil {
+			log.Error.Fatal(err)
+		}
+
+		log.Info.Printf("Running as uid %d, gid %d.", os.Getuid(), os.Getgid())
+	}
+
+	var watcher *discovery.Watcher
+	if address := opts.GetOpts().Discovery.Source; address != "" {
+		source, err := discovery.NewSource(address)
+		if err != nil {
+			log.Error.Fatal(err)
+		}
+
+		watcher = discovery.NewWatcher(source, fleet, opts.GetOpts().Discovery.Interval)
+		watcher.Start()
+	}
+
+	// small goroutine inspector, left out on edge hosts.
+	if !edge {
+		go inspect()
+	}
+
+	log.Info.Printf("Statspout %s started: %d daemons, %s interval, %s mode, %s repo",
+		version.Version,
 		opts.GetOpts().Daemons,
 		opts.GetOpts().Interval,
 		opts.GetOpts().Mode.Name,
 		opts.GetOpts().Repository)
 
-	client.StartMonitor(containers)
+	// wait indefinitely until interrupt is received.
+	closeC := make(chan os.Signal, 1)
+	signal.Notify(closeC, os.Interrupt, os.Kill)
+	<-closeC
 
-	// loop indefinitely until interrupt is received.
-	loop(client, containers)
+	log.Info.Printf("Stopping: closing Goroutines and Clients. Please wait...")
 
 	// close all connections and goroutines.
-	client.Close()
+	if watcher != nil {
+		watcher.Stop()
+	}
+	fleet.Stop()
 
-	// force exit
-	// TODO: this is needed because EventsMonitor is not able to exit gracefully when reading the HTTP
-	// TODO: stream Docker API, I don't know how to fix it at this time.
-	os.Exit(0)
+	if state != nil {
+		if err := state.Save(); err != nil {
+			log.Error.Println(err)
+		}
+	}
+}
+
+// Intervals without any sample after which the instance is reported unhealthy by the HTTP API.
+const HEALTH_INTERVALS = 3
+
+// Age of the newest sample above which the instance is unhealthy: a few of the longest intervals, as stretched when
+// Docker is slow.
+func staleAfterFromFlags() time.Duration {
+	longest := opts.GetOpts().Interval
+
+	// invalid rules were reported when validating.
+	rules, _ := opts.RulesFromFlags()
+	for _, rule := range rules {
+		if rule.Interval > longest {
+			longest = rule.Interval
+		}
+	}
+
+	if opts.GetOpts().Adaptive.Latency > 0 {
+		longest = time.Duration(float64(longest) * opts.GetOpts().Adaptive.Max)
+	}
+
+	return HEALTH_INTERVALS * longest
+}
+
+// Prefix of the names of the simulated containers, followed by their number.
+const SIMULATED_PREFIX = "simulated"
+
+// Label of the simulated containers, so their series can be told apart and dropped.
+const SIMULATED_LABEL = "statspout.simulated"
+
+// Fake Docker API standing for the default host, serving the recorded trace when replaying or the fake containers
+// when simulating, nil otherwise.
+func fakeDockerFromFlags() (*dockertest.Server, error) {
+	o := opts.GetOpts()
+
+	switch {
+	case o.Replay != "":
+		server := dockertest.NewServer()
+		if err := server.Replay(o.Replay); err != nil {
+			server.Close()
+			return nil, err
+		}
+
+		log.Info.Printf("Replaying the Docker API exchanges recorded in %s.", o.Replay)
+		return server, nil
+
+	case o.Simulate.Containers > 0:
+		server := dockertest.NewServer()
+		server.SetRealistic(true)
+		server.SetSeed(o.Simulate.Seed)
+
+		for i := 0; i < o.Simulate.Containers; i++ {
+			server.AddContainer(fmt.Sprintf("%s-%d", SIMULATED_PREFIX, i), map[string]string{SIMULATED_LABEL: "true"})
+		}
+
+		log.Info.Printf("Simulating %d containers, seed %d.", o.Simulate.Containers, o.Simulate.Seed)
+		return server, nil
+	}
+
+	return nil, nil
+}
+
+// Saves the state file periodically, so baselines survive crashes too.
+func persist(state *backend.StateFile) {
+	ticker := time.NewTicker(STATE_SAVE_INTERVAL)
+
+	for range ticker.C {
+		if err := state.Save(); err != nil {
+			log.Error.Printf("Could not save the state: %s", err.Error())
+		}
+	}
 }
@@ -0,0 +1,220 @@
+/*
+Telemetry:
+Internal metrics of the collection pipeline, such as the queue depth, the scheduling delay and the latency of each
+stage, so performance regressions can be observed. The pipeline reports to the Default registry, which is served by
+the HTTP API and can be followed with hooks.
+
+Example
+
+	queued := telemetry.Default.Level("queue_depth")
+	queued.Add(1)
+
+	start := time.Now()
+	...
+	telemetry.Default.Observe("request", time.Since(start))
+
+	telemetry.Default.OnObserve(func(stage string, d time.Duration) {
+		...
+	})
+*/
+package telemetry
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Weight of the latest observation in the average latency of a stage.
+const AVERAGE_WEIGHT = 0.2
+
+// Registry reporting to the HTTP API, the goroutine count is registered on it.
+var Default = New()
+
+// Called with every latency observed, synchronously, so it must be quick.
+type Hook func(stage string, d time.Duration)
+
+// Registry of the pipeline metrics.
+type Registry struct {
+	disabled int32 // observations are dropped, set atomically.
+
+	mutex  sync.RWMutex
+	levels map[string]*Level
+	gauges map[string]func() float64
+	stages map[string]*stage
+	hooks  []Hook
+}
+
+// Value going up and down as the pipeline reports, such as the number of queued requests.
+type Level struct {
+	value int64
+}
+
+// Latency of a stage.
+type stage struct {
+	mutex   sync.Mutex
+	count   uint64
+	sum     time.Duration
+	average time.Duration
+	max     time.Duration
+}
+
+// Metrics of the registry at some point.
+type Snapshot struct {
+	Gauges map[string]float64       `json:"gauges"`
+	Stages map[string]StageSnapshot `json:"stages"`
+}
+
+// Latency of a stage since started, in seconds.
+type StageSnapshot struct {
+	Count   uint64  `json:"count"`
+	Sum     float64 `json:"sum"`
+	Average float64 `json:"average"` // moving average, weighting the latest observations.
+	Max     float64 `json:"max"`
+}
+
+func init() {
+	Default.Gauge("goroutines", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+}
+
+// Creates an empty registry.
+func New() *Registry {
+	return &Registry{
+		levels: make(map[string]*Level),
+		gauges: make(map[string]func() float64),
+		stages: make(map[string]*stage),
+	}
+}
+
+// Level of the given name, created on first use. Every caller with the same name shares it.
+func (r *Registry) Level(name string) *Level {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	level, ok := r.levels[name]
+	if !ok {
+		level = &Level{}
+		r.levels[name] = level
+	}
+
+	return level
+}
+
+// Registers a gauge whose value is read when snapshotted, replacing any of the same name.
+func (r *Registry) Gauge(name string, value func() float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.gauges[name] = value
+}
+
+// Registers a hook called with every latency observed from now on.
+func (r *Registry) OnObserve(hook Hook) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.hooks = append(r.hooks, hook)
+}
+
+// Drops the latencies observed from now on, saving their cost on constrained hosts. Levels and gauges are kept.
+func (r *Registry) SetEnabled(enabled bool) {
+	var disabled int32
+	if !enabled {
+		disabled = 1
+	}
+
+	atomic.StoreInt32(&r.disabled, disabled)
+}
+
+// Records the latency of a stage and calls the hooks, unless disabled.
+func (r *Registry) Observe(name string, d time.Duration) {
+	if atomic.LoadInt32(&r.disabled) != 0 {
+		return
+	}
+
+	r.mutex.RLock()
+	s, ok := r.stages[name]
+	hooks := r.hooks
+	r.mutex.RUnlock()
+
+	if !ok {
+		r.mutex.Lock()
+		if s, ok = r.stages[name]; !ok {
+			s = &stage{}
+			r.stages[name] = s
+		}
+		r.mutex.Unlock()
+	}
+
+	s.observe(d)
+
+	for _, hook := range hooks {
+		hook(name, d)
+	}
+}
+
+// Current value of every level and gauge, and the latency of every stage.
+func (r *Registry) Snapshot() Snapshot {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	snapshot := Snapshot{
+		Gauges: make(map[string]float64, len(r.levels)+len(r.gauges)),
+		Stages: make(map[string]StageSnapshot, len(r.stages)),
+	}
+
+	for name, level := range r.levels {
+		snapshot.Gauges[name] = float64(level.Value())
+	}
+
+	for name, value := range r.gauges {
+		snapshot.Gauges[name] = value()
+	}
+
+	for name, s := range r.stages {
+		snapshot.Stages[name] = s.snapshot()
+	}
+
+	return snapshot
+}
+
+func (l *Level) Add(delta int64) {
+	atomic.AddInt64(&l.value, delta)
+}
+
+func (l *Level) Value() int64 {
+	return atomic.LoadInt64(&l.value)
+}
+
+func (s *stage) observe(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.count == 0 {
+		s.average = d
+	} else {
+		s.average = time.Duration(AVERAGE_WEIGHT*float64(d) + (1-AVERAGE_WEIGHT)*float64(s.average))
+	}
+
+	s.count++
+	s.sum += d
+
+	if d > s.max {
+		s.max = d
+	}
+}
+
+func (s *stage) snapshot() StageSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return StageSnapshot{
+		Count:   s.count,
+		Sum:     s.sum.Seconds(),
+		Average: s.average.Seconds(),
+		Max:     s.max.Seconds(),
+	}
+}
@@ -0,0 +1,529 @@
+/*
+Package top implements an interactive terminal view of the collected stats, similar to top(1).
+
+The view reads the samples retained by a Memory repository, which is fed by a regular Collector.
+
+Keys
+
+	c, m, u, t, r, n   sort by CPU, memory %, memory usage, Tx, Rx or name (again to reverse).
+	up, down, j, k     select a container.
+	enter              show the details of the selected container, esc goes back.
+	/                  filter containers by name, enter applies and esc clears the filter.
+	p                  pause or resume the view, collection goes on.
+	q, ctrl+c          quit.
+*/
+package top
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/mijara/statspout/common"
+	"github.com/mijara/statspout/stats"
+)
+
+// Number of samples per container that the view needs, for the details sparklines.
+const SAMPLES = 60
+
+// Time between each redraw, when no key is pressed.
+const REFRESH = time.Second
+
+// Sortable columns of the containers table.
+type column int
+
+const (
+	COLUMN_NAME column = iota
+	COLUMN_CPU
+	COLUMN_MEMORY
+	COLUMN_USAGE
+	COLUMN_TX
+	COLUMN_RX
+)
+
+var columnNames = map[column]string{
+	COLUMN_NAME:   "name",
+	COLUMN_CPU:    "cpu",
+	COLUMN_MEMORY: "mem",
+	COLUMN_USAGE:  "usage",
+	COLUMN_TX:     "tx",
+	COLUMN_RX:     "rx",
+}
+
+// Keys selecting the sort column.
+var sortKeys = map[string]column{
+	"n": COLUMN_NAME,
+	"c": COLUMN_CPU,
+	"m": COLUMN_MEMORY,
+	"u": COLUMN_USAGE,
+	"t": COLUMN_TX,
+	"r": COLUMN_RX,
+}
+
+// Names of special keys.
+const (
+	KEY_UP        = "up"
+	KEY_DOWN      = "down"
+	KEY_ENTER     = "enter"
+	KEY_ESCAPE    = "esc"
+	KEY_BACKSPACE = "backspace"
+	KEY_INTERRUPT = "ctrl+c"
+)
+
+// Latest state of a container, as shown in the table.
+type row struct {
+	name   string
+	cpu    float64
+	memory float64
+	usage  uint64
+	tx     float64 // bytes per second.
+	rx     float64 // bytes per second.
+}
+
+// Top is the terminal view, see Run.
+type Top struct {
+	memory *common.Memory
+	in     *os.File
+	out    *os.File
+
+	sortBy    column
+	ascending bool
+	selected  int    // index of the selected row.
+	detail    string // container shown in detail, if any.
+	filter    string // applied name filter.
+	input     string // filter being typed.
+	editing   bool   // whether the filter is being typed.
+	paused    bool
+	frozen    map[string][]stats.Stats // samples shown while paused.
+}
+
+// Creates a view of the samples retained by memory, drawn on the terminal.
+func New(memory *common.Memory) *Top {
+	return &Top{
+		memory: memory,
+		in:     os.Stdin,
+		out:    os.Stdout,
+		sortBy: COLUMN_CPU,
+	}
+}
+
+// Takes over the terminal until the user quits.
+func (t *Top) Run() error {
+	fd := int(t.in.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("Top needs an interactive terminal.")
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	// alternate screen and hidden cursor, restored on exit.
+	io.WriteString(t.out, "\x1b[?1049h\x1b[?25l")
+	defer io.WriteString(t.out, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go readKeys(t.in, keys)
+
+	ticker := time.NewTicker(REFRESH)
+	defer ticker.Stop()
+
+	for {
+		t.draw()
+
+		select {
+		case <-ticker.C:
+		case key, ok := <-keys:
+			if !ok || !t.handle(key) {
+				return nil
+			}
+		}
+	}
+}
+
+// Applies the pressed key, returns false to quit.
+func (t *Top) handle(key string) bool {
+	if key == KEY_INTERRUPT {
+		return false
+	}
+
+	if t.editing {
+		switch key {
+		case KEY_ENTER:
+			t.filter = t.input
+			t.editing = false
+			t.selected = 0
+		case KEY_ESCAPE:
+			t.filter = ""
+			t.editing = false
+		case KEY_BACKSPACE:
+			if len(t.input) > 0 {
+				t.input = t.input[:len(t.input)-1]
+			}
+		default:
+			if len(key) == 1 {
+				t.input += key
+			}
+		}
+		return true
+	}
+
+	switch key {
+	case "q":
+		return false
+	case "p":
+		t.paused = !t.paused
+		t.frozen = nil
+		if t.paused {
+			t.frozen = t.memory.Snapshot()
+		}
+	case "/":
+		t.editing = true
+		t.input = t.filter
+	case KEY_UP, "k":
+		if t.selected > 0 {
+			t.selected--
+		}
+	case KEY_DOWN, "j":
+		if t.selected < len(t.rows())-1 {
+			t.selected++
+		}
+	case KEY_ENTER:
+		rows := t.rows()
+		if t.detail == "" && t.selected < len(rows) {
+			t.detail = rows[t.selected].name
+		}
+	case KEY_ESCAPE:
+		if t.detail != "" {
+			t.detail = ""
+		} else {
+			t.filter = ""
+		}
+	default:
+		if c, ok := sortKeys[key]; ok {
+			if t.sortBy == c {
+				t.ascending = !t.ascending
+			} else {
+				t.sortBy = c
+				t.ascending = c == COLUMN_NAME
+			}
+		}
+	}
+
+	return true
+}
+
+// Samples on screen, frozen while paused.
+func (t *Top) samples() map[string][]stats.Stats {
+	if t.paused {
+		return t.frozen
+	}
+
+	return t.memory.Snapshot()
+}
+
+// Containers matching the filter, sorted by the selected column.
+func (t *Top) rows() []row {
+	rows := []row{}
+
+	for name, list := range t.samples() {
+		if len(list) == 0 || !strings.Contains(name, t.filter) {
+			continue
+		}
+
+		last := list[len(list)-1]
+		r := row{
+			name:   name,
+			cpu:    last.CpuPercent,
+			memory: last.MemoryPercent,
+			usage:  last.MemoryUsage,
+		}
+		r.tx, r.rx = rates(list)
+
+		rows = append(rows, r)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if t.ascending {
+			return less(rows[i], rows[j], t.sortBy)
+		}
+		return less(rows[j], rows[i], t.sortBy)
+	})
+
+	return rows
+}
+
+func less(a row, b row, c column) bool {
+	switch c {
+	case COLUMN_CPU:
+		return a.cpu < b.cpu
+	case COLUMN_MEMORY:
+		return a.memory < b.memory
+	case COLUMN_USAGE:
+		return a.usage < b.usage
+	case COLUMN_TX:
+		return a.tx < b.tx
+	case COLUMN_RX:
+		return a.rx < b.rx
+	}
+
+	return a.name < b.name
+}
+
+// Network rates between the last two samples, in bytes per second.
+func rates(list []stats.Stats) (float64, float64) {
+	if len(list) < 2 {
+		return 0, 0
+	}
+
+	a, b := list[len(list)-2], list[len(list)-1]
+	seconds := b.Timestamp.Sub(a.Timestamp).Seconds()
+	if seconds <= 0 {
+		return 0, 0
+	}
+
+	// unsigned subtraction handles counters wrapping around.
+	return float64(b.TxBytesTotal-a.TxBytesTotal) / seconds, float64(b.RxBytesTotal-a.RxBytesTotal) / seconds
+}
+
+// Redraws the whole screen.
+func (t *Top) draw() {
+	width, height, err := term.GetSize(int(t.out.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	var lines []string
+	if t.detail != "" {
+		lines = t.details()
+	} else {
+		lines = t.table(height)
+	}
+
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("\x1b[H\x1b[2J")
+
+	for n, line := range lines {
+		if n >= height {
+			break
+		}
+		if n > 0 {
+			buffer.WriteString("\r\n")
+		}
+		buffer.WriteString(truncate(line, width))
+	}
+
+	t.out.Write(buffer.Bytes())
+}
+
+// Lines of the containers table, fitting the screen height.
+func (t *Top) table(height int) []string {
+	rows := t.rows()
+
+	if t.selected >= len(rows) {
+		t.selected = len(rows) - 1
+	}
+	if t.selected < 0 {
+		t.selected = 0
+	}
+
+	order := "desc"
+	if t.ascending {
+		order = "asc"
+	}
+
+	status := fmt.Sprintf("statspout top - %d containers - sort: %s %s", len(rows), columnNames[t.sortBy], order)
+	if t.filter != "" {
+		status += " - filter: " + t.filter
+	}
+	if t.paused {
+		status += " - PAUSED"
+	}
+
+	prompt := "keys: c m u t r n sort, / filter, p pause, enter details, q quit"
+	if t.editing {
+		prompt = "filter: " + t.input + "_"
+	}
+
+	lines := []string{
+		status,
+		prompt,
+		"",
+		fmt.Sprintf("  %-30s %8s %8s %10s %10s %10s", "NAME", "CPU %", "MEM %", "MEM", "TX/s", "RX/s"),
+	}
+
+	// scroll to keep the selected row visible.
+	visible := height - len(lines)
+	first := 0
+	if visible > 0 && t.selected >= visible {
+		first = t.selected - visible + 1
+	}
+
+	for n := first; n < len(rows); n++ {
+		r := rows[n]
+
+		cursor := "  "
+		if n == t.selected {
+			cursor = "> "
+		}
+
+		lines = append(lines, fmt.Sprintf("%s%-30s %8.2f %8.2f %10s %10s %10s",
+			cursor, truncate(r.name, 30), r.cpu, r.memory, bytesize(float64(r.usage)), bytesize(r.tx), bytesize(r.rx)))
+	}
+
+	return lines
+}
+
+// Lines of the details of the selected container.
+func (t *Top) details() []string {
+	list := t.samples()[t.detail]
+
+	lines := []string{"statspout top - " + t.detail + " - esc to go back", ""}
+	if len(list) == 0 {
+		return append(lines, "No samples, the container may have stopped.")
+	}
+
+	last := list[len(list)-1]
+
+	labels := make([]string, 0, len(last.Labels))
+	for key, value := range last.Labels {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+
+	cpu := make([]float64, len(list))
+	memory := make([]float64, len(list))
+	tx := make([]float64, 0, len(list))
+	rx := make([]float64, 0, len(list))
+
+	for n, s := range list {
+		cpu[n] = s.CpuPercent
+		memory[n] = s.MemoryPercent
+
+		if n > 0 {
+			txRate, rxRate := rates(list[n-1 : n+1])
+			tx = append(tx, txRate)
+			rx = append(rx, rxRate)
+		}
+	}
+
+	txRate, rxRate := rates(list)
+
+	return append(lines,
+		"Labels:  "+strings.Join(labels, " "),
+		"Sampled: "+last.Timestamp.Format("15:04:05.000"),
+		"",
+		fmt.Sprintf("CPU %%   %10.2f  %s", last.CpuPercent, sparkline(cpu)),
+		fmt.Sprintf("MEM %%   %10.2f  %s", last.MemoryPercent, sparkline(memory)),
+		fmt.Sprintf("MEM     %10s", bytesize(float64(last.MemoryUsage))),
+		fmt.Sprintf("TX/s    %10s  %s", bytesize(txRate), sparkline(tx)),
+		fmt.Sprintf("RX/s    %10s  %s", bytesize(rxRate), sparkline(rx)),
+		fmt.Sprintf("TX      %10s", bytesize(float64(last.TxBytesTotal))),
+		fmt.Sprintf("RX      %10s", bytesize(float64(last.RxBytesTotal))))
+}
+
+var ticks = []rune("▁▂▃▄▅▆▇█")
+
+// Draws the values as a line of block characters, scaled to the maximum.
+func sparkline(values []float64) string {
+	max := 0.0
+	for _, value := range values {
+		if value > max {
+			max = value
+		}
+	}
+
+	line := make([]rune, len(values))
+	for n, value := range values {
+		i := 0
+		if max > 0 {
+			i = int(value / max * float64(len(ticks)-1))
+		}
+		line[n] = ticks[i]
+	}
+
+	return string(line)
+}
+
+// Formats a number of bytes with binary units.
+func bytesize(value float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", value, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+// Cuts the text to the given number of characters.
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if width < 0 || len(runes) <= width {
+		return text
+	}
+
+	return string(runes[:width])
+}
+
+// Reads key presses from the raw terminal until it is closed.
+func readKeys(in io.Reader, keys chan<- string) {
+	defer close(keys)
+
+	buffer := make([]byte, 32)
+
+	for {
+		n, err := in.Read(buffer)
+		if err != nil {
+			return
+		}
+
+		for _, key := range parseKeys(buffer[:n]) {
+			keys <- key
+		}
+	}
+}
+
+// Translates the bytes read from the terminal to key names.
+func parseKeys(input []byte) []string {
+	keys := []string{}
+
+	for i := 0; i < len(input); i++ {
+		switch b := input[i]; {
+		case b == 0x1b && i+2 < len(input) && input[i+1] == '[':
+			switch input[i+2] {
+			case 'A':
+				keys = append(keys, KEY_UP)
+			case 'B':
+				keys = append(keys, KEY_DOWN)
+			}
+			i += 2
+		case b == 0x1b:
+			keys = append(keys, KEY_ESCAPE)
+		case b == '\r' || b == '\n':
+			keys = append(keys, KEY_ENTER)
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, KEY_BACKSPACE)
+		case b == 0x03:
+			keys = append(keys, KEY_INTERRUPT)
+		case b >= 0x20 && b < 0x7f:
+			keys = append(keys, string(b))
+		}
+	}
+
+	return keys
+}
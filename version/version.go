@@ -0,0 +1,27 @@
+// Build metadata, set at build time through the linker:
+//
+//	go build -ldflags "-X github.com/mijara/statspout/version.Version=1.0.0 \
+//		-X github.com/mijara/statspout/version.Commit=$(git rev-parse --short HEAD) \
+//		-X github.com/mijara/statspout/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+var (
+	Version   = "dev"     // release version.
+	Commit    = "unknown" // git commit the binary was built from.
+	BuildDate = "unknown" // build date, in UTC.
+)
+
+// Version of Go used to build the binary.
+func GoVersion() string {
+	return runtime.Version()
+}
+
+// Build metadata in a single line.
+func String() string {
+	return fmt.Sprintf("statspout %s (commit: %s, built: %s, %s)", Version, Commit, BuildDate, GoVersion())
+}